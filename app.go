@@ -5,250 +5,124 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net/http"
-	"net/url"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/cezarsa/form"
+	"github.com/ef-ctx/tsuru-flow/tsuru"
 	"github.com/tsuru/tsuru/api"
 	"github.com/tsuru/tsuru/cmd"
 )
 
-type createAppOptions struct {
-	Name        string `form:"name"`
-	Platform    string `form:"platform"`
-	Description string `form:"description,omitempty"`
-	Team        string `form:"teamOwner,omitempty"`
-	Plan        string `form:"plan,omitempty"`
-	Pool        string `form:"pool,omitempty"`
-}
+// Default retry policy of the requests sent to the tsuru API, which can be
+// changed with the environment variables TRANOR_RETRIES and
+// TRANOR_RETRY_DELAY.
+const (
+	defaultRetries    = 3
+	defaultRetryDelay = 500 * time.Millisecond
+	maxRetryDelay     = 10 * time.Second
+)
 
-func createApp(client *cmd.Client, opts createAppOptions) (map[string]string, error) {
-	url, err := cmd.GetURL("/apps")
-	if err != nil {
-		return nil, err
-	}
-	payload, _ := form.EncodeToString(opts)
-	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(payload))
+// newAPIClient returns a client of the tsuru API in the current target,
+// sending requests through the given client of the tsuru cmd package.
+func newAPIClient(client *cmd.Client) (*tsuru.Client, error) {
+	target, err := cmd.GetTarget()
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	var app map[string]string
-	err = json.NewDecoder(resp.Body).Decode(&app)
-	return app, err
-}
-
-func updateApp(client *cmd.Client, opts createAppOptions) error {
-	url, err := cmd.GetURL("/apps/" + opts.Name)
-	if err != nil {
-		return err
-	}
-	opts.Name = ""
-	opts.Platform = ""
-	payload, _ := form.EncodeToString(opts)
-	req, err := http.NewRequest(http.MethodPut, url, strings.NewReader(payload))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	cmd.StreamJSONResponse(ioutil.Discard, resp)
-	return nil
+	apiClient := tsuru.NewClient(client, target)
+	apiClient.Retry = retryPolicy()
+	return apiClient, nil
 }
 
-func deleteApps(apps []app, client *cmd.Client, w io.Writer) ([]error, error) {
-	var errs []error
-	for _, app := range apps {
-		fmt.Fprintf(w, "Deleting from env %q... ", app.Env.Name)
-		url, err := cmd.GetURL("/apps/" + app.Name)
-		if err != nil {
-			return nil, err
-		}
-		req, err := http.NewRequest(http.MethodDelete, url, nil)
-		if err != nil {
-			return nil, err
+// retryPolicy returns the policy used to retry idempotent requests that fail
+// with transient errors.
+func retryPolicy() tsuru.RetryPolicy {
+	policy := tsuru.RetryPolicy{Retries: defaultRetries, Delay: defaultRetryDelay, MaxDelay: maxRetryDelay}
+	if value := os.Getenv("TRANOR_RETRIES"); value != "" {
+		if retries, err := strconv.Atoi(value); err == nil && retries >= 0 {
+			policy.Retries = retries
 		}
-		resp, err := client.Do(req)
-		errs = append(errs, err)
-		cmd.StreamJSONResponse(ioutil.Discard, resp)
-		fmt.Fprintln(w, "ok")
-	}
-	return errs, nil
-}
-
-func listApps(client *cmd.Client, filters map[string]string) ([]app, error) {
-	qs := make(url.Values)
-	for k, v := range filters {
-		qs.Set(k, v)
-	}
-	url, err := cmd.GetURL("/apps?" + qs.Encode())
-	if err != nil {
-		return nil, err
-	}
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return nil, err
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusNoContent {
-		return nil, nil
-	}
-	var apps []app
-	err = json.NewDecoder(resp.Body).Decode(&apps)
-	return apps, err
-}
-
-func lastDeploy(client *cmd.Client, appName string) (deploy, error) {
-	var d deploy
-	resp, err := doReq(client, "/deploys?limit=1&app="+appName)
-	if err != nil {
-		return d, err
-	}
-	defer resp.Body.Close()
-	var deploys []deploy
-	if resp.StatusCode == http.StatusNoContent {
-		return d, nil
-	}
-	err = json.NewDecoder(resp.Body).Decode(&deploys)
-	if err != nil {
-		return d, err
-	}
-	if len(deploys) > 0 {
-		d = deploys[0]
 	}
-	return d, nil
-}
-
-func getApp(client *cmd.Client, appName string) (app, error) {
-	var a app
-	resp, err := doReq(client, "/apps/"+appName)
-	if err != nil {
-		return a, err
+	if value := os.Getenv("TRANOR_RETRY_DELAY"); value != "" {
+		if delay, err := time.ParseDuration(value); err == nil && delay > 0 {
+			policy.Delay = delay
+		}
 	}
-	defer resp.Body.Close()
-	err = json.NewDecoder(resp.Body).Decode(&a)
-	return a, err
+	return policy
 }
 
-func setEnvVars(client *cmd.Client, appName string, envVars *api.Envs) error {
-	url, err := cmd.GetURL("/apps/" + appName + "/env")
-	if err != nil {
-		return err
-	}
-	v, err := form.EncodeToValues(envVars)
-	if err != nil {
-		return err
-	}
-	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(v.Encode()))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	return cmd.StreamJSONResponse(ioutil.Discard, resp)
+// app is a tsuru app that belongs to an environment of a project.
+type app struct {
+	tsuru.App
+	Env  Environment
+	Addr string
 }
 
-func getEnvVars(client *cmd.Client, appName string) ([]envVar, error) {
-	var vars []envVar
-	resp, err := doReq(client, "/apps/"+appName+"/env")
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	err = json.NewDecoder(resp.Body).Decode(&vars)
-	return vars, err
+func deleteApps(ctx context.Context, apps []app, client *tsuru.Client, w io.Writer) []error {
+	return runConcurrently(len(apps), func(i int) error {
+		return client.DeleteApp(ctx, apps[i].Name)
+	}, func(i int, err error) {
+		if err != nil {
+			fmt.Fprintf(w, "Deleting from env %q... failed: %s\n", apps[i].Env.Name, err)
+			return
+		}
+		fmt.Fprintf(w, "Deleting from env %q... ok\n", apps[i].Env.Name)
+	})
 }
 
-func unsetEnvVars(client *cmd.Client, appName string, noRestart bool, envVars []string) error {
-	qs := make(url.Values)
-	qs.Set("noRestart", strconv.FormatBool(noRestart))
-	for _, e := range envVars {
-		qs.Add("env", e)
-	}
-	url, err := cmd.GetURL("/apps/" + appName + "/env?" + qs.Encode())
-	if err != nil {
-		return err
-	}
-	req, err := http.NewRequest(http.MethodDelete, url, nil)
-	if err != nil {
-		return err
+// removeCreatedApps removes the apps created by a command that failed or was
+// interrupted, and returns the given error, extended with the list of apps
+// that could not be removed, if any.
+func removeCreatedApps(client *tsuru.Client, names []string, cause error) error {
+	ctx, cancel := cleanupContext()
+	defer cancel()
+	apps := make([]app, len(names))
+	for i, name := range names {
+		apps[i] = app{App: tsuru.App{Name: name}}
+	}
+	var failed []string
+	for i, err := range deleteApps(ctx, apps, client, ioutil.Discard) {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", names[i], err))
+		}
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+	if len(failed) > 0 {
+		return fmt.Errorf("%s\nthe cleanup failed, the following apps were not removed:\n - %s", cause, strings.Join(failed, "\n - "))
 	}
-	return cmd.StreamJSONResponse(ioutil.Discard, resp)
+	return cause
 }
 
-func doReq(client *cmd.Client, path string) (*http.Response, error) {
-	url, err := cmd.GetURL(path)
-	if err != nil {
-		return nil, err
+// envVarsToSet converts the given variables to the payload of SetEnvVars,
+// sorted by name.
+func envVarsToSet(vars map[string]string) *api.Envs {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
 	}
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return nil, err
+	sort.Strings(names)
+	var envVars api.Envs
+	for _, name := range names {
+		envVars.Envs = append(envVars.Envs, struct{ Name, Value string }{Name: name, Value: vars[name]})
 	}
-	return client.Do(req)
-}
-
-type envVar struct {
-	Name   string `json:"name"`
-	Value  string `json:"value"`
-	Public bool   `json:"public"`
+	return &envVars
 }
 
-func (e *envVar) String() string {
-	value := "*** (private variable)"
-	if e.Public {
-		value = e.Value
+// setUnits adds or removes units of the app so it runs the given number of
+// units.
+func setUnits(ctx context.Context, client *tsuru.Client, a app, units int) error {
+	diff := units - len(a.Units)
+	switch {
+	case diff > 0:
+		return client.AddUnits(ctx, a.Name, diff)
+	case diff < 0:
+		return client.RemoveUnits(ctx, a.Name, -diff)
 	}
-	return fmt.Sprintf("%s=%s", e.Name, value)
-}
-
-type app struct {
-	Name          string        `json:"name"`
-	CName         []string      `json:"cname"`
-	Description   string        `json:"description"`
-	RepositoryURL string        `json:"repository"`
-	Platform      string        `json:"platform"`
-	Teams         []string      `json:"teams"`
-	Owner         string        `json:"owner"`
-	Pool          string        `json:"pool"`
-	TeamOwner     string        `json:"teamowner"`
-	Units         []interface{} `json:"units"`
-	Env           Environment
-	Addr          string
-	Plan          struct {
-		Name string `json:"name"`
-	} `json:"plan"`
-}
-
-type deploy struct {
-	ID        string
-	Commit    string
-	Timestamp time.Time
-	Image     string
+	return nil
 }
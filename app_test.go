@@ -15,11 +15,51 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ef-ctx/tsuru-flow/tsuru"
 	"github.com/tsuru/tsuru/api"
 	"github.com/tsuru/tsuru/cmd"
-	"github.com/tsuru/tsuru/errors"
 )
 
+func testAPIClient(client *cmd.Client) *tsuru.Client {
+	apiClient, err := newAPIClient(client)
+	if err != nil {
+		panic(err)
+	}
+	return apiClient
+}
+
+func TestNewAPIClient(t *testing.T) {
+	cleanup, err := setupFakeConfig("https://tsuru.example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	apiClient, err := newAPIClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedURL := "https://tsuru.example.com/1.0/apps"
+	if url := apiClient.URL("/apps"); url != expectedURL {
+		t.Errorf("wrong URL. Want %q. Got %q", expectedURL, url)
+	}
+}
+
+func TestNewAPIClientNoTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("HOME", dir)
+	apiClient, err := newAPIClient(nil)
+	if apiClient != nil {
+		t.Errorf("unexpected non-nil client: %#v", apiClient)
+	}
+	if err == nil {
+		t.Error("unexpected <nil> error")
+	}
+}
+
 func TestCreateApp(t *testing.T) {
 	fakeServer := newFakeServer(t)
 	defer fakeServer.stop()
@@ -37,7 +77,7 @@ func TestCreateApp(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	app, err := createApp(client, createAppOptions{
+	app, err := testAPIClient(client).CreateApp(requestContext, tsuru.CreateAppOptions{
 		Name:        "app",
 		Description: "my nice app",
 		Plan:        "medium",
@@ -76,22 +116,6 @@ func TestCreateApp(t *testing.T) {
 	}
 }
 
-func TestCreateAppNoTarget(t *testing.T) {
-	dir, err := ioutil.TempDir("", "")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(dir)
-	os.Setenv("HOME", dir)
-	app, err := createApp(nil, createAppOptions{})
-	if app != nil {
-		t.Errorf("unexpected non-nil app: %#v", app)
-	}
-	if err == nil {
-		t.Error("unexpected <nil> error")
-	}
-}
-
 func TestUpdateApp(t *testing.T) {
 	fakeServer := newFakeServer(t)
 	defer fakeServer.stop()
@@ -109,7 +133,7 @@ func TestUpdateApp(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	err = updateApp(client, createAppOptions{
+	err = testAPIClient(client).UpdateApp(requestContext, tsuru.CreateAppOptions{
 		Name:        "myapp",
 		Description: "my nice app - updated!",
 		Plan:        "medium",
@@ -154,7 +178,7 @@ func TestUpdateAppNotFound(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	err = updateApp(client, createAppOptions{
+	err = testAPIClient(client).UpdateApp(requestContext, tsuru.CreateAppOptions{
 		Name:        "myapp",
 		Description: "my nice app - updated!",
 		Plan:        "medium",
@@ -167,19 +191,6 @@ func TestUpdateAppNotFound(t *testing.T) {
 	}
 }
 
-func TestUpdateAppNoTarget(t *testing.T) {
-	dir, err := ioutil.TempDir("", "")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(dir)
-	os.Setenv("HOME", dir)
-	err = updateApp(nil, createAppOptions{})
-	if err == nil {
-		t.Error("unexpected <nil> error")
-	}
-}
-
 func TestDeleteApps(t *testing.T) {
 	fakeServer := newFakeServer(t)
 	defer fakeServer.stop()
@@ -201,29 +212,20 @@ func TestDeleteApps(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	errs, err := deleteApps([]app{
-		{Name: "proj1-dev", Env: Environment{Name: "dev"}},
-		{Name: "proj1-qa", Env: Environment{Name: "qa"}},
-		{Name: "proj1-prod", Env: Environment{Name: "prod"}},
-	}, client, &stdout)
-	if err != nil {
-		t.Fatal(err)
-	}
-	expectedErrs := []error{nil, &errors.HTTP{Code: http.StatusNotFound, Message: "not found\n"}, nil}
-	if !reflect.DeepEqual(errs, expectedErrs) {
-		t.Errorf("wrong error list\nwant %#v\ngot  %#v", expectedErrs, errs)
-	}
-	paths := []string{"/1.0/apps/proj1-dev", "/1.0/apps/proj1-qa", "/1.0/apps/proj1-prod"}
-	for i, req := range fakeServer.reqs {
-		if req.Method != http.MethodDelete {
-			t.Errorf("wrong method. Want DELETE. Got %s", req.Method)
-		}
-		if req.URL.Path != paths[i] {
-			t.Errorf("wrong path\nwant %q\ngot  %q", paths[i], req.URL.Path)
-		}
+	errs := deleteApps(requestContext, []app{
+		{App: tsuru.App{Name: "proj1-dev"}, Env: Environment{Name: "dev"}},
+		{App: tsuru.App{Name: "proj1-qa"}, Env: Environment{Name: "qa"}},
+		{App: tsuru.App{Name: "proj1-prod"}, Env: Environment{Name: "prod"}},
+	}, testAPIClient(client), &stdout)
+	if len(errs) != 3 || errs[0] != nil || !tsuru.IsNotFound(errs[1]) || errs[2] != nil {
+		t.Errorf("wrong error list: %#v", errs)
+	}
+	expectedReqs := []string{"DELETE /1.0/apps/proj1-dev", "DELETE /1.0/apps/proj1-prod", "DELETE /1.0/apps/proj1-qa"}
+	if reqs := fakeServer.sortedRequests(); !reflect.DeepEqual(reqs, expectedReqs) {
+		t.Errorf("wrong requests\nwant %#v\ngot  %#v", expectedReqs, reqs)
 	}
 	expectedOutput := `Deleting from env "dev"... ok
-Deleting from env "qa"... ok
+Deleting from env "qa"... failed: not found
 Deleting from env "prod"... ok
 `
 	if stdout.String() != expectedOutput {
@@ -231,22 +233,6 @@ Deleting from env "prod"... ok
 	}
 }
 
-func TestDeleteAppsNoTarget(t *testing.T) {
-	dir, err := ioutil.TempDir("", "")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(dir)
-	os.Setenv("HOME", dir)
-	errs, err := deleteApps([]app{{Name: "app1"}, {Name: "app2"}}, nil, ioutil.Discard)
-	if len(errs) != 0 {
-		t.Errorf("unexpected non-empty error list: %#v", errs)
-	}
-	if err == nil {
-		t.Error("unexpected <nil> error")
-	}
-}
-
 func TestListApps(t *testing.T) {
 	fakeServer := newFakeServer(t)
 	defer fakeServer.stop()
@@ -270,11 +256,11 @@ func TestListApps(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	apps, err := listApps(client, nil)
+	apps, err := testAPIClient(client).ListApps(requestContext, nil)
 	if err != nil {
 		t.Error(err)
 	}
-	expectedApps := []app{
+	expectedApps := []tsuru.App{
 		{Name: "tsuru-dashboard", CName: []string{}},
 		{Name: "proj2-dev", CName: []string{"proj2-dev.dev.example.com", "proj2.dev.example.com"}},
 		{Name: "proj2-qa", CName: []string{"proj2.qa.example.com"}},
@@ -315,7 +301,7 @@ func TestListAppsEmpty(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	apps, err := listApps(client, nil)
+	apps, err := testAPIClient(client).ListApps(requestContext, nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -324,22 +310,6 @@ func TestListAppsEmpty(t *testing.T) {
 	}
 }
 
-func TestListAppsNoTarget(t *testing.T) {
-	dir, err := ioutil.TempDir("", "")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(dir)
-	os.Setenv("HOME", dir)
-	apps, err := listApps(nil, nil)
-	if len(apps) != 0 {
-		t.Errorf("unexpected non-empty app list: %#v", apps)
-	}
-	if err == nil {
-		t.Error("unexpected <nil> error")
-	}
-}
-
 func TestLastDeploy(t *testing.T) {
 	fakeServer := newFakeServer(t)
 	defer fakeServer.stop()
@@ -357,15 +327,17 @@ func TestLastDeploy(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	d, err := lastDeploy(client, "myapp")
+	d, err := testAPIClient(client).LastDeploy(requestContext, "myapp")
 	if err != nil {
 		t.Fatal(err)
 	}
-	expectedDeployment := deploy{
+	expectedDeployment := tsuru.Deploy{
 		ID:        "57ccc9490640fd3def98b157",
 		Commit:    "40244ff2866eba7e2da6eee8a6fc51464c9f604f",
 		Image:     "v938",
 		Timestamp: time.Date(2016, 9, 5, 1, 24, 25, 706e6, time.UTC),
+		Duration:  125995 * time.Millisecond,
+		User:      "admin@example.com",
 	}
 	if !reflect.DeepEqual(d, expectedDeployment) {
 		t.Errorf("wrong deploy\nwant %#v\ngot  %#v", expectedDeployment, d)
@@ -388,28 +360,15 @@ func TestLastDeployEmpty(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	d, err := lastDeploy(client, "myapp")
+	d, err := testAPIClient(client).LastDeploy(requestContext, "myapp")
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !reflect.DeepEqual(d, deploy{}) {
+	if !reflect.DeepEqual(d, tsuru.Deploy{}) {
 		t.Errorf("expected an empty deploy, got %#v", d)
 	}
 }
 
-func TestLastDeployNoTarget(t *testing.T) {
-	dir, err := ioutil.TempDir("", "")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(dir)
-	os.Setenv("HOME", dir)
-	_, err = lastDeploy(nil, "myapp")
-	if err == nil {
-		t.Error("unexpected <nil> error")
-	}
-}
-
 func TestGetApp(t *testing.T) {
 	fakeServer := newFakeServer(t)
 	defer fakeServer.stop()
@@ -427,11 +386,11 @@ func TestGetApp(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	a, err := getApp(client, "proj3-prod")
+	a, err := testAPIClient(client).GetApp(requestContext, "proj3-prod")
 	if err != nil {
 		t.Fatal(err)
 	}
-	var expectedApp app
+	var expectedApp tsuru.App
 	err = json.Unmarshal([]byte(appInfo2), &expectedApp)
 	if err != nil {
 		t.Fatal(err)
@@ -457,28 +416,15 @@ func TestGetAppNotFound(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	a, err := getApp(client, "proj1-prod")
+	a, err := testAPIClient(client).GetApp(requestContext, "proj1-prod")
 	if err == nil {
 		t.Fatal("unexpected <nil> error")
 	}
-	if !reflect.DeepEqual(a, app{}) {
+	if !reflect.DeepEqual(a, tsuru.App{}) {
 		t.Errorf("go non-empty app: %#v", a)
 	}
 }
 
-func TestGetAppNoTarget(t *testing.T) {
-	dir, err := ioutil.TempDir("", "")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(dir)
-	os.Setenv("HOME", dir)
-	_, err = getApp(nil, "proj1-prod")
-	if err == nil {
-		t.Fatal("unexpected <nil> error")
-	}
-}
-
 func TestSetEnvVars(t *testing.T) {
 	fakeServer := newFakeServer(t)
 	defer fakeServer.stop()
@@ -496,7 +442,7 @@ func TestSetEnvVars(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	err = setEnvVars(client, "proj1-prod", &api.Envs{
+	err = testAPIClient(client).SetEnvVars(requestContext, "proj1-prod", &api.Envs{
 		Private:   true,
 		NoRestart: true,
 		Envs: []struct {
@@ -538,26 +484,13 @@ func TestSetEnvVarsNotFound(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	err = setEnvVars(client, "proj1-prod", nil)
+	err = testAPIClient(client).SetEnvVars(requestContext, "proj1-prod", &api.Envs{})
 	if err == nil {
 		t.Fatal("unexpected <nil> error")
 	}
-	e := err.(*errors.HTTP)
-	if e.Code != http.StatusNotFound {
-		t.Errorf("wrong error code. Want %d. Got %d", http.StatusNotFound, e.Code)
-	}
-}
-
-func TestSetEnvVarsNoTarget(t *testing.T) {
-	dir, err := ioutil.TempDir("", "")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(dir)
-	os.Setenv("HOME", dir)
-	err = setEnvVars(nil, "proj1-prod", nil)
-	if err == nil {
-		t.Fatal("unexpected <nil> error")
+	e := err.(*tsuru.NotFoundError)
+	if e.StatusCode != http.StatusNotFound {
+		t.Errorf("wrong error code. Want %d. Got %d", http.StatusNotFound, e.StatusCode)
 	}
 }
 
@@ -578,11 +511,11 @@ func TestGetEnvVars(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	evars, err := getEnvVars(client, "proj1-prod")
+	evars, err := testAPIClient(client).GetEnvVars(requestContext, "proj1-prod")
 	if err != nil {
 		t.Fatal(err)
 	}
-	expectedVars := []envVar{
+	expectedVars := []tsuru.EnvVar{
 		{
 			Name:   "USER_NAME",
 			Value:  "root",
@@ -609,32 +542,16 @@ func TestGetEnvVarsNotFound(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	evars, err := getEnvVars(client, "proj1-prod")
+	evars, err := testAPIClient(client).GetEnvVars(requestContext, "proj1-prod")
 	if err == nil {
 		t.Fatal("unexpected <nil> error")
 	}
 	if evars != nil {
 		t.Fatalf("unexpected non-nil vars: %#v", evars)
 	}
-	e := err.(*errors.HTTP)
-	if e.Code != http.StatusNotFound {
-		t.Errorf("wrong error code. Want %d. Got %d", http.StatusNotFound, e.Code)
-	}
-}
-
-func TestGetEnvVarsNoTarget(t *testing.T) {
-	dir, err := ioutil.TempDir("", "")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(dir)
-	os.Setenv("HOME", dir)
-	evars, err := getEnvVars(nil, "proj1-prod")
-	if err == nil {
-		t.Fatal("unexpected <nil> error")
-	}
-	if evars != nil {
-		t.Fatalf("unexpected non-nil vars: %#v", evars)
+	e := err.(*tsuru.NotFoundError)
+	if e.StatusCode != http.StatusNotFound {
+		t.Errorf("wrong error code. Want %d. Got %d", http.StatusNotFound, e.StatusCode)
 	}
 }
 
@@ -656,7 +573,7 @@ func TestUnsetEnvVars(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	err = unsetEnvVars(client, "proj1-prod", true, []string{"USER_NAME", "USER_PASSWORD", "PASSWORD_HINT"})
+	err = testAPIClient(client).UnsetEnvVars(requestContext, "proj1-prod", true, []string{"USER_NAME", "USER_PASSWORD", "PASSWORD_HINT"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -681,57 +598,72 @@ func TestUnsetEnvVarsNotFound(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	err = unsetEnvVars(client, "proj1-prod", false, nil)
+	err = testAPIClient(client).UnsetEnvVars(requestContext, "proj1-prod", false, nil)
 	if err == nil {
 		t.Fatal("unexpected <nil> error")
 	}
-	e := err.(*errors.HTTP)
-	if e.Code != http.StatusNotFound {
-		t.Errorf("wrong error code. Want %d. Got %d", http.StatusNotFound, e.Code)
+	e := err.(*tsuru.NotFoundError)
+	if e.StatusCode != http.StatusNotFound {
+		t.Errorf("wrong error code. Want %d. Got %d", http.StatusNotFound, e.StatusCode)
 	}
 }
 
-func TestUnsetEnvVarsNoTarget(t *testing.T) {
-	dir, err := ioutil.TempDir("", "")
+func TestSetUnits(t *testing.T) {
+	fakeServer := newFakeServer(t)
+	defer fakeServer.stop()
+	fakeServer.prepareResponse(preparedResponse{
+		method: http.MethodPut,
+		path:   "/apps/myapp/units",
+		code:   http.StatusOK,
+	})
+	fakeServer.prepareResponse(preparedResponse{
+		method:   http.MethodDelete,
+		path:     "/apps/myapp/units",
+		code:     http.StatusOK,
+		ignoreQS: true,
+	})
+	cleanup, err := setupFakeConfig(fakeServer.url(), "")
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer os.RemoveAll(dir)
-	os.Setenv("HOME", dir)
-	err = unsetEnvVars(nil, "proj1-prod", false, nil)
-	if err == nil {
-		t.Fatal("unexpected <nil> error")
+	defer cleanup()
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	apiClient := testAPIClient(cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{}))
+	a := app{App: tsuru.App{Name: "myapp", Units: make([]tsuru.Unit, 2)}}
+	for _, units := range []int{2, 5, 1} {
+		err = setUnits(requestContext, apiClient, a, units)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectedReqs := []string{"DELETE /1.0/apps/myapp/units", "PUT /1.0/apps/myapp/units"}
+	if reqs := fakeServer.sortedRequests(); !reflect.DeepEqual(reqs, expectedReqs) {
+		t.Errorf("wrong requests\nwant %#v\ngot  %#v", expectedReqs, reqs)
+	}
+	if payload := string(fakeServer.payloads[0]); payload != "process=&units=3" {
+		t.Errorf("wrong payload. Want %q. Got %q", "process=&units=3", payload)
 	}
 }
 
-func TestEnvVarStringRepr(t *testing.T) {
+func TestRetryPolicy(t *testing.T) {
+	defer os.Unsetenv("TRANOR_RETRIES")
+	defer os.Unsetenv("TRANOR_RETRY_DELAY")
 	var tests = []struct {
-		testCase string
-		input    envVar
-		expected string
+		retries  string
+		delay    string
+		expected tsuru.RetryPolicy
 	}{
-		{
-			"public variable",
-			envVar{
-				Name:   "USER_NAME",
-				Value:  "root",
-				Public: true,
-			},
-			"USER_NAME=root",
-		},
-		{
-			"private variable",
-			envVar{
-				Name:  "USER_PASSWORD",
-				Value: "r00t",
-			},
-			"USER_PASSWORD=*** (private variable)",
-		},
+		{"", "", tsuru.RetryPolicy{Retries: defaultRetries, Delay: defaultRetryDelay, MaxDelay: maxRetryDelay}},
+		{"5", "2s", tsuru.RetryPolicy{Retries: 5, Delay: 2 * time.Second, MaxDelay: maxRetryDelay}},
+		{"0", "100ms", tsuru.RetryPolicy{Retries: 0, Delay: 100 * time.Millisecond, MaxDelay: maxRetryDelay}},
+		{"-1", "soon", tsuru.RetryPolicy{Retries: defaultRetries, Delay: defaultRetryDelay, MaxDelay: maxRetryDelay}},
 	}
 	for _, test := range tests {
-		repr := test.input.String()
-		if repr != test.expected {
-			t.Errorf("%s: wrong representation\nwant %q\ngot  %q", test.testCase, test.expected, repr)
+		os.Setenv("TRANOR_RETRIES", test.retries)
+		os.Setenv("TRANOR_RETRY_DELAY", test.delay)
+		if got := retryPolicy(); got != test.expected {
+			t.Errorf("TRANOR_RETRIES=%q TRANOR_RETRY_DELAY=%q: want %#v, got %#v", test.retries, test.delay, test.expected, got)
 		}
 	}
 }
@@ -0,0 +1,321 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+type projectApply struct {
+	cmd.ConfirmationCommand
+	fs     *gnuflag.FlagSet
+	file   string
+	guard  approvalGuard
+	freeze freezeGuard
+}
+
+func (c *projectApply) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "project-apply",
+		Usage: "project-apply [-f/--file tranor.yml] [-y/--assume-yes] [--override-freeze reason]",
+		Desc: `reconciles a project with the definition in the given manifest
+
+Environments defined in the manifest and missing in the project are created,
+environments defined in the project and missing in the manifest are removed.
+The plan, team and description of the remaining environments are updated to
+match the manifest, and the environment variables and extra cnames of the
+manifest are added to them. Variables and cnames missing from the manifest are
+kept, apply never removes them.
+
+The list of changes is displayed before anything is applied.`,
+	}
+}
+
+func (c *projectApply) Run(ctx *cmd.Context, client *cmd.Client) error {
+	ctx.RawOutput()
+	config, err := loadConfigFile()
+	if err != nil {
+		return errors.New("unable to load environments file, please make sure that tranor is properly configured")
+	}
+	manifest, err := loadManifest(c.file)
+	if err != nil {
+		return err
+	}
+	err = manifest.validate(config)
+	if err != nil {
+		return err
+	}
+	apiClient, err := newAPIClient(client)
+	if err != nil {
+		return err
+	}
+	apps, err := projectApps(apiClient, manifest.Name)
+	if err != nil && err != errProjectNotFound {
+		return err
+	}
+	steps, err := c.plan(apiClient, config, manifest, apps)
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		fmt.Fprintf(ctx.Stdout, "project %q is up to date\n", manifest.Name)
+		return nil
+	}
+	fmt.Fprintf(ctx.Stdout, "changes to apply in project %q:\n\n", manifest.Name)
+	for _, step := range steps {
+		fmt.Fprintf(ctx.Stdout, " %s\n", step.desc)
+	}
+	fmt.Fprint(ctx.Stdout, "\nvariables and cnames missing from the manifest are kept, apply doesn't remove them.\n\n")
+	var envNames, restarted []string
+	for _, step := range steps {
+		envNames = append(envNames, step.env)
+		if step.restarts && !containsString(restarted, step.env) {
+			restarted = append(restarted, step.env)
+		}
+	}
+	_, err = c.freeze.check(ctx.Stderr, config, restarted)
+	if err != nil {
+		return err
+	}
+	err = c.guard.check(ctx, client, config, manifest.Name, envNames)
+	if err != nil {
+		return err
+	}
+	if !dryRun && !c.Confirm(ctx, "Apply these changes?") {
+		return nil
+	}
+	defer catchInterrupts(ctx.Stderr)()
+	return applySteps(ctx.Stdout, steps)
+}
+
+// applySteps runs the given steps in order, reporting each of them to w. When
+// the user interrupts the command, it stops after the current step and lists
+// the changes that were not applied.
+func applySteps(w io.Writer, steps []applyStep) error {
+	for i, step := range steps {
+		if interrupted() {
+			fmt.Fprintln(w, "interrupted, the following changes were not applied:")
+			for _, skipped := range steps[i:] {
+				fmt.Fprintf(w, " %s\n", skipped.desc)
+			}
+			return errInterrupted
+		}
+		fmt.Fprintf(w, "%s... ", step.desc)
+		err := step.run()
+		if err != nil {
+			fmt.Fprintln(w, "failed")
+			return err
+		}
+		fmt.Fprintln(w, "ok")
+	}
+	return nil
+}
+
+// applyStep is one of the changes that project-apply makes to a project.
+// Steps that restart the app of an existing environment are refused during
+// deploy freezes.
+type applyStep struct {
+	env      string
+	desc     string
+	run      func() error
+	restarts bool
+}
+
+func (c *projectApply) plan(client *tsuru.Client, config *Config, m *Manifest, apps []app) ([]applyStep, error) {
+	var (
+		steps       []applyStep
+		existingEnv = make(map[string]app, len(apps))
+	)
+	for _, a := range apps {
+		existingEnv[a.Env.Name] = a
+	}
+	opts := tsuru.CreateAppOptions{
+		Description: m.Description,
+		Plan:        m.Plan,
+		Platform:    m.Platform,
+		Team:        m.Team,
+	}
+	for _, env := range getEnvironmentsByName(config.Environments, m.envNames()) {
+		menv := m.env(env.Name)
+		if a, ok := existingEnv[env.Name]; ok {
+			updateSteps, err := c.updateSteps(client, a, m, menv)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, updateSteps...)
+			continue
+		}
+		if _, err := env.appOptions(opts); err != nil {
+			return nil, err
+		}
+		steps = append(steps, c.createStep(client, env, m.Name, opts))
+		if len(menv.EnvVars) > 0 {
+			steps = append(steps, c.envVarsStep(client, env.Name, config.namer().appName(m.Name, env), menv.EnvVars))
+		}
+		for _, cname := range menv.CNames {
+			steps = append(steps, c.cnameStep(client, env.Name, config.namer().appName(m.Name, env), cname))
+		}
+	}
+	var appsToRemove []app
+	for _, a := range apps {
+		if m.env(a.Env.Name) == nil {
+			appsToRemove = append(appsToRemove, a)
+		}
+	}
+	for _, a := range appsToRemove {
+		a := a
+		steps = append(steps, applyStep{
+			env:  a.Env.Name,
+			desc: fmt.Sprintf("- remove env %q", a.Env.Name),
+			run: func() error {
+				return deleteApps(requestContext, []app{a}, client, ioutil.Discard)[0]
+			},
+		})
+	}
+	return steps, nil
+}
+
+func (c *projectApply) createStep(client *tsuru.Client, env Environment, projectName string, opts tsuru.CreateAppOptions) applyStep {
+	return applyStep{
+		env:  env.Name,
+		desc: fmt.Sprintf("+ create env %q", env.Name),
+		run: func() error {
+			apps, err := createApps([]Environment{env}, client, projectName, opts)
+			if err != nil {
+				return err
+			}
+			err = setCNames(apps, client)
+			if err != nil {
+				return removeCreatedApps(client, []string{apps[0]["name"]}, err)
+			}
+			return nil
+		},
+	}
+}
+
+func (c *projectApply) updateSteps(client *tsuru.Client, a app, m *Manifest, menv *ManifestEnvironment) ([]applyStep, error) {
+	var (
+		steps   []applyStep
+		changes []string
+	)
+	currentPlan := a.Plan.Name
+	if currentPlan == "autogenerated" {
+		currentPlan = ""
+	}
+	opts := tsuru.CreateAppOptions{Name: a.Name, Pool: a.Pool}
+	if m.Plan != "" && m.Plan != currentPlan {
+		err := a.Env.checkPlan(m.Plan)
+		if err != nil {
+			return nil, err
+		}
+		opts.Plan = m.Plan
+		changes = append(changes, fmt.Sprintf("plan %q => %q", currentPlan, m.Plan))
+	}
+	if m.Team != "" && m.Team != a.TeamOwner {
+		opts.Team = m.Team
+		changes = append(changes, fmt.Sprintf("team %q => %q", a.TeamOwner, m.Team))
+	}
+	if m.Description != "" && m.Description != a.Description {
+		opts.Description = m.Description
+		changes = append(changes, fmt.Sprintf("description %q => %q", a.Description, m.Description))
+	}
+	if len(changes) > 0 {
+		steps = append(steps, applyStep{
+			env:  a.Env.Name,
+			desc: fmt.Sprintf("~ update env %q: %s", a.Env.Name, strings.Join(changes, ", ")),
+			run: func() error {
+				return client.UpdateApp(requestContext, opts)
+			},
+		})
+	}
+	if len(menv.EnvVars) > 0 {
+		currentVars, err := client.GetEnvVars(requestContext, a.Name)
+		if err != nil {
+			return nil, err
+		}
+		changedVars := make(map[string]string)
+		for name, value := range menv.EnvVars {
+			if !envVarDefined(currentVars, name, value) {
+				changedVars[name] = value
+			}
+		}
+		if len(changedVars) > 0 {
+			step := c.envVarsStep(client, a.Env.Name, a.Name, changedVars)
+			step.restarts = true
+			steps = append(steps, step)
+		}
+	}
+	for _, cname := range menv.CNames {
+		var found bool
+		for _, current := range a.CName {
+			if current == cname {
+				found = true
+				break
+			}
+		}
+		if !found {
+			steps = append(steps, c.cnameStep(client, a.Env.Name, a.Name, cname))
+		}
+	}
+	return steps, nil
+}
+
+func (c *projectApply) envVarsStep(client *tsuru.Client, envName, appName string, vars map[string]string) applyStep {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	envVars := envVarsToSet(vars)
+	return applyStep{
+		env:  envName,
+		desc: fmt.Sprintf("~ set variables in env %q: %s", envName, strings.Join(names, ", ")),
+		run: func() error {
+			return client.SetEnvVars(requestContext, appName, envVars)
+		},
+	}
+}
+
+func (c *projectApply) cnameStep(client *tsuru.Client, envName, appName, cname string) applyStep {
+	return applyStep{
+		env:  envName,
+		desc: fmt.Sprintf("+ add cname %q to env %q", cname, envName),
+		run: func() error {
+			return client.AddCName(requestContext, appName, cname)
+		},
+	}
+}
+
+func (c *projectApply) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = c.ConfirmationCommand.Flags()
+		c.fs.StringVar(&c.file, "file", defaultManifestFile, "path to the project manifest")
+		c.fs.StringVar(&c.file, "f", defaultManifestFile, "path to the project manifest")
+		c.guard.addFlags(c.fs)
+		c.freeze.addFlags(c.fs)
+	}
+	return c.fs
+}
+
+// envVarDefined reports whether the variable is set to the given value. The
+// API hides the values of private variables, so they're compared by name
+// only.
+func envVarDefined(vars []tsuru.EnvVar, name, value string) bool {
+	for _, v := range vars {
+		if v.Name == name {
+			return !v.Public || v.Value == value
+		}
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/tsuru/tsuru/api"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+func TestProjectApplyCreatesProject(t *testing.T) {
+	tsuruServer.reset()
+	cleanup, err := setupFakeConfig(tsuruServer.url(), tsuruServer.token())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	manifestPath := writeTestManifest(t, `name: myproj
+platform: python
+team: myteam
+plan: medium
+envs:
+  - name: dev
+    envVars:
+      DATABASE_NAME: mydb_dev
+  - name: prod
+    cnames:
+      - www.myproj.com
+`)
+	var c projectApply
+	c.Flags().Parse(true, []string{"-f", manifestPath})
+	stdout := bytes.Buffer{}
+	ctx := cmd.Context{Stdout: &stdout, Stderr: ioutil.Discard, Stdin: strings.NewReader("y\n")}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOutput := `changes to apply in project "myproj":
+
+ + create env "dev"
+ ~ set variables in env "dev": DATABASE_NAME
+ + create env "prod"
+ + add cname "www.myproj.com" to env "prod"
+
+variables and cnames missing from the manifest are kept, apply doesn't remove them.
+
+Apply these changes? (y/n) + create env "dev"... ok
+~ set variables in env "dev": DATABASE_NAME... ok
++ create env "prod"... ok
++ add cname "www.myproj.com" to env "prod"... ok
+`
+	if stdout.String() != expectedOutput {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expectedOutput, stdout.String())
+	}
+	apps, err := projectApps(testAPIClient(client), "myproj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 2 {
+		t.Fatalf("wrong number of apps, want 2, got %d", len(apps))
+	}
+	prodCNames := apps[1].CName
+	sort.Strings(prodCNames)
+	expectedCNames := []string{"myproj.example.com", "www.myproj.com"}
+	if !reflect.DeepEqual(prodCNames, expectedCNames) {
+		t.Errorf("wrong cnames in prod\nwant %#v\ngot  %#v", expectedCNames, prodCNames)
+	}
+	vars, err := testAPIClient(client).GetEnvVars(requestContext, "myproj-dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !envVarDefined(vars, "DATABASE_NAME", "mydb_dev") {
+		t.Errorf("DATABASE_NAME not defined in dev: %#v", vars)
+	}
+}
+
+func TestProjectApplyUpdatesProject(t *testing.T) {
+	cleanup := createTestProject("myproj", t)
+	defer cleanup()
+	manifestPath := writeTestManifest(t, `name: myproj
+platform: python
+team: myteam
+plan: small
+envs:
+  - name: dev
+  - name: qa
+  - name: prod
+`)
+	var c projectApply
+	c.Flags().Parse(true, []string{"-f", manifestPath, "-y"})
+	stdout := bytes.Buffer{}
+	ctx := cmd.Context{Stdout: &stdout, Stderr: ioutil.Discard}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err := c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps, err := projectApps(testAPIClient(client), "myproj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var envNames []string
+	for _, a := range apps {
+		envNames = append(envNames, a.Env.Name)
+		if a.Plan.Name != "small" {
+			t.Errorf("wrong plan in env %q: %q", a.Env.Name, a.Plan.Name)
+		}
+	}
+	expectedEnvs := []string{"dev", "qa", "prod"}
+	if !reflect.DeepEqual(envNames, expectedEnvs) {
+		t.Errorf("wrong envs after apply\nwant %#v\ngot  %#v", expectedEnvs, envNames)
+	}
+	stdout.Reset()
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOutput := "project \"myproj\" is up to date\n"
+	if stdout.String() != expectedOutput {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expectedOutput, stdout.String())
+	}
+}
+
+func TestProjectApplyKeepsPrivateVariables(t *testing.T) {
+	cleanup := createTestProject("myproj", t)
+	defer cleanup()
+	stdout := bytes.Buffer{}
+	ctx := cmd.Context{Stdout: &stdout, Stderr: ioutil.Discard}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	envVars := api.Envs{NoRestart: true, Private: true}
+	envVars.Envs = append(envVars.Envs, struct{ Name, Value string }{Name: "SECRET_KEY", Value: "current-key"})
+	err := testAPIClient(client).SetEnvVars(requestContext, "myproj-dev", &envVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestPath := writeTestManifest(t, `name: myproj
+platform: python
+team: myteam
+plan: medium
+envs:
+  - name: dev
+    envVars:
+      SECRET_KEY: manifest-key
+  - name: qa
+  - name: stage
+  - name: prod
+`)
+	var c projectApply
+	c.Flags().Parse(true, []string{"-f", manifestPath, "-y"})
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOutput := "project \"myproj\" is up to date\n"
+	if stdout.String() != expectedOutput {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expectedOutput, stdout.String())
+	}
+	vars, err := testAPIClient(client).GetEnvVars(requestContext, "myproj-dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range vars {
+		if v.Name == "SECRET_KEY" && v.Public {
+			t.Errorf("private variable changed by apply: %#v", v)
+		}
+	}
+}
+
+func TestProjectApplyEnvConstraints(t *testing.T) {
+	cleanup := createTestProject("myproj", t)
+	defer cleanup()
+	setupEnvConstraints(t, func(envs []Environment) {
+		envs[3].AllowedPlans = []string{"medium", "large"}
+		envs[3].AllowedPlatforms = []string{"python"}
+	})
+	var tests = []struct {
+		manifest string
+		errMsg   string
+	}{
+		{
+			"name: myproj\nplatform: python\nplan: small\nenvs:\n  - name: dev\n  - name: qa\n  - name: stage\n  - name: prod\n",
+			`plan "small" is not allowed in the environment "prod" (allowed plans: medium, large)`,
+		},
+		{
+			"name: otherproj\nplatform: ruby\nplan: medium\nenvs:\n  - name: dev\n  - name: prod\n",
+			`platform "ruby" is not allowed in the environment "prod" (allowed platforms: python)`,
+		},
+	}
+	for _, test := range tests {
+		manifestPath := writeTestManifest(t, test.manifest)
+		var c projectApply
+		c.Flags().Parse(true, []string{"-f", manifestPath, "-y"})
+		ctx := cmd.Context{Stdout: ioutil.Discard, Stderr: ioutil.Discard}
+		client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+		err := c.Run(&ctx, client)
+		if err == nil || err.Error() != test.errMsg {
+			t.Errorf("wrong error\nwant %q\ngot  %v", test.errMsg, err)
+		}
+	}
+	client := cmd.NewClient(http.DefaultClient, &cmd.Context{}, &cmd.Manager{})
+	apps, err := projectApps(testAPIClient(client), "myproj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range apps {
+		if a.Plan.Name != "medium" {
+			t.Errorf("plan of env %q should not change: %q", a.Env.Name, a.Plan.Name)
+		}
+	}
+	_, err = projectApps(testAPIClient(client), "otherproj")
+	if err != errProjectNotFound {
+		t.Errorf("project should not be created: %v", err)
+	}
+}
+
+func TestProjectApplyAbort(t *testing.T) {
+	tsuruServer.reset()
+	cleanup, err := setupFakeConfig(tsuruServer.url(), tsuruServer.token())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	manifestPath := writeTestManifest(t, "name: myproj\nplatform: python\nenvs:\n  - name: dev\n")
+	var c projectApply
+	c.Flags().Parse(true, []string{"-f", manifestPath})
+	ctx := cmd.Context{Stdout: ioutil.Discard, Stderr: ioutil.Discard, Stdin: strings.NewReader("n\n")}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = projectApps(testAPIClient(client), "myproj")
+	if err != errProjectNotFound {
+		t.Errorf("wrong error returned: %#v", err)
+	}
+}
+
+func writeTestManifest(t *testing.T, content string) string {
+	path := filepath.Join(os.Getenv("HOME"), "tranor.yml")
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
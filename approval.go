@@ -0,0 +1,278 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+// approvalGuard protects environments flagged as protected in the
+// configuration. Every command that changes a project in an existing
+// environment must call check before doing so.
+type approvalGuard struct {
+	token string
+}
+
+func (g *approvalGuard) addFlags(fs *gnuflag.FlagSet) {
+	fs.StringVar(&g.token, "approval", "", "approval token for changes in protected environments (see project-approve)")
+}
+
+// check ensures that changes to the given environments of the project are
+// approved, either by a valid approval token or by having the user type the
+// name of the project.
+func (g *approvalGuard) check(ctx *cmd.Context, client *cmd.Client, config *Config, projectName string, envNames []string) error {
+	protected := protectedEnvs(config, envNames)
+	if len(protected) == 0 {
+		return nil
+	}
+	if g.token != "" {
+		return verifyApproval(client, config, g.token, projectName, protected)
+	}
+	if dryRun {
+		return nil
+	}
+	names := make([]string, len(protected))
+	for i, env := range protected {
+		names[i] = fmt.Sprintf("%q", env.Name)
+	}
+	fmt.Fprintf(ctx.Stdout, "This change affects protected environments (%s). Please type the name of the project to confirm: ", strings.Join(names, ", "))
+	var answer string
+	if ctx.Stdin != nil {
+		fmt.Fscanf(ctx.Stdin, "%s", &answer)
+	}
+	if answer != projectName {
+		return errors.New("the confirmation doesn't match the name of the project, aborting")
+	}
+	return nil
+}
+
+func protectedEnvs(config *Config, envNames []string) []Environment {
+	var protected []Environment
+	for _, env := range getEnvironmentsByName(config.Environments, envNames) {
+		if env.Protected {
+			protected = append(protected, env)
+		}
+	}
+	return protected
+}
+
+// approval is the payload of an approval token.
+type approval struct {
+	Project  string    `json:"project"`
+	Envs     []string  `json:"envs"`
+	Approver string    `json:"approver"`
+	Expires  time.Time `json:"expires"`
+}
+
+func (a *approval) sign(key ed25519.PrivateKey) (string, error) {
+	payload, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+	signature := ed25519.Sign(key, payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func parseApproval(token string, config *Config) (*approval, error) {
+	errInvalid := errors.New("invalid approval token")
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, errInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errInvalid
+	}
+	var a approval
+	err = json.Unmarshal(payload, &a)
+	if err != nil {
+		return nil, errInvalid
+	}
+	approver := config.approver(a.Approver)
+	if approver == nil {
+		return nil, fmt.Errorf("%q is not an approver", a.Approver)
+	}
+	publicKey, err := decodePublicKey(approver.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key for the approver %q", a.Approver)
+	}
+	if !ed25519.Verify(publicKey, payload, signature) {
+		return nil, errors.New("invalid signature in the approval token")
+	}
+	return &a, nil
+}
+
+func verifyApproval(client *cmd.Client, config *Config, token, projectName string, envs []Environment) error {
+	a, err := parseApproval(token, config)
+	if err != nil {
+		return err
+	}
+	if time.Now().After(a.Expires) {
+		return errors.New("the approval token has expired")
+	}
+	if a.Project != projectName {
+		return fmt.Errorf("the approval token is not valid for the project %q", projectName)
+	}
+	team := config.approver(a.Approver).Team
+	for _, env := range envs {
+		var approved bool
+		for _, envName := range a.Envs {
+			if envName == env.Name {
+				approved = true
+				break
+			}
+		}
+		if !approved {
+			return fmt.Errorf("the approval token is not valid for the environment %q", env.Name)
+		}
+		if len(env.ApprovalTeams) > 0 && !containsString(env.ApprovalTeams, team) {
+			return fmt.Errorf("members of the team %q can't approve changes in the environment %q", team, env.Name)
+		}
+	}
+	user, err := cmd.GetUser(client)
+	if err != nil {
+		return err
+	}
+	if user.Email == a.Approver {
+		return errors.New("the approval token must be issued by a different user")
+	}
+	return nil
+}
+
+type projectApprove struct {
+	fs          *gnuflag.FlagSet
+	projectName string
+	envs        commaSeparatedFlag
+	expires     time.Duration
+}
+
+func (c *projectApprove) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "project-approve",
+		Usage: "project-approve -n/--project-name <projectname> -e/--envs <environments> [--expires 1h]",
+		Desc: `issues an approval token for changes in protected environments of the project
+
+The token is signed with the key stored in ~/.tranor/approval.key, which is
+created in the first run. The corresponding public key must be registered as
+an approver in the remote configuration by the tranor administrator.`,
+	}
+}
+
+func (c *projectApprove) Run(ctx *cmd.Context, client *cmd.Client) error {
+	if c.projectName == "" || len(c.envs.Values()) == 0 {
+		return errors.New("please provide the project name and the environments")
+	}
+	config, err := loadConfigFile()
+	if err != nil {
+		return errors.New("unable to load environments file, please make sure that tranor is properly configured")
+	}
+	err = c.envs.validate(config.envNames())
+	if err != nil {
+		return err
+	}
+	key, created, err := loadApprovalKey()
+	if err != nil {
+		return err
+	}
+	publicKey := base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	if created {
+		fmt.Fprintf(ctx.Stderr, "Created a new approval key. Your public key is:\n\n  %s\n\n", publicKey)
+	}
+	user, err := cmd.GetUser(client)
+	if err != nil {
+		return err
+	}
+	approver := config.approver(user.Email)
+	if approver == nil || approver.PublicKey != publicKey {
+		return fmt.Errorf("you are not registered as an approver with the key %s, please contact the tranor administrator", publicKey)
+	}
+	a := approval{
+		Project:  c.projectName,
+		Envs:     c.envs.Values(),
+		Approver: user.Email,
+		Expires:  time.Now().Add(c.expires).UTC(),
+	}
+	token, err := a.sign(key)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(ctx.Stdout, token)
+	return nil
+}
+
+func (c *projectApprove) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("project-approve", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.projectName, "project-name", "", "name of the project")
+		c.fs.StringVar(&c.projectName, "n", "", "name of the project")
+		c.fs.Var(&c.envs, "envs", "comma-separated list of environments to approve changes in")
+		c.fs.Var(&c.envs, "e", "comma-separated list of environments to approve changes in")
+		c.fs.DurationVar(&c.expires, "expires", time.Hour, "validity of the approval token")
+	}
+	return c.fs
+}
+
+func loadApprovalKey() (key ed25519.PrivateKey, created bool, err error) {
+	path := cmd.JoinWithUserDir(".tranor", "approval.key")
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		key, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != ed25519.PrivateKeySize {
+			return nil, false, fmt.Errorf("invalid approval key in %q", path)
+		}
+		return key, false, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+	_, key, err = ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, false, err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, false, err
+	}
+	err = ioutil.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600)
+	if err != nil {
+		return nil, false, err
+	}
+	return key, true, nil
+}
+
+// decodePublicKey decodes a base64 encoded ed25519 public key.
+func decodePublicKey(key string) (ed25519.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(data) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid ed25519 public key")
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,324 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+func TestApprovalGuardUnprotectedEnvs(t *testing.T) {
+	config := Config{Environments: []Environment{{Name: "dev"}, {Name: "prod", Protected: true}}}
+	var g approvalGuard
+	var stdout bytes.Buffer
+	err := g.check(&cmd.Context{Stdout: &stdout}, nil, &config, "myproj", []string{"dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "" {
+		t.Errorf("unexpected output: %q", stdout.String())
+	}
+}
+
+func TestApprovalGuardTypedConfirmation(t *testing.T) {
+	config := Config{Environments: []Environment{{Name: "dev"}, {Name: "stage", Protected: true}, {Name: "prod", Protected: true}}}
+	var tests = []struct {
+		testCase string
+		input    string
+		errMsg   string
+	}{
+		{"matching name", "myproj\n", ""},
+		{"wrong name", "otherproj\n", "the confirmation doesn't match the name of the project, aborting"},
+		{"no input", "", "the confirmation doesn't match the name of the project, aborting"},
+	}
+	for _, test := range tests {
+		t.Run(test.testCase, func(t *testing.T) {
+			var g approvalGuard
+			var stdout bytes.Buffer
+			ctx := cmd.Context{Stdout: &stdout, Stdin: strings.NewReader(test.input)}
+			err := g.check(&ctx, nil, &config, "myproj", []string{"dev", "stage", "prod"})
+			var errMsg string
+			if err != nil {
+				errMsg = err.Error()
+			}
+			if errMsg != test.errMsg {
+				t.Errorf("wrong error\nwant %q\ngot  %q", test.errMsg, errMsg)
+			}
+			expectedOutput := `This change affects protected environments ("stage", "prod"). Please type the name of the project to confirm: `
+			if stdout.String() != expectedOutput {
+				t.Errorf("wrong output\nwant %q\ngot  %q", expectedOutput, stdout.String())
+			}
+		})
+	}
+}
+
+func TestApprovalGuardToken(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.stop()
+	server.prepareResponse(preparedResponse{
+		method:  http.MethodGet,
+		path:    "/users/info",
+		code:    http.StatusOK,
+		payload: []byte(`{"Email":"bob@example.com"}`),
+	})
+	cleanup, err := setupFakeConfig(server.url(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodedKey := base64.StdEncoding.EncodeToString(publicKey)
+	config := Config{
+		Environments: []Environment{
+			{Name: "dev"},
+			{Name: "prod", Protected: true, ApprovalTeams: []string{"sre"}},
+		},
+		Approvers: []Approver{
+			{Email: "alice@example.com", Team: "sre", PublicKey: encodedKey},
+			{Email: "bob@example.com", Team: "sre", PublicKey: encodedKey},
+			{Email: "carol@example.com", Team: "dev", PublicKey: encodedKey},
+		},
+	}
+	expires := time.Now().Add(time.Hour)
+	var tests = []struct {
+		testCase string
+		approval approval
+		key      ed25519.PrivateKey
+		errMsg   string
+	}{
+		{
+			"valid token",
+			approval{Project: "myproj", Envs: []string{"prod"}, Approver: "alice@example.com", Expires: expires},
+			privateKey,
+			"",
+		},
+		{
+			"invalid signature",
+			approval{Project: "myproj", Envs: []string{"prod"}, Approver: "alice@example.com", Expires: expires},
+			otherKey,
+			"invalid signature in the approval token",
+		},
+		{
+			"unknown approver",
+			approval{Project: "myproj", Envs: []string{"prod"}, Approver: "dave@example.com", Expires: expires},
+			privateKey,
+			`"dave@example.com" is not an approver`,
+		},
+		{
+			"expired token",
+			approval{Project: "myproj", Envs: []string{"prod"}, Approver: "alice@example.com", Expires: time.Now().Add(-time.Minute)},
+			privateKey,
+			"the approval token has expired",
+		},
+		{
+			"different project",
+			approval{Project: "otherproj", Envs: []string{"prod"}, Approver: "alice@example.com", Expires: expires},
+			privateKey,
+			`the approval token is not valid for the project "myproj"`,
+		},
+		{
+			"different env",
+			approval{Project: "myproj", Envs: []string{"dev"}, Approver: "alice@example.com", Expires: expires},
+			privateKey,
+			`the approval token is not valid for the environment "prod"`,
+		},
+		{
+			"team not allowed",
+			approval{Project: "myproj", Envs: []string{"prod"}, Approver: "carol@example.com", Expires: expires},
+			privateKey,
+			`members of the team "dev" can't approve changes in the environment "prod"`,
+		},
+		{
+			"self approval",
+			approval{Project: "myproj", Envs: []string{"prod"}, Approver: "bob@example.com", Expires: expires},
+			privateKey,
+			"the approval token must be issued by a different user",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.testCase, func(t *testing.T) {
+			token, err := test.approval.sign(test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g := approvalGuard{token: token}
+			ctx := cmd.Context{Stdout: ioutil.Discard}
+			client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+			err = g.check(&ctx, client, &config, "myproj", []string{"dev", "prod"})
+			var errMsg string
+			if err != nil {
+				errMsg = err.Error()
+			}
+			if errMsg != test.errMsg {
+				t.Errorf("wrong error\nwant %q\ngot  %q", test.errMsg, errMsg)
+			}
+		})
+	}
+}
+
+func TestApprovalGuardMalformedToken(t *testing.T) {
+	config := Config{Environments: []Environment{{Name: "prod", Protected: true}}}
+	for _, token := range []string{"abc", "a.b.c", "!!!.abc", base64.RawURLEncoding.EncodeToString([]byte("not json")) + ".abc"} {
+		g := approvalGuard{token: token}
+		err := g.check(&cmd.Context{}, nil, &config, "myproj", []string{"prod"})
+		if err == nil || err.Error() != "invalid approval token" {
+			t.Errorf("token %q: wrong error %v", token, err)
+		}
+	}
+}
+
+func TestProjectApprove(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.stop()
+	server.prepareResponse(preparedResponse{
+		method:  http.MethodGet,
+		path:    "/users/info",
+		code:    http.StatusOK,
+		payload: []byte(`{"Email":"alice@example.com"}`),
+	})
+	cleanup, err := setupFakeConfig(server.url(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	var c projectApprove
+	c.Flags().Parse(true, []string{"-n", "myproj", "-e", "prod"})
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = c.Run(&ctx, client)
+	if err == nil {
+		t.Fatal("unexpected <nil> error")
+	}
+	if !strings.HasPrefix(err.Error(), "you are not registered as an approver") {
+		t.Errorf("wrong error message: %q", err.Error())
+	}
+	if !strings.HasPrefix(stderr.String(), "Created a new approval key.") {
+		t.Errorf("wrong stderr: %q", stderr.String())
+	}
+	if _, err = os.Stat(cmd.JoinWithUserDir(".tranor", "approval.key")); err != nil {
+		t.Fatal(err)
+	}
+	key, created, err := loadApprovalKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created {
+		t.Error("approval key should not be created twice")
+	}
+	config, err := loadConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Approvers = []Approver{{
+		Email:     "alice@example.com",
+		Team:      "sre",
+		PublicKey: base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+	}}
+	err = writeConfigFile(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	stderr.Reset()
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stderr.String() != "" {
+		t.Errorf("unexpected stderr: %q", stderr.String())
+	}
+	a, err := parseApproval(strings.TrimSpace(stdout.String()), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Project != "myproj" || len(a.Envs) != 1 || a.Envs[0] != "prod" || a.Approver != "alice@example.com" {
+		t.Errorf("wrong approval issued: %#v", a)
+	}
+}
+
+func TestProjectEnvVarSetProtectedEnv(t *testing.T) {
+	cleanup := createTestProject("myproj", t)
+	defer cleanup()
+	config, err := loadConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Environments[3].Protected = true
+	err = writeConfigFile(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c projectEnvVarSet
+	c.Flags().Parse(true, []string{"-n", "myproj", "-e", "prod"})
+	var stdout bytes.Buffer
+	ctx := cmd.Context{Args: []string{"FOO=bar"}, Stdout: &stdout, Stdin: strings.NewReader("wrongproj\n")}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = c.Run(&ctx, client)
+	if err == nil {
+		t.Fatal("unexpected <nil> error")
+	}
+	envVars, err := testAPIClient(client).GetEnvVars(requestContext, "myproj-prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envVarDefined(envVars, "FOO", "bar") {
+		t.Error("variable should not be set in the protected environment")
+	}
+}
+
+func TestProjectEnvVarSetProtectedEnvOutsideTheProject(t *testing.T) {
+	tsuruServer.reset()
+	cleanup, err := setupFakeConfig(tsuruServer.url(), tsuruServer.token())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	setupEnvConstraints(t, func(envs []Environment) {
+		envs[3].Protected = true
+	})
+	var stdout bytes.Buffer
+	ctx := cmd.Context{Args: []string{"FOO=bar"}, Stdout: &stdout, Stderr: ioutil.Discard}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	appMaps, err := createApps([]Environment{{Name: "dev", DNSSuffix: "dev.example.com"}}, testAPIClient(client), "myproj", tsuru.CreateAppOptions{
+		Team:     "myteam",
+		Platform: "python",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = setCNames(appMaps, testAPIClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c projectEnvVarSet
+	c.Flags().Parse(true, []string{"-n", "myproj"})
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOutput := "setting variables in environment \"dev\"... ok\n"
+	if stdout.String() != expectedOutput {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expectedOutput, stdout.String())
+	}
+}
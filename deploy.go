@@ -7,7 +7,11 @@ package main
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/ef-ctx/tsuru-flow/tsuru"
 	"github.com/tsuru/gnuflag"
 	"github.com/tsuru/tsuru-client/tsuru/client"
 	"github.com/tsuru/tsuru/cmd"
@@ -23,13 +27,18 @@ type projectDeploy struct {
 	projectName string
 	envName     string
 	promoteFrom string
+	version     string
 	image       string
+	copyImage   bool
+	guard       approvalGuard
+	freeze      freezeGuard
+	rollout     rolloutCheck
 }
 
 func (c *projectDeploy) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "project-deploy",
-		Usage: "tranor project-deploy -n/--project-name <projectname> -e/--env <environment> [-i/--image dockerimage] [-p/--promote parent-env] [content]",
+		Usage: "tranor project-deploy -n/--project-name <projectname> -e/--env <environment> [-i/--image dockerimage] [-p/--promote parent-env [--version version] [--copy-image]] [--wait timeout [--health-check path] [--smoke-test command] [--auto-rollback]] [--override-freeze reason] [content]",
 		Desc: `deploys a new version of a project. Also used to promote a version from one environment to another
 
 Can deploy the project using one of the following strategies:
@@ -37,6 +46,23 @@ Can deploy the project using one of the following strategies:
  - Content upload: just provide the list of files/directories to deploy as argument
  - Docker image: use the flags -i/--image
  - Promoting from other environment: use the flags -p/--promote
+
+When promoting, the last successful deploy of the parent environment is
+promoted by default. Use --version to promote another deploy, given by its ID,
+image, image tag or git commit. The image is taken from the registry of the
+parent environment. Use --copy-image to copy it to the registry of the target
+environment, checking its digest, and deploy the copy by its digest.
+
+Use --wait to wait for all units of the app to start after the deploy,
+--health-check to also request the given path in the address of the project
+and --smoke-test to also run the given shell command. The command fails if the
+deploy doesn't pass the checks within the given time. With --auto-rollback, a
+deploy that fails the checks is rolled back to the previous successful deploy
+of another image, and the deploy is refused when there is none.
+
+Deploys to environments in a deploy freeze are refused, unless the reason for
+deploying is given with --override-freeze. The reason is recorded in the
+message of the deploy.
 `,
 	}
 }
@@ -46,14 +72,33 @@ func (c *projectDeploy) Run(ctx *cmd.Context, cli *cmd.Client) error {
 	if c.projectName == "" || c.envName == "" {
 		return errors.New("please provide the project name and the environment")
 	}
+	if err := c.rollout.validate(); err != nil {
+		return err
+	}
+	apiClient, err := newAPIClient(cli)
+	if err != nil {
+		return err
+	}
 	// fail soon if project doesn't exist
-	apps, err := projectApps(cli, c.projectName)
+	apps, err := projectApps(apiClient, c.projectName)
 	if err != nil {
 		return err
 	}
+	config, err := loadConfigFile()
+	if err != nil {
+		return errors.New("unable to load environments file, please make sure that tranor is properly configured")
+	}
 
-	appName := fmt.Sprintf("%s-%s", c.projectName, c.envName)
+	appName := config.appName(c.projectName, c.envName)
 	flags := []string{"-a", appName}
+	if c.version != "" && c.promoteFrom == "" {
+		return errors.New("the version can only be specified when promoting from other environment")
+	}
+	if c.copyImage && c.promoteFrom == "" {
+		return errors.New("the image can only be copied when promoting from other environment")
+	}
+	image := c.image
+	var copyFrom string
 	checkEnv := true
 	if len(ctx.Args) > 0 {
 		if c.image != "" || c.promoteFrom != "" {
@@ -65,11 +110,23 @@ func (c *projectDeploy) Run(ctx *cmd.Context, cli *cmd.Client) error {
 		}
 		flags = append(flags, "-i", c.image)
 	} else if c.promoteFrom != "" {
-		promoteFlags, err := c.promoteFlags(c.projectName, c.promoteFrom, cli)
+		if !config.canPromote(c.promoteFrom, c.envName) {
+			return fmt.Errorf("cannot promote from %q to %q, the environment %q can only receive versions from: %s", c.promoteFrom, c.envName, c.envName, strings.Join(config.upstreamEnvs(c.envName), ", "))
+		}
+		promoteFlags, err := c.promoteFlags(config, c.projectName, c.promoteFrom, c.version, apiClient)
 		if err != nil {
 			return err
 		}
-		flags = append(flags, promoteFlags...)
+		image = promoteFlags[1]
+		if c.copyImage {
+			copyFrom = image
+			image, err = copyDestination(config, copyFrom, c.promoteFrom, c.envName)
+			if err != nil {
+				return err
+			}
+		} else {
+			flags = append(flags, "-i", image)
+		}
 		checkEnv = false
 	} else {
 		return errors.New("please specify either the image, parent env or the list of files/directories to upload")
@@ -78,21 +135,158 @@ func (c *projectDeploy) Run(ctx *cmd.Context, cli *cmd.Client) error {
 	if checkEnv && apps[0].Env.Name != c.envName {
 		return fmt.Errorf("can only deploy directly to %q, use -p/--promote to deploy to other environments", apps[0].Env.Name)
 	}
+	message, err := c.freeze.check(ctx.Stderr, config, []string{c.envName})
+	if err != nil {
+		return err
+	}
+	if message != "" {
+		flags = append(flags, "-m", message)
+	}
+	err = c.guard.check(ctx, cli, config, c.projectName, []string{c.envName})
+	if err != nil {
+		return err
+	}
+	var previous tsuru.Deploy
+	if c.rollout.rollback {
+		deploys, err := apiClient.ListDeploys(requestContext, appName, 0)
+		if err != nil {
+			return err
+		}
+		previous, err = previousDeploy(deploys, image)
+		if err != nil {
+			return fmt.Errorf("cannot deploy with --auto-rollback: %s", err)
+		}
+	}
+	if copyFrom != "" && copyFrom != image {
+		if dryRun {
+			fmt.Fprintf(ctx.Stdout, "[dry-run] copy image %q to %q\n", copyFrom, image)
+		} else {
+			fmt.Fprintf(ctx.Stdout, "copying image %q to %q...\n", copyFrom, image)
+			digest, err := copyImage(copyFrom, image)
+			if err != nil {
+				return fmt.Errorf("unable to copy the image: %s", err)
+			}
+			fmt.Fprintf(ctx.Stdout, "image copied (digest %s)\n", digest)
+			// the tag may be moved after the copy, the deploy uses the
+			// image that was copied
+			image, err = pinnedImage(image, digest)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if copyFrom != "" {
+		flags = append(flags, "-i", image)
+	}
+	if dryRun {
+		return dryRunDeploy(cli, appName, image, message, ctx.Args)
+	}
 	tsuruDeployCommand.Flags().Parse(true, flags)
-	return tsuruDeployCommand.Run(ctx, cli)
+	err = tsuruDeployCommand.Run(ctx, cli)
+	if err != nil || !c.rollout.enabled() {
+		return err
+	}
+	a, ok := findAppByEnv(apps, c.envName)
+	if !ok {
+		return fmt.Errorf("project not found in environment %q", c.envName)
+	}
+	err = c.rollout.wait(ctx.Stdout, apiClient, a)
+	if err == nil || !c.rollout.rollback {
+		return err
+	}
+	return c.rollBack(ctx, apiClient, a, previous, message, err)
+}
+
+// rollBack deploys again the image of the deploy that was running before a
+// deploy that failed the rollout checks, checks the rollback and reports both
+// deploys. It always returns an error, as the deploy failed.
+func (c *projectDeploy) rollBack(ctx *cmd.Context, client *tsuru.Client, a app, previous tsuru.Deploy, message string, checkErr error) error {
+	failed, err := client.LastDeploy(requestContext, a.Name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(ctx.Stdout, "%s\nrolling back project %q in %q to %q...\n", checkErr, c.projectName, c.envName, previous.Image)
+	err = client.RollbackDeploy(requestContext, a.Name, previous.Image, message, ctx.Stdout)
+	if err != nil {
+		return fmt.Errorf("%s (the rollback to %q failed: %s)", checkErr, previous.Image, err)
+	}
+	rollback, err := client.LastDeploy(requestContext, a.Name)
+	if err != nil {
+		return err
+	}
+	rollbackErr := c.rollout.wait(ctx.Stdout, client, a)
+	rollbackResult := "ok"
+	if rollbackErr != nil {
+		rollbackResult = "failed: " + rollbackErr.Error()
+	}
+	table := cmd.Table{Headers: cmd.Row{"", "ID", "Image", "Git hash/tag", "Result"}}
+	table.AddRow(cmd.Row{"Deploy", failed.ID, failed.Image, failed.Commit, "failed: " + checkErr.Error()})
+	table.AddRow(cmd.Row{"Rollback", rollback.ID, rollback.Image, rollback.Commit, rollbackResult})
+	fmt.Fprintln(ctx.Stdout)
+	ctx.Stdout.Write(table.Bytes())
+	if rollbackErr != nil {
+		return fmt.Errorf("the deploy to %q failed the checks and so did the rollback to %q", c.envName, previous.Image)
+	}
+	return fmt.Errorf("the deploy to %q failed the checks and was rolled back to %q", c.envName, previous.Image)
 }
 
-func (c *projectDeploy) promoteFlags(projectName, fromEnv string, cli *cmd.Client) ([]string, error) {
-	config, _ := loadConfigFile()
-	originApp := fmt.Sprintf("%s-%s", projectName, fromEnv)
-	d, err := lastDeploy(cli, originApp)
+func (c *projectDeploy) promoteFlags(config *Config, projectName, fromEnv, version string, client *tsuru.Client) ([]string, error) {
+	originApp := config.appName(projectName, fromEnv)
+	deploys, err := client.ListDeploys(requestContext, originApp, 0)
 	if err != nil {
 		return nil, err
 	}
-	if d.Image == "" {
-		return nil, fmt.Errorf("no version running in %q", fromEnv)
+	d, err := promotedDeploy(deploys, fromEnv, version)
+	if err != nil {
+		return nil, err
+	}
+	return []string{"-i", config.imageApp(fromEnv, originApp, d.Image)}, nil
+}
+
+// promotedDeploy finds the deploy to promote in the deploy history of the
+// source environment, sorted from the most recent deploy to the oldest one:
+// the most recent deploy matching the given version, or the last successful
+// deploy when the version is empty.
+func promotedDeploy(deploys []tsuru.Deploy, fromEnv, version string) (tsuru.Deploy, error) {
+	for _, d := range deploys {
+		if version == "" {
+			if d.Error == "" && d.Image != "" {
+				return d, nil
+			}
+			continue
+		}
+		if deployMatches(d, version) {
+			if d.Error != "" {
+				return d, fmt.Errorf("cannot promote version %q, the deploy failed in %q: %s", version, fromEnv, d.Error)
+			}
+			return d, nil
+		}
+	}
+	if version != "" {
+		return tsuru.Deploy{}, fmt.Errorf("version %q not found in the deploys of %q", version, fromEnv)
+	}
+	return tsuru.Deploy{}, fmt.Errorf("no version running in %q", fromEnv)
+}
+
+// previousDeploy returns the last successful deploy of an image other than
+// the one being deployed, which is the deploy restored by --auto-rollback.
+func previousDeploy(deploys []tsuru.Deploy, image string) (tsuru.Deploy, error) {
+	for _, d := range deploys {
+		if d.Error == "" && d.Image != "" && d.Image != image {
+			return d, nil
+		}
+	}
+	return tsuru.Deploy{}, errors.New("there is no previous successful deploy to roll back to")
+}
+
+// deployMatches reports whether the deploy matches the given version, which
+// may be the ID of the deploy, its image, the tag of the image or its git
+// commit, possibly abbreviated to at least 7 characters.
+func deployMatches(d tsuru.Deploy, version string) bool {
+	if d.ID == version || d.Image == version || strings.HasSuffix(d.Image, ":"+version) {
+		return true
 	}
-	return []string{"-i", config.imageApp(originApp, d.Image)}, nil
+	return len(version) >= 7 && strings.HasPrefix(d.Commit, version)
 }
 
 func (c *projectDeploy) Flags() *gnuflag.FlagSet {
@@ -104,41 +298,383 @@ func (c *projectDeploy) Flags() *gnuflag.FlagSet {
 		c.fs.StringVar(&c.envName, "e", "", "environment to deploy to")
 		c.fs.StringVar(&c.promoteFrom, "promote", "", "promote version from the given environment")
 		c.fs.StringVar(&c.promoteFrom, "p", "", "promote version from the given environment")
+		c.fs.StringVar(&c.version, "version", "", "deploy ID, image, image tag or git commit to promote (defaults to the last successful deploy)")
 		c.fs.StringVar(&c.image, "image", "", "Docker image to deploy")
 		c.fs.StringVar(&c.image, "i", "", "Docker image to deploy")
+		c.fs.BoolVar(&c.copyImage, "copy-image", false, "when promoting, copy the image to the registry of the target environment before deploying it")
+		c.guard.addFlags(c.fs)
+		c.freeze.addFlags(c.fs)
+		c.rollout.addFlags(c.fs)
+	}
+	return c.fs
+}
+
+type projectPromote struct {
+	fs          *gnuflag.FlagSet
+	projectName string
+	from        string
+	to          string
+	version     string
+	copyImage   bool
+	guard       approvalGuard
+	freeze      freezeGuard
+	rollout     rolloutCheck
+}
+
+func (c *projectPromote) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "project-promote",
+		Usage: "project-promote -n/--project-name <projectname> --to <environment> [--from <environment>] [--version <version>] [--copy-image] [--wait timeout [--health-check path] [--smoke-test command] [--auto-rollback]] [--override-freeze reason]",
+		Desc: `promotes the version running in the upstream environment to the given environment
+
+The upstream environment is taken from the promotion pipeline defined in the
+remote configuration. When the pipeline is not defined, the version is
+promoted from the environment that precedes the target environment in the
+project.
+
+The last successful deploy of the upstream environment is promoted by default.
+Use --version to promote another deploy, given by its ID, image, image tag or
+git commit. The flags --copy-image, --wait, --health-check, --smoke-test,
+--auto-rollback and --override-freeze work as in project-deploy.`,
+	}
+}
+
+func (c *projectPromote) Run(ctx *cmd.Context, cli *cmd.Client) error {
+	if c.projectName == "" || c.to == "" {
+		return errors.New("please provide the project name and the target environment")
+	}
+	config, err := loadConfigFile()
+	if err != nil {
+		return errors.New("unable to load environments file, please make sure that tranor is properly configured")
+	}
+	apiClient, err := newAPIClient(cli)
+	if err != nil {
+		return err
+	}
+	apps, err := projectApps(apiClient, c.projectName)
+	if err != nil {
+		return err
+	}
+	from := c.from
+	if from == "" {
+		from, err = promotionSource(config, apps, c.to)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(ctx.Stdout, "promoting project %q from %q to %q...\n", c.projectName, from, c.to)
+	deployCmd := projectDeploy{
+		projectName: c.projectName,
+		envName:     c.to,
+		promoteFrom: from,
+		version:     c.version,
+		copyImage:   c.copyImage,
+		guard:       c.guard,
+		freeze:      c.freeze,
+		rollout:     c.rollout,
+	}
+	return deployCmd.Run(&cmd.Context{Stdout: ctx.Stdout, Stderr: ctx.Stderr, Stdin: ctx.Stdin}, cli)
+}
+
+func (c *projectPromote) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("project-promote", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.projectName, "project-name", "", "name of the project to promote")
+		c.fs.StringVar(&c.projectName, "n", "", "name of the project to promote")
+		c.fs.StringVar(&c.to, "to", "", "environment to promote the version to")
+		c.fs.StringVar(&c.from, "from", "", "environment to promote the version from (defaults to the upstream environment)")
+		c.fs.StringVar(&c.version, "version", "", "deploy ID, image, image tag or git commit to promote (defaults to the last successful deploy)")
+		c.fs.BoolVar(&c.copyImage, "copy-image", false, "copy the image to the registry of the target environment before deploying it")
+		c.guard.addFlags(c.fs)
+		c.freeze.addFlags(c.fs)
+		c.rollout.addFlags(c.fs)
 	}
 	return c.fs
 }
 
+// promotionSource finds the environment that the project should be promoted
+// from in order to reach the given environment.
+func promotionSource(config *Config, apps []app, to string) (string, error) {
+	index := -1
+	for i, a := range apps {
+		if a.Env.Name == to {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return "", fmt.Errorf("env %q is not defined in this project", to)
+	}
+	if len(config.Pipeline) == 0 {
+		if index == 0 {
+			return "", fmt.Errorf("there is no environment to promote to %q from", to)
+		}
+		return apps[index-1].Env.Name, nil
+	}
+	var candidates []string
+	for _, envName := range config.upstreamEnvs(to) {
+		for _, a := range apps {
+			if a.Env.Name == envName {
+				candidates = append(candidates, envName)
+				break
+			}
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("there is no environment to promote to %q from", to)
+	case 1:
+		return candidates[0], nil
+	default:
+		return "", fmt.Errorf("multiple environments can be promoted to %q (%s), please specify one with --from", to, strings.Join(candidates, ", "))
+	}
+}
+
+type projectDeployRollback struct {
+	fs          *gnuflag.FlagSet
+	projectName string
+	envName     string
+	to          string
+	guard       approvalGuard
+	freeze      freezeGuard
+}
+
+func (c *projectDeployRollback) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "project-deploy-rollback",
+		Usage: "project-deploy-rollback -n/--project-name <projectname> -e/--env <environment> [--to <version>] [--override-freeze reason]",
+		Desc: `rolls back the project in the given environment to a previous version
+
+Without --to, the project is rolled back to the image deployed before the
+current one, skipping failed deploys. The target given in --to may be the ID of
+a deploy, the name of an image, just its tag (like v3) or a git commit.
+
+Rollbacks in environments in a deploy freeze are refused, unless the reason
+for the rollback is given with --override-freeze. The reason is recorded in
+the message of the deploy.`,
+	}
+}
+
+func (c *projectDeployRollback) Run(ctx *cmd.Context, cli *cmd.Client) error {
+	if c.projectName == "" || c.envName == "" {
+		return errors.New("please provide the project name and the environment")
+	}
+	config, err := loadConfigFile()
+	if err != nil {
+		return errors.New("unable to load environments file, please make sure that tranor is properly configured")
+	}
+	apiClient, err := newAPIClient(cli)
+	if err != nil {
+		return err
+	}
+	appName := config.appName(c.projectName, c.envName)
+	deploys, err := apiClient.ListDeploys(requestContext, appName, 0)
+	if err != nil {
+		if tsuru.IsNotFound(err) {
+			return fmt.Errorf("project %q not found in environment %q", c.projectName, c.envName)
+		}
+		return err
+	}
+	image, err := rollbackImage(deploys, c.to)
+	if err != nil {
+		return err
+	}
+	message, err := c.freeze.check(ctx.Stderr, config, []string{c.envName})
+	if err != nil {
+		return err
+	}
+	err = c.guard.check(ctx, cli, config, c.projectName, []string{c.envName})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(ctx.Stdout, "rolling back project %q in %q to %q...\n", c.projectName, c.envName, image)
+	return apiClient.RollbackDeploy(requestContext, appName, image, message, ctx.Stdout)
+}
+
+func (c *projectDeployRollback) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("project-deploy-rollback", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.projectName, "project-name", "", "name of the project to roll back")
+		c.fs.StringVar(&c.projectName, "n", "", "name of the project to roll back")
+		c.fs.StringVar(&c.envName, "env", "", "environment to roll back")
+		c.fs.StringVar(&c.envName, "e", "", "environment to roll back")
+		c.fs.StringVar(&c.to, "to", "", "deploy ID, image, image tag or git commit to roll back to (defaults to the previous image)")
+		c.guard.addFlags(c.fs)
+		c.freeze.addFlags(c.fs)
+	}
+	return c.fs
+}
+
+// rollbackImage finds the image to roll back to in the deploys of an app,
+// from the most recent one, skipping failed deploys.
+func rollbackImage(deploys []tsuru.Deploy, to string) (string, error) {
+	var current string
+	for _, d := range deploys {
+		if to != "" && d.ID == to && d.Error != "" {
+			return "", fmt.Errorf("cannot roll back to deploy %q, it failed: %s", to, d.Error)
+		}
+		if d.Error != "" || d.Image == "" {
+			continue
+		}
+		if to != "" {
+			if deployMatches(d, to) {
+				return d.Image, nil
+			}
+			continue
+		}
+		if current == "" {
+			current = d.Image
+		} else if d.Image != current {
+			return d.Image, nil
+		}
+	}
+	if to != "" {
+		return "", fmt.Errorf("no successful deploy matching %q", to)
+	}
+	return "", errors.New("there is no previous successful deploy to roll back to")
+}
+
 type projectDeployList struct {
 	fs          *gnuflag.FlagSet
 	projectName string
 	envName     string
+	output      outputFormat
 }
 
 func (c *projectDeployList) Info() *cmd.Info {
 	return &cmd.Info{
 		Name: "project-deploy-list",
-		Desc: "lists all deployments of the project in the given environment",
+		Desc: "lists all deployments of the project in the given environment, or in all environments when no environment is given",
 	}
 }
 
 func (c *projectDeployList) Run(ctx *cmd.Context, cli *cmd.Client) error {
-	if c.projectName == "" || c.envName == "" {
-		return errors.New("please provide the project name and the environment")
+	if c.projectName == "" {
+		return errors.New("please provide the name of the project")
+	}
+	err := c.output.validate()
+	if err != nil {
+		return err
+	}
+	config, err := loadConfigFile()
+	if err != nil {
+		return errors.New("unable to load environments file, please make sure that tranor is properly configured")
+	}
+	if c.envName == "" {
+		return c.timeline(ctx, cli, config)
+	}
+	appName := config.appName(c.projectName, c.envName)
+	if c.output.structured() {
+		apiClient, err := newAPIClient(cli)
+		if err != nil {
+			return err
+		}
+		deploys, err := apiClient.ListDeploys(requestContext, appName, 0)
+		if err != nil {
+			return err
+		}
+		if deploys == nil {
+			deploys = []tsuru.Deploy{}
+		}
+		return c.output.render(ctx.Stdout, deploysOutput{Env: c.envName, App: appName, Deploys: deploys})
 	}
-	appName := fmt.Sprintf("%s-%s", c.projectName, c.envName)
 	tsuruDeployListCommand.Flags().Parse(true, []string{"-a", appName})
 	return tsuruDeployListCommand.Run(ctx, cli)
 }
 
+// timeline displays the deploys of the project in all environments, from the
+// most recent to the oldest one.
+func (c *projectDeployList) timeline(ctx *cmd.Context, cli *cmd.Client, config *Config) error {
+	apiClient, err := newAPIClient(cli)
+	if err != nil {
+		return err
+	}
+	apps, err := projectApps(apiClient, c.projectName)
+	if err != nil {
+		return err
+	}
+	deploys := make([][]tsuru.Deploy, len(apps))
+	errs := runConcurrently(len(apps), func(i int) error {
+		var err error
+		deploys[i], err = apiClient.ListDeploys(requestContext, apps[i].Name, 0)
+		return err
+	}, nil)
+	if err = firstError(errs); err != nil {
+		return err
+	}
+	entries := []timelineOutput{}
+	for i, a := range apps {
+		for _, d := range deploys[i] {
+			entries = append(entries, timelineOutput{
+				Env:          a.Env.Name,
+				App:          a.Name,
+				PromotedFrom: promotedFrom(config, apps, a, d),
+				Deploy:       d,
+			})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.After(entries[j].Timestamp)
+	})
+	if c.output.structured() {
+		return c.output.render(ctx.Stdout, entries)
+	}
+	table := cmd.Table{Headers: cmd.Row{"Date", "Environment", "Image", "Commit", "User", "Duration", "Status", "Notes"}}
+	for _, entry := range entries {
+		status := "ok"
+		if entry.Error != "" {
+			status = "failed: " + entry.Error
+		}
+		var notes []string
+		if entry.PromotedFrom != "" {
+			notes = append(notes, fmt.Sprintf("promoted from %q", entry.PromotedFrom))
+		}
+		if entry.Origin == "rollback" {
+			notes = append(notes, "rollback")
+		}
+		var duration string
+		if entry.Duration > 0 {
+			duration = entry.Duration.Round(time.Second).String()
+		}
+		table.AddRow(cmd.Row{
+			entry.Timestamp.Local().Format(time.RFC1123),
+			entry.Env,
+			entry.Image,
+			entry.Commit,
+			entry.User,
+			duration,
+			status,
+			strings.Join(notes, ", "),
+		})
+	}
+	ctx.Stdout.Write(table.Bytes())
+	return nil
+}
+
+// promotedFrom returns the environment where the image of a promoted deploy
+// was built (see projectDeploy.promoteFlags).
+func promotedFrom(config *Config, apps []app, deployed app, d tsuru.Deploy) string {
+	for _, a := range apps {
+		if a.Name == deployed.Name {
+			continue
+		}
+		// the image may have been copied to the registry of the
+		// environment it was promoted to.
+		if strings.HasPrefix(d.Image, config.imageApp(a.Env.Name, a.Name, "")) || strings.HasPrefix(d.Image, config.imageApp(deployed.Env.Name, a.Name, "")) {
+			return a.Env.Name
+		}
+	}
+	return ""
+}
+
 func (c *projectDeployList) Flags() *gnuflag.FlagSet {
 	if c.fs == nil {
 		c.fs = gnuflag.NewFlagSet("project-deploy", gnuflag.ExitOnError)
 		c.fs.StringVar(&c.projectName, "project-name", "", "name of the project to deploy to")
 		c.fs.StringVar(&c.projectName, "n", "", "name of the project to deploy to")
-		c.fs.StringVar(&c.envName, "env", "", "environment to deploy to")
-		c.fs.StringVar(&c.envName, "e", "", "environment to deploy to")
+		c.fs.StringVar(&c.envName, "env", "", "environment to list the deploys (defaults to all environments)")
+		c.fs.StringVar(&c.envName, "e", "", "environment to list the deploys (defaults to all environments)")
+		c.output.addFlags(c.fs)
 	}
 	return c.fs
 }
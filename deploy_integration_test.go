@@ -5,10 +5,18 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/ef-ctx/tsuru-flow/registry/registrytest"
+	"github.com/ef-ctx/tsuru-flow/tsuru"
 	"github.com/tsuru/tsuru-client/tsuru/client"
 	"github.com/tsuru/tsuru/cmd"
 )
@@ -126,6 +134,24 @@ func TestProjectDeployErrors(t *testing.T) {
 			nil,
 			`no version running in "dev"`,
 		},
+		{
+			"version without promotion",
+			[]string{"-n", "myproj", "-e", "dev", "--version", "v1"},
+			[]string{"."},
+			"the version can only be specified when promoting from other environment",
+		},
+		{
+			"copy image without promotion",
+			[]string{"-n", "myproj", "-e", "dev", "-i", "some/image", "--copy-image"},
+			nil,
+			"the image can only be copied when promoting from other environment",
+		},
+		{
+			"health check without wait",
+			[]string{"-n", "myproj", "-e", "dev", "--health-check", "/healthcheck"},
+			[]string{"."},
+			"please provide the time to wait for the checks with --wait",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.testCase, func(t *testing.T) {
@@ -144,6 +170,220 @@ func TestProjectDeployErrors(t *testing.T) {
 	}
 }
 
+func TestProjectDeployWait(t *testing.T) {
+	cleanup := createTestProject("myproj", t)
+	oldCommand := tsuruDeployCommand
+	oldInterval := rolloutPollInterval
+	rolloutPollInterval = 10 * time.Millisecond
+	defer func() {
+		tsuruDeployCommand = oldCommand
+		rolloutPollInterval = oldInterval
+		cleanup()
+	}()
+	tsuruDeployCommand = &fakeTsuruCommand{FlaggedCommand: &client.AppDeploy{}}
+	var stdout bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stdout}
+	cli := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	var c projectDeploy
+	c.Flags().Parse(true, []string{"-n", "myproj", "-e", "dev", "-i", "some/image", "--wait", "100ms"})
+	err := c.Run(&ctx, cli)
+	expectedErr := `the deploy to "dev" didn't roll out in 100ms: the app has no units`
+	if err == nil || err.Error() != expectedErr {
+		t.Errorf("wrong error\nwant %q\ngot  %v", expectedErr, err)
+	}
+	err = testAPIClient(cli).AddUnits(requestContext, "myproj-dev", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	err = c.Run(&ctx, cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOutput := "waiting for the units of \"myproj-dev\" to start...\nall units started\n"
+	if stdout.String() != expectedOutput {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expectedOutput, stdout.String())
+	}
+}
+
+func TestProjectDeployPromoteCopyImage(t *testing.T) {
+	cleanup := createTestProject("myproj", t)
+	oldCommand := tsuruDeployCommand
+	defer func() {
+		tsuruDeployCommand = oldCommand
+		cleanup()
+	}()
+	stageRegistry := registrytest.NewServer("", "")
+	defer stageRegistry.Stop()
+	prodRegistry := registrytest.NewServer("", "")
+	defer prodRegistry.Stop()
+	setupEnvConstraints(t, func(envs []Environment) {
+		envs[2].Registry = stageRegistry.Addr()
+		envs[3].Registry = prodRegistry.Addr()
+	})
+	digest := stageRegistry.AddImage("tsuru/app-myproj-stage", "v3", []byte("layer 1"), []byte("layer 2"))
+	addDeploy(t, "myproj-stage", tsuru.Deploy{ID: "1", Image: "v3"})
+	fakeCommand := fakeTsuruCommand{FlaggedCommand: &client.AppDeploy{}}
+	tsuruDeployCommand = &fakeCommand
+	var stdout bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stdout}
+	var c projectDeploy
+	c.Flags().Parse(true, []string{"-n", "myproj", "-e", "prod", "-p", "stage", "--copy-image"})
+	err := c.Run(&ctx, cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcImage := stageRegistry.Addr() + "/tsuru/app-myproj-stage:v3"
+	dstImage := prodRegistry.Addr() + "/tsuru/app-myproj-stage:v3"
+	deployedImage := prodRegistry.Addr() + "/tsuru/app-myproj-stage@" + digest
+	expectedFlags := map[string]string{
+		"a":     "myproj-prod",
+		"app":   "myproj-prod",
+		"i":     deployedImage,
+		"image": deployedImage,
+	}
+	if flags := fakeCommand.inputFlags(); !reflect.DeepEqual(flags, expectedFlags) {
+		t.Errorf("wrong flags sent to app-deploy\ngot  %#v\nwant %#v", flags, expectedFlags)
+	}
+	if got := prodRegistry.Digest("tsuru/app-myproj-stage", "v3"); got != digest {
+		t.Errorf("wrong digest of the copied image\nwant %q\ngot  %q", digest, got)
+	}
+	expectedOutput := fmt.Sprintf("copying image %q to %q...\nimage copied (digest %s)\n", srcImage, dstImage, digest)
+	if stdout.String() != expectedOutput {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expectedOutput, stdout.String())
+	}
+}
+
+func TestProjectDeployPromoteCopyImageNotFound(t *testing.T) {
+	cleanup := createTestProject("myproj", t)
+	oldCommand := tsuruDeployCommand
+	defer func() {
+		tsuruDeployCommand = oldCommand
+		cleanup()
+	}()
+	stageRegistry := registrytest.NewServer("", "")
+	defer stageRegistry.Stop()
+	prodRegistry := registrytest.NewServer("", "")
+	defer prodRegistry.Stop()
+	setupEnvConstraints(t, func(envs []Environment) {
+		envs[2].Registry = stageRegistry.Addr()
+		envs[3].Registry = prodRegistry.Addr()
+	})
+	addDeploy(t, "myproj-stage", tsuru.Deploy{ID: "1", Image: "v3"})
+	fakeCommand := fakeTsuruCommand{FlaggedCommand: &client.AppDeploy{}}
+	tsuruDeployCommand = &fakeCommand
+	var stdout bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stdout}
+	var c projectDeploy
+	c.Flags().Parse(true, []string{"-n", "myproj", "-e", "prod", "-p", "stage", "--copy-image"})
+	err := c.Run(&ctx, cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{}))
+	expectedErr := "unable to copy the image: registry " + stageRegistry.Addr() + " responded with status 404: manifest unknown"
+	if err == nil || err.Error() != expectedErr {
+		t.Errorf("wrong error\nwant %q\ngot  %v", expectedErr, err)
+	}
+	if fakeCommand.called {
+		t.Error("the image should not be deployed")
+	}
+}
+
+// hookedTsuruCommand is a fake tsuru command that calls hook when it runs.
+type hookedTsuruCommand struct {
+	fakeTsuruCommand
+	hook func()
+}
+
+func (c *hookedTsuruCommand) Run(ctx *cmd.Context, cli *cmd.Client) error {
+	c.hook()
+	return c.fakeTsuruCommand.Run(ctx, cli)
+}
+
+func TestProjectDeployAutoRollback(t *testing.T) {
+	cleanup := createTestProject("myproj", t)
+	oldCommand := tsuruDeployCommand
+	oldInterval := rolloutPollInterval
+	rolloutPollInterval = 10 * time.Millisecond
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		tsuruDeployCommand = oldCommand
+		rolloutPollInterval = oldInterval
+		os.RemoveAll(dir)
+		cleanup()
+	}()
+	addDeploy(t, "myproj-dev", tsuru.Deploy{
+		ID:     "1",
+		Image:  "docker-registry.example.com/tsuru/app-myproj-dev:v1",
+		Commit: "40244ff",
+	})
+	tsuruDeployCommand = &hookedTsuruCommand{
+		fakeTsuruCommand: fakeTsuruCommand{FlaggedCommand: &client.AppDeploy{}},
+		hook: func() {
+			addDeploy(t, "myproj-dev", tsuru.Deploy{
+				ID:     "2",
+				Image:  "docker-registry.example.com/tsuru/app-myproj-dev:v2",
+				Commit: "5d1e9b0",
+			})
+		},
+	}
+	var stdout bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stdout}
+	cli := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = testAPIClient(cli).AddUnits(requestContext, "myproj-dev", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the smoke test fails in the first run, and passes in the next ones.
+	smokeTest := fmt.Sprintf("test -f %[1]s/ran || { touch %[1]s/ran; exit 1; }", dir)
+	var c projectDeploy
+	c.Flags().Parse(true, []string{"-n", "myproj", "-e", "dev", "-i", "some/image", "--wait", "1s", "--smoke-test", smokeTest, "--auto-rollback"})
+	err = c.Run(&ctx, cli)
+	expectedErr := `the deploy to "dev" failed the checks and was rolled back to "docker-registry.example.com/tsuru/app-myproj-dev:v1"`
+	if err == nil || err.Error() != expectedErr {
+		t.Errorf("wrong error\nwant %q\ngot  %v", expectedErr, err)
+	}
+	checkErr := `the deploy to "dev" didn't pass the smoke test: exit status 1`
+	table := cmd.Table{Headers: cmd.Row{"", "ID", "Image", "Git hash/tag", "Result"}}
+	table.AddRow(cmd.Row{"Deploy", "2", "docker-registry.example.com/tsuru/app-myproj-dev:v2", "5d1e9b0", "failed: " + checkErr})
+	table.AddRow(cmd.Row{"Rollback", "3", "docker-registry.example.com/tsuru/app-myproj-dev:v1", "", "ok"})
+	checks := "waiting for the units of \"myproj-dev\" to start...\nall units started\nrunning the smoke test...\n"
+	expectedOutput := checks + checkErr + "\n" +
+		`rolling back project "myproj" in "dev" to "docker-registry.example.com/tsuru/app-myproj-dev:v1"...` + "\n" +
+		"rolling back to docker-registry.example.com/tsuru/app-myproj-dev:v1\n" +
+		checks + "smoke test passed\n\n" + table.String()
+	if stdout.String() != expectedOutput {
+		t.Errorf("wrong output\nwant:\n%s\ngot:\n%s", expectedOutput, stdout.String())
+	}
+}
+
+func TestProjectDeployAutoRollbackWithoutPreviousDeploy(t *testing.T) {
+	cleanup := createTestProject("myproj", t)
+	oldCommand := tsuruDeployCommand
+	oldInterval := rolloutPollInterval
+	rolloutPollInterval = 10 * time.Millisecond
+	defer func() {
+		tsuruDeployCommand = oldCommand
+		rolloutPollInterval = oldInterval
+		cleanup()
+	}()
+	addDeploy(t, "myproj-dev", tsuru.Deploy{ID: "1", Image: "some/image"})
+	fakeCommand := fakeTsuruCommand{FlaggedCommand: &client.AppDeploy{}}
+	tsuruDeployCommand = &fakeCommand
+	var stdout bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stdout}
+	var c projectDeploy
+	c.Flags().Parse(true, []string{"-n", "myproj", "-e", "dev", "-i", "some/image", "--wait", "50ms", "--auto-rollback"})
+	err := c.Run(&ctx, cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{}))
+	expectedErr := "cannot deploy with --auto-rollback: there is no previous successful deploy to roll back to"
+	if err == nil || err.Error() != expectedErr {
+		t.Errorf("wrong error\nwant %q\ngot  %v", expectedErr, err)
+	}
+	if fakeCommand.called {
+		t.Error("the image should not be deployed")
+	}
+}
+
 func createTestProject(name string, t *testing.T) func() {
 	tsuruServer.reset()
 	cleanup, err := setupFakeConfig(tsuruServer.url(), tsuruServer.token())
@@ -155,7 +395,7 @@ func createTestProject(name string, t *testing.T) func() {
 		t.Fatal(err)
 	}
 	client := cmd.NewClient(http.DefaultClient, &cmd.Context{}, &cmd.Manager{})
-	apps, err := createApps(config.Environments, client, name, createAppOptions{
+	apps, err := createApps(config.Environments, testAPIClient(client), name, tsuru.CreateAppOptions{
 		Plan:        "medium",
 		Description: "some project",
 		Team:        "myteam",
@@ -164,9 +404,107 @@ func createTestProject(name string, t *testing.T) func() {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = setCNames(apps, client, name)
+	err = setCNames(apps, testAPIClient(client))
 	if err != nil {
 		t.Fatal(err)
 	}
 	return cleanup
 }
+
+func addTimelineDeploys(t *testing.T) time.Time {
+	date := time.Date(2018, 1, 10, 10, 0, 0, 0, time.UTC)
+	addDeploy(t, "proj1-dev", tsuru.Deploy{
+		ID:        "1",
+		Image:     "docker-registry.example.com/tsuru/app-proj1-dev:v1",
+		Commit:    "40244ff",
+		User:      "dev@example.com",
+		Duration:  90 * time.Second,
+		Timestamp: date,
+	})
+	addDeploy(t, "proj1-dev", tsuru.Deploy{
+		ID:        "2",
+		Image:     "docker-registry.example.com/tsuru/app-proj1-dev:v2",
+		Commit:    "5d1e9b0",
+		User:      "dev@example.com",
+		Duration:  30 * time.Second,
+		Timestamp: date.Add(time.Hour),
+		Error:     "build failed",
+	})
+	addDeploy(t, "proj1-qa", tsuru.Deploy{
+		ID:        "3",
+		Image:     "docker-registry.example.com/tsuru/app-proj1-dev:v1",
+		User:      "qa@example.com",
+		Duration:  20 * time.Second,
+		Timestamp: date.Add(2 * time.Hour),
+		Origin:    "image",
+	})
+	addDeploy(t, "proj1-prod", tsuru.Deploy{
+		ID:        "4",
+		Image:     "docker-registry.example.com/tsuru/app-proj1-prod:v1",
+		User:      "ops@example.com",
+		Duration:  10 * time.Second,
+		Timestamp: date.Add(3 * time.Hour),
+		Origin:    "rollback",
+	})
+	return date
+}
+
+func TestProjectDeployListTimeline(t *testing.T) {
+	cleanup := createTestProject("proj1", t)
+	defer cleanup()
+	date := addTimelineDeploys(t)
+	var c projectDeployList
+	err := c.Flags().Parse(true, []string{"-n", "proj1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := func(d time.Time) string {
+		return d.Local().Format(time.RFC1123)
+	}
+	table := cmd.Table{Headers: cmd.Row{"Date", "Environment", "Image", "Commit", "User", "Duration", "Status", "Notes"}}
+	table.AddRow(cmd.Row{format(date.Add(3 * time.Hour)), "prod", "docker-registry.example.com/tsuru/app-proj1-prod:v1", "", "ops@example.com", "10s", "ok", "rollback"})
+	table.AddRow(cmd.Row{format(date.Add(2 * time.Hour)), "qa", "docker-registry.example.com/tsuru/app-proj1-dev:v1", "", "qa@example.com", "20s", "ok", `promoted from "dev"`})
+	table.AddRow(cmd.Row{format(date.Add(time.Hour)), "dev", "docker-registry.example.com/tsuru/app-proj1-dev:v2", "5d1e9b0", "dev@example.com", "30s", "failed: build failed", ""})
+	table.AddRow(cmd.Row{format(date), "dev", "docker-registry.example.com/tsuru/app-proj1-dev:v1", "40244ff", "dev@example.com", "1m30s", "ok", ""})
+	if expected := table.String(); stdout.String() != expected {
+		t.Errorf("wrong output\nwant:\n%s\ngot:\n%s", expected, stdout.String())
+	}
+}
+
+func TestProjectDeployListTimelineJSON(t *testing.T) {
+	cleanup := createTestProject("proj1", t)
+	defer cleanup()
+	addTimelineDeploys(t)
+	var c projectDeployList
+	err := c.Flags().Parse(true, []string{"-n", "proj1", "--format", "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []timelineOutput
+	err = json.Unmarshal(stdout.Bytes(), &entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.ID+" "+entry.Env+" "+entry.App+" "+entry.PromotedFrom)
+	}
+	expected := []string{"4 prod proj1-prod ", "3 qa proj1-qa dev", "2 dev proj1-dev ", "1 dev proj1-dev "}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong entries\nwant %#v\ngot  %#v", expected, got)
+	}
+}
@@ -5,11 +5,14 @@
 package main
 
 import (
+	"bytes"
 	"net/http"
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/ef-ctx/tsuru-flow/tsuru"
 	"github.com/tsuru/gnuflag"
 	"github.com/tsuru/tsuru-client/tsuru/client"
 	"github.com/tsuru/tsuru/cmd"
@@ -41,7 +44,7 @@ func TestProjectDeployPromoteFlags(t *testing.T) {
 	})
 	fakeServer.prepareResponse(preparedResponse{
 		method:  http.MethodGet,
-		path:    "/deploys?limit=1&app=proj1-dev",
+		path:    "/deploys?app=proj1-dev",
 		code:    http.StatusOK,
 		payload: []byte(deployments),
 	})
@@ -102,7 +105,7 @@ func TestProjectDeployPromoteFailToGetLastDeploy(t *testing.T) {
 	})
 	fakeServer.prepareResponse(preparedResponse{
 		method:  http.MethodGet,
-		path:    "/deploys?limit=1&app=proj1-dev",
+		path:    "/deploys?app=proj1-dev",
 		code:    http.StatusInternalServerError,
 		payload: []byte("something went wrong"),
 	})
@@ -121,15 +124,236 @@ func TestProjectDeployPromoteFailToGetLastDeploy(t *testing.T) {
 	}
 }
 
+func TestProjectDeployPromoteSkippingPipelineEdge(t *testing.T) {
+	fakeServer := newPromotionFakeServer(t, "proj1", "dev")
+	defer fakeServer.stop()
+	cleanup, err := setupFakeConfig(fakeServer.url(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	err = setupFakePipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c projectDeploy
+	ctx := cmd.Context{}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	c.Flags().Parse(true, []string{"-n", "proj1", "-e", "prod", "-p", "dev"})
+	err = c.Run(&ctx, client)
+	if err == nil {
+		t.Fatal("unexpected <nil> error")
+	}
+	expectedMsg := `cannot promote from "dev" to "prod", the environment "prod" can only receive versions from: stage`
+	if err.Error() != expectedMsg {
+		t.Errorf("wrong error message\nwant %q\ngot  %q", expectedMsg, err.Error())
+	}
+}
+
+func TestProjectPromote(t *testing.T) {
+	fakeServer := newPromotionFakeServer(t, "proj1", "qa")
+	defer fakeServer.stop()
+	cleanup, err := setupFakeConfig(fakeServer.url(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = setupFakePipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldCommand := tsuruDeployCommand
+	fakeCommand := fakeTsuruCommand{FlaggedCommand: &client.AppDeploy{}}
+	tsuruDeployCommand = &fakeCommand
+	defer func() {
+		tsuruDeployCommand = oldCommand
+		cleanup()
+	}()
+	var c projectPromote
+	var stdout bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	c.Flags().Parse(true, []string{"-n", "proj1", "--to", "stage"})
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedFlags := map[string]string{
+		"a":     "proj1-stage",
+		"app":   "proj1-stage",
+		"i":     "docker-registry.example.com/tsuru/app-proj1-qa:v938",
+		"image": "docker-registry.example.com/tsuru/app-proj1-qa:v938",
+	}
+	if flags := fakeCommand.inputFlags(); !reflect.DeepEqual(flags, expectedFlags) {
+		t.Errorf("wrong flags used\nwant %#v\ngot  %#v", expectedFlags, flags)
+	}
+	expectedOutput := "promoting project \"proj1\" from \"qa\" to \"stage\"...\n"
+	if stdout.String() != expectedOutput {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expectedOutput, stdout.String())
+	}
+}
+
+func TestProjectPromoteVersion(t *testing.T) {
+	var tests = []struct {
+		testCase string
+		version  string
+		image    string
+		errMsg   string
+	}{
+		{"git commit", "40244ff2866e", "docker-registry.example.com/tsuru/app-proj1-qa:v938", ""},
+		{"deploy ID", "57ccc9490640fd3def98b157", "docker-registry.example.com/tsuru/app-proj1-qa:v938", ""},
+		{"unknown version", "v937", "", `version "v937" not found in the deploys of "qa"`},
+	}
+	for _, test := range tests {
+		t.Run(test.testCase, func(t *testing.T) {
+			fakeServer := newPromotionFakeServer(t, "proj1", "qa")
+			defer fakeServer.stop()
+			cleanup, err := setupFakeConfig(fakeServer.url(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cleanup()
+			oldCommand := tsuruDeployCommand
+			fakeCommand := fakeTsuruCommand{FlaggedCommand: &client.AppDeploy{}}
+			tsuruDeployCommand = &fakeCommand
+			defer func() { tsuruDeployCommand = oldCommand }()
+			var c projectPromote
+			var stdout bytes.Buffer
+			ctx := cmd.Context{Stdout: &stdout}
+			client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+			c.Flags().Parse(true, []string{"-n", "proj1", "--to", "stage", "--version", test.version})
+			err = c.Run(&ctx, client)
+			if test.errMsg != "" {
+				if err == nil || err.Error() != test.errMsg {
+					t.Errorf("wrong error\nwant %q\ngot  %v", test.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image := fakeCommand.inputFlags()["image"]; image != test.image {
+				t.Errorf("wrong image\nwant %q\ngot  %q", test.image, image)
+			}
+		})
+	}
+}
+
+func TestPromotedDeploy(t *testing.T) {
+	deploys := []tsuru.Deploy{
+		{ID: "4", Image: "v4", Commit: "9f8e7d6c5b4a", Error: "health check failed"},
+		{ID: "3", Image: "v3", Commit: "1a2b3c4d5e6f"},
+		{ID: "2", Image: "v2", Commit: "0f1e2d3c4b5a"},
+		{ID: "1", Image: "v1", Error: "build failed"},
+	}
+	var tests = []struct {
+		testCase string
+		deploys  []tsuru.Deploy
+		version  string
+		expected string
+		errMsg   string
+	}{
+		{"last successful deploy", deploys, "", "3", ""},
+		{"deploy ID", deploys, "2", "2", ""},
+		{"image tag", deploys, "v2", "2", ""},
+		{"abbreviated commit", deploys, "0f1e2d3", "2", ""},
+		{"full commit", deploys, "1a2b3c4d5e6f", "3", ""},
+		{"commit too short", deploys, "0f1e", "", `version "0f1e" not found in the deploys of "qa"`},
+		{"failed deploy", deploys, "v4", "", `cannot promote version "v4", the deploy failed in "qa": health check failed`},
+		{"no successful deploys", []tsuru.Deploy{deploys[3]}, "", "", `no version running in "qa"`},
+		{"no deploys", nil, "", "", `no version running in "qa"`},
+	}
+	for _, test := range tests {
+		t.Run(test.testCase, func(t *testing.T) {
+			d, err := promotedDeploy(test.deploys, "qa", test.version)
+			if test.errMsg != "" {
+				if err == nil || err.Error() != test.errMsg {
+					t.Errorf("wrong error\nwant %q\ngot  %v", test.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.ID != test.expected {
+				t.Errorf("wrong deploy\nwant %q\ngot  %q", test.expected, d.ID)
+			}
+		})
+	}
+}
+
+func TestProjectPromoteMissingParams(t *testing.T) {
+	var c projectPromote
+	c.Flags().Parse(true, []string{"-n", "proj1"})
+	err := c.Run(&cmd.Context{}, nil)
+	if err == nil {
+		t.Fatal("unexpected <nil> error")
+	}
+	expectedMsg := "please provide the project name and the target environment"
+	if err.Error() != expectedMsg {
+		t.Errorf("wrong error message\nwant %q\ngot  %q", expectedMsg, err.Error())
+	}
+}
+
+func TestPromotionSource(t *testing.T) {
+	apps := []app{
+		{Env: Environment{Name: "dev"}},
+		{Env: Environment{Name: "qa"}},
+		{Env: Environment{Name: "hotfix"}},
+		{Env: Environment{Name: "prod"}},
+	}
+	envs := []Environment{{Name: "dev"}, {Name: "qa"}, {Name: "hotfix"}, {Name: "prod"}}
+	pipeline := []Promotion{
+		{From: "dev", To: "qa"},
+		{From: "qa", To: "prod"},
+		{From: "hotfix", To: "prod"},
+	}
+	var tests = []struct {
+		testCase string
+		config   Config
+		apps     []app
+		to       string
+		expected string
+		errMsg   string
+	}{
+		{"no pipeline", Config{Environments: envs}, apps, "hotfix", "qa", ""},
+		{"no pipeline, first env", Config{Environments: envs}, apps, "dev", "", `there is no environment to promote to "dev" from`},
+		{"env not in project", Config{Environments: envs}, apps[:2], "prod", "", `env "prod" is not defined in this project`},
+		{"single upstream", Config{Environments: envs, Pipeline: pipeline}, apps, "qa", "dev", ""},
+		{"multiple upstreams", Config{Environments: envs, Pipeline: pipeline}, apps, "prod", "", `multiple environments can be promoted to "prod" (qa, hotfix), please specify one with --from`},
+		{"single upstream in project", Config{Environments: envs, Pipeline: pipeline}, []app{apps[0], apps[1], apps[3]}, "prod", "qa", ""},
+		{"no upstream", Config{Environments: envs, Pipeline: pipeline}, apps, "hotfix", "", `there is no environment to promote to "hotfix" from`},
+	}
+	for _, test := range tests {
+		t.Run(test.testCase, func(t *testing.T) {
+			got, err := promotionSource(&test.config, test.apps, test.to)
+			var errMsg string
+			if err != nil {
+				errMsg = err.Error()
+			}
+			if errMsg != test.errMsg {
+				t.Errorf("wrong error\nwant %q\ngot  %q", test.errMsg, errMsg)
+			}
+			if got != test.expected {
+				t.Errorf("wrong source env\nwant %q\ngot  %q", test.expected, got)
+			}
+		})
+	}
+}
+
 func TestProjectDeployListFlags(t *testing.T) {
 	oldCommand := tsuruDeployListCommand
 	defer func() { tsuruDeployListCommand = oldCommand }()
 	fakeCommand := fakeTsuruCommand{FlaggedCommand: &client.AppDeployList{}}
 	tsuruDeployListCommand = &fakeCommand
+	cleanup, err := setupFakeConfig("http://localhost", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
 	var c projectDeployList
 	ctx := cmd.Context{}
 	c.Flags().Parse(true, []string{"-n", "myproj", "-e", "dev"})
-	err := c.Run(&ctx, nil)
+	err = c.Run(&ctx, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -153,13 +377,13 @@ func TestProjectDeployListMissingParams(t *testing.T) {
 			"missing project name",
 			nil,
 			nil,
-			"please provide the project name and the environment",
+			"please provide the name of the project",
 		},
 		{
-			"missing env name",
-			[]string{"-n", "myproj"},
+			"missing project name with env name",
+			[]string{"-e", "dev"},
 			nil,
-			"please provide the project name and the environment",
+			"please provide the name of the project",
 		},
 	}
 	for _, test := range tests {
@@ -212,3 +436,279 @@ func (c *fakeTsuruCommand) inputFlags() map[string]string {
 	}
 	return r
 }
+
+func newPromotionFakeServer(t *testing.T, projectName, sourceEnv string) *fakeServer {
+	fakeServer := newFakeServer(t)
+	fakeServer.prepareResponse(preparedResponse{
+		method:  http.MethodGet,
+		path:    "/apps?name=" + url.QueryEscape("^"+projectName),
+		code:    http.StatusOK,
+		payload: []byte(listOfApps),
+	})
+	for _, envName := range []string{"dev", "qa", "stage", "prod"} {
+		fakeServer.prepareResponse(preparedResponse{
+			method:  http.MethodGet,
+			path:    "/apps/" + projectName + "-" + envName,
+			code:    http.StatusOK,
+			payload: []byte(appInfo1),
+		})
+	}
+	fakeServer.prepareResponse(preparedResponse{
+		method:  http.MethodGet,
+		path:    "/deploys?app=" + projectName + "-" + sourceEnv,
+		code:    http.StatusOK,
+		payload: []byte(deployments),
+	})
+	return fakeServer
+}
+
+func setupFakePipeline() error {
+	config, err := loadConfigFile()
+	if err != nil {
+		return err
+	}
+	config.Pipeline = []Promotion{
+		{From: "dev", To: "qa"},
+		{From: "qa", To: "stage"},
+		{From: "stage", To: "prod"},
+	}
+	return writeConfigFile(config)
+}
+
+func TestRollbackImage(t *testing.T) {
+	deploys := []tsuru.Deploy{
+		{ID: "5", Image: "registry.example.com/tsuru/app-proj1-prod:v5", Error: "deploy timed out"},
+		{ID: "4", Image: "registry.example.com/tsuru/app-proj1-prod:v4"},
+		{ID: "3", Image: "registry.example.com/tsuru/app-proj1-prod:v4", Origin: "rollback"},
+		{ID: "2", Image: "registry.example.com/tsuru/app-proj1-prod:v2"},
+		{ID: "1", Image: "registry.example.com/tsuru/app-proj1-prod:v1"},
+	}
+	var tests = []struct {
+		testCase string
+		deploys  []tsuru.Deploy
+		to       string
+		expected string
+		errMsg   string
+	}{
+		{
+			"previous image, skipping failures and redeploys",
+			deploys,
+			"",
+			"registry.example.com/tsuru/app-proj1-prod:v2",
+			"",
+		},
+		{
+			"deploy ID",
+			deploys,
+			"1",
+			"registry.example.com/tsuru/app-proj1-prod:v1",
+			"",
+		},
+		{
+			"image name",
+			deploys,
+			"registry.example.com/tsuru/app-proj1-prod:v2",
+			"registry.example.com/tsuru/app-proj1-prod:v2",
+			"",
+		},
+		{
+			"image tag",
+			deploys,
+			"v1",
+			"registry.example.com/tsuru/app-proj1-prod:v1",
+			"",
+		},
+		{
+			"failed deploy",
+			deploys,
+			"5",
+			"",
+			`cannot roll back to deploy "5", it failed: deploy timed out`,
+		},
+		{
+			"unknown target",
+			deploys,
+			"v8",
+			"",
+			`no successful deploy matching "v8"`,
+		},
+		{
+			"single image",
+			deploys[1:3],
+			"",
+			"",
+			"there is no previous successful deploy to roll back to",
+		},
+		{
+			"no deploys",
+			nil,
+			"",
+			"",
+			"there is no previous successful deploy to roll back to",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.testCase, func(t *testing.T) {
+			image, err := rollbackImage(test.deploys, test.to)
+			if test.errMsg != "" {
+				if err == nil || err.Error() != test.errMsg {
+					t.Errorf("wrong error\nwant %q\ngot  %v", test.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image != test.expected {
+				t.Errorf("wrong image\nwant %q\ngot  %q", test.expected, image)
+			}
+		})
+	}
+}
+
+func TestPreviousDeploy(t *testing.T) {
+	deploys := []tsuru.Deploy{
+		{ID: "4", Image: "registry.example.com/tsuru/app-proj1-prod:v5", Error: "deploy timed out"},
+		{ID: "3", Image: "registry.example.com/tsuru/app-proj1-prod:v4"},
+		{ID: "2", Image: "registry.example.com/tsuru/app-proj1-prod:v2"},
+		{ID: "1", Image: "registry.example.com/tsuru/app-proj1-prod:v1"},
+	}
+	var tests = []struct {
+		testCase string
+		deploys  []tsuru.Deploy
+		image    string
+		expected string
+		errMsg   string
+	}{
+		{"last successful deploy", deploys, "registry.example.com/tsuru/app-proj1-prod:v6", "3", ""},
+		{"skip the deployed image", deploys, "registry.example.com/tsuru/app-proj1-prod:v4", "2", ""},
+		{"new files", deploys, "", "3", ""},
+		{"single image", deploys[:2], "registry.example.com/tsuru/app-proj1-prod:v4", "", "there is no previous successful deploy to roll back to"},
+		{"no deploys", nil, "", "", "there is no previous successful deploy to roll back to"},
+	}
+	for _, test := range tests {
+		t.Run(test.testCase, func(t *testing.T) {
+			d, err := previousDeploy(test.deploys, test.image)
+			if test.errMsg != "" {
+				if err == nil || err.Error() != test.errMsg {
+					t.Errorf("wrong error\nwant %q\ngot  %v", test.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.ID != test.expected {
+				t.Errorf("wrong deploy\nwant %q\ngot  %q", test.expected, d.ID)
+			}
+		})
+	}
+}
+
+func prepareRollbackServer(t *testing.T) (*fakeServer, func()) {
+	fakeServer := newFakeServer(t)
+	fakeServer.prepareResponse(preparedResponse{
+		method:  http.MethodGet,
+		path:    "/deploys?app=proj1-prod",
+		code:    http.StatusOK,
+		payload: []byte(`[{"id":"2","image":"registry.example.com/tsuru/app-proj1-prod:v2"},{"id":"1","image":"registry.example.com/tsuru/app-proj1-prod:v1"}]`),
+	})
+	fakeServer.prepareResponse(preparedResponse{
+		method:  http.MethodPost,
+		path:    "/apps/proj1-prod/deploy/rollback",
+		code:    http.StatusOK,
+		payload: []byte(`{"Message":"deploying registry.example.com/tsuru/app-proj1-prod:v1\n"}`),
+	})
+	cleanup, err := setupFakeConfig(fakeServer.url(), "")
+	if err != nil {
+		fakeServer.stop()
+		t.Fatal(err)
+	}
+	config, err := loadConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Environments[3].Protected = true
+	err = writeConfigFile(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fakeServer, func() {
+		cleanup()
+		fakeServer.stop()
+	}
+}
+
+func TestProjectDeployRollback(t *testing.T) {
+	fakeServer, cleanup := prepareRollbackServer(t)
+	defer cleanup()
+	var c projectDeployRollback
+	err := c.Flags().Parse(true, []string{"-n", "proj1", "-e", "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stdin: strings.NewReader("proj1\n")}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOutput := `This change affects protected environments ("prod"). Please type the name of the project to confirm: ` +
+		`rolling back project "proj1" in "prod" to "registry.example.com/tsuru/app-proj1-prod:v1"...
+deploying registry.example.com/tsuru/app-proj1-prod:v1
+`
+	if stdout.String() != expectedOutput {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expectedOutput, stdout.String())
+	}
+	expectedReqs := []string{"GET /1.0/deploys", "POST /1.0/apps/proj1-prod/deploy/rollback"}
+	if reqs := fakeServer.sortedRequests(); !reflect.DeepEqual(reqs, expectedReqs) {
+		t.Fatalf("wrong requests\nwant %#v\ngot  %#v", expectedReqs, reqs)
+	}
+	values, err := url.ParseQuery(string(fakeServer.payloads[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedValues := url.Values{"origin": {"rollback"}, "image": {"registry.example.com/tsuru/app-proj1-prod:v1"}}
+	if !reflect.DeepEqual(values, expectedValues) {
+		t.Errorf("wrong payload\nwant %#v\ngot  %#v", expectedValues, values)
+	}
+}
+
+func TestProjectDeployRollbackNotConfirmed(t *testing.T) {
+	fakeServer, cleanup := prepareRollbackServer(t)
+	defer cleanup()
+	var c projectDeployRollback
+	err := c.Flags().Parse(true, []string{"-n", "proj1", "-e", "prod", "--to", "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stdin: strings.NewReader("proj2\n")}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = c.Run(&ctx, client)
+	if err == nil || err.Error() != "the confirmation doesn't match the name of the project, aborting" {
+		t.Errorf("wrong error: %v", err)
+	}
+	expectedReqs := []string{"GET /1.0/deploys"}
+	if reqs := fakeServer.sortedRequests(); !reflect.DeepEqual(reqs, expectedReqs) {
+		t.Errorf("wrong requests\nwant %#v\ngot  %#v", expectedReqs, reqs)
+	}
+}
+
+func TestProjectDeployRollbackMissingParams(t *testing.T) {
+	var tests = [][]string{nil, {"-n", "proj1"}, {"-e", "prod"}}
+	for _, flags := range tests {
+		var c projectDeployRollback
+		err := c.Flags().Parse(true, flags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := cmd.Context{}
+		client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+		err = c.Run(&ctx, client)
+		if err == nil || err.Error() != "please provide the project name and the environment" {
+			t.Errorf("wrong error for flags %v: %v", flags, err)
+		}
+	}
+}
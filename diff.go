@@ -0,0 +1,231 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+const privateValue = "(private, cannot compare)"
+
+type projectDiff struct {
+	fs     *gnuflag.FlagSet
+	name   string
+	envs   commaSeparatedFlag
+	output outputFormat
+}
+
+func (c *projectDiff) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "project-diff",
+		Usage: "project-diff -n/--name <projectname> [-e/--envs <env1,env2,...>]",
+		Desc:  "Compares the apps of the project in the given environments (defaults to all environments of the project)",
+	}
+}
+
+func (c *projectDiff) Run(ctx *cmd.Context, client *cmd.Client) error {
+	if c.name == "" {
+		return errors.New("please provide the name of the project")
+	}
+	err := c.output.validate()
+	if err != nil {
+		return err
+	}
+	apiClient, err := newAPIClient(client)
+	if err != nil {
+		return err
+	}
+	apps, err := projectEnvApps(apiClient, c.name, c.envs.Values())
+	if err != nil {
+		return err
+	}
+	if len(apps) < 2 {
+		return errors.New("please provide at least two environments to compare")
+	}
+	diff := diffOutput{Project: c.name, Envs: make([]projectEnvOutput, len(apps))}
+	envVars := make([][]tsuru.EnvVar, len(apps))
+	errs := runConcurrently(len(apps), func(i int) error {
+		diff.Envs[i] = newProjectEnvOutput(apps[i])
+		diff.Envs[i].Teams = apps[i].Teams
+		deploy, err := apiClient.LastDeploy(requestContext, apps[i].Name)
+		if err != nil {
+			return err
+		}
+		if deploy.Image != "" {
+			diff.Envs[i].LastDeploy = &deploy
+		}
+		envVars[i], err = apiClient.GetEnvVars(requestContext, apps[i].Name)
+		return err
+	}, nil)
+	if err = firstError(errs); err != nil {
+		return err
+	}
+	diff.EnvVars = diffEnvVars(apps, envVars)
+	if c.output.structured() {
+		return c.output.render(ctx.Stdout, diff)
+	}
+	c.render(ctx, diff)
+	return nil
+}
+
+func (c *projectDiff) render(ctx *cmd.Context, diff diffOutput) {
+	fmt.Fprintf(ctx.Stdout, "Project: %s\n\n", diff.Project)
+	headers := cmd.Row{""}
+	for _, env := range diff.Envs {
+		headers = append(headers, env.Env)
+	}
+	properties := []struct {
+		name  string
+		value func(projectEnvOutput) string
+	}{
+		{"App", func(e projectEnvOutput) string { return e.App }},
+		{"Image", func(e projectEnvOutput) string {
+			if e.LastDeploy == nil {
+				return ""
+			}
+			return e.LastDeploy.Image
+		}},
+		{"Git hash/tag", func(e projectEnvOutput) string {
+			if e.LastDeploy == nil {
+				return ""
+			}
+			return e.LastDeploy.Commit
+		}},
+		{"Plan", func(e projectEnvOutput) string { return e.Plan }},
+		{"Units", func(e projectEnvOutput) string { return strconv.Itoa(e.Units) }},
+		{"Pool", func(e projectEnvOutput) string { return e.Pool }},
+		{"Teams", func(e projectEnvOutput) string { return strings.Join(e.Teams, ", ") }},
+	}
+	var (
+		table   cmd.Table
+		differs bool
+	)
+	table.Headers = headers
+	for _, property := range properties {
+		row := cmd.Row{property.name}
+		for _, env := range diff.Envs {
+			row = append(row, property.value(env))
+		}
+		// apps and their images are named after the environment, so
+		// they're always different.
+		if property.name != "App" && property.name != "Image" && !allEqual(row[1:]) {
+			row[0] = "* " + row[0]
+			differs = true
+		}
+		table.AddRow(row)
+	}
+	ctx.Stdout.Write(table.Bytes())
+	if differs {
+		fmt.Fprintln(ctx.Stdout, "* differs between the environments")
+	}
+	var equalVars int
+	table = cmd.Table{Headers: append(cmd.Row{"Name"}, headers[1:]...)}
+	for _, v := range diff.EnvVars {
+		if v.Equal {
+			equalVars++
+			continue
+		}
+		row := cmd.Row{v.Name}
+		for _, env := range diff.Envs {
+			value, ok := v.Values[env.Env]
+			if !ok {
+				value = "(not set)"
+			}
+			row = append(row, value)
+		}
+		table.AddRow(row)
+	}
+	if table.Rows() == 0 {
+		fmt.Fprintf(ctx.Stdout, "\nAll %d environment variable(s) are equal in all environments.\n", equalVars)
+		return
+	}
+	fmt.Fprintln(ctx.Stdout, "\nEnvironment variables that differ:")
+	ctx.Stdout.Write(table.Bytes())
+	fmt.Fprintf(ctx.Stdout, "\n%d environment variable(s) are equal in all environments.\n", equalVars)
+}
+
+func (c *projectDiff) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("project-diff", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.name, "name", "", "name of the project")
+		c.fs.StringVar(&c.name, "n", "", "name of the project")
+		c.fs.Var(&c.envs, "envs", "comma-separated list of environments to compare")
+		c.fs.Var(&c.envs, "e", "comma-separated list of environments to compare")
+		c.output.addFlags(c.fs)
+	}
+	return c.fs
+}
+
+// diffEnvVars compares the variables of the apps by name, ignoring the ones
+// set by tsuru and by tranor. Private values are masked by the API, so private
+// variables are never equal.
+func diffEnvVars(apps []app, envVars [][]tsuru.EnvVar) []envVarDiffOutput {
+	injected := make(map[string]bool)
+	for _, a := range apps {
+		for name := range a.Env.envVars() {
+			injected[name] = true
+		}
+	}
+	byName := make(map[string][]*tsuru.EnvVar)
+	var names []string
+	for i, vars := range envVars {
+		for j, v := range vars {
+			if strings.HasPrefix(v.Name, "TSURU_") || injected[v.Name] {
+				continue
+			}
+			if _, ok := byName[v.Name]; !ok {
+				byName[v.Name] = make([]*tsuru.EnvVar, len(apps))
+				names = append(names, v.Name)
+			}
+			byName[v.Name][i] = &vars[j]
+		}
+	}
+	sort.Strings(names)
+	diffs := make([]envVarDiffOutput, len(names))
+	for i, name := range names {
+		d := envVarDiffOutput{Name: name, Values: make(map[string]string), Equal: true}
+		first := byName[name][0]
+		for j, v := range byName[name] {
+			if v == nil || first == nil || !v.Public || *v != *first {
+				d.Equal = false
+			}
+			if v == nil {
+				continue
+			}
+			d.Values[apps[j].Env.Name] = v.Value
+			if !v.Public {
+				d.Values[apps[j].Env.Name] = privateValue
+			}
+		}
+		diffs[i] = d
+	}
+	return diffs
+}
+
+func findAppByEnv(apps []app, envName string) (app, bool) {
+	for _, a := range apps {
+		if a.Env.Name == envName {
+			return a, true
+		}
+	}
+	return app{}, false
+}
+
+func allEqual(values []string) bool {
+	for _, v := range values[1:] {
+		if v != values[0] {
+			return false
+		}
+	}
+	return true
+}
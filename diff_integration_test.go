@@ -0,0 +1,156 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/tsuru/tsuru/api"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+func setDiffTestEnvVars(t *testing.T, client *cmd.Client) {
+	apiClient := testAPIClient(client)
+	vars := map[string][][2]string{
+		"proj1-stage": {{"DATABASE_HOST", "db.stage"}, {"WORKERS", "2"}},
+		"proj1-prod":  {{"DATABASE_HOST", "db.prod"}, {"WORKERS", "2"}, {"CACHE", "on"}},
+	}
+	for appName, appVars := range vars {
+		envVars := api.Envs{NoRestart: true}
+		for _, v := range appVars {
+			envVars.Envs = append(envVars.Envs, struct{ Name, Value string }{Name: v[0], Value: v[1]})
+		}
+		if err := apiClient.SetEnvVars(requestContext, appName, &envVars); err != nil {
+			t.Fatal(err)
+		}
+		envVars = api.Envs{NoRestart: true, Private: true}
+		envVars.Envs = append(envVars.Envs, struct{ Name, Value string }{Name: "SECRET_KEY", Value: "key-" + appName})
+		if err := apiClient.SetEnvVars(requestContext, appName, &envVars); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := apiClient.AddUnits(requestContext, "proj1-prod", 2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProjectDiff(t *testing.T) {
+	cleanup := createTestProject("proj1", t)
+	defer cleanup()
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	setDiffTestEnvVars(t, client)
+	setupEnvConstraints(t, func(envs []Environment) {
+		envs[2].EnvVars = map[string]string{"LOG_LEVEL": "debug"}
+		envs[3].EnvVars = map[string]string{"LOG_LEVEL": "warn"}
+	})
+	for appName, level := range map[string]string{"proj1-stage": "debug", "proj1-prod": "warn"} {
+		envVars := api.Envs{NoRestart: true}
+		envVars.Envs = append(envVars.Envs, struct{ Name, Value string }{Name: "LOG_LEVEL", Value: level})
+		if err := testAPIClient(client).SetEnvVars(requestContext, appName, &envVars); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var c projectDiff
+	err := c.Flags().Parse(true, []string{"-n", "proj1", "-e", "stage,prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stage, err := testAPIClient(client).GetApp(requestContext, "proj1-stage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := cmd.Table{Headers: cmd.Row{"", "stage", "prod"}}
+	table.AddRow(cmd.Row{"App", "proj1-stage", "proj1-prod"})
+	table.AddRow(cmd.Row{"Image", "", ""})
+	table.AddRow(cmd.Row{"Git hash/tag", "", ""})
+	table.AddRow(cmd.Row{"Plan", "medium", "medium"})
+	table.AddRow(cmd.Row{"* Units", "0", "2"})
+	table.AddRow(cmd.Row{"* Pool", `stage\stage.example.com`, `prod\example.com`})
+	table.AddRow(cmd.Row{"Teams", stage.Teams[0], stage.Teams[0]})
+	varsTable := cmd.Table{Headers: cmd.Row{"Name", "stage", "prod"}}
+	varsTable.AddRow(cmd.Row{"CACHE", "(not set)", "on"})
+	varsTable.AddRow(cmd.Row{"DATABASE_HOST", "db.stage", "db.prod"})
+	varsTable.AddRow(cmd.Row{"SECRET_KEY", "(private, cannot compare)", "(private, cannot compare)"})
+	expectedOutput := "Project: proj1\n\n" + table.String() +
+		"* differs between the environments\n\nEnvironment variables that differ:\n" +
+		varsTable.String() + "\n1 environment variable(s) are equal in all environments.\n"
+	if stdout.String() != expectedOutput {
+		t.Errorf("wrong output\nwant:\n%s\ngot:\n%s", expectedOutput, stdout.String())
+	}
+}
+
+func TestProjectDiffJSON(t *testing.T) {
+	cleanup := createTestProject("proj1", t)
+	defer cleanup()
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	setDiffTestEnvVars(t, client)
+	var c projectDiff
+	err := c.Flags().Parse(true, []string{"-n", "proj1", "-e", "prod,stage", "--format", "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var diff diffOutput
+	err = json.Unmarshal(stdout.Bytes(), &diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Envs) != 2 || diff.Envs[0].Env != "prod" || diff.Envs[1].Env != "stage" {
+		t.Errorf("wrong envs: %#v", diff.Envs)
+	}
+	expectedVars := []envVarDiffOutput{
+		{Name: "CACHE", Values: map[string]string{"prod": "on"}},
+		{Name: "DATABASE_HOST", Values: map[string]string{"prod": "db.prod", "stage": "db.stage"}},
+		{Name: "SECRET_KEY", Values: map[string]string{"prod": privateValue, "stage": privateValue}},
+		{Name: "WORKERS", Values: map[string]string{"prod": "2", "stage": "2"}, Equal: true},
+	}
+	if !reflect.DeepEqual(diff.EnvVars, expectedVars) {
+		t.Errorf("wrong variables\nwant %#v\ngot  %#v", expectedVars, diff.EnvVars)
+	}
+}
+
+func TestProjectDiffInvalidEnvs(t *testing.T) {
+	cleanup := createTestProject("proj1", t)
+	defer cleanup()
+	var tests = []struct {
+		testCase string
+		envs     string
+		errMsg   string
+	}{
+		{"single env", "prod", "please provide at least two environments to compare"},
+		{"unknown env", "prod,staging", `project not found in environment "staging"`},
+	}
+	for _, test := range tests {
+		t.Run(test.testCase, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+			client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+			var c projectDiff
+			err := c.Flags().Parse(true, []string{"-n", "proj1", "-e", test.envs})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = c.Run(&ctx, client)
+			if err == nil || err.Error() != test.errMsg {
+				t.Errorf("wrong error\nwant %q\ngot  %v", test.errMsg, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tsuru/tsuru/cmd"
+)
+
+// dryRun is set by the global flag --dry-run, which prints the requests that
+// would change anything in the tsuru API instead of sending them.
+var dryRun bool
+
+func enableDryRun(client *http.Client, w io.Writer) {
+	dryRun = true
+	client.Transport = &dryRunTransport{base: client.Transport, w: w}
+}
+
+// extractDryRunFlag removes --dry-run from the flags of the command line,
+// reporting whether it was present.
+func extractDryRunFlag(manager *cmd.Manager, args []string) ([]string, bool) {
+	var (
+		found     bool
+		isFlag    = flagArgs(manager, args)
+		remaining = make([]string, 0, len(args))
+	)
+	for i, arg := range args {
+		if isFlag[i] && arg == "--dry-run" {
+			found = true
+			continue
+		}
+		remaining = append(remaining, arg)
+	}
+	return remaining, found
+}
+
+// dryRunTransport forwards read-only requests and prints the other ones,
+// responding as if they succeeded.
+type dryRunTransport struct {
+	base http.RoundTripper
+	w    io.Writer
+	mu   sync.Mutex
+}
+
+func (t *dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		base := t.base
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		return base.RoundTrip(req)
+	}
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	t.mu.Lock()
+	fmt.Fprintf(t.w, "[dry-run] %s %s%s\n", req.Method, req.URL.RequestURI(), describeBody(req.Header.Get("Content-Type"), body))
+	t.mu.Unlock()
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader("{}")),
+		Request:    req,
+	}, nil
+}
+
+// describeBody returns the sorted parameters of a form, or the size of other
+// bodies.
+func describeBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if !strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		return fmt.Sprintf(" (%d bytes)", len(body))
+	}
+	values, err := url.ParseQuery(string(bytes.TrimSpace(body)))
+	if err != nil {
+		return " " + string(body)
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var params []string
+	for _, name := range names {
+		for _, value := range values[name] {
+			params = append(params, fmt.Sprintf("%s=%q", name, value))
+		}
+	}
+	return " " + strings.Join(params, " ")
+}
+
+// dryRunDeploy prints the request that deploys the image, or the files, to
+// the app. The files are only listed.
+func dryRunDeploy(client *cmd.Client, appName, image, message string, files []string) error {
+	values := make(url.Values)
+	if message != "" {
+		values.Set("message", message)
+	}
+	if image != "" {
+		values.Set("origin", "image")
+		values.Set("image", image)
+	} else {
+		values.Set("origin", "app-deploy")
+		values.Set("files", strings.Join(files, ","))
+	}
+	reqURL, err := cmd.GetURL("/apps/" + appName + "/deploy")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, reqURL, strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tsuru/tsuru/cmd"
+)
+
+// newDryRunClient returns a tsuru client in dry-run mode, that prints the
+// requests to w, and a function that disables the dry-run mode.
+func newDryRunClient(ctx *cmd.Context, w *bytes.Buffer) (*cmd.Client, func()) {
+	httpClient := &http.Client{}
+	enableDryRun(httpClient, w)
+	return cmd.NewClient(httpClient, ctx, &cmd.Manager{}), func() { dryRun = false }
+}
+
+func TestExtractDryRunFlag(t *testing.T) {
+	var tests = []struct {
+		input    []string
+		expected []string
+		found    bool
+	}{
+		{[]string{"project-create", "-n", "myproj"}, []string{"project-create", "-n", "myproj"}, false},
+		{[]string{"--dry-run", "project-create", "-n", "myproj"}, []string{"project-create", "-n", "myproj"}, true},
+		{[]string{"project-remove", "-n", "myproj", "--dry-run"}, []string{"project-remove", "-n", "myproj"}, true},
+		{[]string{"-v", "1", "--dry-run", "project-remove", "-yn", "myproj", "--dry-run"}, []string{"-v", "1", "project-remove", "-yn", "myproj"}, true},
+		{[]string{"envvar-set", "-n", "myproj", "--private", "ARGS=x", "--dry-run"}, []string{"envvar-set", "-n", "myproj", "--private", "ARGS=x", "--dry-run"}, false},
+		{[]string{"envvar-set", "-n", "myproj", "--", "--dry-run"}, []string{"envvar-set", "-n", "myproj", "--", "--dry-run"}, false},
+		{[]string{"envvar-unset", "-n", "--dry-run", "FOO"}, []string{"envvar-unset", "-n", "--dry-run", "FOO"}, false},
+		{[]string{"--dry-run", "envvar-set", "-n", "myproj", "-e=dev", "--dry-run", "ARGS=--dry-run"}, []string{"envvar-set", "-n", "myproj", "-e=dev", "ARGS=--dry-run"}, true},
+	}
+	manager := buildManager("tranor")
+	for _, test := range tests {
+		args, found := extractDryRunFlag(manager, test.input)
+		if !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("wrong args for %v\nwant %#v\ngot  %#v", test.input, test.expected, args)
+		}
+		if found != test.found {
+			t.Errorf("wrong flag for %v: want %v, got %v", test.input, test.found, found)
+		}
+	}
+}
+
+func TestDescribeBody(t *testing.T) {
+	var tests = []struct {
+		contentType string
+		body        string
+		expected    string
+	}{
+		{"", "", ""},
+		{"application/x-www-form-urlencoded", "", ""},
+		{"application/x-www-form-urlencoded", "plan=medium&name=myproj-dev", ` name="myproj-dev" plan="medium"`},
+		{"application/x-www-form-urlencoded", "env=FOO&env=BAR", ` env="FOO" env="BAR"`},
+		{"multipart/form-data", "some content", " (12 bytes)"},
+	}
+	for _, test := range tests {
+		got := describeBody(test.contentType, []byte(test.body))
+		if got != test.expected {
+			t.Errorf("wrong description of %q\nwant %q\ngot  %q", test.body, test.expected, got)
+		}
+	}
+}
+
+func TestProjectCreateDryRun(t *testing.T) {
+	tsuruServer.reset()
+	cleanup, err := setupFakeConfig(tsuruServer.url(), tsuruServer.token())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	var c projectCreate
+	err = c.Flags().Parse(true, []string{"-n", "myproj", "-l", "python", "-t", "myteam", "-p", "medium", "-e", "dev,prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr, requests bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client, disable := newDryRunClient(&ctx, &requests)
+	defer disable()
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedRequests := []string{
+		`[dry-run] POST /1.0/apps name="myproj-dev" plan="medium" platform="python" pool="dev\\dev.example.com" teamOwner="myteam"`,
+		`[dry-run] POST /1.0/apps/myproj-dev/env Envs.0.Name="TRANOR_ENV_NAME" Envs.0.Value="dev" NoRestart="" Private=""`,
+		`[dry-run] POST /1.0/apps name="myproj-prod" plan="medium" platform="python" pool="prod\\example.com" teamOwner="myteam"`,
+		`[dry-run] POST /1.0/apps/myproj-prod/env Envs.0.Name="TRANOR_ENV_NAME" Envs.0.Value="prod" NoRestart="" Private=""`,
+		`[dry-run] POST /1.0/apps/myproj-dev/cname cname="myproj.dev.example.com"`,
+		`[dry-run] POST /1.0/apps/myproj-prod/cname cname="myproj.example.com"`,
+	}
+	lines := strings.Split(strings.TrimSpace(requests.String()), "\n")
+	if len(lines) != len(expectedRequests) {
+		t.Fatalf("wrong requests\nwant %#v\ngot  %#v", expectedRequests, lines)
+	}
+	for i, line := range lines {
+		if line != expectedRequests[i] {
+			t.Errorf("wrong request %d\nwant %q\ngot  %q", i, expectedRequests[i], line)
+		}
+	}
+	disable()
+	apps, err := testAPIClient(cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})).ListApps(requestContext, map[string]string{"name": "^myproj"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) > 0 {
+		t.Errorf("unexpected apps created in dry-run mode: %#v", apps)
+	}
+}
+
+func TestProjectDeployDryRun(t *testing.T) {
+	cleanup := createTestProject("myproj", t)
+	defer cleanup()
+	var c projectDeploy
+	c.Flags().Parse(true, []string{"-n", "myproj", "-e", "dev", "-i", "tsuru/python"})
+	var stdout, stderr, requests bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client, disable := newDryRunClient(&ctx, &requests)
+	defer disable()
+	err := c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[dry-run] POST /1.0/apps/myproj-dev/deploy image=\"tsuru/python\" origin=\"image\"\n"
+	if requests.String() != expected {
+		t.Errorf("wrong requests\nwant %q\ngot  %q", expected, requests.String())
+	}
+}
+
+func TestProjectRemoveDryRun(t *testing.T) {
+	cleanup := createTestProject("myproj", t)
+	defer cleanup()
+	var c projectRemove
+	c.Flags().Parse(true, []string{"-n", "myproj"})
+	var stdout, stderr, requests bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client, disable := newDryRunClient(&ctx, &requests)
+	defer disable()
+	err := c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[dry-run] DELETE /1.0/apps/myproj-dev
+[dry-run] DELETE /1.0/apps/myproj-qa
+[dry-run] DELETE /1.0/apps/myproj-stage
+[dry-run] DELETE /1.0/apps/myproj-prod
+`
+	if requests.String() != expected {
+		t.Errorf("wrong requests\nwant %q\ngot  %q", expected, requests.String())
+	}
+	disable()
+	apps, err := projectApps(testAPIClient(cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})), "myproj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 4 {
+		t.Errorf("apps removed in dry-run mode: %#v", apps)
+	}
+}
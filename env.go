@@ -9,29 +9,53 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+	"github.com/tsuru/gnuflag"
 	"github.com/tsuru/tsuru/cmd"
 )
 
-type envList struct{}
+type envList struct {
+	fs     *gnuflag.FlagSet
+	output outputFormat
+}
 
-func (envList) Info() *cmd.Info {
+func (c *envList) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "env-list",
-		Usage: "env-list",
+		Usage: "env-list [--format table|json|yaml|template] [--template <template>]",
 		Desc:  "list currently available environments",
 	}
 }
 
-func (envList) Run(ctx *cmd.Context, _ *cmd.Client) error {
+func (c *envList) Run(ctx *cmd.Context, _ *cmd.Client) error {
+	err := c.output.validate()
+	if err != nil {
+		return err
+	}
 	config, err := loadConfigFile()
 	if err != nil {
 		return errors.New("unable to load environments file, please make sure that tranor is properly configured")
 	}
+	if refreshed, err := waitConfigRefresh(); err == nil && refreshed != nil {
+		config = refreshed
+	}
+	name := currentTargetName()
+	if configStale(name, config) {
+		updated, _ := configUpdatedAt(name)
+		fmt.Fprintf(ctx.Stderr, "Warning: the local configuration is stale (last updated at %s) and could not be refreshed.\n", updated.Format("2006-01-02 15:04:05"))
+	}
+	if c.output.structured() {
+		envs := make([]envOutput, len(config.Environments))
+		for i, env := range config.Environments {
+			envs[i] = envOutput{Name: env.Name, DNSSuffix: env.DNSSuffix, Protected: env.Protected}
+		}
+		return c.output.render(ctx.Stdout, envs)
+	}
 	table := cmd.NewTable()
 	table.Headers = cmd.Row{"Environment", "DNS Suffix"}
 	for _, env := range config.Environments {
@@ -41,11 +65,45 @@ func (envList) Run(ctx *cmd.Context, _ *cmd.Client) error {
 	return nil
 }
 
+func (c *envList) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("env-list", gnuflag.ExitOnError)
+		c.output.addFlags(c.fs)
+	}
+	return c.fs
+}
+
 // Config represents the configuration for the tranor command line.
 type Config struct {
+	Version      int           `json:"version,omitempty"`
+	Source       string        `json:"source,omitempty"`
+	SigningKey   string        `json:"signingKey,omitempty"`
+	ETag         string        `json:"etag,omitempty"`
+	LastModified string        `json:"lastModified,omitempty"`
 	Target       string        `json:"target"`
 	Registry     string        `json:"registry"`
 	Environments []Environment `json:"envs"`
+	Pipeline     []Promotion   `json:"pipeline,omitempty"`
+	Approvers    []Approver    `json:"approvers,omitempty"`
+	Naming       *Naming       `json:"naming,omitempty"`
+	Freezes      []Freeze      `json:"freezes,omitempty"`
+	names        *namer
+}
+
+// Approver represents a user that is allowed to approve changes in protected
+// environments. PublicKey is the base64 encoded ed25519 key used to verify
+// approval tokens issued by the user.
+type Approver struct {
+	Email     string `json:"email"`
+	Team      string `json:"team"`
+	PublicKey string `json:"publicKey"`
+}
+
+// Promotion represents an edge in the promotion pipeline, allowing versions
+// running in the environment From to be promoted to the environment To.
+type Promotion struct {
+	From string `json:"from"`
+	To   string `json:"to"`
 }
 
 func (c *Config) envNames() []string {
@@ -56,48 +114,188 @@ func (c *Config) envNames() []string {
 	return names
 }
 
-func (c *Config) imageApp(appName, version string) string {
+// canPromote reports whether a version can be promoted from one environment
+// to the other. When no pipeline is defined, promotions between any two
+// environments are allowed.
+func (c *Config) canPromote(from, to string) bool {
+	if len(c.Pipeline) == 0 {
+		return from != to
+	}
+	for _, p := range c.Pipeline {
+		if p.From == from && p.To == to {
+			return true
+		}
+	}
+	return false
+}
+
+// upstreamEnvs returns the names of the environments that can be promoted to
+// the given environment, following the order of the environments.
+func (c *Config) upstreamEnvs(to string) []string {
+	var names []string
+	for _, env := range c.Environments {
+		if env.Name != to && c.canPromote(env.Name, to) {
+			names = append(names, env.Name)
+		}
+	}
+	return names
+}
+
+func (c *Config) approver(email string) *Approver {
+	for i := range c.Approvers {
+		if c.Approvers[i].Email == email {
+			return &c.Approvers[i]
+		}
+	}
+	return nil
+}
+
+// registry returns the Docker registry of the images of the apps in the given
+// environment, which defaults to the registry of the configuration.
+func (c *Config) registry(envName string) string {
+	for _, env := range c.Environments {
+		if env.Name == envName && env.Registry != "" {
+			return env.Registry
+		}
+	}
+	return c.Registry
+}
+
+// imageApp returns the image of the given version of the app, stored in the
+// registry of the environment of the app.
+func (c *Config) imageApp(envName, appName, version string) string {
 	parts := []string{"tsuru", "app-" + appName + ":" + version}
-	if c.Registry != "" {
-		parts = []string{c.Registry, parts[0], parts[1]}
+	if registry := c.registry(envName); registry != "" {
+		parts = []string{registry, parts[0], parts[1]}
 	}
 	return strings.Join(parts, "/")
 }
 
-func (c *Config) writeTarget() error {
-	cmd.WriteOnTargetList("tranor", c.Target)
+// writeTarget defines the tsuru target of the given tranor target as the
+// current tsuru target, registering it in the tsuru target list.
+func (c *Config) writeTarget(name string) error {
+	cmd.WriteOnTargetList("tranor-"+name, c.Target)
 	return cmd.WriteTarget(c.Target)
 }
 
 // Environment represents an environment for deploying projects.
+//
+// Besides its name and DNS suffix, an environment may define the defaults and
+// constraints for the apps of projects in it: the default plan, the allowed
+// plans and platforms, the range of units and environment variables that are
+// always set. The images of the apps are stored in the registry of the
+// environment, or in the registry of the configuration when it's not defined.
+// Deploy freezes of the environment apply in addition to the global ones.
 type Environment struct {
-	Name      string `json:"name"`
-	DNSSuffix string `json:"dnsSuffix"`
-	namer     *regexp.Regexp
-	dnsr      *regexp.Regexp
+	Name             string            `json:"name"`
+	DNSSuffix        string            `json:"dnsSuffix"`
+	Registry         string            `json:"registry,omitempty"`
+	Protected        bool              `json:"protected,omitempty"`
+	ApprovalTeams    []string          `json:"approvalTeams,omitempty"`
+	DefaultPlan      string            `json:"defaultPlan,omitempty"`
+	AllowedPlans     []string          `json:"allowedPlans,omitempty"`
+	AllowedPlatforms []string          `json:"allowedPlatforms,omitempty"`
+	MinUnits         int               `json:"minUnits,omitempty"`
+	MaxUnits         int               `json:"maxUnits,omitempty"`
+	EnvVars          map[string]string `json:"envVars,omitempty"`
+	Freezes          []Freeze          `json:"freezes,omitempty"`
 }
 
-func (e *Environment) poolName() string {
-	return fmt.Sprintf(`%s\%s`, e.Name, e.DNSSuffix)
+// appOptions applies the defaults of the environment to the given options,
+// returning an error if they break the constraints of the environment.
+func (e *Environment) appOptions(opts tsuru.CreateAppOptions) (tsuru.CreateAppOptions, error) {
+	if opts.Plan == "" {
+		opts.Plan = e.DefaultPlan
+	}
+	err := e.checkPlan(opts.Plan)
+	if err != nil {
+		return opts, err
+	}
+	if len(e.AllowedPlatforms) > 0 && !containsString(e.AllowedPlatforms, opts.Platform) {
+		return opts, fmt.Errorf("platform %q is not allowed in the environment %q (allowed platforms: %s)", opts.Platform, e.Name, strings.Join(e.AllowedPlatforms, ", "))
+	}
+	return opts, nil
 }
 
-func (e *Environment) nameRegexp() *regexp.Regexp {
-	if e.namer == nil {
-		e.namer = regexp.MustCompile("^(.+)-" + e.Name + "$")
+func (e *Environment) checkPlan(plan string) error {
+	if len(e.AllowedPlans) == 0 || containsString(e.AllowedPlans, plan) {
+		return nil
+	}
+	if plan == "" {
+		return fmt.Errorf("a plan is required in the environment %q (allowed plans: %s)", e.Name, strings.Join(e.AllowedPlans, ", "))
 	}
-	return e.namer
+	return fmt.Errorf("plan %q is not allowed in the environment %q (allowed plans: %s)", plan, e.Name, strings.Join(e.AllowedPlans, ", "))
 }
 
-func (e *Environment) dnsRegexp() *regexp.Regexp {
-	if e.dnsr == nil {
-		e.dnsr = regexp.MustCompile(`^([^.]+)\.` + e.DNSSuffix + "$")
+func (e *Environment) checkUnits(units int) error {
+	if e.MinUnits > 0 && units < e.MinUnits {
+		return fmt.Errorf("the environment %q requires at least %d units", e.Name, e.MinUnits)
 	}
-	return e.dnsr
+	if e.MaxUnits > 0 && units > e.MaxUnits {
+		return fmt.Errorf("the environment %q allows at most %d units", e.Name, e.MaxUnits)
+	}
+	return nil
 }
 
+// envVars returns the variables that are always set in apps of the
+// environment, including TRANOR_ENV_NAME.
+func (e *Environment) envVars() map[string]string {
+	vars := map[string]string{"TRANOR_ENV_NAME": e.Name}
+	for name, value := range e.EnvVars {
+		vars[name] = value
+	}
+	return vars
+}
+
+// currentTargetName returns the name of the tranor target in use, as defined
+// by the TRANOR_TARGET environment variable or the target-use command. An
+// empty name means that the legacy, unnamed, configuration file is in use.
+func currentTargetName() string {
+	if name := os.Getenv("TRANOR_TARGET"); name != "" {
+		return name
+	}
+	return savedTargetName()
+}
+
+// savedTargetName returns the name of the tranor target defined by the
+// target-use command, ignoring the TRANOR_TARGET environment variable.
+func savedTargetName() string {
+	data, err := ioutil.ReadFile(cmd.JoinWithUserDir(".tranor", "target"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func writeCurrentTargetName(name string) error {
+	err := os.MkdirAll(cmd.JoinWithUserDir(".tranor"), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cmd.JoinWithUserDir(".tranor", "target"), []byte(name+"\n"), 0644)
+}
+
+func configFilePath(targetName string) string {
+	if targetName == "" {
+		return cmd.JoinWithUserDir(".tranor", "config.json")
+	}
+	return cmd.JoinWithUserDir(".tranor", "targets", targetName, "config.json")
+}
+
+// loadConfigFile loads the configuration of the current target. When the
+// local copy is older than the TTL, it's refreshed in the background (see
+// waitConfigRefresh).
 func loadConfigFile() (*Config, error) {
-	filePath := cmd.JoinWithUserDir(".tranor", "config.json")
-	f, err := os.Open(filePath)
+	name := currentTargetName()
+	config, err := loadTargetConfig(name)
+	if err == nil && configStale(name, config) {
+		startConfigRefresh(name, config)
+	}
+	return config, err
+}
+
+func loadTargetConfig(targetName string) (*Config, error) {
+	f, err := os.Open(configFilePath(targetName))
 	if err != nil {
 		return nil, err
 	}
@@ -105,22 +303,46 @@ func loadConfigFile() (*Config, error) {
 	return parseConfig(f)
 }
 
+// parseConfig decodes the configuration from the given reader, migrating it
+// to the current schema version and validating it. Unknown fields are
+// ignored, so servers can add optional fields without breaking older
+// versions of tranor.
 func parseConfig(r io.Reader) (*Config, error) {
 	var config Config
 	err := json.NewDecoder(r).Decode(&config)
-	return &config, err
+	if err != nil {
+		return &config, err
+	}
+	err = config.migrate()
+	if err != nil {
+		return &config, err
+	}
+	return &config, config.validate()
 }
 
 func writeConfigFile(c *Config) error {
-	dir := cmd.JoinWithUserDir(".tranor")
-	err := os.Mkdir(dir, 0755)
-	if err != nil && !os.IsExist(err) {
+	return writeTargetConfig(currentTargetName(), c)
+}
+
+func writeTargetConfig(targetName string, c *Config) error {
+	filePath := configFilePath(targetName)
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
 		return err
 	}
-	f, err := os.Create(filepath.Join(dir, "config.json"))
+	f, err := ioutil.TempFile(filepath.Dir(filePath), ".config")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	return json.NewEncoder(f).Encode(c)
+	defer os.Remove(f.Name())
+	err = json.NewEncoder(f).Encode(c)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(f.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filePath)
 }
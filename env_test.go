@@ -17,11 +17,11 @@ import (
 )
 
 func TestEnvListIsATsuruCommand(t *testing.T) {
-	var _ cmd.Command = envList{}
+	var _ cmd.FlaggedCommand = &envList{}
 }
 
 func TestEnvListInfo(t *testing.T) {
-	info := envList{}.Info()
+	info := (&envList{}).Info()
 	if info == nil {
 		t.Fatal("unexpected <nil> info")
 	}
@@ -91,6 +91,7 @@ func TestLoadConfigFile(t *testing.T) {
 		t.Fatal(err)
 	}
 	expectedConfig := Config{
+		Version:  configVersion,
 		Target:   "http://tsuru-api.example.com",
 		Registry: "localhost:5000",
 		Environments: []Environment{
@@ -251,10 +252,26 @@ func TestConfigImageApp(t *testing.T) {
 			Config{},
 			"tsuru/app-myapp:v10",
 		},
+		{
+			"with registry in the environment",
+			Config{
+				Registry:     "localhost:4040",
+				Environments: []Environment{{Name: "dev"}, {Name: "prod", Registry: "prod-registry.example.com"}},
+			},
+			"prod-registry.example.com/tsuru/app-myapp:v10",
+		},
+		{
+			"with registry in other environment",
+			Config{
+				Registry:     "localhost:4040",
+				Environments: []Environment{{Name: "dev", Registry: "dev-registry.example.com"}, {Name: "prod"}},
+			},
+			"localhost:4040/tsuru/app-myapp:v10",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.testCase, func(t *testing.T) {
-			gotImage := test.config.imageApp("myapp", "v10")
+			gotImage := test.config.imageApp("prod", "myapp", "v10")
 			if gotImage != test.expectedImage {
 				t.Errorf("wrong image returned\nwant %q\ngot  %q", test.expectedImage, gotImage)
 			}
@@ -262,24 +279,53 @@ func TestConfigImageApp(t *testing.T) {
 	}
 }
 
-func TestEnvironmentPoolName(t *testing.T) {
+func TestConfigCanPromote(t *testing.T) {
+	pipeline := []Promotion{
+		{From: "dev", To: "qa"},
+		{From: "qa", To: "stage"},
+		{From: "qa", To: "demo"},
+		{From: "stage", To: "prod"},
+	}
 	var tests = []struct {
-		input  Environment
-		output string
+		testCase string
+		pipeline []Promotion
+		from     string
+		to       string
+		expected bool
 	}{
-		{
-			Environment{Name: "dev", DNSSuffix: "dev.example.com"},
-			`dev\dev.example.com`,
+		{"no pipeline", nil, "dev", "prod", true},
+		{"no pipeline, same env", nil, "dev", "dev", false},
+		{"edge in the pipeline", pipeline, "dev", "qa", true},
+		{"branch in the pipeline", pipeline, "qa", "demo", true},
+		{"skipping an edge", pipeline, "dev", "prod", false},
+		{"backwards", pipeline, "prod", "stage", false},
+	}
+	for _, test := range tests {
+		t.Run(test.testCase, func(t *testing.T) {
+			c := Config{Pipeline: test.pipeline}
+			if got := c.canPromote(test.from, test.to); got != test.expected {
+				t.Errorf("canPromote(%q, %q): want %v, got %v", test.from, test.to, test.expected, got)
+			}
+		})
+	}
+}
+
+func TestConfigUpstreamEnvs(t *testing.T) {
+	c := Config{
+		Environments: []Environment{
+			{Name: "dev"}, {Name: "qa"}, {Name: "hotfix"}, {Name: "prod"},
 		},
-		{
-			Environment{Name: "qa", DNSSuffix: "whatever"},
-			`qa\whatever`,
+		Pipeline: []Promotion{
+			{From: "dev", To: "qa"},
+			{From: "hotfix", To: "prod"},
+			{From: "qa", To: "prod"},
 		},
 	}
-	for _, test := range tests {
-		got := test.input.poolName()
-		if got != test.output {
-			t.Errorf("wrong pool name\nWant %q\nGot  %q", test.output, got)
-		}
+	expected := []string{"qa", "hotfix"}
+	if got := c.upstreamEnvs("prod"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong upstream envs\nwant %#v\ngot  %#v", expected, got)
+	}
+	if got := c.upstreamEnvs("dev"); len(got) != 0 {
+		t.Errorf("unexpected upstream envs for dev: %#v", got)
 	}
 }
@@ -7,15 +7,14 @@ package main
 import (
 	"errors"
 	"fmt"
-	"net/http"
 	"regexp"
 	"sort"
 	"strings"
 
+	"github.com/ef-ctx/tsuru-flow/tsuru"
 	"github.com/tsuru/gnuflag"
 	"github.com/tsuru/tsuru/api"
 	"github.com/tsuru/tsuru/cmd"
-	tsuruerrors "github.com/tsuru/tsuru/errors"
 )
 
 type projectEnvVarSet struct {
@@ -23,6 +22,8 @@ type projectEnvVarSet struct {
 	envs        commaSeparatedFlag
 	private     bool
 	noRestart   bool
+	guard       approvalGuard
+	freeze      freezeGuard
 	fs          *gnuflag.FlagSet
 }
 
@@ -30,7 +31,7 @@ func (c *projectEnvVarSet) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "envvar-set",
 		Desc:    "defines environment variables for a given project",
-		Usage:   "envvar-set <NAME=value> [NAME=value]... <-n/--project-name projectname> [-p/--private] [--no-restart]",
+		Usage:   "envvar-set <NAME=value> [NAME=value]... <-n/--project-name projectname> [-p/--private] [--no-restart] [--override-freeze reason]",
 		MinArgs: 1,
 	}
 }
@@ -58,26 +59,50 @@ func (c *projectEnvVarSet) Run(ctx *cmd.Context, client *cmd.Client) error {
 		parts := strings.SplitN(decl[1], "=", 2)
 		envVars.Envs = append(envVars.Envs, struct{ Name, Value string }{Name: parts[0], Value: parts[1]})
 	}
-	envNames := c.envs.Values()
-	if len(envNames) == 0 {
-		envNames = config.envNames()
+	apiClient, err := newAPIClient(client)
+	if err != nil {
+		return err
+	}
+	apps, err := projectEnvApps(apiClient, c.projectName, c.envs.Values())
+	if err != nil {
+		return err
+	}
+	envNames := make([]string, len(apps))
+	appNames := make([]string, len(apps))
+	for i, a := range apps {
+		envNames[i] = a.Env.Name
+		appNames[i] = a.Name
+	}
+	if !c.noRestart {
+		if _, err = c.freeze.check(ctx.Stderr, config, envNames); err != nil {
+			return err
+		}
 	}
+	err = c.guard.check(ctx, client, config, c.projectName, envNames)
+	if err != nil {
+		return err
+	}
+	defer catchInterrupts(ctx.Stderr)()
 	var cmdErr error
-	for _, envName := range envNames {
-		appName := fmt.Sprintf("%s-%s", c.projectName, envName)
-		fmt.Fprintf(ctx.Stdout, "setting variables in environment %q... ", envName)
-		err := setEnvVars(client, appName, &envVars)
+	runConcurrently(len(envNames), interruptible(func(i int) error {
+		return apiClient.SetEnvVars(requestContext, appNames[i], &envVars)
+	}), func(i int, err error) {
 		status := "ok"
 		if err != nil {
-			if e, ok := err.(*tsuruerrors.HTTP); ok && e.Code == http.StatusNotFound {
+			if tsuru.IsNotFound(err) {
 				status = "not found"
+			} else if err == errInterrupted {
+				status = "skipped"
+				if cmdErr == nil {
+					cmdErr = err
+				}
 			} else {
 				status = "failed"
 				cmdErr = err
 			}
 		}
-		fmt.Fprintln(ctx.Stdout, status)
-	}
+		fmt.Fprintf(ctx.Stdout, "setting variables in environment %q... %s\n", envNames[i], status)
+	})
 	return cmdErr
 }
 
@@ -91,6 +116,8 @@ func (c *projectEnvVarSet) Flags() *gnuflag.FlagSet {
 		c.fs.BoolVar(&c.private, "private", false, "set the variables to private (not visible through command line)")
 		c.fs.BoolVar(&c.private, "p", false, "set the variables to private (not visible through command line)")
 		c.fs.BoolVar(&c.noRestart, "no-restart", false, "set the environment variables without restarting the application process")
+		c.guard.addFlags(c.fs)
+		c.freeze.addFlags(c.fs)
 	}
 	return c.fs
 }
@@ -98,6 +125,7 @@ func (c *projectEnvVarSet) Flags() *gnuflag.FlagSet {
 type projectEnvVarGet struct {
 	projectName string
 	envs        commaSeparatedFlag
+	output      outputFormat
 	fs          *gnuflag.FlagSet
 }
 
@@ -112,6 +140,10 @@ func (c *projectEnvVarGet) Run(ctx *cmd.Context, client *cmd.Client) error {
 	if c.projectName == "" {
 		return errors.New("please provide the name of the project")
 	}
+	err := c.output.validate()
+	if err != nil {
+		return err
+	}
 	config, err := loadConfigFile()
 	if err != nil {
 		return errors.New("unable to load environments file, please make sure that tranor is properly configured")
@@ -120,16 +152,35 @@ func (c *projectEnvVarGet) Run(ctx *cmd.Context, client *cmd.Client) error {
 	if len(envNames) == 0 {
 		envNames = config.envNames()
 	}
-	for _, envName := range envNames {
-		appName := fmt.Sprintf("%s-%s", c.projectName, envName)
-		envVars, err := getEnvVars(client, appName)
+	apiClient, err := newAPIClient(client)
+	if err != nil {
+		return err
+	}
+	appNames := make([]string, len(envNames))
+	for i, envName := range envNames {
+		appNames[i] = config.appName(c.projectName, envName)
+	}
+	results := make([][]tsuru.EnvVar, len(envNames))
+	errs := runConcurrently(len(envNames), func(i int) error {
+		var err error
+		results[i], err = apiClient.GetEnvVars(requestContext, appNames[i])
+		return err
+	}, nil)
+	outputs := []envVarsOutput{}
+	for i, envName := range envNames {
+		envVars, err := results[i], errs[i]
 		if err != nil {
-			if e, ok := err.(*tsuruerrors.HTTP); ok && e.Code == http.StatusNotFound {
+			if tsuru.IsNotFound(err) {
 				fmt.Fprintf(ctx.Stderr, "WARNING: project not found in environment %q\n", envName)
 				continue
 			}
 			return err
 		}
+		if c.output.structured() {
+			sort.Slice(envVars, func(i, j int) bool { return envVars[i].Name < envVars[j].Name })
+			outputs = append(outputs, envVarsOutput{Env: envName, Vars: envVars})
+			continue
+		}
 		fmt.Fprintf(ctx.Stdout, "variables in %q:\n\n", envName)
 		lines := make([]string, len(envVars))
 		for i, evar := range envVars {
@@ -139,6 +190,9 @@ func (c *projectEnvVarGet) Run(ctx *cmd.Context, client *cmd.Client) error {
 		fmt.Fprintln(ctx.Stdout, strings.Join(lines, "\n"))
 		fmt.Fprint(ctx.Stdout, "\n\n")
 	}
+	if c.output.structured() {
+		return c.output.render(ctx.Stdout, outputs)
+	}
 	return nil
 }
 
@@ -149,6 +203,7 @@ func (c *projectEnvVarGet) Flags() *gnuflag.FlagSet {
 		c.fs.StringVar(&c.projectName, "n", "", "name of the project")
 		c.fs.Var(&c.envs, "envs", "comma-separated list of environments to get the variables")
 		c.fs.Var(&c.envs, "e", "comma-separated list of environments to get the variables")
+		c.output.addFlags(c.fs)
 	}
 	return c.fs
 }
@@ -157,6 +212,8 @@ type projectEnvVarUnset struct {
 	projectName string
 	noRestart   bool
 	envs        commaSeparatedFlag
+	guard       approvalGuard
+	freeze      freezeGuard
 	fs          *gnuflag.FlagSet
 }
 
@@ -176,26 +233,50 @@ func (c *projectEnvVarUnset) Run(ctx *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return errors.New("unable to load environments file, please make sure that tranor is properly configured")
 	}
-	envNames := c.envs.Values()
-	if len(envNames) == 0 {
-		envNames = config.envNames()
+	apiClient, err := newAPIClient(client)
+	if err != nil {
+		return err
 	}
+	apps, err := projectEnvApps(apiClient, c.projectName, c.envs.Values())
+	if err != nil {
+		return err
+	}
+	envNames := make([]string, len(apps))
+	appNames := make([]string, len(apps))
+	for i, a := range apps {
+		envNames[i] = a.Env.Name
+		appNames[i] = a.Name
+	}
+	if !c.noRestart {
+		if _, err = c.freeze.check(ctx.Stderr, config, envNames); err != nil {
+			return err
+		}
+	}
+	err = c.guard.check(ctx, client, config, c.projectName, envNames)
+	if err != nil {
+		return err
+	}
+	defer catchInterrupts(ctx.Stderr)()
 	var cmdErr error
-	for _, envName := range envNames {
-		appName := fmt.Sprintf("%s-%s", c.projectName, envName)
-		fmt.Fprintf(ctx.Stdout, "unsetting variables from environment %q... ", envName)
-		err := unsetEnvVars(client, appName, c.noRestart, ctx.Args)
+	runConcurrently(len(envNames), interruptible(func(i int) error {
+		return apiClient.UnsetEnvVars(requestContext, appNames[i], c.noRestart, ctx.Args)
+	}), func(i int, err error) {
 		status := "ok"
 		if err != nil {
-			if e, ok := err.(*tsuruerrors.HTTP); ok && e.Code == http.StatusNotFound {
+			if tsuru.IsNotFound(err) {
 				status = "not found"
+			} else if err == errInterrupted {
+				status = "skipped"
+				if cmdErr == nil {
+					cmdErr = err
+				}
 			} else {
 				status = "failed"
 				cmdErr = err
 			}
 		}
-		fmt.Fprintln(ctx.Stdout, status)
-	}
+		fmt.Fprintf(ctx.Stdout, "unsetting variables from environment %q... %s\n", envNames[i], status)
+	})
 	return cmdErr
 }
 
@@ -207,6 +288,26 @@ func (c *projectEnvVarUnset) Flags() *gnuflag.FlagSet {
 		c.fs.Var(&c.envs, "envs", "comma-separated list of environments to set the variables")
 		c.fs.Var(&c.envs, "e", "comma-separated list of environments to set the variables")
 		c.fs.BoolVar(&c.noRestart, "no-restart", false, "unset environment variables without restarting the application process")
+		c.guard.addFlags(c.fs)
+		c.freeze.addFlags(c.fs)
 	}
 	return c.fs
 }
+
+// projectEnvApps returns the apps of the project in the given environments,
+// or in all the environments of the project when none is given.
+func projectEnvApps(client *tsuru.Client, projectName string, envNames []string) ([]app, error) {
+	apps, err := projectApps(client, projectName)
+	if err != nil || len(envNames) == 0 {
+		return apps, err
+	}
+	selected := make([]app, len(envNames))
+	for i, envName := range envNames {
+		a, ok := findAppByEnv(apps, envName)
+		if !ok {
+			return nil, fmt.Errorf("project not found in environment %q", envName)
+		}
+		selected[i] = a
+	}
+	return selected, nil
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"testing"
 
+	"github.com/ef-ctx/tsuru-flow/tsuru"
 	"github.com/tsuru/tsuru/cmd"
 )
 
@@ -30,7 +31,7 @@ func TestProjectEnvVarSet(t *testing.T) {
 		{Name: "dev", DNSSuffix: "dev.example.com"},
 		{Name: "stage", DNSSuffix: "stage.example.com"},
 		{Name: "prod", DNSSuffix: "example.com"},
-	}, client, "myproj", createAppOptions{
+	}, testAPIClient(client), "myproj", tsuru.CreateAppOptions{
 		Plan:        "medium",
 		Description: "my nice project",
 		Team:        "myteam",
@@ -39,7 +40,7 @@ func TestProjectEnvVarSet(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = setCNames(appMaps, client, "myproj")
+	err = setCNames(appMaps, testAPIClient(client))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -81,7 +82,7 @@ func TestProjectEnvVarSetDefaultEnvs(t *testing.T) {
 	}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
 	config, _ := loadConfigFile()
-	appMaps, err := createApps(config.Environments, client, "myproj", createAppOptions{
+	appMaps, err := createApps(config.Environments, testAPIClient(client), "myproj", tsuru.CreateAppOptions{
 		Plan:        "medium",
 		Description: "my nice project",
 		Team:        "myteam",
@@ -90,7 +91,7 @@ func TestProjectEnvVarSetDefaultEnvs(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = setCNames(appMaps, client, "myproj")
+	err = setCNames(appMaps, testAPIClient(client))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -131,10 +132,10 @@ func TestProjectEnvVarSetAppNotFound(t *testing.T) {
 		Args:   []string{"USER_NAME=root", "USER_PASSWORD=r00t", `PREFERRED_TEAM="some nice team"`},
 	}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	_, err = createApps([]Environment{
+	appMaps, err := createApps([]Environment{
 		{Name: "dev", DNSSuffix: "dev.example.com"},
 		{Name: "prod", DNSSuffix: "example.com"},
-	}, client, "myproj", createAppOptions{
+	}, testAPIClient(client), "myproj", tsuru.CreateAppOptions{
 		Plan:        "medium",
 		Description: "my nice project",
 		Team:        "myteam",
@@ -143,6 +144,10 @@ func TestProjectEnvVarSetAppNotFound(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	err = setCNames(appMaps, testAPIClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
 	var c projectEnvVarSet
 	err = c.Flags().Parse(true, []string{
 		"-n", "myproj",
@@ -154,15 +159,12 @@ func TestProjectEnvVarSetAppNotFound(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = c.Run(&ctx, client)
-	if err != nil {
-		t.Fatal(err)
+	expectedMsg := `project not found in environment "stage"`
+	if err == nil || err.Error() != expectedMsg {
+		t.Errorf("wrong error\nwant %q\ngot  %v", expectedMsg, err)
 	}
-	expectedOutput := `setting variables in environment "dev"... ok
-setting variables in environment "stage"... not found
-setting variables in environment "prod"... ok
-`
-	if stdout.String() != expectedOutput {
-		t.Errorf("wrong output\nwant:\n%s\ngot:\n%s", expectedOutput, stdout.String())
+	if stdout.String() != "" {
+		t.Errorf("variables set in an invalid environment: %q", stdout.String())
 	}
 }
 
@@ -177,7 +179,7 @@ func TestProjectEnvVarGet(t *testing.T) {
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
 	config, _ := loadConfigFile()
-	_, err = createApps(config.Environments, client, "myproj", createAppOptions{
+	_, err = createApps(config.Environments, testAPIClient(client), "myproj", tsuru.CreateAppOptions{
 		Plan:        "medium",
 		Description: "my nice project",
 		Team:        "myteam",
@@ -239,7 +241,7 @@ func TestProjectEnvVarGetDefaultEnvs(t *testing.T) {
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
 	config, _ := loadConfigFile()
-	_, err = createApps(config.Environments, client, "myproj", createAppOptions{
+	_, err = createApps(config.Environments, testAPIClient(client), "myproj", tsuru.CreateAppOptions{
 		Plan:        "medium",
 		Description: "my nice project",
 		Team:        "myteam",
@@ -308,7 +310,7 @@ func TestProjectEnvVarGetAppNotFound(t *testing.T) {
 	_, err = createApps([]Environment{
 		{Name: "dev", DNSSuffix: "dev.example.com"},
 		{Name: "prod", DNSSuffix: "example.com"},
-	}, client, "myproj", createAppOptions{
+	}, testAPIClient(client), "myproj", tsuru.CreateAppOptions{
 		Plan:        "medium",
 		Description: "my nice project",
 		Team:        "myteam",
@@ -372,7 +374,7 @@ func TestProjectEnvVarUnset(t *testing.T) {
 	}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
 	config, _ := loadConfigFile()
-	_, err = createApps(config.Environments, client, "myproj", createAppOptions{
+	appMaps, err := createApps(config.Environments, testAPIClient(client), "myproj", tsuru.CreateAppOptions{
 		Plan:        "medium",
 		Description: "my nice project",
 		Team:        "myteam",
@@ -381,6 +383,10 @@ func TestProjectEnvVarUnset(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	err = setCNames(appMaps, testAPIClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
 	var c projectEnvVarUnset
 	err = c.Flags().Parse(true, []string{
 		"-n", "myproj",
@@ -419,7 +425,7 @@ func TestProjectEnvVarUnsetDefaultEnvs(t *testing.T) {
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
 	var c projectEnvVarUnset
 	config, _ := loadConfigFile()
-	_, err = createApps(config.Environments, client, "myproj", createAppOptions{
+	appMaps, err := createApps(config.Environments, testAPIClient(client), "myproj", tsuru.CreateAppOptions{
 		Plan:        "medium",
 		Description: "my nice project",
 		Team:        "myteam",
@@ -428,6 +434,10 @@ func TestProjectEnvVarUnsetDefaultEnvs(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	err = setCNames(appMaps, testAPIClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
 	err = c.Flags().Parse(true, []string{
 		"-n", "myproj",
 		"--no-restart",
@@ -463,10 +473,10 @@ func TestProjectEnvVarUnsetAppNotFound(t *testing.T) {
 		Args:   []string{"USER_NAME", "USER_PASSWORD", "PREFERRED_TEAM"},
 	}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	_, err = createApps([]Environment{
+	appMaps, err := createApps([]Environment{
 		{Name: "dev", DNSSuffix: "dev.example.com"},
 		{Name: "prod", DNSSuffix: "example.com"},
-	}, client, "myproj", createAppOptions{
+	}, testAPIClient(client), "myproj", tsuru.CreateAppOptions{
 		Plan:        "medium",
 		Description: "my nice project",
 		Team:        "myteam",
@@ -475,6 +485,10 @@ func TestProjectEnvVarUnsetAppNotFound(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	err = setCNames(appMaps, testAPIClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
 	var c projectEnvVarUnset
 	err = c.Flags().Parse(true, []string{
 		"-n", "myproj",
@@ -485,14 +499,11 @@ func TestProjectEnvVarUnsetAppNotFound(t *testing.T) {
 		t.Fatal(err)
 	}
 	err = c.Run(&ctx, client)
-	if err != nil {
-		t.Fatal(err)
+	expectedMsg := `project not found in environment "stage"`
+	if err == nil || err.Error() != expectedMsg {
+		t.Errorf("wrong error\nwant %q\ngot  %v", expectedMsg, err)
 	}
-	expectedOutput := `unsetting variables from environment "dev"... ok
-unsetting variables from environment "stage"... not found
-unsetting variables from environment "prod"... ok
-`
-	if stdout.String() != expectedOutput {
-		t.Errorf("wrong output\nwant:\n%s\ngot:\n%s", expectedOutput, stdout.String())
+	if stdout.String() != "" {
+		t.Errorf("variables unset in an invalid environment: %q", stdout.String())
 	}
 }
@@ -9,9 +9,11 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"testing"
 
+	"github.com/ef-ctx/tsuru-flow/tsuru"
 	"github.com/tsuru/tsuru/cmd"
 )
 
@@ -96,6 +98,7 @@ func TestProjectEnvVarSetNoConfiguration(t *testing.T) {
 func TestProjectEnvVarSetErrorInOneOfTheApps(t *testing.T) {
 	server := newFakeServer(t)
 	defer server.stop()
+	prepareProjectApps(t, server, "proj1", map[string]string{"dev": "dev.example.com", "stage": "stage.example.com", "prod": "example.com"})
 	server.prepareResponse(preparedResponse{
 		method:  http.MethodPost,
 		path:    "/apps/proj1-stage/env",
@@ -319,6 +322,7 @@ func TestProjectEnvVarUnsetMissingName(t *testing.T) {
 func TestProjectEnvVarUnsetError(t *testing.T) {
 	server := newFakeServer(t)
 	defer server.stop()
+	prepareProjectApps(t, server, "proj1", map[string]string{"dev": "dev.example.com", "stage": "stage.example.com", "prod": "example.com"})
 	server.prepareResponse(preparedResponse{
 		method:   http.MethodDelete,
 		path:     "/apps/proj1-stage/env",
@@ -369,3 +373,34 @@ unsetting variables from environment "prod"... ok
 		t.Errorf("wrong output\nwant:\n%s\ngot:\n%s", expectedOutput, stdout.String())
 	}
 }
+
+// prepareProjectApps prepares the responses that projectApps uses to find the
+// apps of the project in the given environments, indexed by the DNS suffix of
+// each environment.
+func prepareProjectApps(t *testing.T, server *fakeServer, projectName string, envs map[string]string) {
+	var apps []tsuru.App
+	for envName, dnsSuffix := range envs {
+		a := tsuru.App{Name: projectName + "-" + envName, CName: []string{projectName + "." + dnsSuffix}}
+		data, err := json.Marshal(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.prepareResponse(preparedResponse{
+			method:  http.MethodGet,
+			path:    "/apps/" + a.Name,
+			code:    http.StatusOK,
+			payload: data,
+		})
+		apps = append(apps, a)
+	}
+	data, err := json.Marshal(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.prepareResponse(preparedResponse{
+		method:  http.MethodGet,
+		path:    "/apps?name=" + url.QueryEscape("^"+projectName),
+		code:    http.StatusOK,
+		payload: data,
+	})
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+// defaultWorkers is the default number of environments handled at a time.
+const defaultWorkers = 4
+
+// workerLimit returns the number of concurrent tasks, set by TRANOR_WORKERS.
+// Tasks run one at a time in dry-run mode.
+func workerLimit() int {
+	if dryRun {
+		return 1
+	}
+	if value := os.Getenv("TRANOR_WORKERS"); value != "" {
+		if workers, err := strconv.Atoi(value); err == nil && workers > 0 {
+			return workers
+		}
+	}
+	return defaultWorkers
+}
+
+// runConcurrently runs task for every index in [0, n), at most workerLimit()
+// at a time, and returns the error of each task. When not nil, done is called
+// with the result of each task in index order, in the calling goroutine.
+func runConcurrently(n int, task func(i int) error, done func(i int, err error)) []error {
+	errs := make([]error, n)
+	finished := make([]chan struct{}, n)
+	for i := range finished {
+		finished[i] = make(chan struct{})
+	}
+	sem := make(chan struct{}, workerLimit())
+	go func() {
+		for i := 0; i < n; i++ {
+			sem <- struct{}{}
+			go func(i int) {
+				defer func() {
+					<-sem
+					close(finished[i])
+				}()
+				errs[i] = task(i)
+			}(i)
+		}
+	}()
+	for i := 0; i < n; i++ {
+		<-finished[i]
+		if done != nil {
+			done(i, errs[i])
+		}
+	}
+	return errs
+}
+
+// interruptible makes a task fail with errInterrupted, instead of starting,
+// after the user interrupts the command.
+func interruptible(task func(i int) error) func(i int) error {
+	return func(i int) error {
+		if interrupted() {
+			return errInterrupted
+		}
+		return task(i)
+	}
+}
+
+// firstError returns the first non-nil error in the list.
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
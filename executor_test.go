@@ -0,0 +1,96 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerLimit(t *testing.T) {
+	defer os.Unsetenv("TRANOR_WORKERS")
+	var tests = []struct {
+		value    string
+		expected int
+	}{
+		{"", defaultWorkers},
+		{"10", 10},
+		{"1", 1},
+		{"0", defaultWorkers},
+		{"-2", defaultWorkers},
+		{"many", defaultWorkers},
+	}
+	for _, test := range tests {
+		os.Setenv("TRANOR_WORKERS", test.value)
+		if got := workerLimit(); got != test.expected {
+			t.Errorf("TRANOR_WORKERS=%q: want %d, got %d", test.value, test.expected, got)
+		}
+	}
+}
+
+func TestRunConcurrently(t *testing.T) {
+	os.Setenv("TRANOR_WORKERS", "3")
+	defer os.Unsetenv("TRANOR_WORKERS")
+	var (
+		mu             sync.Mutex
+		running, peak  int
+		doneOrder      []int
+		expectedErrors = make([]error, 10)
+	)
+	expectedErrors[4] = errors.New("failed 4")
+	expectedErrors[7] = errors.New("failed 7")
+	errs := runConcurrently(10, func(i int) error {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		// later tasks finish first, so done must wait for the previous ones
+		time.Sleep(time.Duration(10-i) * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if i == 4 || i == 7 {
+			return fmt.Errorf("failed %d", i)
+		}
+		return nil
+	}, func(i int, err error) {
+		doneOrder = append(doneOrder, i)
+		if !reflect.DeepEqual(err, expectedErrors[i]) {
+			t.Errorf("task %d: wrong error %v", i, err)
+		}
+	})
+	if !reflect.DeepEqual(errs, expectedErrors) {
+		t.Errorf("wrong errors\nwant %#v\ngot  %#v", expectedErrors, errs)
+	}
+	if expected := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}; !reflect.DeepEqual(doneOrder, expected) {
+		t.Errorf("wrong done order\nwant %#v\ngot  %#v", expected, doneOrder)
+	}
+	if peak > 3 {
+		t.Errorf("too many concurrent tasks: %d", peak)
+	}
+	if err := firstError(errs); err != errs[4] {
+		t.Errorf("wrong first error: %v", err)
+	}
+}
+
+func TestRunConcurrentlyNoTasks(t *testing.T) {
+	errs := runConcurrently(0, func(int) error {
+		t.Error("unexpected call to task")
+		return nil
+	}, nil)
+	if len(errs) != 0 {
+		t.Errorf("unexpected errors: %#v", errs)
+	}
+	if err := firstError(errs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"sort"
+	"sync"
 	"testing"
 )
 
@@ -20,6 +22,7 @@ type fakeServer struct {
 	payloads [][]byte
 	resps    []preparedResponse
 	t        *testing.T
+	mu       sync.Mutex
 }
 
 func newFakeServer(t *testing.T) *fakeServer {
@@ -41,9 +44,24 @@ func (s *fakeServer) prepareResponse(r preparedResponse) {
 	s.resps = append(s.resps, r)
 }
 
+// sortedRequests returns the method and path of the requests received by the
+// server, sorted, for tests of commands that send requests concurrently.
+func (s *fakeServer) sortedRequests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reqs := make([]string, len(s.reqs))
+	for i, req := range s.reqs {
+		reqs[i] = req.Method + " " + req.URL.Path
+	}
+	sort.Strings(reqs)
+	return reqs
+}
+
 func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	payload, _ := ioutil.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.reqs = append(s.reqs, *r)
 	s.payloads = append(s.payloads, payload)
 	var resp *preparedResponse
@@ -0,0 +1,178 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/tsuru/gnuflag"
+)
+
+const (
+	weeklyFreezeLayout = "15:04"
+	oneOffFreezeLayout = "2006-01-02 15:04"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Freeze is a period in which projects can't be deployed, promoted or
+// restarted. Recurring freezes are given like "Fri 16:00", and one-off freezes
+// like "2018-12-24 00:00", in the time zone of the freeze (UTC by default).
+type Freeze struct {
+	Name     string `json:"name"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// freezeWindow is the parsed period of a freeze.
+type freezeWindow struct {
+	weekly           bool
+	fromMin, toMin   int
+	fromTime, toTime time.Time
+	location         *time.Location
+}
+
+func (f *Freeze) window() (*freezeWindow, error) {
+	location, err := time.LoadLocation(f.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q", f.Timezone)
+	}
+	w := freezeWindow{location: location}
+	fromMin, fromWeekly := parseWeeklyTime(f.From)
+	toMin, toWeekly := parseWeeklyTime(f.To)
+	if fromWeekly && toWeekly {
+		if fromMin == toMin {
+			return nil, errors.New("the start and the end are the same")
+		}
+		w.weekly, w.fromMin, w.toMin = true, fromMin, toMin
+		return &w, nil
+	}
+	fromTime, fromErr := time.ParseInLocation(oneOffFreezeLayout, f.From, location)
+	toTime, toErr := time.ParseInLocation(oneOffFreezeLayout, f.To, location)
+	switch {
+	case !fromWeekly && fromErr != nil:
+		return nil, fmt.Errorf("invalid start %q, use a weekday and a time (like \"Fri 16:00\") or a date and a time (like \"2018-12-24 00:00\")", f.From)
+	case !toWeekly && toErr != nil:
+		return nil, fmt.Errorf("invalid end %q, use a weekday and a time (like \"Mon 08:00\") or a date and a time (like \"2018-12-26 08:00\")", f.To)
+	case fromWeekly || toWeekly:
+		return nil, errors.New("the start and the end must be both weekly or both dates")
+	case !toTime.After(fromTime):
+		return nil, errors.New("the end must be after the start")
+	}
+	w.fromTime, w.toTime = fromTime, toTime
+	return &w, nil
+}
+
+// parseWeeklyTime returns the minutes since the start of the week of a time
+// like "Fri 16:00".
+func parseWeeklyTime(value string) (int, bool) {
+	parts := strings.Fields(value)
+	if len(parts) != 2 {
+		return 0, false
+	}
+	day, ok := weekdays[strings.ToLower(parts[0])]
+	if !ok {
+		return 0, false
+	}
+	t, err := time.Parse(weeklyFreezeLayout, parts[1])
+	if err != nil {
+		return 0, false
+	}
+	return int(day)*24*60 + t.Hour()*60 + t.Minute(), true
+}
+
+func (w *freezeWindow) contains(now time.Time) bool {
+	now = now.In(w.location)
+	if !w.weekly {
+		return !now.Before(w.fromTime) && now.Before(w.toTime)
+	}
+	minute := int(now.Weekday())*24*60 + now.Hour()*60 + now.Minute()
+	if w.fromMin < w.toMin {
+		return minute >= w.fromMin && minute < w.toMin
+	}
+	// the freeze wraps around the end of the week, like "Fri 16:00" to
+	// "Mon 08:00".
+	return minute >= w.fromMin || minute < w.toMin
+}
+
+// active reports whether the freeze is in effect at the given time.
+func (f *Freeze) active(now time.Time) bool {
+	w, err := f.window()
+	return err == nil && w.contains(now)
+}
+
+func (f *Freeze) String() string {
+	period := fmt.Sprintf("%s - %s", f.From, f.To)
+	if f.Timezone != "" {
+		period += " " + f.Timezone
+	}
+	return fmt.Sprintf("%q (%s)", f.Name, period)
+}
+
+// activeFreeze returns the freeze in effect in the environment, or nil.
+func (c *Config) activeFreeze(envName string, now time.Time) *Freeze {
+	for i := range c.Freezes {
+		if c.Freezes[i].active(now) {
+			return &c.Freezes[i]
+		}
+	}
+	for _, env := range c.Environments {
+		if env.Name != envName {
+			continue
+		}
+		for i := range env.Freezes {
+			if env.Freezes[i].active(now) {
+				return &env.Freezes[i]
+			}
+		}
+	}
+	return nil
+}
+
+// freezeGuard enforces the deploy freezes defined in the configuration.
+type freezeGuard struct {
+	reason string
+}
+
+func (g *freezeGuard) addFlags(fs *gnuflag.FlagSet) {
+	fs.StringVar(&g.reason, "override-freeze", "", "reason for deploying or restarting the project during a deploy freeze")
+}
+
+// check refuses changes to frozen environments, unless overridden with a
+// reason, returning a message that records the reason.
+func (g *freezeGuard) check(w io.Writer, config *Config, envNames []string) (string, error) {
+	now := time.Now()
+	var overridden []string
+	for _, envName := range envNames {
+		freeze := config.activeFreeze(envName, now)
+		if freeze == nil {
+			continue
+		}
+		if g.reason == "" {
+			return "", fmt.Errorf("the environment %q is in the deploy freeze %s, use --override-freeze <reason> to override it", envName, freeze)
+		}
+		fmt.Fprintf(w, "Warning: overriding the deploy freeze %s in the environment %q.\n", freeze, envName)
+		if name := fmt.Sprintf("%q", freeze.Name); !containsString(overridden, name) {
+			overridden = append(overridden, name)
+		}
+	}
+	if len(overridden) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("deploy freeze %s overridden: %s", strings.Join(overridden, ", "), g.reason), nil
+}
@@ -0,0 +1,417 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+	"github.com/tsuru/tsuru-client/tsuru/client"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+func TestFreezeActive(t *testing.T) {
+	weekend := Freeze{Name: "weekend", From: "Fri 16:00", To: "Mon 08:00"}
+	lunch := Freeze{Name: "lunch", From: "wed 12:00", To: "Wed 13:30", Timezone: "America/Sao_Paulo"}
+	holidays := Freeze{Name: "holidays", From: "2018-12-22 00:00", To: "2019-01-02 08:00"}
+	var tests = []struct {
+		freeze   Freeze
+		now      string
+		expected bool
+	}{
+		{weekend, "2018-11-02T15:59:00Z", false},
+		{weekend, "2018-11-02T16:00:00Z", true},
+		{weekend, "2018-11-03T10:00:00Z", true},
+		{weekend, "2018-11-04T23:59:00Z", true},
+		{weekend, "2018-11-05T07:59:00Z", true},
+		{weekend, "2018-11-05T08:00:00Z", false},
+		{weekend, "2018-11-07T12:00:00Z", false},
+		{lunch, "2018-11-07T12:30:00Z", false},
+		{lunch, "2018-11-07T14:30:00Z", true},
+		{lunch, "2018-11-07T12:30:00-02:00", true},
+		{lunch, "2018-11-07T15:30:00Z", false},
+		{holidays, "2018-12-21T23:59:00Z", false},
+		{holidays, "2018-12-25T10:00:00Z", true},
+		{holidays, "2019-01-02T08:00:00Z", false},
+		{Freeze{Name: "broken", From: "Fri 16:00", To: "2019-01-02 08:00"}, "2018-11-02T17:00:00Z", false},
+	}
+	for _, test := range tests {
+		now, err := time.Parse(time.RFC3339, test.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if active := test.freeze.active(now); active != test.expected {
+			t.Errorf("wrong result for %s at %s. Want %v. Got %v", &test.freeze, test.now, test.expected, active)
+		}
+	}
+}
+
+func TestFreezeWindowInvalid(t *testing.T) {
+	var tests = []struct {
+		freeze      Freeze
+		expectedErr string
+	}{
+		{
+			Freeze{From: "Fri 16:00", To: "Mon 08:00", Timezone: "Mars/Olympus_Mons"},
+			`invalid time zone "Mars/Olympus_Mons"`,
+		},
+		{
+			Freeze{From: "Friday", To: "Mon 08:00"},
+			`invalid start "Friday", use a weekday and a time (like "Fri 16:00") or a date and a time (like "2018-12-24 00:00")`,
+		},
+		{
+			Freeze{From: "2018-12-22 00:00", To: "2019-01-02"},
+			`invalid end "2019-01-02", use a weekday and a time (like "Mon 08:00") or a date and a time (like "2018-12-26 08:00")`,
+		},
+		{
+			Freeze{From: "Fri 16:00", To: "2019-01-02 08:00"},
+			"the start and the end must be both weekly or both dates",
+		},
+		{
+			Freeze{From: "2019-01-02 08:00", To: "2018-12-22 00:00"},
+			"the end must be after the start",
+		},
+		{
+			Freeze{From: "Fri 16:00", To: "fri 16:00"},
+			"the start and the end are the same",
+		},
+	}
+	for _, test := range tests {
+		_, err := test.freeze.window()
+		if err == nil || err.Error() != test.expectedErr {
+			t.Errorf("wrong error for %#v\nwant %q\ngot  %v", test.freeze, test.expectedErr, err)
+		}
+	}
+}
+
+func TestConfigActiveFreeze(t *testing.T) {
+	config := Config{
+		Freezes: []Freeze{{Name: "holidays", From: "2018-12-22 00:00", To: "2019-01-02 08:00"}},
+		Environments: []Environment{
+			{Name: "dev"},
+			{Name: "prod", Freezes: []Freeze{{Name: "weekend", From: "Fri 16:00", To: "Mon 08:00"}}},
+		},
+	}
+	var tests = []struct {
+		env      string
+		now      time.Time
+		expected string
+	}{
+		{"dev", time.Date(2018, 11, 3, 10, 0, 0, 0, time.UTC), ""},
+		{"prod", time.Date(2018, 11, 3, 10, 0, 0, 0, time.UTC), "weekend"},
+		{"prod", time.Date(2018, 11, 7, 10, 0, 0, 0, time.UTC), ""},
+		{"dev", time.Date(2018, 12, 25, 10, 0, 0, 0, time.UTC), "holidays"},
+		{"prod", time.Date(2018, 12, 29, 10, 0, 0, 0, time.UTC), "holidays"},
+	}
+	for _, test := range tests {
+		var name string
+		if freeze := config.activeFreeze(test.env, test.now); freeze != nil {
+			name = freeze.Name
+		}
+		if name != test.expected {
+			t.Errorf("wrong freeze in %q at %s. Want %q. Got %q", test.env, test.now, test.expected, name)
+		}
+	}
+}
+
+// activeFreezeNow returns a one-off freeze that is in effect now.
+func activeFreezeNow(name string) Freeze {
+	now := time.Now().UTC()
+	return Freeze{
+		Name: name,
+		From: now.Add(-time.Hour).Format(oneOffFreezeLayout),
+		To:   now.Add(time.Hour).Format(oneOffFreezeLayout),
+	}
+}
+
+func TestFreezeGuardCheck(t *testing.T) {
+	config := Config{
+		Freezes: []Freeze{activeFreezeNow("release")},
+		Environments: []Environment{
+			{Name: "dev"},
+			{Name: "stage"},
+			{Name: "prod", Freezes: []Freeze{activeFreezeNow("holidays")}},
+		},
+	}
+	var g freezeGuard
+	var stderr bytes.Buffer
+	_, err := g.check(&stderr, &config, []string{"prod"})
+	expectedPrefix := `the environment "prod" is in the deploy freeze "release" (`
+	if err == nil || !strings.HasPrefix(err.Error(), expectedPrefix) || !strings.HasSuffix(err.Error(), "), use --override-freeze <reason> to override it") {
+		t.Errorf("wrong error\nwant %q...\ngot  %v", expectedPrefix, err)
+	}
+	config.Freezes = nil
+	g.reason = "fix the checkout"
+	message, err := g.check(&stderr, &config, []string{"dev", "stage", "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMessage := `deploy freeze "holidays" overridden: fix the checkout`
+	if message != expectedMessage {
+		t.Errorf("wrong message\nwant %q\ngot  %q", expectedMessage, message)
+	}
+	expectedWarning := `Warning: overriding the deploy freeze "holidays" (`
+	if !strings.HasPrefix(stderr.String(), expectedWarning) || !strings.HasSuffix(stderr.String(), ` in the environment "prod".`+"\n") {
+		t.Errorf("wrong warning\nwant %q...\ngot  %q", expectedWarning, stderr.String())
+	}
+	message, err = g.check(&stderr, &config, []string{"dev", "stage"})
+	if err != nil || message != "" {
+		t.Errorf("unexpected result for environments not in a freeze: %q, %v", message, err)
+	}
+}
+
+func TestProjectPromoteDuringFreeze(t *testing.T) {
+	fakeServer := newPromotionFakeServer(t, "proj1", "qa")
+	defer fakeServer.stop()
+	cleanup, err := setupFakeConfig(fakeServer.url(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupEnvConstraints(t, func(envs []Environment) {
+		envs[2].Freezes = []Freeze{activeFreezeNow("release")}
+	})
+	oldCommand := tsuruDeployCommand
+	fakeCommand := fakeTsuruCommand{FlaggedCommand: &client.AppDeploy{}}
+	tsuruDeployCommand = &fakeCommand
+	defer func() {
+		tsuruDeployCommand = oldCommand
+		cleanup()
+	}()
+	var c projectPromote
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	cli := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	c.Flags().Parse(true, []string{"-n", "proj1", "--from", "qa", "--to", "stage"})
+	err = c.Run(&ctx, cli)
+	if err == nil || !strings.HasPrefix(err.Error(), `the environment "stage" is in the deploy freeze "release"`) {
+		t.Errorf("wrong error returned: %v", err)
+	}
+	if fakeCommand.called {
+		t.Error("unexpected deploy during the freeze")
+	}
+	c = projectPromote{}
+	c.Flags().Parse(true, []string{"-n", "proj1", "--from", "qa", "--to", "stage", "--override-freeze", "security fix"})
+	err = c.Run(&ctx, cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedFlags := map[string]string{
+		"a":       "proj1-stage",
+		"app":     "proj1-stage",
+		"i":       "docker-registry.example.com/tsuru/app-proj1-qa:v938",
+		"image":   "docker-registry.example.com/tsuru/app-proj1-qa:v938",
+		"m":       `deploy freeze "release" overridden: security fix`,
+		"message": `deploy freeze "release" overridden: security fix`,
+	}
+	if flags := fakeCommand.inputFlags(); !reflect.DeepEqual(flags, expectedFlags) {
+		t.Errorf("wrong flags used\nwant %#v\ngot  %#v", expectedFlags, flags)
+	}
+	if !strings.HasPrefix(stderr.String(), `Warning: overriding the deploy freeze "release"`) {
+		t.Errorf("missing warning in the output: %q", stderr.String())
+	}
+}
+
+func TestProjectEnvVarSetDuringFreeze(t *testing.T) {
+	cleanup := createTestProject("myproj", t)
+	defer cleanup()
+	setupEnvConstraints(t, func(envs []Environment) {
+		envs[3].Freezes = []Freeze{activeFreezeNow("release")}
+	})
+	var c projectEnvVarSet
+	c.Flags().Parse(true, []string{"-n", "myproj", "-e", "prod"})
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Args: []string{"FOO=bar"}, Stdout: &stdout, Stderr: &stderr}
+	cli := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err := c.Run(&ctx, cli)
+	if err == nil || !strings.HasPrefix(err.Error(), `the environment "prod" is in the deploy freeze "release"`) {
+		t.Errorf("wrong error returned: %v", err)
+	}
+	envVars, err := testAPIClient(cli).GetEnvVars(requestContext, "myproj-prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envVarDefined(envVars, "FOO", "bar") {
+		t.Error("variable should not be set during the freeze")
+	}
+	c = projectEnvVarSet{}
+	c.Flags().Parse(true, []string{"-n", "myproj", "-e", "prod", "--no-restart"})
+	err = c.Run(&ctx, cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envVars, err = testAPIClient(cli).GetEnvVars(requestContext, "myproj-prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !envVarDefined(envVars, "FOO", "bar") {
+		t.Error("variable should be set without restarting the app")
+	}
+}
+
+func TestProjectEnvVarUnsetFreezeInOtherEnv(t *testing.T) {
+	tsuruServer.reset()
+	cleanup, err := setupFakeConfig(tsuruServer.url(), tsuruServer.token())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	setupEnvConstraints(t, func(envs []Environment) {
+		envs[3].Freezes = []Freeze{activeFreezeNow("release")}
+	})
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Args: []string{"FOO"}, Stdout: &stdout, Stderr: &stderr}
+	cli := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	appMaps, err := createApps([]Environment{{Name: "dev", DNSSuffix: "dev.example.com"}}, testAPIClient(cli), "myproj", tsuru.CreateAppOptions{
+		Team:     "myteam",
+		Platform: "python",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = setCNames(appMaps, testAPIClient(cli))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c projectEnvVarUnset
+	c.Flags().Parse(true, []string{"-n", "myproj"})
+	err = c.Run(&ctx, cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOutput := "unsetting variables from environment \"dev\"... ok\n"
+	if stdout.String() != expectedOutput {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expectedOutput, stdout.String())
+	}
+}
+
+func TestProjectDeployRollbackDuringFreeze(t *testing.T) {
+	fakeServer, cleanup := prepareRollbackServer(t)
+	defer cleanup()
+	setupEnvConstraints(t, func(envs []Environment) {
+		envs[3].Freezes = []Freeze{activeFreezeNow("release")}
+	})
+	var c projectDeployRollback
+	c.Flags().Parse(true, []string{"-n", "proj1", "-e", "prod"})
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr, Stdin: strings.NewReader("proj1\n")}
+	cli := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err := c.Run(&ctx, cli)
+	if err == nil || !strings.HasPrefix(err.Error(), `the environment "prod" is in the deploy freeze "release"`) {
+		t.Errorf("wrong error returned: %v", err)
+	}
+	expectedReqs := []string{"GET /1.0/deploys"}
+	if reqs := fakeServer.sortedRequests(); !reflect.DeepEqual(reqs, expectedReqs) {
+		t.Fatalf("wrong requests\nwant %#v\ngot  %#v", expectedReqs, reqs)
+	}
+	c = projectDeployRollback{}
+	c.Flags().Parse(true, []string{"-n", "proj1", "-e", "prod", "--override-freeze", "the deploy broke the checkout"})
+	err = c.Run(&ctx, cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := url.ParseQuery(string(fakeServer.payloads[2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedValues := url.Values{
+		"origin":  {"rollback"},
+		"image":   {"registry.example.com/tsuru/app-proj1-prod:v1"},
+		"message": {`deploy freeze "release" overridden: the deploy broke the checkout`},
+	}
+	if !reflect.DeepEqual(values, expectedValues) {
+		t.Errorf("wrong payload\nwant %#v\ngot  %#v", expectedValues, values)
+	}
+}
+
+func TestProjectApplyDuringFreeze(t *testing.T) {
+	cleanup := createTestProject("myproj", t)
+	defer cleanup()
+	setupEnvConstraints(t, func(envs []Environment) {
+		envs[3].Freezes = []Freeze{activeFreezeNow("release")}
+	})
+	manifestPath := writeTestManifest(t, `name: myproj
+platform: python
+envs:
+  - name: dev
+  - name: qa
+  - name: stage
+  - name: prod
+    envVars:
+      LOG_LEVEL: debug
+`)
+	var c projectApply
+	c.Flags().Parse(true, []string{"-f", manifestPath, "-y"})
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	cli := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err := c.Run(&ctx, cli)
+	if err == nil || !strings.HasPrefix(err.Error(), `the environment "prod" is in the deploy freeze "release"`) {
+		t.Errorf("wrong error returned: %v", err)
+	}
+	envVars, err := testAPIClient(cli).GetEnvVars(requestContext, "myproj-prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envVarDefined(envVars, "LOG_LEVEL", "debug") {
+		t.Error("variable should not be set during the freeze")
+	}
+	c = projectApply{}
+	c.Flags().Parse(true, []string{"-f", manifestPath, "-y", "--override-freeze", "enable debug logs"})
+	err = c.Run(&ctx, cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envVars, err = testAPIClient(cli).GetEnvVars(requestContext, "myproj-prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !envVarDefined(envVars, "LOG_LEVEL", "debug") {
+		t.Error("variable should be set when the freeze is overridden")
+	}
+}
+
+func TestProjectUpdateDuringFreeze(t *testing.T) {
+	cleanup := createTestProject("myproj", t)
+	defer cleanup()
+	setupEnvConstraints(t, func(envs []Environment) {
+		envs[3].Freezes = []Freeze{activeFreezeNow("release")}
+		envs[3].EnvVars = map[string]string{"LOG_LEVEL": "warn"}
+	})
+	var c projectUpdate
+	c.Flags().Parse(true, []string{"-n", "myproj", "-d", "updated project"})
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	cli := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err := c.Run(&ctx, cli)
+	if err == nil || !strings.HasPrefix(err.Error(), `the environment "prod" is in the deploy freeze "release"`) {
+		t.Errorf("wrong error returned: %v", err)
+	}
+	a, err := testAPIClient(cli).GetApp(requestContext, "myproj-prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Description != "some project" {
+		t.Errorf("project should not be updated during the freeze: %q", a.Description)
+	}
+	c = projectUpdate{}
+	c.Flags().Parse(true, []string{"-n", "myproj", "-d", "updated project", "--override-freeze", "set the log level"})
+	err = c.Run(&ctx, cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envVars, err := testAPIClient(cli).GetEnvVars(requestContext, "myproj-prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !envVarDefined(envVars, "LOG_LEVEL", "warn") {
+		t.Error("variable should be set when the freeze is overridden")
+	}
+}
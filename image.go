@@ -0,0 +1,98 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ef-ctx/tsuru-flow/registry"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+var registryClient = &registry.Client{Credentials: dockerCredentials}
+
+// copyDestination returns the image in the registry of toEnv that a promoted
+// image is copied to, or the image itself when both envs share the registry.
+func copyDestination(config *Config, image, fromEnv, toEnv string) (string, error) {
+	from, to := config.registry(fromEnv), config.registry(toEnv)
+	if from == to {
+		return image, nil
+	}
+	if from == "" {
+		return "", fmt.Errorf("cannot copy the image, the environment %q doesn't define a registry", fromEnv)
+	}
+	if to == "" {
+		return "", fmt.Errorf("cannot copy the image, the environment %q doesn't define a registry", toEnv)
+	}
+	if !strings.HasPrefix(image, from+"/") {
+		return "", fmt.Errorf("cannot copy the image %q, it's not in the registry of the environment %q (%s)", image, fromEnv, from)
+	}
+	return to + strings.TrimPrefix(image, from), nil
+}
+
+// copyImage copies the image src to dst, returning the digest of the image.
+func copyImage(src, dst string) (string, error) {
+	srcRef, err := registry.ParseReference(src)
+	if err != nil {
+		return "", err
+	}
+	dstRef, err := registry.ParseReference(dst)
+	if err != nil {
+		return "", err
+	}
+	return registryClient.Copy(requestContext, srcRef, dstRef)
+}
+
+// pinnedImage returns the image referenced by its digest.
+func pinnedImage(image, digest string) (string, error) {
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+	return ref.Registry + "/" + ref.Repository + "@" + digest, nil
+}
+
+// dockerCredentials returns the credentials stored by docker login, in
+// DOCKER_CONFIG or ~/.docker. Credential helpers are not supported.
+func dockerCredentials(registry string) (string, string) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		dir = cmd.JoinWithUserDir(".docker")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return "", ""
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if json.Unmarshal(data, &config) != nil {
+		return "", ""
+	}
+	for _, key := range []string{registry, "https://" + registry, "http://" + registry} {
+		entry, ok := config.Auths[key]
+		if !ok {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return "", ""
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return "", ""
+		}
+		return parts[0], parts[1]
+	}
+	return "", ""
+}
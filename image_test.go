@@ -0,0 +1,130 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyDestination(t *testing.T) {
+	config := Config{
+		Registry: "registry.example.com",
+		Environments: []Environment{
+			{Name: "dev"},
+			{Name: "stage"},
+			{Name: "prod", Registry: "prod-registry.example.com"},
+		},
+	}
+	var tests = []struct {
+		testCase      string
+		config        Config
+		image         string
+		from          string
+		to            string
+		expectedImage string
+		expectedErr   string
+	}{
+		{
+			"same registry",
+			config,
+			"registry.example.com/tsuru/app-proj1-dev:v3",
+			"dev",
+			"stage",
+			"registry.example.com/tsuru/app-proj1-dev:v3",
+			"",
+		},
+		{
+			"different registries",
+			config,
+			"registry.example.com/tsuru/app-proj1-stage:v3",
+			"stage",
+			"prod",
+			"prod-registry.example.com/tsuru/app-proj1-stage:v3",
+			"",
+		},
+		{
+			"image in other registry",
+			config,
+			"other-registry.example.com/tsuru/app-proj1-stage:v3",
+			"stage",
+			"prod",
+			"",
+			`cannot copy the image "other-registry.example.com/tsuru/app-proj1-stage:v3", it's not in the registry of the environment "stage" (registry.example.com)`,
+		},
+		{
+			"no registry in the source",
+			Config{Environments: []Environment{{Name: "stage"}, {Name: "prod", Registry: "prod-registry.example.com"}}},
+			"tsuru/app-proj1-stage:v3",
+			"stage",
+			"prod",
+			"",
+			`cannot copy the image, the environment "stage" doesn't define a registry`,
+		},
+		{
+			"no registry in the target",
+			Config{Environments: []Environment{{Name: "stage", Registry: "registry.example.com"}, {Name: "prod"}}},
+			"registry.example.com/tsuru/app-proj1-stage:v3",
+			"stage",
+			"prod",
+			"",
+			`cannot copy the image, the environment "prod" doesn't define a registry`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.testCase, func(t *testing.T) {
+			image, err := copyDestination(&test.config, test.image, test.from, test.to)
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Errorf("wrong error\nwant %q\ngot  %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image != test.expectedImage {
+				t.Errorf("wrong image\nwant %q\ngot  %q", test.expectedImage, image)
+			}
+		})
+	}
+}
+
+func TestDockerCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("DOCKER_CONFIG", dir)
+	defer os.Unsetenv("DOCKER_CONFIG")
+	config := `{"auths": {
+	"registry.example.com": {"auth": "dXNlcjpzM2NyM3Q6eA=="},
+	"https://prod-registry.example.com": {"auth": "cHJvZDpwYXNz"},
+	"broken.example.com": {"auth": "!!!"}
+}}`
+	err = ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		registry string
+		username string
+		password string
+	}{
+		{"registry.example.com", "user", "s3cr3t:x"},
+		{"prod-registry.example.com", "prod", "pass"},
+		{"broken.example.com", "", ""},
+		{"other.example.com", "", ""},
+	}
+	for _, test := range tests {
+		username, password := dockerCredentials(test.registry)
+		if username != test.username || password != test.password {
+			t.Errorf("wrong credentials for %q\nwant %q:%q\ngot  %q:%q", test.registry, test.username, test.password, username, password)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+var errInterrupted = errors.New("interrupted by the user")
+
+// cleanupTimeout bounds the requests that undo changes.
+const cleanupTimeout = time.Minute
+
+var (
+	// requestContext is canceled when the user interrupts a command twice.
+	requestContext, cancelRequests = context.WithCancel(context.Background())
+
+	// stopRequested is closed when the user interrupts a command.
+	stopRequested = make(chan struct{})
+	stopOnce      sync.Once
+)
+
+// cleanupContext returns the context of the requests that undo changes,
+// which isn't canceled when the user abandons the command.
+func cleanupContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), cleanupTimeout)
+}
+
+// requestStop asks the running command to stop after the current step.
+func requestStop() {
+	stopOnce.Do(func() {
+		close(stopRequested)
+	})
+}
+
+// interrupted reports whether the user asked the running command to stop.
+func interrupted() bool {
+	select {
+	case <-stopRequested:
+		return true
+	default:
+		return false
+	}
+}
+
+// catchInterrupts handles SIGINT: the first signal stops the command after the
+// current step, the second one cancels the requests in progress. The returned
+// function stops the handling.
+func catchInterrupts(w io.Writer) func() {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		fmt.Fprintln(w, "\ninterrupted, stopping after the current step (press Ctrl-C again to abandon it)")
+		requestStop()
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		fmt.Fprintln(w, "\nabandoning the current step")
+		signal.Stop(signals)
+		cancelRequests()
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tsuru/tsuru/cmd"
+)
+
+// resetInterrupts restores the state of the interruption handling, so a test
+// can interrupt commands without affecting other tests.
+func resetInterrupts() {
+	requestContext, cancelRequests = context.WithCancel(context.Background())
+	stopRequested = make(chan struct{})
+	stopOnce = sync.Once{}
+}
+
+func TestCatchInterrupts(t *testing.T) {
+	defer resetInterrupts()
+	var stderr bytes.Buffer
+	restore := catchInterrupts(&stderr)
+	defer restore()
+	syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	waitFor(t, "the stop request", interrupted)
+	if requestContext.Err() != nil {
+		t.Fatal("the first interruption shouldn't cancel the requests")
+	}
+	syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	waitFor(t, "the cancellation of the requests", func() bool {
+		return requestContext.Err() != nil
+	})
+	expectedOutput := "\ninterrupted, stopping after the current step (press Ctrl-C again to abandon it)\n\nabandoning the current step\n"
+	if stderr.String() != expectedOutput {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expectedOutput, stderr.String())
+	}
+}
+
+func waitFor(t *testing.T, desc string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", desc)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestInterruptible(t *testing.T) {
+	defer resetInterrupts()
+	var ran []int
+	task := interruptible(func(i int) error {
+		ran = append(ran, i)
+		if i == 1 {
+			requestStop()
+		}
+		return nil
+	})
+	var errs []error
+	for i := 0; i < 4; i++ {
+		errs = append(errs, task(i))
+	}
+	if expected := []int{0, 1}; !reflect.DeepEqual(ran, expected) {
+		t.Errorf("wrong tasks executed\nwant %#v\ngot  %#v", expected, ran)
+	}
+	expectedErrs := []error{nil, nil, errInterrupted, errInterrupted}
+	if !reflect.DeepEqual(errs, expectedErrs) {
+		t.Errorf("wrong errors\nwant %#v\ngot  %#v", expectedErrs, errs)
+	}
+}
+
+func TestApplyStepsInterrupted(t *testing.T) {
+	defer resetInterrupts()
+	var stdout bytes.Buffer
+	steps := []applyStep{
+		{desc: "~ update env \"dev\"", run: func() error { return nil }},
+		{desc: "~ set variables in env \"dev\": DB", run: func() error {
+			requestStop()
+			return nil
+		}},
+		{desc: "+ create env \"prod\"", run: func() error { return errors.New("should not run") }},
+		{desc: "- remove env \"stage\"", run: func() error { return errors.New("should not run") }},
+	}
+	err := applySteps(&stdout, steps)
+	if err != errInterrupted {
+		t.Errorf("wrong error. Want %#v. Got %#v", errInterrupted, err)
+	}
+	expectedOutput := `~ update env "dev"... ok
+~ set variables in env "dev": DB... ok
+interrupted, the following changes were not applied:
+ + create env "prod"
+ - remove env "stage"
+`
+	if stdout.String() != expectedOutput {
+		t.Errorf("wrong output\nwant:\n%s\ngot:\n%s", expectedOutput, stdout.String())
+	}
+}
+
+func TestProjectEnvVarSetInterrupted(t *testing.T) {
+	defer resetInterrupts()
+	cleanup := createTestProject("myproj", t)
+	defer cleanup()
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr, Args: []string{"USER_NAME=root"}}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	var c projectEnvVarSet
+	err := c.Flags().Parse(true, []string{"-n", "myproj", "-e", "dev,qa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestStop()
+	err = c.Run(&ctx, client)
+	if err != errInterrupted {
+		t.Errorf("wrong error. Want %#v. Got %#v", errInterrupted, err)
+	}
+	expectedOutput := `setting variables in environment "dev"... skipped
+setting variables in environment "qa"... skipped
+`
+	if stdout.String() != expectedOutput {
+		t.Errorf("wrong output\nwant:\n%s\ngot:\n%s", expectedOutput, stdout.String())
+	}
+	vars, err := testAPIClient(client).GetEnvVars(requestContext, "myproj-dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envVarDefined(vars, "USER_NAME", "root") {
+		t.Error("variable set after the interruption")
+	}
+}
+
+func TestProjectCreateInterrupted(t *testing.T) {
+	defer resetInterrupts()
+	tsuruServer.reset()
+	cleanup, err := setupFakeConfig(tsuruServer.url(), tsuruServer.token())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	var c projectCreate
+	err = c.Flags().Parse(true, []string{"-n", "myproj", "-l", "python", "-t", "myteam"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestStop()
+	err = c.Run(&ctx, client)
+	if err == nil {
+		t.Fatal("unexpected <nil> error")
+	}
+	apps, err := testAPIClient(client).ListApps(requestContext, map[string]string{"name": "^myproj"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) > 0 {
+		t.Errorf("apps left after the interruption: %#v", apps)
+	}
+}
+
+func TestProjectCreateAbandonedDuringStep(t *testing.T) {
+	defer resetInterrupts()
+	tsuruServer.reset()
+	target, err := url.Parse(tsuruServer.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsuruProxy := httputil.NewSingleHostReverseProxy(target)
+	tsuruProxy.ErrorLog = log.New(ioutil.Discard, "", 0)
+	stop := make(chan struct{})
+	// abandons the command while the cname of the prod app is being set,
+	// like pressing Ctrl-C twice would do.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/1.0/apps/myproj-prod/cname" {
+			cancelRequests()
+			select {
+			case <-r.Context().Done():
+			case <-stop:
+			}
+			return
+		}
+		tsuruProxy.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	defer close(stop)
+	cleanup, err := setupFakeConfig(server.URL, tsuruServer.token())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	var c projectCreate
+	err = c.Flags().Parse(true, []string{"-n", "myproj", "-l", "python", "-t", "myteam", "-e", "dev,prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Run(&ctx, client)
+	if err == nil {
+		t.Fatal("unexpected <nil> error")
+	}
+	resetInterrupts()
+	apps, err := testAPIClient(client).ListApps(requestContext, map[string]string{"name": "^myproj"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) > 0 {
+		t.Errorf("apps left after abandoning the command: %#v", apps)
+	}
+}
@@ -5,46 +5,272 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
-	"strconv"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/ef-ctx/tsuru-flow/tsuru"
 	"github.com/tsuru/gnuflag"
-	"github.com/tsuru/tsuru-client/tsuru/client"
 	"github.com/tsuru/tsuru/cmd"
+	tsuruerrors "github.com/tsuru/tsuru/errors"
 )
 
+// logReconnectDelay is the time project-log waits before reconnecting to a
+// log stream that dropped while following the logs.
+var logReconnectDelay = 2 * time.Second
+
+// filteredLogLines is the number of log lines fetched from each environment
+// when the logs are filtered with --grep, --exclude or --since, which are
+// applied by tranor, not by the API.
+const filteredLogLines = 5000
+
+// envTagColors are the colors of the tags of the environments in the logs.
+var envTagColors = []string{"green", "yellow", "magenta", "cyan", "red"}
+
 type projectLog struct {
 	fs         *gnuflag.FlagSet
 	name       string
-	envName    string
+	envs       commaSeparatedFlag
 	lines      int
 	follow     bool
 	omitDate   bool
 	omitSource bool
+	unit       string
+	source     string
+	grep       string
+	exclude    string
+	since      string
+	format     string
 }
 
 func (c *projectLog) Info() *cmd.Info {
 	return &cmd.Info{
 		Name: "project-log",
-		Desc: "Display logs of the given project and environment",
+		Desc: "Display logs of the given project, in all environments or in the given ones",
 	}
 }
 
 func (c *projectLog) Run(ctx *cmd.Context, cli *cmd.Client) error {
-	if c.name == "" || c.envName == "" {
-		return errors.New("please provide the project name and the environment")
-	}
-	appName := fmt.Sprintf("%s-%s", c.name, c.envName)
-	var appLog client.AppLog
-	appLog.Flags().Parse(true, []string{
-		"--app=" + appName,
-		"--lines=" + strconv.Itoa(c.lines),
-		"--follow=" + strconv.FormatBool(c.follow),
-		"--no-date=" + strconv.FormatBool(c.omitDate),
-		"--no-source=" + strconv.FormatBool(c.omitSource),
+	if c.name == "" {
+		return errors.New("please provide the name of the project")
+	}
+	if c.format != "text" && c.format != "jsonl" {
+		return fmt.Errorf("invalid output format %q, valid formats are: text and jsonl", c.format)
+	}
+	filter, err := c.filter(time.Now())
+	if err != nil {
+		return err
+	}
+	config, err := loadConfigFile()
+	if err != nil {
+		return errors.New("unable to load environments file, please make sure that tranor is properly configured")
+	}
+	envNames := c.envs.Values()
+	if len(envNames) == 0 {
+		envNames = config.envNames()
+	}
+	apiClient, err := newAPIClient(cli)
+	if err != nil {
+		return err
+	}
+	appNames := make([]string, len(envNames))
+	for i, envName := range envNames {
+		appNames[i] = config.appName(c.name, envName)
+	}
+	p := logPrinter{
+		stdout:     ctx.Stdout,
+		stderr:     ctx.Stderr,
+		omitDate:   c.omitDate,
+		omitSource: c.omitSource,
+		jsonLines:  c.format == "jsonl",
+		filter:     filter,
+	}
+	if len(envNames) > 1 {
+		p.tags = make(map[string]string, len(envNames))
+		for i, envName := range envNames {
+			p.tags[envName] = cmd.Colorfy("["+envName+"]", envTagColors[i%len(envTagColors)], "", "")
+		}
+	}
+	if c.follow {
+		return c.followLogs(apiClient, envNames, appNames, &p)
+	}
+	return c.showLogs(apiClient, envNames, appNames, &p)
+}
+
+// showLogs displays the last lines of the log of each app that match the
+// filter, merged and ordered by date.
+func (c *projectLog) showLogs(client *tsuru.Client, envNames, appNames []string, p *logPrinter) error {
+	results := make([][]tsuru.Log, len(appNames))
+	errs := runConcurrently(len(appNames), func(i int) error {
+		return client.StreamLogs(requestContext, appNames[i], c.logOptions(false), func(l tsuru.Log) error {
+			if !p.filter.match(l) {
+				return nil
+			}
+			results[i] = append(results[i], l)
+			if len(results[i]) > c.lines {
+				results[i] = results[i][1:]
+			}
+			return nil
+		})
+	}, nil)
+	var entries []envLog
+	for i, envName := range envNames {
+		if err := errs[i]; err != nil {
+			if tsuru.IsNotFound(err) {
+				p.warnNotFound(envName)
+				continue
+			}
+			return err
+		}
+		for _, l := range results[i] {
+			entries = append(entries, envLog{env: envName, app: appNames[i], Log: l})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Date.Before(entries[j].Date)
 	})
-	return appLog.Run(ctx, cli)
+	for _, entry := range entries {
+		p.print(entry)
+	}
+	return nil
+}
+
+// followLogs streams the logs of all apps at the same time, reconnecting to
+// the streams that drop.
+func (c *projectLog) followLogs(client *tsuru.Client, envNames, appNames []string, p *logPrinter) error {
+	errs := make([]error, len(appNames))
+	var wg sync.WaitGroup
+	for i := range appNames {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = c.followLog(client, envNames[i], appNames[i], p)
+		}(i)
+	}
+	wg.Wait()
+	return firstError(errs)
+}
+
+func (c *projectLog) followLog(client *tsuru.Client, envName, appName string, p *logPrinter) error {
+	// printed holds the last entries displayed, all with the same date.
+	// After reconnecting, the API sends again the entries that were
+	// already displayed, which are skipped up to the last one of them.
+	var printed []tsuru.Log
+	opts := c.logOptions(true)
+	for {
+		replayed := append([]tsuru.Log(nil), printed...)
+		err := client.StreamLogs(requestContext, appName, opts, func(l tsuru.Log) error {
+			if len(replayed) > 0 {
+				if l.Date.Before(replayed[0].Date) {
+					return nil
+				}
+				if i := indexLog(replayed, l); i >= 0 {
+					replayed = append(replayed[:i], replayed[i+1:]...)
+					return nil
+				}
+				replayed = nil
+			}
+			if len(printed) > 0 && !l.Date.Equal(printed[0].Date) {
+				printed = printed[:0]
+			}
+			printed = append(printed, l)
+			p.print(envLog{env: envName, app: appName, Log: l})
+			return nil
+		})
+		if requestContext.Err() != nil {
+			return nil
+		}
+		if tsuru.IsNotFound(err) {
+			p.warnNotFound(envName)
+			return nil
+		}
+		if !reconnectable(err) {
+			return err
+		}
+		p.warnReconnect(envName, err)
+		select {
+		case <-time.After(logReconnectDelay):
+		case <-requestContext.Done():
+			return nil
+		}
+	}
+}
+
+func indexLog(logs []tsuru.Log, l tsuru.Log) int {
+	for i := range logs {
+		if logs[i].Date.Equal(l.Date) && logs[i].Message == l.Message && logs[i].Source == l.Source && logs[i].Unit == l.Unit {
+			return i
+		}
+	}
+	return -1
+}
+
+// reconnectable reports whether a log stream that ended with err should be
+// opened again.
+func reconnectable(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return true
+	case *tsuru.HTTPError:
+		return e.StatusCode >= 500
+	case *tsuru.NotFoundError, *tsuru.ConflictError, *tsuru.ForbiddenError, *tsuruerrors.HTTP:
+		return false
+	}
+	return true
+}
+
+// logOptions returns the parameters of the log streams. Units and sources are
+// filtered by the API, while the other filters are applied by logPrinter, so
+// more lines are fetched to display the last lines that match them. When
+// following the logs, the filters apply only to the last lines.
+func (c *projectLog) logOptions(follow bool) tsuru.LogOptions {
+	opts := tsuru.LogOptions{Lines: c.lines, Follow: follow, Unit: c.unit, Source: c.source}
+	filtered := c.grep != "" || c.exclude != "" || c.since != ""
+	if filtered && !follow && opts.Lines < filteredLogLines {
+		opts.Lines = filteredLogLines
+	}
+	return opts
+}
+
+func (c *projectLog) filter(now time.Time) (logFilter, error) {
+	var (
+		f   logFilter
+		err error
+	)
+	if c.grep != "" {
+		if f.include, err = regexp.Compile(c.grep); err != nil {
+			return f, fmt.Errorf("invalid regular expression in --grep: %s", err)
+		}
+	}
+	if c.exclude != "" {
+		if f.exclude, err = regexp.Compile(c.exclude); err != nil {
+			return f, fmt.Errorf("invalid regular expression in --exclude: %s", err)
+		}
+	}
+	if c.since != "" {
+		if f.since, err = parseSince(c.since, now); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// parseSince parses the value of --since, which is either a duration,
+// relative to now, or an RFC 3339 timestamp.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid value for --since %q, please provide a duration (like 30m) or an RFC 3339 timestamp", value)
 }
 
 func (c *projectLog) Flags() *gnuflag.FlagSet {
@@ -52,14 +278,109 @@ func (c *projectLog) Flags() *gnuflag.FlagSet {
 		c.fs = gnuflag.NewFlagSet("project-log", gnuflag.ExitOnError)
 		c.fs.StringVar(&c.name, "name", "", "name of the project")
 		c.fs.StringVar(&c.name, "n", "", "name of the project")
-		c.fs.StringVar(&c.envName, "env", "", "name of the environment")
-		c.fs.StringVar(&c.envName, "e", "", "name of the environment")
-		c.fs.IntVar(&c.lines, "lines", 10, "number of log lines to display")
-		c.fs.IntVar(&c.lines, "l", 10, "number of log lines to display")
+		c.fs.Var(&c.envs, "env", "comma-separated list of environments to display the logs (defaults to all environments)")
+		c.fs.Var(&c.envs, "e", "comma-separated list of environments to display the logs (defaults to all environments)")
+		c.fs.IntVar(&c.lines, "lines", 10, "number of log lines to display from each environment (with --follow, the filters apply only to these lines)")
+		c.fs.IntVar(&c.lines, "l", 10, "number of log lines to display from each environment (with --follow, the filters apply only to these lines)")
 		c.fs.BoolVar(&c.follow, "follow", false, "follow logs")
 		c.fs.BoolVar(&c.follow, "f", false, "follow logs")
-		c.fs.BoolVar(&c.omitDate, "no-date", false, "follow logs")
-		c.fs.BoolVar(&c.omitSource, "no-source", false, "follow logs")
+		c.fs.BoolVar(&c.omitDate, "no-date", false, "omit the date of the log lines")
+		c.fs.BoolVar(&c.omitSource, "no-source", false, "omit the source of the log lines")
+		c.fs.StringVar(&c.unit, "unit", "", "display only the log lines of the given unit")
+		c.fs.StringVar(&c.unit, "u", "", "display only the log lines of the given unit")
+		c.fs.StringVar(&c.source, "source", "", "display only the log lines of the given source (like app or tsuru)")
+		c.fs.StringVar(&c.source, "s", "", "display only the log lines of the given source (like app or tsuru)")
+		c.fs.StringVar(&c.grep, "grep", "", "display only the log lines matching the given regular expression")
+		c.fs.StringVar(&c.grep, "g", "", "display only the log lines matching the given regular expression")
+		c.fs.StringVar(&c.exclude, "exclude", "", "omit the log lines matching the given regular expression")
+		c.fs.StringVar(&c.since, "since", "", "display only the log lines newer than the given duration (like 30m) or RFC 3339 timestamp")
+		c.fs.StringVar(&c.format, "format", "text", "output format: text or jsonl (one JSON object per line)")
 	}
 	return c.fs
 }
+
+// envLog is an entry of the log of the app of a project in an environment.
+type envLog struct {
+	tsuru.Log
+	env string
+	app string
+}
+
+// logFilter selects log entries by their message and date.
+type logFilter struct {
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+	since   time.Time
+}
+
+func (f *logFilter) match(l tsuru.Log) bool {
+	if !f.since.IsZero() && l.Date.Before(f.since) {
+		return false
+	}
+	if f.include != nil && !f.include.MatchString(l.Message) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.MatchString(l.Message)
+}
+
+// logPrinter writes the log entries that match the filter like tsuru app-log,
+// tagged with the environment, or as JSON lines.
+type logPrinter struct {
+	stdout     io.Writer
+	stderr     io.Writer
+	omitDate   bool
+	omitSource bool
+	jsonLines  bool
+	tags       map[string]string
+	filter     logFilter
+	mu         sync.Mutex
+}
+
+func (p *logPrinter) print(l envLog) {
+	if !p.filter.match(l.Log) {
+		return
+	}
+	if p.jsonLines {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		json.NewEncoder(p.stdout).Encode(logOutput{Env: l.env, App: l.app, Log: l.Log})
+		return
+	}
+	var prefix []string
+	if !p.omitDate {
+		prefix = append(prefix, l.Date.In(time.Local).Format("2006-01-02 15:04:05 -0700"))
+	}
+	if !p.omitSource {
+		if l.Unit != "" {
+			prefix = append(prefix, fmt.Sprintf("[%s][%s]", l.Source, l.Unit))
+		} else {
+			prefix = append(prefix, fmt.Sprintf("[%s]", l.Source))
+		}
+	}
+	line := l.Message
+	if len(prefix) > 0 {
+		line = cmd.Colorfy(strings.Join(prefix, " ")+":", "blue", "", "") + " " + line
+	}
+	if tag, ok := p.tags[l.env]; ok {
+		line = tag + " " + line
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintln(p.stdout, line)
+}
+
+func (p *logPrinter) warnNotFound(envName string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.stderr, "WARNING: project not found in environment %q\n", envName)
+}
+
+func (p *logPrinter) warnReconnect(envName string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		fmt.Fprintf(p.stderr, "WARNING: lost the log stream of environment %q (%s), reconnecting...\n", envName, err)
+	} else {
+		fmt.Fprintf(p.stderr, "WARNING: lost the log stream of environment %q, reconnecting...\n", envName)
+	}
+}
@@ -3,10 +3,18 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/ef-ctx/tsuru-flow/tsuru"
 	"github.com/tsuru/tsuru-client/tsuru/client"
 	"github.com/tsuru/tsuru/cmd"
 )
@@ -84,18 +92,415 @@ func TestProjectLogMissingName(t *testing.T) {
 	}
 }
 
-func TestProjectLogMissingEnv(t *testing.T) {
-	var c projectLog
-	err := c.Flags().Parse(true, []string{"-n", "proj1"})
+func TestProjectLogMultipleEnvs(t *testing.T) {
+	os.Setenv("TSURU_DISABLE_COLORS", "1")
+	defer os.Unsetenv("TSURU_DISABLE_COLORS")
+	tsuruServer := newFakeServer(t)
+	defer tsuruServer.stop()
+	cleanup, err := setupFakeConfig(tsuruServer.url(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	now := time.Now().UTC()
+	envLogs := map[string][]apiLog{
+		"dev": {
+			{Date: now, Message: "dev started", Source: "app", Unit: "abc"},
+			{Date: now.Add(2 * time.Hour), Message: "dev stopped", Source: "app", Unit: "abc"},
+		},
+		"qa": {
+			{Date: now.Add(time.Hour), Message: "qa started", Source: "tsuru"},
+		},
+	}
+	for envName, logs := range envLogs {
+		result, err := json.Marshal(logs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tsuruServer.prepareResponse(preparedResponse{
+			code:    http.StatusOK,
+			payload: result,
+			method:  "GET",
+			path:    "/apps/myapp-" + envName + "/log?lines=5",
+		})
+	}
+	var command projectLog
+	err = command.Flags().Parse(true, []string{"-n", "myapp", "-l", "5"})
 	if err != nil {
 		t.Fatal(err)
 	}
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	cli := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	err = c.Run(&ctx, cli)
-	if err == nil {
-		t.Fatal("got unexpected <nil> error")
+	err = command.Run(&ctx, cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := "2006-01-02 15:04:05 -0700"
+	expectedOutput := fmt.Sprintf(`[dev] %s [app][abc]: dev started
+[qa] %s [tsuru]: qa started
+[dev] %s [app][abc]: dev stopped
+`, now.Local().Format(format), now.Add(time.Hour).Local().Format(format), now.Add(2*time.Hour).Local().Format(format))
+	if got := stdout.String(); got != expectedOutput {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expectedOutput, got)
+	}
+	expectedWarnings := `WARNING: project not found in environment "stage"
+WARNING: project not found in environment "prod"
+`
+	if got := stderr.String(); got != expectedWarnings {
+		t.Errorf("wrong warnings\nwant %q\ngot  %q", expectedWarnings, got)
+	}
+}
+
+func TestProjectLogEnvTags(t *testing.T) {
+	p := logPrinter{omitDate: true, tags: map[string]string{"dev": cmd.Colorfy("[dev]", "green", "", "")}}
+	var stdout bytes.Buffer
+	p.stdout = &stdout
+	p.print(envLog{env: "dev", Log: tsuru.Log{Message: "hello", Source: "app"}})
+	expected := cmd.Colorfy("[dev]", "green", "", "") + " " + cmd.Colorfy("[app]:", "blue", "", "") + " hello\n"
+	if got := stdout.String(); got != expected {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expected, got)
+	}
+}
+
+func TestProjectLogFollowReconnects(t *testing.T) {
+	defer resetInterrupts()
+	os.Setenv("TSURU_DISABLE_COLORS", "1")
+	defer os.Unsetenv("TSURU_DISABLE_COLORS")
+	defer func(delay time.Duration) { logReconnectDelay = delay }(logReconnectDelay)
+	logReconnectDelay = 0
+	var (
+		mu          sync.Mutex
+		connections = make(map[string]int)
+		waiting     int
+	)
+	date := time.Date(2018, 1, 10, 10, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("follow") != "1" {
+			http.Error(w, "not following", http.StatusBadRequest)
+			return
+		}
+		appName := strings.Split(r.URL.Path, "/")[3]
+		mu.Lock()
+		connections[appName]++
+		n := connections[appName]
+		if n > 2 {
+			waiting++
+			if waiting == 2 {
+				cancelRequests()
+			}
+		}
+		mu.Unlock()
+		if n > 2 {
+			<-r.Context().Done()
+			return
+		}
+		logs := []apiLog{{Date: date, Message: appName + " first", Source: "app"}}
+		if n == 2 {
+			logs = append(logs, apiLog{Date: date.Add(time.Second), Message: appName + " second", Source: "app"})
+		}
+		json.NewEncoder(w).Encode(logs)
+	}))
+	defer server.Close()
+	cleanup, err := setupFakeConfig(server.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	var command projectLog
+	err = command.Flags().Parse(true, []string{"-n", "myapp", "-e", "dev,qa", "-f", "--no-date"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	cli := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = command.Run(&ctx, cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	sort.Strings(lines)
+	expectedLines := []string{
+		"[dev] [app]: myapp-dev first",
+		"[dev] [app]: myapp-dev second",
+		"[qa] [app]: myapp-qa first",
+		"[qa] [app]: myapp-qa second",
+	}
+	if !reflect.DeepEqual(lines, expectedLines) {
+		t.Errorf("wrong output\nwant %#v\ngot  %#v", expectedLines, lines)
+	}
+	if n := strings.Count(stderr.String(), `WARNING: lost the log stream of environment "dev", reconnecting...`); n != 2 {
+		t.Errorf("wrong number of reconnections to dev. Want 2. Got %d\n%s", n, stderr.String())
+	}
+	if n := strings.Count(stderr.String(), `WARNING: lost the log stream of environment "qa", reconnecting...`); n != 2 {
+		t.Errorf("wrong number of reconnections to qa. Want 2. Got %d\n%s", n, stderr.String())
+	}
+}
+
+func TestProjectLogFollowEntriesWithTheSameDate(t *testing.T) {
+	defer resetInterrupts()
+	os.Setenv("TSURU_DISABLE_COLORS", "1")
+	defer os.Unsetenv("TSURU_DISABLE_COLORS")
+	defer func(delay time.Duration) { logReconnectDelay = delay }(logReconnectDelay)
+	logReconnectDelay = 0
+	var (
+		mu          sync.Mutex
+		connections int
+	)
+	date := time.Date(2018, 1, 10, 10, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		connections++
+		n := connections
+		mu.Unlock()
+		if n > 2 {
+			cancelRequests()
+			<-r.Context().Done()
+			return
+		}
+		logs := []apiLog{
+			{Date: date, Message: "Traceback (most recent call last):", Source: "app", Unit: "abc"},
+			{Date: date, Message: "ValueError: invalid literal", Source: "app", Unit: "abc"},
+		}
+		if n == 2 {
+			logs = append(logs,
+				apiLog{Date: date, Message: "worker exiting", Source: "app", Unit: "abc"},
+				apiLog{Date: date.Add(-time.Second), Message: "late line from other unit", Source: "app", Unit: "def"},
+			)
+		}
+		json.NewEncoder(w).Encode(logs)
+	}))
+	defer server.Close()
+	cleanup, err := setupFakeConfig(server.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	var command projectLog
+	err = command.Flags().Parse(true, []string{"-n", "myapp", "-e", "dev", "-f", "--no-date"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	cli := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = command.Run(&ctx, cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	expectedLines := []string{
+		"[app][abc]: Traceback (most recent call last):",
+		"[app][abc]: ValueError: invalid literal",
+		"[app][abc]: worker exiting",
+		"[app][def]: late line from other unit",
+	}
+	if !reflect.DeepEqual(lines, expectedLines) {
+		t.Errorf("wrong output\nwant %#v\ngot  %#v", expectedLines, lines)
+	}
+}
+
+func TestProjectLogFollowPermanentError(t *testing.T) {
+	tsuruServer := newFakeServer(t)
+	defer tsuruServer.stop()
+	cleanup, err := setupFakeConfig(tsuruServer.url(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	tsuruServer.prepareResponse(preparedResponse{
+		code:     http.StatusForbidden,
+		payload:  []byte("access denied"),
+		method:   "GET",
+		path:     "/apps/myapp-dev/log",
+		ignoreQS: true,
+	})
+	var command projectLog
+	err = command.Flags().Parse(true, []string{"-n", "myapp", "-e", "dev", "-f"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	cli := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = command.Run(&ctx, cli)
+	if err == nil || err.Error() != "access denied" {
+		t.Errorf("wrong error: %v", err)
+	}
+}
+
+func TestProjectLogFiltersAndJSONLines(t *testing.T) {
+	tsuruServer := newFakeServer(t)
+	defer tsuruServer.stop()
+	cleanup, err := setupFakeConfig(tsuruServer.url(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	now := time.Now().UTC().Truncate(time.Second)
+	logs := []apiLog{
+		{Date: now.Add(-2 * time.Hour), Message: "GET /old 200", Source: "app", Unit: "abc"},
+		{Date: now.Add(-time.Minute), Message: "GET /health 200", Source: "app", Unit: "abc"},
+		{Date: now.Add(-time.Minute), Message: "GET /users 200", Source: "app", Unit: "abc"},
+		{Date: now.Add(-time.Minute), Message: "POST /users 500", Source: "app", Unit: "abc"},
+	}
+	result, err := json.Marshal(logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsuruServer.prepareResponse(preparedResponse{
+		code:    http.StatusOK,
+		payload: result,
+		method:  "GET",
+		path:    fmt.Sprintf("/apps/myapp-qa/log?lines=%d&source=app&unit=abc", filteredLogLines),
+	})
+	var command projectLog
+	err = command.Flags().Parse(true, []string{
+		"-n", "myapp", "-e", "qa", "-u", "abc", "-s", "app",
+		"--grep", "users|health", "--exclude", "health", "--since", "1h", "--format", "jsonl",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	cli := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = command.Run(&ctx, cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []logOutput
+	decoder := json.NewDecoder(&stdout)
+	for decoder.More() {
+		var entry logOutput
+		if err = decoder.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, entry)
+	}
+	expected := []logOutput{
+		{Env: "qa", App: "myapp-qa", Log: tsuru.Log{Date: logs[2].Date, Message: logs[2].Message, Source: "app", Unit: "abc"}},
+		{Env: "qa", App: "myapp-qa", Log: tsuru.Log{Date: logs[3].Date, Message: logs[3].Message, Source: "app", Unit: "abc"}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong output\nwant %#v\ngot  %#v", expected, got)
+	}
+}
+
+func TestProjectLogFiltersMoreEntriesThanLines(t *testing.T) {
+	tsuruServer := newFakeServer(t)
+	defer tsuruServer.stop()
+	cleanup, err := setupFakeConfig(tsuruServer.url(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	now := time.Now().UTC().Truncate(time.Second)
+	logs := []apiLog{
+		{Date: now.Add(-2 * time.Hour), Message: "GET /old 200", Source: "app"},
+		{Date: now.Add(-4 * time.Minute), Message: "GET /users/1 200", Source: "app"},
+		{Date: now.Add(-3 * time.Minute), Message: "GET /users/2 200", Source: "app"},
+		{Date: now.Add(-2 * time.Minute), Message: "GET /users/3 200", Source: "app"},
+		{Date: now.Add(-time.Minute), Message: "GET /health 200", Source: "app"},
+		{Date: now.Add(-time.Minute), Message: "GET /health 200", Source: "app"},
+	}
+	result, err := json.Marshal(logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsuruServer.prepareResponse(preparedResponse{
+		code:    http.StatusOK,
+		payload: result,
+		method:  "GET",
+		path:    fmt.Sprintf("/apps/myapp-qa/log?lines=%d", filteredLogLines),
+	})
+	var command projectLog
+	err = command.Flags().Parse(true, []string{
+		"-n", "myapp", "-e", "qa", "-l", "2", "--since", "1h", "--exclude", "health", "--no-date",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	cli := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = command.Run(&ctx, cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := cmd.Colorfy("[app]:", "blue", "", "")
+	expectedOutput := prefix + " GET /users/2 200\n" + prefix + " GET /users/3 200\n"
+	if stdout.String() != expectedOutput {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expectedOutput, stdout.String())
+	}
+}
+
+func TestProjectLogInvalidFlags(t *testing.T) {
+	var tests = []struct {
+		testCase string
+		args     []string
+		errMsg   string
+	}{
+		{
+			"invalid format",
+			[]string{"--format", "xml"},
+			`invalid output format "xml", valid formats are: text and jsonl`,
+		},
+		{
+			"invalid grep",
+			[]string{"--grep", "users("},
+			"invalid regular expression in --grep: error parsing regexp: missing closing ): `users(`",
+		},
+		{
+			"invalid exclude",
+			[]string{"--exclude", "*"},
+			"invalid regular expression in --exclude: error parsing regexp: missing argument to repetition operator: `*`",
+		},
+		{
+			"invalid since",
+			[]string{"--since", "yesterday"},
+			`invalid value for --since "yesterday", please provide a duration (like 30m) or an RFC 3339 timestamp`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.testCase, func(t *testing.T) {
+			var c projectLog
+			err := c.Flags().Parse(true, append([]string{"-n", "myapp"}, test.args...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stdout, stderr bytes.Buffer
+			ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+			cli := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+			err = c.Run(&ctx, cli)
+			if err == nil || err.Error() != test.errMsg {
+				t.Errorf("wrong error\nwant %q\ngot  %v", test.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2018, 1, 10, 10, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		value    string
+		expected time.Time
+	}{
+		{"30m", time.Date(2018, 1, 10, 9, 30, 0, 0, time.UTC)},
+		{"2h", time.Date(2018, 1, 10, 8, 0, 0, 0, time.UTC)},
+		{"2018-01-09T12:00:00Z", time.Date(2018, 1, 9, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := parseSince(test.value, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(test.expected) {
+				t.Errorf("wrong time\nwant %s\ngot  %s", test.expected, got)
+			}
+		})
 	}
 }
 
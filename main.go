@@ -5,11 +5,15 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"strings"
 
+	"github.com/tsuru/gnuflag"
 	"github.com/tsuru/tsuru-client/tsuru/admin"
 	"github.com/tsuru/tsuru-client/tsuru/client"
 	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/net"
 )
 
 const version = "0.1"
@@ -21,8 +25,11 @@ func buildManager(name string) *cmd.Manager {
 	for _, c := range baseCommandsToRemove {
 		delete(mngr.Commands, c)
 	}
-	mngr.Register(envList{})
-	mngr.Register(targetSet{})
+	mngr.Register(&envList{})
+	mngr.Register(&targetSet{})
+	mngr.Register(targetList{})
+	mngr.Register(targetUse{})
+	mngr.Register(targetRemove{})
 	mngr.Register(admin.PlatformList{})
 	mngr.Register(&client.TeamList{})
 	mngr.Register(&client.TeamCreate{})
@@ -30,6 +37,7 @@ func buildManager(name string) *cmd.Manager {
 	mngr.Register(&client.PlanList{})
 	mngr.Register(&projectCreate{})
 	mngr.Register(&projectUpdate{})
+	mngr.Register(&projectApply{})
 	mngr.Register(&projectRemove{})
 	mngr.Register(&projectList{})
 	mngr.Register(&projectInfo{})
@@ -38,12 +46,115 @@ func buildManager(name string) *cmd.Manager {
 	mngr.Register(&projectEnvVarSet{})
 	mngr.Register(&projectEnvVarUnset{})
 	mngr.Register(&projectDeploy{})
+	mngr.Register(&projectPromote{})
+	mngr.Register(&projectApprove{})
 	mngr.Register(&projectDeployList{})
+	mngr.Register(&projectDeployRollback{})
 	mngr.Register(&projectLog{})
+	mngr.Register(&projectDiff{})
 	return mngr
 }
 
 func main() {
 	name := cmd.ExtractProgramName(os.Args[0])
-	buildManager(name).Run(os.Args[1:])
+	manager := buildManager(name)
+	args, target, err := extractTargetFlag(manager, os.Args[1:])
+	if err == nil && target != "" {
+		err = useTarget(target)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(2)
+	}
+	args, dryRunFlag := extractDryRunFlag(manager, args)
+	if dryRunFlag {
+		enableDryRun(net.Dial5FullUnlimitedClient, os.Stdout)
+	}
+	manager.Run(args)
+	waitConfigRefresh()
+}
+
+// flagArgs reports which arguments of the command line are flags, as opposed
+// to flag values, the name of the command, arguments of the command and
+// anything after "--". The flags end at "--" or at the first argument of the
+// command, and the flags of the commands registered in the manager tell which
+// flags are followed by a value.
+func flagArgs(manager *cmd.Manager, args []string) []bool {
+	var (
+		isFlag  = make([]bool, len(args))
+		global  = globalFlags()
+		command *gnuflag.FlagSet
+		named   bool
+	)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return isFlag
+		case arg == "-" || !strings.HasPrefix(arg, "-"):
+			if named {
+				return isFlag
+			}
+			named = true
+			command = commandFlags(manager, arg)
+		default:
+			isFlag[i] = true
+			if flagTakesValue(arg, command, global) {
+				i++
+			}
+		}
+	}
+	return isFlag
+}
+
+// globalFlags returns the flags that tranor accepts in any command.
+func globalFlags() *gnuflag.FlagSet {
+	var (
+		verbosity int
+		target    string
+		dryRun    bool
+	)
+	fs := gnuflag.NewFlagSet("global", gnuflag.ContinueOnError)
+	fs.IntVar(&verbosity, "verbosity", 0, "")
+	fs.IntVar(&verbosity, "v", 0, "")
+	fs.StringVar(&target, "target", "", "")
+	fs.BoolVar(&dryRun, "dry-run", false, "")
+	return fs
+}
+
+// commandFlags returns the flags of the given command, or nil when the
+// command doesn't exist or has no flags.
+func commandFlags(manager *cmd.Manager, name string) *gnuflag.FlagSet {
+	if flagged, ok := manager.Commands[name].(cmd.FlaggedCommand); ok {
+		return flagged.Flags()
+	}
+	return nil
+}
+
+// flagTakesValue reports whether the given argument is a flag that is
+// followed by its value, like "-n" in "-n myproj", looking it up in the given
+// flag sets, in order. In groups of short flags, like "-yn", only the last
+// flag may take a value.
+func flagTakesValue(arg string, sets ...*gnuflag.FlagSet) bool {
+	if strings.Contains(arg, "=") {
+		return false
+	}
+	name := strings.TrimPrefix(arg, "--")
+	if name == arg {
+		name = arg[len(arg)-1:]
+	}
+	for _, fs := range sets {
+		if fs == nil {
+			continue
+		}
+		flag := fs.Lookup(name)
+		if flag == nil {
+			continue
+		}
+		b, ok := flag.Value.(interface {
+			IsBoolFlag() bool
+		})
+		return !ok || !b.IsBoolFlag()
+	}
+	return false
 }
@@ -39,11 +39,25 @@ func TestBaseCommandsAreRegistered(t *testing.T) {
 
 func TestDefaultTargetCommandsArentRegistered(t *testing.T) {
 	manager := buildManager("tranor")
-	cmds := []string{"target-add", "target-list", "target-remove"}
-	for _, cmd := range cmds {
-		if _, ok := manager.Commands[cmd]; ok {
-			t.Errorf("command %q should not be registered", cmd)
-		}
+	if _, ok := manager.Commands["target-add"]; ok {
+		t.Error("command target-add should not be registered")
+	}
+	if _, ok := manager.Commands["target-list"].(targetList); !ok {
+		t.Errorf("command %#v is not of type targetList{}", manager.Commands["target-list"])
+	}
+	if _, ok := manager.Commands["target-remove"].(targetRemove); !ok {
+		t.Errorf("command %#v is not of type targetRemove{}", manager.Commands["target-remove"])
+	}
+}
+
+func TestTargetUseIsRegistered(t *testing.T) {
+	manager := buildManager("tranor")
+	gotCommand, ok := manager.Commands["target-use"]
+	if !ok {
+		t.Error("command target-use not found")
+	}
+	if _, ok := gotCommand.(targetUse); !ok {
+		t.Errorf("command %#v is not of type targetUse{}", gotCommand)
 	}
 }
 
@@ -53,7 +67,7 @@ func TestEnvListIsRegistered(t *testing.T) {
 	if !ok {
 		t.Error("command env-list not found")
 	}
-	if _, ok := gotCommand.(envList); !ok {
+	if _, ok := gotCommand.(*envList); !ok {
 		t.Errorf("command %#v is not of type envList{}", gotCommand)
 	}
 }
@@ -135,6 +149,17 @@ func TestProjectUpdateIsRegistered(t *testing.T) {
 	}
 }
 
+func TestProjectApplyIsRegistered(t *testing.T) {
+	manager := buildManager("tranor")
+	gotCommand, ok := manager.Commands["project-apply"]
+	if !ok {
+		t.Error("command project-apply not found")
+	}
+	if _, ok := gotCommand.(*projectApply); !ok {
+		t.Errorf("command %#v is not of type projectApply{}", gotCommand)
+	}
+}
+
 func TestProjectRemoveIsRegistered(t *testing.T) {
 	manager := buildManager("tranor")
 	gotCommand, ok := manager.Commands["project-remove"]
@@ -223,6 +248,28 @@ func TestProjectDeployIsRegistered(t *testing.T) {
 	}
 }
 
+func TestProjectPromoteIsRegistered(t *testing.T) {
+	manager := buildManager("tranor")
+	gotCommand, ok := manager.Commands["project-promote"]
+	if !ok {
+		t.Error("command project-promote not found")
+	}
+	if _, ok := gotCommand.(*projectPromote); !ok {
+		t.Errorf("command %#v is not of type projectPromote{}", gotCommand)
+	}
+}
+
+func TestProjectApproveIsRegistered(t *testing.T) {
+	manager := buildManager("tranor")
+	gotCommand, ok := manager.Commands["project-approve"]
+	if !ok {
+		t.Error("command project-approve not found")
+	}
+	if _, ok := gotCommand.(*projectApprove); !ok {
+		t.Errorf("command %#v is not of type projectApprove{}", gotCommand)
+	}
+}
+
 func TestProjectDeployListIsRegistered(t *testing.T) {
 	manager := buildManager("tranor")
 	gotCommand, ok := manager.Commands["project-deploy-list"]
@@ -234,6 +281,17 @@ func TestProjectDeployListIsRegistered(t *testing.T) {
 	}
 }
 
+func TestProjectDeployRollbackIsRegistered(t *testing.T) {
+	manager := buildManager("tranor")
+	gotCommand, ok := manager.Commands["project-deploy-rollback"]
+	if !ok {
+		t.Error("command project-deploy-rollback not found")
+	}
+	if _, ok := gotCommand.(*projectDeployRollback); !ok {
+		t.Errorf("command %#v is not of type projectDeployRollback{}", gotCommand)
+	}
+}
+
 func TestProjectLogIsRegistered(t *testing.T) {
 	manager := buildManager("tranor")
 	gotCommand, ok := manager.Commands["project-log"]
@@ -245,13 +303,24 @@ func TestProjectLogIsRegistered(t *testing.T) {
 	}
 }
 
+func TestProjectDiffIsRegistered(t *testing.T) {
+	manager := buildManager("tranor")
+	gotCommand, ok := manager.Commands["project-diff"]
+	if !ok {
+		t.Error("command project-diff not found")
+	}
+	if _, ok := gotCommand.(*projectDiff); !ok {
+		t.Errorf("command %#v is not of type projectDiff{}", gotCommand)
+	}
+}
+
 func TestBuiltinTargetSetIsOverwritten(t *testing.T) {
 	manager := buildManager("tranor")
 	gotCommand, ok := manager.Commands["target-set"]
 	if !ok {
 		t.Error("command target-set not found")
 	}
-	if _, ok := gotCommand.(targetSet); !ok {
+	if _, ok := gotCommand.(*targetSet); !ok {
 		t.Errorf("command %#v is not of type targetSet{}", gotCommand)
 	}
 }
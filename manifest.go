@@ -0,0 +1,82 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+)
+
+const defaultManifestFile = "tranor.yml"
+
+// Manifest is the declarative description of a project, usually stored in a
+// tranor.yml file in the root of the project repository.
+type Manifest struct {
+	Name         string                `json:"name"`
+	Description  string                `json:"description,omitempty"`
+	Platform     string                `json:"platform"`
+	Team         string                `json:"team,omitempty"`
+	Plan         string                `json:"plan,omitempty"`
+	Environments []ManifestEnvironment `json:"envs"`
+}
+
+// ManifestEnvironment describes the settings of a project in one of the
+// environments.
+type ManifestEnvironment struct {
+	Name    string            `json:"name"`
+	EnvVars map[string]string `json:"envVars,omitempty"`
+	CNames  []string          `json:"cnames,omitempty"`
+}
+
+func (m *Manifest) envNames() []string {
+	names := make([]string, len(m.Environments))
+	for i, env := range m.Environments {
+		names[i] = env.Name
+	}
+	return names
+}
+
+func (m *Manifest) env(name string) *ManifestEnvironment {
+	for i := range m.Environments {
+		if m.Environments[i].Name == name {
+			return &m.Environments[i]
+		}
+	}
+	return nil
+}
+
+func (m *Manifest) validate(config *Config) error {
+	if m.Name == "" || m.Platform == "" {
+		return errors.New("the manifest must define the name and the platform of the project")
+	}
+	if len(m.Environments) == 0 {
+		return errors.New("the manifest must define at least one environment")
+	}
+	seen := make(map[string]bool, len(m.Environments))
+	for _, env := range m.Environments {
+		if seen[env.Name] {
+			return fmt.Errorf("env %q is defined more than once in the manifest", env.Name)
+		}
+		seen[env.Name] = true
+	}
+	envs := commaSeparatedFlag{values: m.envNames()}
+	return envs.validate(config.envNames())
+}
+
+func loadManifest(path string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	err = yaml.Unmarshal(data, &m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest %q: %s", path, err)
+	}
+	return &m, nil
+}
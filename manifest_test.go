@@ -0,0 +1,122 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tranor.yml")
+	err = ioutil.WriteFile(path, []byte(`name: myproj
+platform: python
+team: myteam
+plan: medium
+description: my nice project
+envs:
+  - name: dev
+    envVars:
+      DATABASE_NAME: mydb_dev
+  - name: prod
+    envVars:
+      DATABASE_NAME: mydb
+    cnames:
+      - www.myproj.com
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := loadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Manifest{
+		Name:        "myproj",
+		Platform:    "python",
+		Team:        "myteam",
+		Plan:        "medium",
+		Description: "my nice project",
+		Environments: []ManifestEnvironment{
+			{Name: "dev", EnvVars: map[string]string{"DATABASE_NAME": "mydb_dev"}},
+			{Name: "prod", EnvVars: map[string]string{"DATABASE_NAME": "mydb"}, CNames: []string{"www.myproj.com"}},
+		},
+	}
+	if !reflect.DeepEqual(*m, expected) {
+		t.Errorf("wrong manifest\nwant %#v\ngot  %#v", expected, *m)
+	}
+}
+
+func TestLoadManifestNotFound(t *testing.T) {
+	_, err := loadManifest("/tmp/this/file/does/not/exist/tranor.yml")
+	if !os.IsNotExist(err) {
+		t.Errorf("wrong error returned: %#v", err)
+	}
+}
+
+func TestManifestValidate(t *testing.T) {
+	config := Config{
+		Environments: []Environment{
+			{Name: "dev", DNSSuffix: "dev.example.com"},
+			{Name: "prod", DNSSuffix: "example.com"},
+		},
+	}
+	var tests = []struct {
+		testCase string
+		manifest Manifest
+		errMsg   string
+	}{
+		{
+			"valid manifest",
+			Manifest{Name: "myproj", Platform: "python", Environments: []ManifestEnvironment{{Name: "dev"}}},
+			"",
+		},
+		{
+			"missing name",
+			Manifest{Platform: "python", Environments: []ManifestEnvironment{{Name: "dev"}}},
+			"the manifest must define the name and the platform of the project",
+		},
+		{
+			"missing platform",
+			Manifest{Name: "myproj", Environments: []ManifestEnvironment{{Name: "dev"}}},
+			"the manifest must define the name and the platform of the project",
+		},
+		{
+			"no envs",
+			Manifest{Name: "myproj", Platform: "python"},
+			"the manifest must define at least one environment",
+		},
+		{
+			"duplicate env",
+			Manifest{Name: "myproj", Platform: "python", Environments: []ManifestEnvironment{{Name: "dev"}, {Name: "dev"}}},
+			`env "dev" is defined more than once in the manifest`,
+		},
+		{
+			"unknown env",
+			Manifest{Name: "myproj", Platform: "python", Environments: []ManifestEnvironment{{Name: "dev"}, {Name: "qa"}}},
+			"invalid values: qa (valid options are: dev, prod)",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.testCase, func(t *testing.T) {
+			err := test.manifest.validate(&config)
+			var errMsg string
+			if err != nil {
+				errMsg = err.Error()
+			}
+			if errMsg != test.errMsg {
+				t.Errorf("wrong error\nwant %q\ngot  %q", test.errMsg, errMsg)
+			}
+		})
+	}
+}
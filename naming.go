@@ -0,0 +1,213 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+)
+
+const (
+	defaultAppNameTemplate  = "{{.Project}}-{{.Env}}"
+	defaultPoolNameTemplate = `{{.Env}}\{{.DNSSuffix}}`
+	defaultCNameTemplate    = "{{.Project}}.{{.DNSSuffix}}"
+
+	// projectPlaceholder is used in place of the name of the project when
+	// building the regular expressions that extract it from app names and
+	// cnames.
+	projectPlaceholder = "\x00"
+)
+
+// Naming defines the templates used for naming the apps, pools and cnames of
+// projects. Templates use the text/template syntax and have access to the
+// fields Project, Env and DNSSuffix. Empty templates fallback to the default
+// convention: "<project>-<env>" for apps, "<env>\<dnsSuffix>" for pools and
+// "<project>.<dnsSuffix>" for cnames.
+type Naming struct {
+	App   string `json:"app,omitempty"`
+	Pool  string `json:"pool,omitempty"`
+	CName string `json:"cname,omitempty"`
+}
+
+type namingData struct {
+	Project   string
+	Env       string
+	DNSSuffix string
+}
+
+// namer builds and parses the names of apps, pools and cnames of projects,
+// according to the naming templates in the configuration.
+type namer struct {
+	appTmpl   *template.Template
+	poolTmpl  *template.Template
+	cnameTmpl *template.Template
+
+	mu      sync.Mutex
+	regexps map[string]*regexp.Regexp
+}
+
+func newNamer(n *Naming) (*namer, error) {
+	if n == nil {
+		n = &Naming{}
+	}
+	var (
+		nm   namer
+		err  error
+		errs configError
+	)
+	nm.appTmpl, err = parseNamingTemplate("app", n.App, defaultAppNameTemplate)
+	if err != nil {
+		errs.add("naming: %s", err)
+	}
+	nm.poolTmpl, err = parseNamingTemplate("pool", n.Pool, defaultPoolNameTemplate)
+	if err != nil {
+		errs.add("naming: %s", err)
+	}
+	nm.cnameTmpl, err = parseNamingTemplate("cname", n.CName, defaultCNameTemplate)
+	if err != nil {
+		errs.add("naming: %s", err)
+	}
+	if len(errs.problems) > 0 {
+		return nil, &errs
+	}
+	sample := Environment{Name: "env", DNSSuffix: "example.com"}
+	for _, t := range []*template.Template{nm.appTmpl, nm.cnameTmpl} {
+		name, err := execNamingTemplate(t, projectPlaceholder, sample)
+		if err != nil {
+			errs.add("naming: %s", err)
+		} else if strings.Count(name, projectPlaceholder) != 1 {
+			errs.add("naming: the %s template must reference the project exactly once", t.Name())
+		}
+	}
+	pool1, err1 := execNamingTemplate(nm.poolTmpl, "project1", sample)
+	pool2, err2 := execNamingTemplate(nm.poolTmpl, "project2", sample)
+	if err1 != nil {
+		errs.add("naming: %s", err1)
+	} else if err2 == nil && pool1 != pool2 {
+		errs.add("naming: the pool template must not reference the project")
+	}
+	if len(errs.problems) > 0 {
+		return nil, &errs
+	}
+	nm.regexps = make(map[string]*regexp.Regexp)
+	return &nm, nil
+}
+
+func parseNamingTemplate(name, text, defaultText string) (*template.Template, error) {
+	if text == "" {
+		text = defaultText
+	}
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+func execNamingTemplate(t *template.Template, projectName string, env Environment) (string, error) {
+	var buf bytes.Buffer
+	err := t.Execute(&buf, namingData{Project: projectName, Env: env.Name, DNSSuffix: env.DNSSuffix})
+	return buf.String(), err
+}
+
+// execute runs a template validated by newNamer.
+func (n *namer) execute(t *template.Template, projectName string, env Environment) string {
+	name, _ := execNamingTemplate(t, projectName, env)
+	return name
+}
+
+func (n *namer) appName(projectName string, env Environment) string {
+	return n.execute(n.appTmpl, projectName, env)
+}
+
+func (n *namer) poolName(env Environment) string {
+	return n.execute(n.poolTmpl, "", env)
+}
+
+func (n *namer) cname(projectName string, env Environment) string {
+	return n.execute(n.cnameTmpl, projectName, env)
+}
+
+// appFilter returns the regular expression used for filtering the apps of a
+// project when listing apps in the tsuru API.
+func (n *namer) appFilter(projectName string) string {
+	name := n.appName(projectPlaceholder, Environment{Name: "env", DNSSuffix: "example.com"})
+	if strings.HasPrefix(name, projectPlaceholder) {
+		return "^" + regexp.QuoteMeta(projectName)
+	}
+	return regexp.QuoteMeta(projectName)
+}
+
+// regexp returns the regular expression that extracts the name of the
+// project from names generated by the given template in the environment.
+func (n *namer) regexp(t *template.Template, env Environment, projectPattern string) *regexp.Regexp {
+	key := t.Name() + "\x00" + env.Name + "\x00" + env.DNSSuffix
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if r, ok := n.regexps[key]; ok {
+		return r
+	}
+	parts := strings.SplitN(n.execute(t, projectPlaceholder, env), projectPlaceholder, 2)
+	if len(parts) < 2 {
+		parts = append(parts, "")
+	}
+	r := regexp.MustCompile("^" + regexp.QuoteMeta(parts[0]) + projectPattern + regexp.QuoteMeta(parts[1]) + "$")
+	n.regexps[key] = r
+	return r
+}
+
+// extractProjectName returns the project and the cname of the app in the
+// environment, failing if the app isn't part of a tranor project.
+func (n *namer) extractProjectName(a tsuru.App, env Environment) (projectName string, cname string, err error) {
+	partsName := n.regexp(n.appTmpl, env, "(.+)").FindStringSubmatch(a.Name)
+	cname, err = n.findCName(a, env)
+	if err != nil {
+		return "", "", err
+	}
+	partsDNS := n.regexp(n.cnameTmpl, env, `([^.]+)`).FindStringSubmatch(cname)
+	if len(partsName) == 2 && len(partsDNS) == 2 && partsName[1] == partsDNS[1] {
+		return partsDNS[1], cname, nil
+	}
+	return "", "", errors.New("not a tranor project")
+}
+
+func (n *namer) findCName(a tsuru.App, env Environment) (string, error) {
+	r := n.regexp(n.cnameTmpl, env, `([^.]+)`)
+	for _, cname := range a.CName {
+		if r.MatchString(cname) {
+			return cname, nil
+		}
+	}
+	return "", errors.New("cname not defined")
+}
+
+// namer returns the naming component of the configuration.
+func (c *Config) namer() *namer {
+	if c.names == nil {
+		n, err := newNamer(c.Naming)
+		if err != nil {
+			// templates are validated when the configuration is loaded,
+			// so this only happens with configurations built in memory.
+			n, _ = newNamer(nil)
+		}
+		c.names = n
+	}
+	return c.names
+}
+
+// appName returns the name of the app of the project in the environment with
+// the given name.
+func (c *Config) appName(projectName, envName string) string {
+	env := Environment{Name: envName}
+	for _, e := range c.Environments {
+		if e.Name == envName {
+			env = e
+			break
+		}
+	}
+	return c.namer().appName(projectName, env)
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+)
+
+func TestNamerDefaultConvention(t *testing.T) {
+	n, err := newNamer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := Environment{Name: "dev", DNSSuffix: "dev.example.com"}
+	if name := n.appName("myproj", env); name != "myproj-dev" {
+		t.Errorf("wrong app name. Want %q. Got %q", "myproj-dev", name)
+	}
+	if pool := n.poolName(env); pool != `dev\dev.example.com` {
+		t.Errorf("wrong pool name. Want %q. Got %q", `dev\dev.example.com`, pool)
+	}
+	if cname := n.cname("myproj", env); cname != "myproj.dev.example.com" {
+		t.Errorf("wrong cname. Want %q. Got %q", "myproj.dev.example.com", cname)
+	}
+	if filter := n.appFilter("myproj"); filter != "^myproj" {
+		t.Errorf("wrong app filter. Want %q. Got %q", "^myproj", filter)
+	}
+}
+
+func TestNamerCustomTemplates(t *testing.T) {
+	n, err := newNamer(&Naming{
+		App:   "{{.Env}}-{{.Project}}",
+		Pool:  "pool-{{.Env}}",
+		CName: "{{.Project}}.{{.Env}}.apps.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := Environment{Name: "qa", DNSSuffix: "qa.example.com"}
+	if name := n.appName("myproj", env); name != "qa-myproj" {
+		t.Errorf("wrong app name. Want %q. Got %q", "qa-myproj", name)
+	}
+	if pool := n.poolName(env); pool != "pool-qa" {
+		t.Errorf("wrong pool name. Want %q. Got %q", "pool-qa", pool)
+	}
+	if cname := n.cname("myproj", env); cname != "myproj.qa.apps.example.com" {
+		t.Errorf("wrong cname. Want %q. Got %q", "myproj.qa.apps.example.com", cname)
+	}
+	if filter := n.appFilter("myproj"); filter != "myproj" {
+		t.Errorf("wrong app filter. Want %q. Got %q", "myproj", filter)
+	}
+	a := tsuru.App{Name: "qa-myproj", CName: []string{"other.example.com", "myproj.qa.apps.example.com"}}
+	projectName, cname, err := n.extractProjectName(a, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if projectName != "myproj" || cname != "myproj.qa.apps.example.com" {
+		t.Errorf("wrong project extracted. Got %q (%q)", projectName, cname)
+	}
+}
+
+func TestNamerExtractProjectName(t *testing.T) {
+	n, err := newNamer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := Environment{Name: "dev", DNSSuffix: "dev.example.com"}
+	var tests = []struct {
+		testCase string
+		app      tsuru.App
+		project  string
+	}{
+		{"tranor project", tsuru.App{Name: "myproj-dev", CName: []string{"myproj.dev.example.com"}}, "myproj"},
+		{"dashes in the name", tsuru.App{Name: "my-proj-dev", CName: []string{"my-proj.dev.example.com"}}, "my-proj"},
+		{"other env", tsuru.App{Name: "myproj-qa", CName: []string{"myproj.dev.example.com"}}, ""},
+		{"cname mismatch", tsuru.App{Name: "myproj-dev", CName: []string{"other.dev.example.com"}}, ""},
+		{"dots in the suffix are not wildcards", tsuru.App{Name: "myproj-dev", CName: []string{"myproj.devxexample.com"}}, ""},
+		{"no cname", tsuru.App{Name: "myproj-dev"}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.testCase, func(t *testing.T) {
+			projectName, _, err := n.extractProjectName(test.app, env)
+			if test.project == "" {
+				if err == nil {
+					t.Errorf("unexpected <nil> error, got project %q", projectName)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if projectName != test.project {
+				t.Errorf("wrong project name. Want %q. Got %q", test.project, projectName)
+			}
+		})
+	}
+}
+
+func TestNamerQuotesEnvName(t *testing.T) {
+	n, err := newNamer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := Environment{Name: "dev.1", DNSSuffix: "example.com"}
+	a := tsuru.App{Name: "myproj-devx1", CName: []string{"myproj.example.com"}}
+	if _, _, err := n.extractProjectName(a, env); err == nil {
+		t.Error("dots in the env name should not match any character")
+	}
+}
+
+func TestNewNamerInvalidTemplates(t *testing.T) {
+	var tests = []struct {
+		testCase string
+		naming   Naming
+		errMsg   string
+	}{
+		{"missing project in app", Naming{App: "{{.Env}}"}, "naming: the app template must reference the project exactly once"},
+		{"project twice in cname", Naming{CName: "{{.Project}}.{{.Project}}.com"}, "naming: the cname template must reference the project exactly once"},
+		{"project in pool", Naming{Pool: "{{.Project}}"}, "naming: the pool template must not reference the project"},
+		{"unknown field", Naming{App: "{{.Project}}-{{.Team}}"}, "naming: template: app:1:15: executing \"app\" at <.Team>: can't evaluate field Team in type main.namingData"},
+	}
+	for _, test := range tests {
+		t.Run(test.testCase, func(t *testing.T) {
+			_, err := newNamer(&test.naming)
+			if err == nil {
+				t.Fatal("unexpected <nil> error")
+			}
+			if got := strings.TrimPrefix(err.Error(), "invalid configuration:\n - "); got != test.errMsg {
+				t.Errorf("wrong error\nwant %q\ngot  %q", test.errMsg, got)
+			}
+		})
+	}
+}
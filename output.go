@@ -0,0 +1,177 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/template"
+
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+	"github.com/ghodss/yaml"
+	"github.com/tsuru/gnuflag"
+)
+
+// outputFormat renders the data of read commands as JSON, YAML or a Go
+// template, instead of the default table.
+type outputFormat struct {
+	format   string
+	template string
+}
+
+func (o *outputFormat) addFlags(fs *gnuflag.FlagSet) {
+	fs.StringVar(&o.format, "format", "table", "output format: table, json, yaml or template")
+	fs.StringVar(&o.template, "template", "", "Go template used for rendering the output with --format template")
+}
+
+func (o *outputFormat) validate() error {
+	switch o.format {
+	case "", "table", "json", "yaml":
+		return nil
+	case "template":
+		if o.template == "" {
+			return fmt.Errorf("please provide the template with --template")
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid output format %q, valid formats are: table, json, yaml and template", o.format)
+}
+
+// structured reports whether the data is rendered in a structured format.
+func (o *outputFormat) structured() bool {
+	return o.format != "" && o.format != "table"
+}
+
+func (o *outputFormat) render(w io.Writer, data interface{}) error {
+	switch o.format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(data)
+	case "yaml":
+		b, err := yaml.Marshal(data)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	case "template":
+		t, err := template.New("output").Parse(o.template)
+		if err != nil {
+			return fmt.Errorf("invalid template: %s", err)
+		}
+		return t.Execute(w, data)
+	}
+	return fmt.Errorf("invalid output format %q", o.format)
+}
+
+// envOutput is the schema of environments in env-list.
+type envOutput struct {
+	Name      string `json:"name"`
+	DNSSuffix string `json:"dnsSuffix"`
+	Protected bool   `json:"protected"`
+}
+
+// projectOutput is the schema of projects in project-info and project-list.
+type projectOutput struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description,omitempty"`
+	Repository  string             `json:"repository,omitempty"`
+	Platform    string             `json:"platform,omitempty"`
+	Teams       []string           `json:"teams,omitempty"`
+	Owner       string             `json:"owner,omitempty"`
+	TeamOwner   string             `json:"teamOwner,omitempty"`
+	Envs        []projectEnvOutput `json:"envs"`
+}
+
+// projectEnvOutput is the schema of the app of a project in one environment.
+// LastDeploy is only available in project-info and project-diff, and Teams
+// only in project-diff.
+type projectEnvOutput struct {
+	Env        string        `json:"env"`
+	App        string        `json:"app"`
+	Address    string        `json:"address"`
+	Pool       string        `json:"pool"`
+	Plan       string        `json:"plan"`
+	Units      int           `json:"units"`
+	Teams      []string      `json:"teams,omitempty"`
+	LastDeploy *tsuru.Deploy `json:"lastDeploy,omitempty"`
+}
+
+// newProjectOutput builds the output of the project, taking its general
+// information from the first app.
+func newProjectOutput(name string, apps []app) projectOutput {
+	project := projectOutput{Name: name, Envs: make([]projectEnvOutput, len(apps))}
+	if len(apps) > 0 {
+		project.Description = apps[0].Description
+		project.Repository = apps[0].RepositoryURL
+		project.Platform = apps[0].Platform
+		project.Teams = apps[0].Teams
+		project.Owner = apps[0].Owner
+		project.TeamOwner = apps[0].TeamOwner
+	}
+	for i, a := range apps {
+		project.Envs[i] = newProjectEnvOutput(a)
+	}
+	return project
+}
+
+func newProjectEnvOutput(a app) projectEnvOutput {
+	return projectEnvOutput{
+		Env:     a.Env.Name,
+		App:     a.Name,
+		Address: a.Addr,
+		Pool:    a.Pool,
+		Plan:    a.Plan.Name,
+		Units:   len(a.Units),
+	}
+}
+
+// envVarsOutput is the schema of the variables of a project in one
+// environment, used by envvar-get.
+type envVarsOutput struct {
+	Env  string         `json:"env"`
+	Vars []tsuru.EnvVar `json:"vars"`
+}
+
+// deploysOutput is the schema of project-deploy-list in one environment.
+type deploysOutput struct {
+	Env     string         `json:"env"`
+	App     string         `json:"app"`
+	Deploys []tsuru.Deploy `json:"deploys"`
+}
+
+// logOutput is the schema of the log entries of project-log, written one per
+// line with --format jsonl.
+type logOutput struct {
+	Env string `json:"env"`
+	App string `json:"app"`
+	tsuru.Log
+}
+
+// diffOutput is the schema of project-diff.
+type diffOutput struct {
+	Project string             `json:"project"`
+	Envs    []projectEnvOutput `json:"envs"`
+	EnvVars []envVarDiffOutput `json:"envVars"`
+}
+
+// envVarDiffOutput is the comparison of a variable across environments,
+// indexed by the environments where it's set.
+type envVarDiffOutput struct {
+	Name   string            `json:"name"`
+	Values map[string]string `json:"values"`
+	Equal  bool              `json:"equal"`
+}
+
+// timelineOutput is the schema of project-deploy-list in all environments.
+// PromotedFrom is the environment where a promoted image was built.
+type timelineOutput struct {
+	Env          string `json:"env"`
+	App          string `json:"app"`
+	PromotedFrom string `json:"promotedFrom,omitempty"`
+	tsuru.Deploy
+}
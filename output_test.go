@@ -0,0 +1,256 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+func TestOutputFormatValidate(t *testing.T) {
+	var tests = []struct {
+		output outputFormat
+		errMsg string
+	}{
+		{outputFormat{}, ""},
+		{outputFormat{format: "table"}, ""},
+		{outputFormat{format: "json"}, ""},
+		{outputFormat{format: "yaml"}, ""},
+		{outputFormat{format: "template", template: "{{.}}"}, ""},
+		{outputFormat{format: "template"}, "please provide the template with --template"},
+		{outputFormat{format: "xml"}, `invalid output format "xml", valid formats are: table, json, yaml and template`},
+	}
+	for _, test := range tests {
+		var errMsg string
+		if err := test.output.validate(); err != nil {
+			errMsg = err.Error()
+		}
+		if errMsg != test.errMsg {
+			t.Errorf("wrong error for %#v\nwant %q\ngot  %q", test.output, test.errMsg, errMsg)
+		}
+	}
+}
+
+func TestOutputFormatRender(t *testing.T) {
+	data := []envOutput{{Name: "dev", DNSSuffix: "dev.example.com"}, {Name: "prod", DNSSuffix: "example.com", Protected: true}}
+	var tests = []struct {
+		output   outputFormat
+		expected string
+	}{
+		{
+			outputFormat{format: "json"},
+			`[
+  {
+    "name": "dev",
+    "dnsSuffix": "dev.example.com",
+    "protected": false
+  },
+  {
+    "name": "prod",
+    "dnsSuffix": "example.com",
+    "protected": true
+  }
+]
+`,
+		},
+		{
+			outputFormat{format: "yaml"},
+			`- dnsSuffix: dev.example.com
+  name: dev
+  protected: false
+- dnsSuffix: example.com
+  name: prod
+  protected: true
+`,
+		},
+		{
+			outputFormat{format: "template", template: "{{range .}}{{.Name}}={{.DNSSuffix}}\n{{end}}"},
+			"dev=dev.example.com\nprod=example.com\n",
+		},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err := test.output.render(&buf, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.expected {
+			t.Errorf("wrong output for %q\nwant %q\ngot  %q", test.output.format, test.expected, buf.String())
+		}
+	}
+}
+
+func TestOutputFormatRenderInvalidTemplate(t *testing.T) {
+	o := outputFormat{format: "template", template: "{{.Name"}
+	err := o.render(&bytes.Buffer{}, nil)
+	if err == nil {
+		t.Fatal("unexpected <nil> error")
+	}
+}
+
+func TestEnvListRunJSON(t *testing.T) {
+	p, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("HOME", p)
+	var c envList
+	c.Flags().Parse(true, []string{"--format", "json"})
+	var stdout bytes.Buffer
+	err = c.Run(&cmd.Context{Stdout: &stdout, Stderr: &bytes.Buffer{}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var envs []envOutput
+	err = json.Unmarshal(stdout.Bytes(), &envs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []envOutput{
+		{Name: "dev", DNSSuffix: "dev.example.com"},
+		{Name: "qa", DNSSuffix: "qa.example.com"},
+		{Name: "stage", DNSSuffix: "stage.example.com"},
+		{Name: "prod", DNSSuffix: "example.com"},
+	}
+	if !reflect.DeepEqual(envs, expected) {
+		t.Errorf("wrong output\nwant %#v\ngot  %#v", expected, envs)
+	}
+}
+
+func TestProjectInfoJSON(t *testing.T) {
+	cleanup := createTestProject("myproj", t)
+	defer cleanup()
+	var c projectInfo
+	c.Flags().Parse(true, []string{"-n", "myproj", "--format", "json"})
+	var stdout bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &bytes.Buffer{}}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err := c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var project projectOutput
+	err = json.Unmarshal(stdout.Bytes(), &project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.Name != "myproj" || project.Platform != "python" || project.TeamOwner != "myteam" || len(project.Envs) != 4 {
+		t.Fatalf("wrong project output: %#v", project)
+	}
+	expectedEnv := projectEnvOutput{
+		Env:     "dev",
+		App:     "myproj-dev",
+		Address: "myproj.dev.example.com",
+		Pool:    `dev\dev.example.com`,
+		Plan:    "medium",
+	}
+	if !reflect.DeepEqual(project.Envs[0], expectedEnv) {
+		t.Errorf("wrong env output\nwant %#v\ngot  %#v", expectedEnv, project.Envs[0])
+	}
+}
+
+func TestProjectListTemplate(t *testing.T) {
+	cleanup := createTestProject("myproj", t)
+	defer cleanup()
+	var c projectList
+	c.Flags().Parse(true, []string{"--format", "template", "--template", "{{range .}}{{.Name}}:{{range .Envs}} {{.Env}}{{end}}\n{{end}}"})
+	var stdout bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &bytes.Buffer{}}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err := c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "myproj: dev qa stage prod\n"
+	if stdout.String() != expected {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expected, stdout.String())
+	}
+}
+
+func TestProjectEnvVarGetYAML(t *testing.T) {
+	cleanup := createTestProject("myproj", t)
+	defer cleanup()
+	var c projectEnvVarGet
+	c.Flags().Parse(true, []string{"-n", "myproj", "-e", "dev", "--format", "yaml"})
+	var stdout bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &bytes.Buffer{}}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err := c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `- env: dev
+  vars:
+  - name: TRANOR_ENV_NAME
+    public: true
+    value: dev
+  - name: TSURU_APPDIR
+    public: false
+    value: '*** (private variable)'
+  - name: TSURU_APPNAME
+    public: false
+    value: '*** (private variable)'
+  - name: TSURU_APP_TOKEN
+    public: false
+    value: '*** (private variable)'
+`
+	if stdout.String() != expected {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expected, stdout.String())
+	}
+}
+
+func TestProjectDeployListJSON(t *testing.T) {
+	fakeServer := newFakeServer(t)
+	defer fakeServer.stop()
+	fakeServer.prepareResponse(preparedResponse{
+		method:  http.MethodGet,
+		path:    "/deploys?app=proj1-dev",
+		code:    http.StatusOK,
+		payload: []byte(deployments),
+	})
+	cleanup, err := setupFakeConfig(fakeServer.url(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	var c projectDeployList
+	c.Flags().Parse(true, []string{"-n", "proj1", "-e", "dev", "--format", "json"})
+	var stdout bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &bytes.Buffer{}}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output deploysOutput
+	err = json.Unmarshal(stdout.Bytes(), &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := deploysOutput{
+		Env: "dev",
+		App: "proj1-dev",
+		Deploys: []tsuru.Deploy{{
+			ID:        "57ccc9490640fd3def98b157",
+			Commit:    "40244ff2866eba7e2da6eee8a6fc51464c9f604f",
+			Image:     "v938",
+			User:      "admin@example.com",
+			Duration:  125995 * time.Millisecond,
+			Timestamp: time.Date(2016, 9, 5, 1, 24, 25, 706000000, time.UTC),
+		}},
+	}
+	if !reflect.DeepEqual(output, expected) {
+		t.Errorf("wrong output\nwant %#v\ngot  %#v", expected, output)
+	}
+}
@@ -5,24 +5,24 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net/http"
-	"net/url"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ef-ctx/tsuru-flow/tsuru"
 	"github.com/tsuru/gnuflag"
 	"github.com/tsuru/tsuru-client/tsuru/client"
-	"github.com/tsuru/tsuru/api"
 	"github.com/tsuru/tsuru/cmd"
-	tsuruerrors "github.com/tsuru/tsuru/errors"
 )
 
+var errProjectNotFound = errors.New("project not found")
+
 type projectCreate struct {
 	fs          *gnuflag.FlagSet
 	name        string
@@ -52,8 +52,13 @@ func (c *projectCreate) Run(ctx *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return fmt.Errorf("failed to load environments: %s", err)
 	}
+	apiClient, err := newAPIClient(client)
+	if err != nil {
+		return err
+	}
+	defer catchInterrupts(ctx.Stderr)()
 	envs := getEnvironmentsByName(config.Environments, c.envs.Values())
-	apps, err := createApps(envs, client, c.name, createAppOptions{
+	apps, err := createApps(envs, apiClient, c.name, tsuru.CreateAppOptions{
 		Description: c.description,
 		Plan:        c.plan,
 		Platform:    c.platform,
@@ -62,14 +67,13 @@ func (c *projectCreate) Run(ctx *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
-	err = setCNames(apps, client, c.name)
+	err = setCNames(apps, apiClient)
 	if err != nil {
-		appObjs := make([]app, len(apps))
+		names := make([]string, len(apps))
 		for i, a := range apps {
-			appObjs[i] = app{Name: a["name"]}
+			names[i] = a["name"]
 		}
-		deleteApps(appObjs, client, ioutil.Discard)
-		return fmt.Errorf("failed to configure project %q: %s", c.name, err)
+		return removeCreatedApps(apiClient, names, fmt.Errorf("failed to configure project %q: %s", c.name, err))
 	}
 	fmt.Fprintf(ctx.Stdout, "successfully created the project %q!\n", c.name)
 	if gitRepo := apps[0]["repository_url"]; gitRepo != "" {
@@ -112,8 +116,11 @@ type projectUpdate struct {
 	team        string
 	plan        string
 	description string
+	units       int
 	addEnvs     commaSeparatedFlag
 	removeEnvs  commaSeparatedFlag
+	guard       approvalGuard
+	freeze      freezeGuard
 }
 
 func (c *projectUpdate) Info() *cmd.Info {
@@ -131,7 +138,11 @@ func (c *projectUpdate) Run(ctx *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return errors.New("unable to load environments file, please make sure that tranor is properly configured")
 	}
-	apps, err := projectApps(client, c.name)
+	apiClient, err := newAPIClient(client)
+	if err != nil {
+		return err
+	}
+	apps, err := projectApps(apiClient, c.name)
 	if err != nil {
 		return err
 	}
@@ -143,37 +154,217 @@ func (c *projectUpdate) Run(ctx *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
-	opts := c.baseOpts(apps[0])
-	envsToAdd := getEnvironmentsByName(config.Environments, c.addEnvs.Values())
-	fmt.Fprintln(ctx.Stdout, "adding new environments...")
-	appMaps, err := createApps(envsToAdd, client, c.name, opts)
+	err = c.checkConstraints(appsToUpdate)
+	if err != nil {
+		return err
+	}
+	varsToSet, currentVars, err := c.missingEnvVars(apiClient, appsToUpdate)
+	if err != nil {
+		return err
+	}
+	_, err = c.freeze.check(ctx.Stderr, config, restartedEnvs(appsToUpdate, varsToSet))
 	if err != nil {
 		return err
 	}
-	err = setCNames(appMaps, client, c.name)
+	err = c.guard.check(ctx, client, config, c.name, c.affectedEnvs(appsToUpdate, appsToRemove, varsToSet))
 	if err != nil {
 		return err
 	}
+	defer catchInterrupts(ctx.Stderr)()
+	var tx transaction
+	opts := c.baseOpts(apps[0])
+	err = c.createEnvs(ctx, apiClient, config, opts, &tx)
+	if err != nil {
+		return tx.rollback(ctx.Stdout, err)
+	}
+	for _, a := range appsToUpdate {
+		if interrupted() {
+			return tx.rollback(ctx.Stdout, errInterrupted)
+		}
+		err = c.updateEnv(apiClient, a, opts, varsToSet[a.Name], currentVars[a.Name], &tx)
+		if err != nil {
+			return tx.rollback(ctx.Stdout, err)
+		}
+	}
+	if interrupted() {
+		return tx.rollback(ctx.Stdout, errInterrupted)
+	}
+	// removing an environment can't be undone, so it's the last change
 	fmt.Fprintln(ctx.Stdout, "removing old environments...")
-	_, err = deleteApps(appsToRemove, client, ctx.Stdout)
+	return firstError(deleteApps(requestContext, appsToRemove, apiClient, ctx.Stdout))
+}
+
+// createEnvs creates the apps of the new environments, recording their
+// removal in the transaction.
+func (c *projectUpdate) createEnvs(ctx *cmd.Context, client *tsuru.Client, config *Config, opts tsuru.CreateAppOptions, tx *transaction) error {
+	envsToAdd := getEnvironmentsByName(config.Environments, c.addEnvs.Values())
+	fmt.Fprintln(ctx.Stdout, "adding new environments...")
+	appMaps, err := createApps(envsToAdd, client, c.name, opts)
 	if err != nil {
 		return err
 	}
+	for i, env := range envsToAdd {
+		a := app{App: tsuru.App{Name: appMaps[i]["name"]}, Env: env}
+		tx.done(fmt.Sprintf("remove env %q", env.Name), func(ctx context.Context) error {
+			return deleteApps(ctx, []app{a}, client, ioutil.Discard)[0]
+		})
+	}
+	return setCNames(appMaps, client)
+}
+
+// updateEnv applies the changes to the app of an existing environment,
+// recording how to restore its previous state in the transaction.
+func (c *projectUpdate) updateEnv(client *tsuru.Client, a app, opts tsuru.CreateAppOptions, vars map[string]string, currentVars []tsuru.EnvVar, tx *transaction) error {
 	if c.plan != "" || c.team != "" || c.description != "" {
-		for _, a := range appsToUpdate {
-			opts.Name = a.Name
-			opts.Pool = a.Pool
-			err = updateApp(client, opts)
+		opts.Name = a.Name
+		opts.Pool = a.Pool
+		err := client.UpdateApp(requestContext, opts)
+		if err != nil {
+			return err
+		}
+		previous := tsuru.CreateAppOptions{Name: a.Name, Pool: a.Pool, Team: a.TeamOwner, Description: a.Description}
+		if a.Plan.Name != "autogenerated" {
+			previous.Plan = a.Plan.Name
+		}
+		tx.done(fmt.Sprintf("restore plan, team and description of env %q", a.Env.Name), func(ctx context.Context) error {
+			return client.UpdateApp(ctx, previous)
+		})
+	}
+	if len(vars) > 0 {
+		err := client.SetEnvVars(requestContext, a.Name, envVarsToSet(vars))
+		if err != nil {
+			return err
+		}
+		tx.done(fmt.Sprintf("restore environment variables of env %q", a.Env.Name), func(ctx context.Context) error {
+			return restoreEnvVars(ctx, client, a.Name, vars, currentVars)
+		})
+	}
+	if c.units > 0 && c.units != len(a.Units) {
+		err := setUnits(requestContext, client, a, c.units)
+		if err != nil {
+			return err
+		}
+		updated := app{App: tsuru.App{Name: a.Name, Units: make([]tsuru.Unit, c.units)}}
+		tx.done(fmt.Sprintf("restore the number of units of env %q", a.Env.Name), func(ctx context.Context) error {
+			return setUnits(ctx, client, updated, len(a.Units))
+		})
+	}
+	return nil
+}
+
+// affectedEnvs returns the names of the existing environments that are
+// changed by the update.
+func (c *projectUpdate) affectedEnvs(appsToUpdate, appsToRemove []app, varsToSet map[string]map[string]string) []string {
+	var envNames []string
+	for _, a := range appsToRemove {
+		envNames = append(envNames, a.Env.Name)
+	}
+	for _, a := range appsToUpdate {
+		if c.plan != "" || c.team != "" || c.description != "" || c.units > 0 || len(varsToSet[a.Name]) > 0 {
+			envNames = append(envNames, a.Env.Name)
+		}
+	}
+	return envNames
+}
+
+// restartedEnvs returns the names of the environments whose apps are
+// restarted by setting the given variables, indexed by app name.
+func restartedEnvs(apps []app, varsToSet map[string]map[string]string) []string {
+	var envNames []string
+	for _, a := range apps {
+		if len(varsToSet[a.Name]) > 0 {
+			envNames = append(envNames, a.Env.Name)
+		}
+	}
+	return envNames
+}
+
+// checkConstraints ensures that the changes to the existing environments
+// don't break their constraints.
+func (c *projectUpdate) checkConstraints(appsToUpdate []app) error {
+	for _, a := range appsToUpdate {
+		if c.plan != "" {
+			err := a.Env.checkPlan(c.plan)
 			if err != nil {
 				return err
 			}
 		}
+		if c.units > 0 {
+			err := a.Env.checkUnits(c.units)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// missingEnvVars returns the variables defined in the environments of the
+// given apps that are not set in the apps, along with the variables currently
+// set in the apps, both indexed by app name.
+func (c *projectUpdate) missingEnvVars(client *tsuru.Client, apps []app) (map[string]map[string]string, map[string][]tsuru.EnvVar, error) {
+	missing := make(map[string]map[string]string)
+	currentVars := make(map[string][]tsuru.EnvVar)
+	for _, a := range apps {
+		if len(a.Env.EnvVars) == 0 {
+			continue
+		}
+		current, err := client.GetEnvVars(requestContext, a.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		currentVars[a.Name] = current
+		for name, value := range a.Env.EnvVars {
+			if !envVarDefined(current, name, value) {
+				if missing[a.Name] == nil {
+					missing[a.Name] = make(map[string]string)
+				}
+				missing[a.Name][name] = value
+			}
+		}
+	}
+	return missing, currentVars, nil
+}
+
+// restoreEnvVars restores the previous state of the given variables of the
+// app: variables that were not defined are unset and public variables get
+// their previous value back. Previous values of private variables are not
+// known, so they can't be restored.
+func restoreEnvVars(ctx context.Context, client *tsuru.Client, appName string, vars map[string]string, previous []tsuru.EnvVar) error {
+	var (
+		toUnset   []string
+		toRestore = make(map[string]string)
+	)
+	for name := range vars {
+		var found bool
+		for _, v := range previous {
+			if v.Name == name {
+				found = true
+				if v.Public {
+					toRestore[name] = v.Value
+				}
+				break
+			}
+		}
+		if !found {
+			toUnset = append(toUnset, name)
+		}
+	}
+	if len(toUnset) > 0 {
+		sort.Strings(toUnset)
+		err := client.UnsetEnvVars(ctx, appName, false, toUnset)
+		if err != nil {
+			return err
+		}
+	}
+	if len(toRestore) > 0 {
+		return client.SetEnvVars(ctx, appName, envVarsToSet(toRestore))
 	}
 	return nil
 }
 
-func (c *projectUpdate) baseOpts(a app) createAppOptions {
-	opts := createAppOptions{
+func (c *projectUpdate) baseOpts(a app) tsuru.CreateAppOptions {
+	opts := tsuru.CreateAppOptions{
 		Description: a.Description,
 		Platform:    a.Platform,
 		Pool:        a.Pool,
@@ -254,8 +445,11 @@ func (c *projectUpdate) Flags() *gnuflag.FlagSet {
 		c.fs.StringVar(&c.team, "t", "", "team that owns the project")
 		c.fs.StringVar(&c.plan, "plan", "", "plan to use for the project")
 		c.fs.StringVar(&c.plan, "p", "", "plan to use for the project")
+		c.fs.IntVar(&c.units, "units", 0, "number of units to run in each environment of the project")
 		c.fs.Var(&c.addEnvs, "add-envs", "comma-separated list of environments to add to the project")
 		c.fs.Var(&c.removeEnvs, "remove-envs", "comma-separated list of environments to remove from the project")
+		c.guard.addFlags(c.fs)
+		c.freeze.addFlags(c.fs)
 	}
 	return c.fs
 }
@@ -299,8 +493,9 @@ func (f *commaSeparatedFlag) validate(validValues []string) error {
 
 type projectRemove struct {
 	cmd.ConfirmationCommand
-	name string
-	fs   *gnuflag.FlagSet
+	name  string
+	guard approvalGuard
+	fs    *gnuflag.FlagSet
 }
 
 func (c *projectRemove) Info() *cmd.Info {
@@ -319,29 +514,26 @@ func (c *projectRemove) Run(ctx *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return errors.New("unable to load environments file, please make sure that tranor is properly configured")
 	}
-	if !c.Confirm(ctx, fmt.Sprintf("Are you sure you want to remove the project %q?", c.name)) {
-		return nil
-	}
-	apps := make([]app, len(config.Environments))
-	for i, env := range config.Environments {
-		apps[i] = app{Name: fmt.Sprintf("%s-%s", c.name, env.Name), Env: env}
+	apiClient, err := newAPIClient(client)
+	if err != nil {
+		return err
 	}
-	errs, err := deleteApps(apps, client, ctx.Stdout)
+	apps, err := projectApps(apiClient, c.name)
 	if err != nil {
 		return err
 	}
-	var notFound int
-	for _, err := range errs {
-		if err != nil {
-			if e, ok := err.(*tsuruerrors.HTTP); ok && e.Code == http.StatusNotFound {
-				notFound++
-			}
-		}
+	envNames := make([]string, len(apps))
+	for i, a := range apps {
+		envNames[i] = a.Env.Name
+	}
+	err = c.guard.check(ctx, client, config, c.name, envNames)
+	if err != nil {
+		return err
 	}
-	if notFound == len(errs) {
-		return errors.New("project not found")
+	if !dryRun && !c.Confirm(ctx, fmt.Sprintf("Are you sure you want to remove the project %q?", c.name)) {
+		return nil
 	}
-	return nil
+	return firstError(deleteApps(requestContext, apps, apiClient, ctx.Stdout))
 }
 
 func (c *projectRemove) Flags() *gnuflag.FlagSet {
@@ -349,13 +541,15 @@ func (c *projectRemove) Flags() *gnuflag.FlagSet {
 		c.fs = c.ConfirmationCommand.Flags()
 		c.fs.StringVar(&c.name, "name", "", "name of the project to b remove")
 		c.fs.StringVar(&c.name, "n", "", "name of the project to remove")
+		c.guard.addFlags(c.fs)
 	}
 	return c.fs
 }
 
 type projectInfo struct {
-	name string
-	fs   *gnuflag.FlagSet
+	name   string
+	output outputFormat
+	fs     *gnuflag.FlagSet
 }
 
 func (c *projectInfo) Info() *cmd.Info {
@@ -369,10 +563,38 @@ func (c *projectInfo) Run(ctx *cmd.Context, client *cmd.Client) error {
 	if c.name == "" {
 		return errors.New("please provide the name of the project")
 	}
-	apps, err := projectApps(client, c.name)
+	err := c.output.validate()
+	if err != nil {
+		return err
+	}
+	apiClient, err := newAPIClient(client)
 	if err != nil {
 		return err
 	}
+	apps, err := projectApps(apiClient, c.name)
+	if err != nil {
+		return err
+	}
+	deploys := make([]*tsuru.Deploy, len(apps))
+	errs := runConcurrently(len(apps), func(i int) error {
+		appDeploy, err := apiClient.LastDeploy(requestContext, apps[i].Name)
+		if err == nil && appDeploy.Image != "" {
+			deploys[i] = &appDeploy
+		}
+		return err
+	}, nil)
+	for i, err := range errs {
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "WARNING: failed to get the last deploy in environment %q: %s\n", apps[i].Env.Name, err)
+		}
+	}
+	if c.output.structured() {
+		project := newProjectOutput(c.name, apps)
+		for i := range apps {
+			project.Envs[i].LastDeploy = deploys[i]
+		}
+		return c.output.render(ctx.Stdout, project)
+	}
 	fmt.Fprintf(ctx.Stdout, "Project name: %s\n", c.name)
 	fmt.Fprintf(ctx.Stdout, "Description: %s\n", apps[0].Description)
 	fmt.Fprintf(ctx.Stdout, "Repository: %s\n", apps[0].RepositoryURL)
@@ -382,9 +604,9 @@ func (c *projectInfo) Run(ctx *cmd.Context, client *cmd.Client) error {
 	fmt.Fprintf(ctx.Stdout, "Team owner: %s\n", apps[0].TeamOwner)
 	var envs cmd.Table
 	envs.Headers = cmd.Row{"Environment", "Address", "Image", "Git hash/tag", "Deploy date", "Units"}
-	for _, app := range apps {
+	for i, app := range apps {
 		row := cmd.Row{app.Env.Name, app.Addr, "", "", "", strconv.Itoa(len(app.Units))}
-		if appDeploy, err := lastDeploy(client, app.Name); err == nil && appDeploy.Image != "" {
+		if appDeploy := deploys[i]; appDeploy != nil {
 			row[2] = appDeploy.Image
 			row[4] = appDeploy.Timestamp.Format(time.RFC1123)
 			if appDeploy.Commit != "" {
@@ -403,6 +625,7 @@ func (c *projectInfo) Flags() *gnuflag.FlagSet {
 		c.fs = gnuflag.NewFlagSet("project-info", gnuflag.ExitOnError)
 		c.fs.StringVar(&c.name, "name", "", "Name of the project")
 		c.fs.StringVar(&c.name, "n", "", "Name of the project")
+		c.output.addFlags(c.fs)
 	}
 	return c.fs
 }
@@ -424,9 +647,12 @@ func (c *projectEnvInfo) Run(ctx *cmd.Context, cli *cmd.Client) error {
 	if c.name == "" || c.envName == "" {
 		return errors.New("please provide the project name and the environment")
 	}
-	appName := fmt.Sprintf("%s-%s", c.name, c.envName)
+	config, err := loadConfigFile()
+	if err != nil {
+		return errors.New("unable to load environments file, please make sure that tranor is properly configured")
+	}
 	var appInfo client.AppInfo
-	appInfo.Flags().Parse(true, []string{"--app", appName})
+	appInfo.Flags().Parse(true, []string{"--app", config.appName(c.name, c.envName)})
 	return appInfo.Run(ctx, cli)
 }
 
@@ -441,7 +667,10 @@ func (c *projectEnvInfo) Flags() *gnuflag.FlagSet {
 	return c.fs
 }
 
-type projectList struct{}
+type projectList struct {
+	output outputFormat
+	fs     *gnuflag.FlagSet
+}
 
 func (c *projectList) Info() *cmd.Info {
 	return &cmd.Info{
@@ -451,31 +680,57 @@ func (c *projectList) Info() *cmd.Info {
 }
 
 func (c *projectList) Run(ctx *cmd.Context, client *cmd.Client) error {
+	err := c.output.validate()
+	if err != nil {
+		return err
+	}
 	config, err := loadConfigFile()
 	if err != nil {
 		return errors.New("unable to load environments file, please make sure that tranor is properly configured")
 	}
-	apps, err := listApps(client, nil)
+	apiClient, err := newAPIClient(client)
+	if err != nil {
+		return err
+	}
+	apps, err := apiClient.ListApps(requestContext, nil)
 	if err != nil {
 		return err
 	}
 	projects := make(map[string][]app)
 	for _, env := range config.Environments {
-		for _, app := range apps {
-			if len(app.CName) < 1 {
+		for _, a := range apps {
+			if len(a.CName) < 1 {
 				continue
 			}
-			if projectName, cname, err := extractProjectName(app, env); err == nil && app.Pool == env.poolName() {
-				app.Env = env
-				app.Addr = cname
-				projects[projectName] = append(projects[projectName], app)
+			if projectName, cname, err := config.namer().extractProjectName(a, env); err == nil && a.Pool == config.namer().poolName(env) {
+				projects[projectName] = append(projects[projectName], app{App: a, Env: env, Addr: cname})
 			}
 		}
 	}
+	if c.output.structured() {
+		names := make([]string, 0, len(projects))
+		for name := range projects {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		list := make([]projectOutput, len(names))
+		for i, name := range names {
+			list[i] = newProjectOutput(name, projects[name])
+		}
+		return c.output.render(ctx.Stdout, list)
+	}
 	c.render(ctx.Stdout, projects)
 	return nil
 }
 
+func (c *projectList) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("project-list", gnuflag.ExitOnError)
+		c.output.addFlags(c.fs)
+	}
+	return c.fs
+}
+
 func (c *projectList) render(w io.Writer, projects map[string][]app) {
 	var list projectSlice
 	for name, apps := range projects {
@@ -502,95 +757,97 @@ func (c *projectList) render(w io.Writer, projects map[string][]app) {
 	w.Write(table.Bytes())
 }
 
-func createApps(envs []Environment, client *cmd.Client, projectName string, opts createAppOptions) ([]map[string]string, error) {
-	createdApps := make([]map[string]string, 0, len(envs))
-	apps := make([]app, 0, len(envs))
-	for _, env := range envs {
-		opts.Name = fmt.Sprintf("%s-%s", projectName, env.Name)
-		opts.Pool = env.poolName()
-		a, err := createApp(client, opts)
+// createApps creates the apps of the project in the given environments, with
+// the variables and the minimum number of units of each environment. The apps
+// are removed when any of them fails.
+func createApps(envs []Environment, client *tsuru.Client, projectName string, opts tsuru.CreateAppOptions) ([]map[string]string, error) {
+	config, err := loadConfigFile()
+	if err != nil {
+		return nil, errors.New("unable to load environments file, please make sure that tranor is properly configured")
+	}
+	names := config.namer()
+	envOpts := make([]tsuru.CreateAppOptions, len(envs))
+	for i, env := range envs {
+		envOpts[i], err = env.appOptions(opts)
 		if err != nil {
-			deleteApps(apps, client, ioutil.Discard)
-			return nil, fmt.Errorf("failed to create the project in env %q: %s", env.Name, err)
-		}
-		a["name"] = opts.Name
-		a["dnsSuffix"] = env.DNSSuffix
-		setEnvVars(client, opts.Name, &api.Envs{
-			Envs: []struct {
-				Name  string
-				Value string
-			}{
-				{Name: "TRANOR_ENV_NAME", Value: env.Name},
-			},
-		})
-		createdApps = append(createdApps, a)
-		apps = append(apps, app{Name: opts.Name})
+			return nil, err
+		}
+		envOpts[i].Name = names.appName(projectName, env)
+		envOpts[i].Pool = names.poolName(env)
 	}
-	return createdApps, nil
-}
-
-func setCNames(apps []map[string]string, client *cmd.Client, projectName string) error {
-	for _, app := range apps {
-		err := setCName(app["name"], fmt.Sprintf("%s.%s", projectName, app["dnsSuffix"]), client)
+	createdApps := make([]map[string]string, len(envs))
+	errs := runConcurrently(len(envs), interruptible(func(i int) error {
+		a, err := client.CreateApp(requestContext, envOpts[i])
 		if err != nil {
 			return err
 		}
+		a["name"] = envOpts[i].Name
+		a["cname"] = names.cname(projectName, envs[i])
+		createdApps[i] = a
+		err = client.SetEnvVars(requestContext, envOpts[i].Name, envVarsToSet(envs[i].envVars()))
+		if err != nil {
+			return err
+		}
+		return setUnits(requestContext, client, app{App: tsuru.App{Name: envOpts[i].Name}}, envs[i].MinUnits)
+	}), nil)
+	for i, err := range errs {
+		if err != nil {
+			var names []string
+			for j, a := range createdApps {
+				if a != nil {
+					names = append(names, envOpts[j].Name)
+				}
+			}
+			return nil, removeCreatedApps(client, names, fmt.Errorf("failed to create the project in env %q: %s", envs[i].Name, err))
+		}
 	}
-	return nil
+	return createdApps, nil
 }
 
-func setCName(appName, cname string, client *cmd.Client) error {
-	reqURL, err := cmd.GetURL(fmt.Sprintf("/apps/%s/cname", appName))
-	if err != nil {
-		return err
-	}
-	v := make(url.Values)
-	v.Set("cname", cname)
-	req, err := http.NewRequest(http.MethodPost, reqURL, strings.NewReader(v.Encode()))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	return nil
+func setCNames(apps []map[string]string, client *tsuru.Client) error {
+	errs := runConcurrently(len(apps), interruptible(func(i int) error {
+		return client.AddCName(requestContext, apps[i]["name"], apps[i]["cname"])
+	}), nil)
+	return firstError(errs)
 }
 
-func projectApps(client *cmd.Client, name string) ([]app, error) {
+func projectApps(client *tsuru.Client, name string) ([]app, error) {
 	config, err := loadConfigFile()
 	if err != nil {
 		return nil, errors.New("unable to load environments file, please make sure that tranor is properly configured")
 	}
-	apps, err := listApps(client, map[string]string{"name": "^" + name})
+	apps, err := client.ListApps(requestContext, map[string]string{"name": config.namer().appFilter(name)})
 	if err != nil {
 		return nil, err
 	}
 	var projectApps []app
 	for _, env := range config.Environments {
-		for _, app := range apps {
-			if len(app.CName) < 1 {
+		for _, a := range apps {
+			if len(a.CName) < 1 {
 				continue
 			}
-			projectName, cname, err := extractProjectName(app, env)
+			projectName, cname, err := config.namer().extractProjectName(a, env)
 			if err != nil {
 				continue
 			}
 			if projectName == name {
-				app, err = getApp(client, app.Name)
-				if err != nil {
-					return nil, err
-				}
-				app.Addr = cname
-				app.Env = env
-				projectApps = append(projectApps, app)
+				projectApps = append(projectApps, app{App: a, Env: env, Addr: cname})
 			}
 		}
 	}
 	if len(projectApps) == 0 {
-		return nil, errors.New("project not found")
+		return nil, errProjectNotFound
+	}
+	errs := runConcurrently(len(projectApps), func(i int) error {
+		a, err := client.GetApp(requestContext, projectApps[i].Name)
+		if err != nil {
+			return err
+		}
+		projectApps[i].App = a
+		return nil
+	}, nil)
+	if err = firstError(errs); err != nil {
+		return nil, err
 	}
 	return projectApps, nil
 }
@@ -608,28 +865,6 @@ func getEnvironmentsByName(envs []Environment, names []string) []Environment {
 	return filtered
 }
 
-func extractProjectName(a app, env Environment) (projectName string, cname string, err error) {
-	partsName := env.nameRegexp().FindStringSubmatch(a.Name)
-	cname, err = findCName(a, env)
-	if err != nil {
-		return "", "", err
-	}
-	partsDNS := env.dnsRegexp().FindStringSubmatch(cname)
-	if len(partsName) == 2 && len(partsDNS) == 2 && partsName[1] == partsDNS[1] {
-		return partsDNS[1], cname, nil
-	}
-	return "", "", errors.New("not a tranor project")
-}
-
-func findCName(a app, env Environment) (string, error) {
-	for _, cname := range a.CName {
-		if env.dnsRegexp().MatchString(cname) {
-			return cname, nil
-		}
-	}
-	return "", errors.New("cname not defined")
-}
-
 type projectSlice []struct {
 	Name string
 	Apps []app
@@ -13,6 +13,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/ef-ctx/tsuru-flow/tsuru"
 	"github.com/tsuru/tsuru-client/tsuru/client"
 	"github.com/tsuru/tsuru/cmd"
 )
@@ -42,24 +43,22 @@ func TestProjectCreateDefaultEnvs(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	expectedApp := app{
+	expectedApp := tsuru.App{
 		Description: "my nice project",
 		Platform:    "python",
 		TeamOwner:   "myteam",
-		Plan: struct {
-			Name string `json:"name"`
-		}{Name: "medium"},
+		Plan:        tsuru.Plan{Name: "medium"},
 	}
 	envNames := []string{"dev", "prod", "qa", "stage"}
 	dnsSuffixes := []string{"dev.example.com", "example.com", "qa.example.com", "stage.example.com"}
-	apps, err := listApps(client, map[string]string{"name": "^myproj"})
+	apps, err := testAPIClient(client).ListApps(requestContext, map[string]string{"name": "^myproj"})
 	if err != nil {
 		t.Fatal(err)
 	}
 	alist := appList(apps)
 	sort.Sort(alist)
 	for i, a := range alist {
-		a, err = getApp(client, a.Name)
+		a, err = testAPIClient(client).GetApp(requestContext, a.Name)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -68,7 +67,6 @@ func TestProjectCreateDefaultEnvs(t *testing.T) {
 		expectedApp.CName = []string{"myproj." + dnsSuffixes[i]}
 
 		// we don't care about the value of the fields below
-		expectedApp.Addr = a.Addr
 		expectedApp.Owner = a.Owner
 		expectedApp.Teams = a.Teams
 		expectedApp.Units = a.Units
@@ -113,24 +111,22 @@ func TestProjectCreateSpecifyEnvs(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	expectedApp := app{
+	expectedApp := tsuru.App{
 		Description: "super project, just dev and prod needed",
 		Platform:    "python",
 		TeamOwner:   "myteam",
-		Plan: struct {
-			Name string `json:"name"`
-		}{Name: "medium"},
+		Plan:        tsuru.Plan{Name: "medium"},
 	}
 	envNames := []string{"dev", "prod"}
 	dnsSuffixes := []string{"dev.example.com", "example.com"}
-	apps, err := listApps(client, map[string]string{"name": "^superproj"})
+	apps, err := testAPIClient(client).ListApps(requestContext, map[string]string{"name": "^superproj"})
 	if err != nil {
 		t.Fatal(err)
 	}
 	alist := appList(apps)
 	sort.Sort(alist)
 	for i, a := range alist {
-		a, err = getApp(client, a.Name)
+		a, err = testAPIClient(client).GetApp(requestContext, a.Name)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -139,7 +135,6 @@ func TestProjectCreateSpecifyEnvs(t *testing.T) {
 		expectedApp.CName = []string{"superproj." + dnsSuffixes[i]}
 
 		// we don't care about the value of the fields below
-		expectedApp.Addr = a.Addr
 		expectedApp.Owner = a.Owner
 		expectedApp.Teams = a.Teams
 		expectedApp.Units = a.Units
@@ -168,7 +163,7 @@ func TestProjectCreateFailToCreateApp(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	createApp(client, createAppOptions{
+	testAPIClient(client).CreateApp(requestContext, tsuru.CreateAppOptions{
 		Name:     "superproj-dev",
 		Platform: "python",
 		Team:     "myteam",
@@ -203,7 +198,7 @@ func TestProjectUpdate(t *testing.T) {
 	appMaps, err := createApps([]Environment{
 		{Name: "dev", DNSSuffix: "dev.example.com"},
 		{Name: "prod", DNSSuffix: "example.com"},
-	}, client, "myproj", createAppOptions{
+	}, testAPIClient(client), "myproj", tsuru.CreateAppOptions{
 		Plan:        "medium",
 		Description: "my nice project",
 		Team:        "myteam",
@@ -212,15 +207,15 @@ func TestProjectUpdate(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = setCName(appMaps[0]["name"], "some-cname.example.com", client)
+	err = testAPIClient(client).AddCName(requestContext, appMaps[0]["name"], "some-cname.example.com")
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = setCNames(appMaps, client, "myproj")
+	err = setCNames(appMaps, testAPIClient(client))
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = setCName(appMaps[0]["name"], "another-cname.example.com", client)
+	err = testAPIClient(client).AddCName(requestContext, appMaps[0]["name"], "another-cname.example.com")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -242,22 +237,20 @@ func TestProjectUpdate(t *testing.T) {
 	}
 	envNames := []string{"prod", "qa", "stage"}
 	dnsSuffixes := []string{"example.com", "qa.example.com", "stage.example.com"}
-	apps, err := listApps(client, map[string]string{"name": "^myproj"})
+	apps, err := testAPIClient(client).ListApps(requestContext, map[string]string{"name": "^myproj"})
 	if err != nil {
 		t.Fatal(err)
 	}
-	expectedApp := app{
+	expectedApp := tsuru.App{
 		Description: "updated project description",
 		Platform:    "python",
 		TeamOwner:   "superteam",
-		Plan: struct {
-			Name string `json:"name"`
-		}{Name: "huge"},
+		Plan:        tsuru.Plan{Name: "huge"},
 	}
 	alist := appList(apps)
 	sort.Sort(alist)
 	for i, a := range alist {
-		a, err = getApp(client, a.Name)
+		a, err = testAPIClient(client).GetApp(requestContext, a.Name)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -266,7 +259,6 @@ func TestProjectUpdate(t *testing.T) {
 		expectedApp.CName = []string{"myproj." + dnsSuffixes[i]}
 
 		// we don't care about the value of the fields below
-		expectedApp.Addr = a.Addr
 		expectedApp.Owner = a.Owner
 		expectedApp.Teams = a.Teams
 		expectedApp.Units = a.Units
@@ -293,7 +285,7 @@ func TestProjectUpdateAutogenerated(t *testing.T) {
 	appMaps, err := createApps([]Environment{
 		{Name: "dev", DNSSuffix: "dev.example.com"},
 		{Name: "prod", DNSSuffix: "example.com"},
-	}, client, "myproj", createAppOptions{
+	}, testAPIClient(client), "myproj", tsuru.CreateAppOptions{
 		Description: "my nice project",
 		Team:        "myteam",
 		Platform:    "python",
@@ -301,7 +293,7 @@ func TestProjectUpdateAutogenerated(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = setCNames(appMaps, client, "myproj")
+	err = setCNames(appMaps, testAPIClient(client))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -322,22 +314,20 @@ func TestProjectUpdateAutogenerated(t *testing.T) {
 	}
 	envNames := []string{"prod", "qa", "stage"}
 	dnsSuffixes := []string{"example.com", "qa.example.com", "stage.example.com"}
-	apps, err := listApps(client, map[string]string{"name": "^myproj"})
+	apps, err := testAPIClient(client).ListApps(requestContext, map[string]string{"name": "^myproj"})
 	if err != nil {
 		t.Fatal(err)
 	}
-	expectedApp := app{
+	expectedApp := tsuru.App{
 		Description: "updated project description",
 		Platform:    "python",
 		TeamOwner:   "superteam",
-		Plan: struct {
-			Name string `json:"name"`
-		}{Name: "autogenerated"},
+		Plan:        tsuru.Plan{Name: "autogenerated"},
 	}
 	alist := appList(apps)
 	sort.Sort(alist)
 	for i, a := range alist {
-		a, err = getApp(client, a.Name)
+		a, err = testAPIClient(client).GetApp(requestContext, a.Name)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -346,7 +336,6 @@ func TestProjectUpdateAutogenerated(t *testing.T) {
 		expectedApp.CName = []string{"myproj." + dnsSuffixes[i]}
 
 		// we don't care about the value of the fields below
-		expectedApp.Addr = a.Addr
 		expectedApp.Owner = a.Owner
 		expectedApp.Teams = a.Teams
 		expectedApp.Units = a.Units
@@ -373,7 +362,7 @@ func TestProjectUpdateNoNewEnvs(t *testing.T) {
 	appMaps, err := createApps([]Environment{
 		{Name: "dev", DNSSuffix: "dev.example.com"},
 		{Name: "prod", DNSSuffix: "example.com"},
-	}, client, "myproj", createAppOptions{
+	}, testAPIClient(client), "myproj", tsuru.CreateAppOptions{
 		Description: "my nice project",
 		Team:        "myteam",
 		Platform:    "python",
@@ -381,7 +370,7 @@ func TestProjectUpdateNoNewEnvs(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = setCNames(appMaps, client, "myproj")
+	err = setCNames(appMaps, testAPIClient(client))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -400,18 +389,18 @@ func TestProjectUpdateNoNewEnvs(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	apps, err := listApps(client, map[string]string{"name": "^myproj"})
+	apps, err := testAPIClient(client).ListApps(requestContext, map[string]string{"name": "^myproj"})
 	if err != nil {
 		t.Fatal(err)
 	}
 	if len(apps) != 1 {
 		t.Errorf("too many apps: %#v", apps)
 	}
-	a, err := getApp(client, apps[0].Name)
+	a, err := testAPIClient(client).GetApp(requestContext, apps[0].Name)
 	if err != nil {
 		t.Fatal(err)
 	}
-	expectedApp := app{
+	expectedApp := tsuru.App{
 		Name:          "myproj-prod",
 		CName:         []string{"myproj.example.com"},
 		Pool:          `prod\example.com`,
@@ -419,13 +408,10 @@ func TestProjectUpdateNoNewEnvs(t *testing.T) {
 		Platform:      "python",
 		TeamOwner:     "superteam",
 		RepositoryURL: a.RepositoryURL,
-		Addr:          a.Addr,
 		Teams:         a.Teams,
 		Owner:         a.Owner,
 		Units:         a.Units,
-		Plan: struct {
-			Name string `json:"name"`
-		}{Name: "small"},
+		Plan:          tsuru.Plan{Name: "small"},
 	}
 	if !reflect.DeepEqual(a, expectedApp) {
 		t.Errorf("wrong app returned\nwant %#v\ngot  %#v", expectedApp, a)
@@ -445,7 +431,7 @@ func TestProjectUpdateOnlyEnvs(t *testing.T) {
 	appMaps, err := createApps([]Environment{
 		{Name: "dev", DNSSuffix: "dev.example.com"},
 		{Name: "prod", DNSSuffix: "example.com"},
-	}, client, "myproj", createAppOptions{
+	}, testAPIClient(client), "myproj", tsuru.CreateAppOptions{
 		Description: "my nice project",
 		Team:        "myteam",
 		Plan:        "medium",
@@ -454,7 +440,7 @@ func TestProjectUpdateOnlyEnvs(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = setCNames(appMaps, client, "myproj")
+	err = setCNames(appMaps, testAPIClient(client))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -473,22 +459,20 @@ func TestProjectUpdateOnlyEnvs(t *testing.T) {
 	}
 	envNames := []string{"prod", "qa", "stage"}
 	dnsSuffixes := []string{"example.com", "qa.example.com", "stage.example.com"}
-	apps, err := listApps(client, map[string]string{"name": "^myproj"})
+	apps, err := testAPIClient(client).ListApps(requestContext, map[string]string{"name": "^myproj"})
 	if err != nil {
 		t.Fatal(err)
 	}
-	expectedApp := app{
+	expectedApp := tsuru.App{
 		Description: "my nice project",
 		Platform:    "python",
 		TeamOwner:   "myteam",
-		Plan: struct {
-			Name string `json:"name"`
-		}{Name: "medium"},
+		Plan:        tsuru.Plan{Name: "medium"},
 	}
 	alist := appList(apps)
 	sort.Sort(alist)
 	for i, a := range alist {
-		a, err = getApp(client, a.Name)
+		a, err = testAPIClient(client).GetApp(requestContext, a.Name)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -497,7 +481,6 @@ func TestProjectUpdateOnlyEnvs(t *testing.T) {
 		expectedApp.CName = []string{"myproj." + dnsSuffixes[i]}
 
 		// we don't care about the value of the fields below
-		expectedApp.Addr = a.Addr
 		expectedApp.Owner = a.Owner
 		expectedApp.Teams = a.Teams
 		expectedApp.Units = a.Units
@@ -526,7 +509,7 @@ func TestProjectRemove(t *testing.T) {
 		{Name: "qa", DNSSuffix: "qa.example.com"},
 		{Name: "stage", DNSSuffix: "stage.example.com"},
 		{Name: "prod", DNSSuffix: "example.com"},
-	}, client, "myproj", createAppOptions{
+	}, testAPIClient(client), "myproj", tsuru.CreateAppOptions{
 		Description: "my nice project",
 		Team:        "myteam",
 		Plan:        "medium",
@@ -535,7 +518,7 @@ func TestProjectRemove(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = setCNames(appMaps, client, "myproj")
+	err = setCNames(appMaps, testAPIClient(client))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -571,7 +554,7 @@ func TestProjectRemoveSomeEnvironments(t *testing.T) {
 	appMaps, err := createApps([]Environment{
 		{Name: "dev", DNSSuffix: "dev.example.com"},
 		{Name: "prod", DNSSuffix: "example.com"},
-	}, client, "myproj", createAppOptions{
+	}, testAPIClient(client), "myproj", tsuru.CreateAppOptions{
 		Description: "my nice project",
 		Team:        "myteam",
 		Plan:        "medium",
@@ -580,7 +563,7 @@ func TestProjectRemoveSomeEnvironments(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = setCNames(appMaps, client, "myproj")
+	err = setCNames(appMaps, testAPIClient(client))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -594,8 +577,6 @@ func TestProjectRemoveSomeEnvironments(t *testing.T) {
 		t.Fatal(err)
 	}
 	expectedOutput := `Deleting from env "dev"... ok
-Deleting from env "qa"... ok
-Deleting from env "stage"... ok
 Deleting from env "prod"... ok
 `
 	if stdout.String() != expectedOutput {
@@ -603,6 +584,54 @@ Deleting from env "prod"... ok
 	}
 }
 
+func TestProjectRemoveOnlyChecksEnvsOfTheProject(t *testing.T) {
+	tsuruServer.reset()
+	cleanup, err := setupFakeConfig(tsuruServer.url(), tsuruServer.token())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	config, err := loadConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Environments[3].Protected = true
+	err = writeConfigFile(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	appMaps, err := createApps([]Environment{
+		{Name: "dev", DNSSuffix: "dev.example.com"},
+	}, testAPIClient(client), "myproj", tsuru.CreateAppOptions{
+		Team:     "myteam",
+		Plan:     "medium",
+		Platform: "python",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = setCNames(appMaps, testAPIClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c projectRemove
+	err = c.Flags().Parse(true, []string{"-yn", "myproj"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOutput := "Deleting from env \"dev\"... ok\n"
+	if stdout.String() != expectedOutput {
+		t.Errorf("Wrong output\nWant:\n%s\nGot:\n%s", expectedOutput, stdout.String())
+	}
+}
+
 func TestProjectRemoveNotFound(t *testing.T) {
 	tsuruServer.reset()
 	cleanup, err := setupFakeConfig(tsuruServer.url(), tsuruServer.token())
@@ -639,7 +668,7 @@ func TestProjectInfo(t *testing.T) {
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
 	config, _ := loadConfigFile()
-	appMaps, err := createApps(config.Environments, client, "proj1", createAppOptions{
+	appMaps, err := createApps(config.Environments, testAPIClient(client), "proj1", tsuru.CreateAppOptions{
 		Plan:        "medium",
 		Description: "my nice project",
 		Team:        "myteam",
@@ -648,7 +677,7 @@ func TestProjectInfo(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = setCNames(appMaps, client, "proj1")
+	err = setCNames(appMaps, testAPIClient(client))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -661,7 +690,7 @@ func TestProjectInfo(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	a, _ := getApp(client, "proj1-dev")
+	a, _ := testAPIClient(client).GetApp(requestContext, "proj1-dev")
 	table := cmd.Table{Headers: cmd.Row([]string{"Environment", "Address", "Image", "Git hash/tag", "Deploy date", "Units"})}
 	expectedOutput := fmt.Sprintf(`Project name: proj1
 Description: my nice project
@@ -696,7 +725,7 @@ func TestProjectInfoWithDash(t *testing.T) {
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
 	config, _ := loadConfigFile()
-	appMaps, err := createApps(config.Environments, client, "my-proj1", createAppOptions{
+	appMaps, err := createApps(config.Environments, testAPIClient(client), "my-proj1", tsuru.CreateAppOptions{
 		Plan:        "medium",
 		Description: "my nice project",
 		Team:        "myteam",
@@ -705,7 +734,7 @@ func TestProjectInfoWithDash(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = setCNames(appMaps, client, "my-proj1")
+	err = setCNames(appMaps, testAPIClient(client))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -718,7 +747,7 @@ func TestProjectInfoWithDash(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	a, _ := getApp(client, "my-proj1-dev")
+	a, _ := testAPIClient(client).GetApp(requestContext, "my-proj1-dev")
 	table := cmd.Table{Headers: cmd.Row([]string{"Environment", "Address", "Image", "Git hash/tag", "Deploy date", "Units"})}
 	expectedOutput := fmt.Sprintf(`Project name: my-proj1
 Description: my nice project
@@ -777,7 +806,7 @@ func TestProjectEnvInfo(t *testing.T) {
 	var stdout, stderr bytes.Buffer
 	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
 	cli := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
-	_, err = createApp(cli, createAppOptions{
+	_, err = testAPIClient(cli).CreateApp(requestContext, tsuru.CreateAppOptions{
 		Name:     "proj1-prod",
 		Platform: "python",
 		Team:     "myteam",
@@ -821,7 +850,7 @@ func TestProjectList(t *testing.T) {
 	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
 	config, _ := loadConfigFile()
 	for _, projName := range []string{"myproj1", "myproj2"} {
-		appMaps, innerErr := createApps(config.Environments, client, projName, createAppOptions{
+		appMaps, innerErr := createApps(config.Environments, testAPIClient(client), projName, tsuru.CreateAppOptions{
 			Plan:        "medium",
 			Description: "my nice project",
 			Team:        "myteam",
@@ -830,7 +859,7 @@ func TestProjectList(t *testing.T) {
 		if innerErr != nil {
 			t.Fatal(innerErr)
 		}
-		innerErr = setCNames(appMaps, client, projName)
+		innerErr = setCNames(appMaps, testAPIClient(client))
 		if innerErr != nil {
 			t.Fatal(innerErr)
 		}
@@ -838,7 +867,7 @@ func TestProjectList(t *testing.T) {
 	appMaps, err := createApps([]Environment{
 		{Name: "dev", DNSSuffix: "dev.example.com"},
 		{Name: "prod", DNSSuffix: "example.com"},
-	}, client, "my-proj3", createAppOptions{
+	}, testAPIClient(client), "my-proj3", tsuru.CreateAppOptions{
 		Plan:        "medium",
 		Description: "my nice project",
 		Team:        "myteam",
@@ -847,11 +876,11 @@ func TestProjectList(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = setCName(appMaps[0]["name"], "some-cname.example.com", client)
+	err = testAPIClient(client).AddCName(requestContext, appMaps[0]["name"], "some-cname.example.com")
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = setCNames(appMaps, client, "my-proj3")
+	err = setCNames(appMaps, testAPIClient(client))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -882,7 +911,7 @@ func TestProjectList(t *testing.T) {
 	}
 }
 
-type appList []app
+type appList []tsuru.App
 
 func (s appList) Len() int {
 	return len(s)
@@ -896,9 +925,237 @@ func (s appList) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
 
-func repoLine(a app) string {
+func repoLine(a tsuru.App) string {
 	if a.RepositoryURL == "" {
 		return ""
 	}
 	return fmt.Sprintf("Git repository: %s\n", a.RepositoryURL)
 }
+
+func TestProjectCreateCustomNaming(t *testing.T) {
+	tsuruServer.reset()
+	cleanup, err := setupFakeConfig(tsuruServer.url(), tsuruServer.token())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	config, err := loadConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Naming = &Naming{
+		App:   "{{.Env}}-{{.Project}}",
+		Pool:  "pool-{{.Env}}",
+		CName: "{{.Project}}.{{.Env}}.apps.example.com",
+	}
+	err = writeConfigFile(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c projectCreate
+	err = c.Flags().Parse(true, []string{"-n", "myproj", "-l", "python", "-t", "myteam", "-e", "dev,qa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := cmd.Context{Stdout: &bytes.Buffer{}, Stderr: &bytes.Buffer{}}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps, err := projectApps(testAPIClient(client), "myproj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 2 {
+		t.Fatalf("wrong number of apps. Want 2. Got %d", len(apps))
+	}
+	for _, a := range apps {
+		expectedName := a.Env.Name + "-myproj"
+		expectedPool := "pool-" + a.Env.Name
+		expectedAddr := "myproj." + a.Env.Name + ".apps.example.com"
+		if a.Name != expectedName || a.Pool != expectedPool || a.Addr != expectedAddr {
+			t.Errorf("wrong app in env %q. Want %q/%q/%q. Got %q/%q/%q", a.Env.Name, expectedName, expectedPool, expectedAddr, a.Name, a.Pool, a.Addr)
+		}
+	}
+}
+
+func setupEnvConstraints(t *testing.T, update func(envs []Environment)) {
+	config, err := loadConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	update(config.Environments)
+	err = writeConfigFile(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProjectCreateEnvDefaults(t *testing.T) {
+	tsuruServer.reset()
+	cleanup, err := setupFakeConfig(tsuruServer.url(), tsuruServer.token())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	setupEnvConstraints(t, func(envs []Environment) {
+		envs[3].DefaultPlan = "large"
+		envs[3].AllowedPlans = []string{"large", "huge"}
+		envs[3].EnvVars = map[string]string{"LOG_LEVEL": "warn"}
+		envs[3].MinUnits = 2
+	})
+	var c projectCreate
+	err = c.Flags().Parse(true, []string{"-n", "myproj", "-l", "python", "-e", "dev,prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := cmd.Context{Stdout: &bytes.Buffer{}, Stderr: &bytes.Buffer{}}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := testAPIClient(client).GetApp(requestContext, "myproj-prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Plan.Name != "large" {
+		t.Errorf("wrong plan in prod. Want %q. Got %q", "large", a.Plan.Name)
+	}
+	if len(a.Units) != 2 {
+		t.Errorf("wrong number of units in prod. Want 2. Got %d", len(a.Units))
+	}
+	a, err = testAPIClient(client).GetApp(requestContext, "myproj-dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Plan.Name != "autogenerated" {
+		t.Errorf("wrong plan in dev. Want %q. Got %q", "autogenerated", a.Plan.Name)
+	}
+	envVars, err := testAPIClient(client).GetEnvVars(requestContext, "myproj-prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !envVarDefined(envVars, "LOG_LEVEL", "warn") || !envVarDefined(envVars, "TRANOR_ENV_NAME", "prod") {
+		t.Errorf("default variables not set in prod: %#v", envVars)
+	}
+}
+
+func TestProjectCreateBreaksEnvConstraints(t *testing.T) {
+	var tests = []struct {
+		testCase string
+		flags    []string
+		errMsg   string
+	}{
+		{
+			"plan not allowed",
+			[]string{"-p", "small"},
+			`plan "small" is not allowed in the environment "prod" (allowed plans: large, huge)`,
+		},
+		{
+			"platform not allowed",
+			[]string{"-l", "ruby"},
+			`platform "ruby" is not allowed in the environment "prod" (allowed platforms: python, go)`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.testCase, func(t *testing.T) {
+			tsuruServer.reset()
+			cleanup, err := setupFakeConfig(tsuruServer.url(), tsuruServer.token())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cleanup()
+			setupEnvConstraints(t, func(envs []Environment) {
+				envs[3].AllowedPlans = []string{"large", "huge"}
+				envs[3].AllowedPlatforms = []string{"python", "go"}
+			})
+			var c projectCreate
+			err = c.Flags().Parse(true, append([]string{"-n", "myproj", "-l", "python", "-p", "large"}, test.flags...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := cmd.Context{Stdout: &bytes.Buffer{}, Stderr: &bytes.Buffer{}}
+			client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+			err = c.Run(&ctx, client)
+			if err == nil || err.Error() != test.errMsg {
+				t.Errorf("wrong error\nwant %q\ngot  %v", test.errMsg, err)
+			}
+			apps, err := testAPIClient(client).ListApps(requestContext, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(apps) != 0 {
+				t.Errorf("no apps should be created, got %d", len(apps))
+			}
+		})
+	}
+}
+
+func TestProjectUpdateEnvConstraints(t *testing.T) {
+	tsuruServer.reset()
+	cleanup, err := setupFakeConfig(tsuruServer.url(), tsuruServer.token())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	ctx := cmd.Context{Stdout: &bytes.Buffer{}, Stderr: &bytes.Buffer{}}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	config, err := loadConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	appMaps, err := createApps(config.Environments[:2], testAPIClient(client), "myproj", tsuru.CreateAppOptions{Platform: "python", Plan: "small"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = setCNames(appMaps, testAPIClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupEnvConstraints(t, func(envs []Environment) {
+		envs[1].AllowedPlans = []string{"small", "medium"}
+		envs[1].MinUnits = 2
+		envs[1].MaxUnits = 4
+		envs[1].EnvVars = map[string]string{"LOG_LEVEL": "debug"}
+	})
+	var tests = []struct {
+		flags  []string
+		errMsg string
+	}{
+		{[]string{"-p", "huge"}, `plan "huge" is not allowed in the environment "qa" (allowed plans: small, medium)`},
+		{[]string{"--units", "1"}, `the environment "qa" requires at least 2 units`},
+		{[]string{"--units", "5"}, `the environment "qa" allows at most 4 units`},
+	}
+	for _, test := range tests {
+		var c projectUpdate
+		c.Flags().Parse(true, append([]string{"-n", "myproj"}, test.flags...))
+		err = c.Run(&ctx, client)
+		if err == nil || err.Error() != test.errMsg {
+			t.Errorf("wrong error for %v\nwant %q\ngot  %v", test.flags, test.errMsg, err)
+		}
+	}
+	var c projectUpdate
+	c.Flags().Parse(true, []string{"-n", "myproj", "--units", "3"})
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, appName := range []string{"myproj-dev", "myproj-qa"} {
+		a, err := testAPIClient(client).GetApp(requestContext, appName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(a.Units) != 3 {
+			t.Errorf("wrong number of units in %q. Want 3. Got %d", appName, len(a.Units))
+		}
+	}
+	envVars, err := testAPIClient(client).GetEnvVars(requestContext, "myproj-qa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !envVarDefined(envVars, "LOG_LEVEL", "debug") {
+		t.Errorf("default variables not set in qa: %#v", envVars)
+	}
+}
@@ -107,6 +107,11 @@ func TestProjectCreateNoRepo(t *testing.T) {
 			code:    http.StatusOK,
 			payload: []byte(`{}`),
 		})
+		server.prepareResponse(preparedResponse{
+			method: http.MethodPost,
+			path:   "/apps/" + appName + "/env",
+			code:   http.StatusOK,
+		})
 	}
 	cleanup, err := setupFakeConfig(server.url(), "")
 	if err != nil {
@@ -253,6 +258,13 @@ func TestProjectCreateFailToSetCNames(t *testing.T) {
 		path:   "/apps/superproj-prod",
 		code:   http.StatusOK,
 	})
+	for _, appName := range []string{"superproj-dev", "superproj-prod"} {
+		server.prepareResponse(preparedResponse{
+			method: http.MethodPost,
+			path:   "/apps/" + appName + "/env",
+			code:   http.StatusOK,
+		})
+	}
 	server.prepareResponse(preparedResponse{
 		method:  http.MethodPost,
 		path:    "/apps/superproj-prod/cname",
@@ -281,25 +293,74 @@ func TestProjectCreateFailToSetCNames(t *testing.T) {
 	if err == nil {
 		t.Fatal("unexpected <nil> error")
 	}
-	expectedMethods := []string{http.MethodPost, "POST", "POST", "POST", "POST", "POST", http.MethodDelete, "DELETE"}
-	expectedPaths := []string{
-		"/1.0/apps", "/1.0/apps/superproj-dev/env",
-		"/1.0/apps", "/1.0/apps/superproj-prod/env",
-		"/1.0/apps/superproj-dev/cname",
-		"/1.0/apps/superproj-prod/cname",
-		"/1.0/apps/superproj-dev",
-		"/1.0/apps/superproj-prod",
+	expectedReqs := []string{
+		"DELETE /1.0/apps/superproj-dev",
+		"DELETE /1.0/apps/superproj-prod",
+		"POST /1.0/apps",
+		"POST /1.0/apps",
+		"POST /1.0/apps/superproj-dev/cname",
+		"POST /1.0/apps/superproj-dev/env",
+		"POST /1.0/apps/superproj-prod/cname",
+		"POST /1.0/apps/superproj-prod/env",
 	}
-	if len(server.reqs) != len(expectedPaths) {
-		t.Fatalf("wrong number of requests sent to the server. Want %d. Got %d", len(expectedPaths), len(server.reqs))
+	if reqs := server.sortedRequests(); !reflect.DeepEqual(reqs, expectedReqs) {
+		t.Errorf("wrong requests\nwant %#v\ngot  %#v", expectedReqs, reqs)
 	}
-	for i, req := range server.reqs {
-		if req.Method != expectedMethods[i] {
-			t.Errorf("wrong method. Want %q. Got %q", expectedMethods[i], req.Method)
-		}
-		if req.URL.Path != expectedPaths[i] {
-			t.Errorf("wrong path. Want %q. Got %q", expectedPaths[i], req.URL.Path)
-		}
+}
+
+func TestProjectCreateFailToSetEnvVars(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.stop()
+	server.prepareResponse(preparedResponse{
+		method:  http.MethodPost,
+		path:    "/apps",
+		code:    http.StatusCreated,
+		payload: []byte(`{}`),
+	})
+	server.prepareResponse(preparedResponse{
+		method: http.MethodPost,
+		path:   "/apps/superproj-dev/env",
+		code:   http.StatusOK,
+	})
+	server.prepareResponse(preparedResponse{
+		method:  http.MethodPost,
+		path:    "/apps/superproj-prod/env",
+		code:    http.StatusInternalServerError,
+		payload: []byte("something went wrong"),
+	})
+	for _, appName := range []string{"superproj-dev", "superproj-prod"} {
+		server.prepareResponse(preparedResponse{
+			method: http.MethodDelete,
+			path:   "/apps/" + appName,
+			code:   http.StatusOK,
+		})
+	}
+	cleanup, err := setupFakeConfig(server.url(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	var c projectCreate
+	err = c.Flags().Parse(true, []string{"-n", "superproj", "-l", "python", "-e", "dev,prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	err = c.Run(&ctx, cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{}))
+	if err == nil || !strings.HasPrefix(err.Error(), `failed to create the project in env "prod": `) {
+		t.Errorf("wrong error returned: %v", err)
+	}
+	expectedReqs := []string{
+		"DELETE /1.0/apps/superproj-dev",
+		"DELETE /1.0/apps/superproj-prod",
+		"POST /1.0/apps",
+		"POST /1.0/apps",
+		"POST /1.0/apps/superproj-dev/env",
+		"POST /1.0/apps/superproj-prod/env",
+	}
+	if reqs := server.sortedRequests(); !reflect.DeepEqual(reqs, expectedReqs) {
+		t.Errorf("wrong requests\nwant %#v\ngot  %#v", expectedReqs, reqs)
 	}
 }
 
@@ -552,6 +613,166 @@ func TestProjectUpdateFailToUpdate(t *testing.T) {
 	}
 }
 
+func TestProjectUpdateFailToRemoveEnv(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.stop()
+	server.prepareResponse(preparedResponse{
+		method:  http.MethodGet,
+		path:    "/apps?name=" + url.QueryEscape("^proj3"),
+		code:    http.StatusOK,
+		payload: []byte(listOfApps),
+	})
+	appRespMap := map[string][]byte{
+		"proj3-dev":  []byte(appInfo1),
+		"proj3-prod": []byte(appInfo2),
+	}
+	for appName, payload := range appRespMap {
+		server.prepareResponse(preparedResponse{
+			method:  http.MethodGet,
+			path:    "/apps/" + appName,
+			code:    http.StatusOK,
+			payload: payload,
+		})
+	}
+	server.prepareResponse(preparedResponse{
+		method:  http.MethodDelete,
+		path:    "/apps/proj3-dev",
+		code:    http.StatusInternalServerError,
+		payload: []byte("something went wrong"),
+	})
+	cleanup, err := setupFakeConfig(server.url(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	var c projectUpdate
+	err = c.Flags().Parse(true, []string{"-n", "proj3", "--remove-envs", "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = c.Run(&ctx, client)
+	if err == nil || !strings.Contains(err.Error(), "something went wrong") {
+		t.Errorf("wrong error: %v", err)
+	}
+	expectedOutput := "adding new environments...\nremoving old environments...\nDeleting from env \"dev\"... failed: " + err.Error() + "\n"
+	if stdout.String() != expectedOutput {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expectedOutput, stdout.String())
+	}
+}
+
+func TestProjectUpdateRollback(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.stop()
+	server.prepareResponse(preparedResponse{
+		method:  http.MethodGet,
+		path:    "/apps?name=" + url.QueryEscape("^proj3"),
+		code:    http.StatusOK,
+		payload: []byte(listOfApps),
+	})
+	appRespMap := map[string][]byte{
+		"proj3-dev":  []byte(appInfo1),
+		"proj3-prod": []byte(appInfo2),
+	}
+	for appName, payload := range appRespMap {
+		server.prepareResponse(preparedResponse{
+			method:  http.MethodGet,
+			path:    "/apps/" + appName,
+			code:    http.StatusOK,
+			payload: payload,
+		})
+	}
+	server.prepareResponse(preparedResponse{
+		method:  http.MethodPost,
+		path:    "/apps",
+		code:    http.StatusCreated,
+		payload: []byte(`{}`),
+	})
+	for _, appName := range []string{"proj3-qa", "proj3-stage"} {
+		server.prepareResponse(preparedResponse{
+			method: http.MethodPost,
+			path:   "/apps/" + appName + "/env",
+			code:   http.StatusOK,
+		})
+		server.prepareResponse(preparedResponse{
+			method: http.MethodPost,
+			path:   "/apps/" + appName + "/cname",
+			code:   http.StatusOK,
+		})
+		server.prepareResponse(preparedResponse{
+			method: http.MethodDelete,
+			path:   "/apps/" + appName,
+			code:   http.StatusOK,
+		})
+	}
+	server.prepareResponse(preparedResponse{
+		method: http.MethodPut,
+		path:   "/apps/proj3-dev",
+		code:   http.StatusOK,
+	})
+	server.prepareResponse(preparedResponse{
+		method:  http.MethodPut,
+		path:    "/apps/proj3-prod",
+		code:    http.StatusInternalServerError,
+		payload: []byte("plan not found"),
+	})
+	cleanup, err := setupFakeConfig(server.url(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	var c projectUpdate
+	err = c.Flags().Parse(true, []string{
+		"-n", "proj3",
+		"-d", "updated project description",
+		"-t", "superteam",
+		"--add-envs", "qa,stage",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = c.Run(&ctx, client)
+	if err == nil {
+		t.Fatal("unexpected <nil> error")
+	}
+	if err.Error() != "plan not found" {
+		t.Errorf("wrong error message: %q", err.Error())
+	}
+	expectedOutput := `adding new environments...
+failed: plan not found
+rolling back the changes...
+ restore plan, team and description of env "dev"... ok
+ remove env "stage"... ok
+ remove env "qa"... ok
+`
+	if stdout.String() != expectedOutput {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expectedOutput, stdout.String())
+	}
+	var restorePayload []byte
+	var devUpdates int
+	for i, req := range server.reqs {
+		if req.Method == http.MethodPut && req.URL.Path == "/1.0/apps/proj3-dev" {
+			devUpdates++
+			restorePayload = server.payloads[i]
+		}
+	}
+	if devUpdates != 2 {
+		t.Fatalf("wrong number of updates to the app in dev. Want 2. Got %d", devUpdates)
+	}
+	params, err := url.ParseQuery(string(restorePayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Get("teamOwner") != "admin" || params.Get("description") != "my nice project" {
+		t.Errorf("wrong payload to restore the app in dev: %#v", params)
+	}
+}
+
 func TestProjectUpdateInvalidNewEnv(t *testing.T) {
 	server := newFakeServer(t)
 	defer server.stop()
@@ -717,13 +938,10 @@ func TestProjectUpdateFailToLoadConfig(t *testing.T) {
 }
 
 func TestRemoveProjectNoConfirmation(t *testing.T) {
-	cleanup, err := setupFakeConfig("http://localhost:8080", "")
-	if err != nil {
-		t.Fatal(err)
-	}
+	cleanup := createTestProject("myproj", t)
 	defer cleanup()
 	var c projectRemove
-	err = c.Flags().Parse(true, []string{"-n", "myproj"})
+	err := c.Flags().Parse(true, []string{"-n", "myproj"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -734,6 +952,13 @@ func TestRemoveProjectNoConfirmation(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	apps, err := projectApps(testAPIClient(client), "myproj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 4 {
+		t.Errorf("apps removed without confirmation: %#v", apps)
+	}
 }
 
 func TestRemoveProjectValidation(t *testing.T) {
@@ -803,6 +1028,62 @@ func TestProjectInfoErrorToListApps(t *testing.T) {
 	}
 }
 
+func TestProjectInfoErrorToGetLastDeploy(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.stop()
+	server.prepareResponse(preparedResponse{
+		method:  http.MethodGet,
+		path:    "/apps?name=" + url.QueryEscape("^proj3"),
+		code:    http.StatusOK,
+		payload: []byte(listOfApps),
+	})
+	appRespMap := map[string][]byte{
+		"proj3-dev":  []byte(appInfo1),
+		"proj3-prod": []byte(appInfo2),
+	}
+	for appName, payload := range appRespMap {
+		server.prepareResponse(preparedResponse{
+			method:  http.MethodGet,
+			path:    "/apps/" + appName,
+			code:    http.StatusOK,
+			payload: payload,
+		})
+	}
+	server.prepareResponse(preparedResponse{
+		method:  http.MethodGet,
+		path:    "/deploys?limit=1&app=proj3-dev",
+		code:    http.StatusOK,
+		payload: []byte("[]"),
+	})
+	server.prepareResponse(preparedResponse{
+		method:  http.MethodGet,
+		path:    "/deploys?limit=1&app=proj3-prod",
+		code:    http.StatusInternalServerError,
+		payload: []byte("something went wrong"),
+	})
+	cleanup, err := setupFakeConfig(server.url(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	var c projectInfo
+	err = c.Flags().Parse(true, []string{"-n", "proj3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(http.DefaultClient, &ctx, &cmd.Manager{})
+	err = c.Run(&ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedWarning := `WARNING: failed to get the last deploy in environment "prod": `
+	if !strings.HasPrefix(stderr.String(), expectedWarning) || !strings.Contains(stderr.String(), "something went wrong") {
+		t.Errorf("wrong warning\nwant %q...\ngot  %q", expectedWarning, stderr.String())
+	}
+}
+
 func TestProjectInfoConfigIssue(t *testing.T) {
 	dir, err := ioutil.TempDir("", "")
 	if err != nil {
@@ -942,7 +1223,7 @@ func setupFakeConfig(target, token string) (func(), error) {
 			},
 		},
 	}
-	err = config.writeTarget()
+	err = config.writeTarget(defaultTargetName)
 	if err != nil {
 		os.RemoveAll(dir)
 		return nil, err
@@ -0,0 +1,108 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultConfigTTL is the time after which the local copy of the
+// configuration is refreshed, unless TRANOR_CONFIG_TTL is defined.
+const defaultConfigTTL = time.Hour
+
+var configClient = &http.Client{Timeout: 10 * time.Second}
+
+// configRefresh tracks the refresh of the configuration started by
+// loadConfigFile.
+var configRefresh struct {
+	sync.Mutex
+	wg      sync.WaitGroup
+	started bool
+	config  *Config
+	err     error
+}
+
+// configTTL returns the maximum age of the local copy of the configuration.
+// A zero TTL disables the automatic refresh.
+func configTTL() time.Duration {
+	if value := os.Getenv("TRANOR_CONFIG_TTL"); value != "" {
+		if ttl, err := time.ParseDuration(value); err == nil && ttl >= 0 {
+			return ttl
+		}
+	}
+	return defaultConfigTTL
+}
+
+func configUpdatedAt(targetName string) (time.Time, error) {
+	info, err := os.Stat(configFilePath(targetName))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// configStale reports whether the local copy of the configuration is older
+// than the TTL. Configurations not downloaded from a server are never stale.
+func configStale(targetName string, config *Config) bool {
+	ttl := configTTL()
+	if config.Source == "" || ttl == 0 {
+		return false
+	}
+	updated, err := configUpdatedAt(targetName)
+	return err == nil && time.Since(updated) > ttl
+}
+
+// startConfigRefresh refreshes the configuration of the given target in the
+// background. Only one refresh is started per execution.
+func startConfigRefresh(targetName string, cached *Config) {
+	configRefresh.Lock()
+	defer configRefresh.Unlock()
+	if configRefresh.started {
+		return
+	}
+	configRefresh.started = true
+	configRefresh.wg.Add(1)
+	go func() {
+		defer configRefresh.wg.Done()
+		config, err := refreshConfiguration(targetName, cached)
+		configRefresh.Lock()
+		configRefresh.config, configRefresh.err = config, err
+		configRefresh.Unlock()
+	}()
+}
+
+// waitConfigRefresh waits for the refresh started by loadConfigFile, if any,
+// returning the new configuration, or nil when it didn't change.
+func waitConfigRefresh() (*Config, error) {
+	configRefresh.wg.Wait()
+	configRefresh.Lock()
+	defer configRefresh.Unlock()
+	return configRefresh.config, configRefresh.err
+}
+
+// refreshConfiguration downloads the configuration of the target if it
+// changed, returning nil otherwise. Only the target set by target-use updates
+// the tsuru target.
+func refreshConfiguration(targetName string, cached *Config) (*Config, error) {
+	config, err := fetchConfiguration(cached.Source, cached.SigningKey, cached)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		now := time.Now()
+		return nil, os.Chtimes(configFilePath(targetName), now, now)
+	}
+	err = writeTargetConfig(targetName, config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Target != cached.Target && targetName == savedTargetName() {
+		err = config.writeTarget(targetName)
+	}
+	return config, err
+}
@@ -0,0 +1,237 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tsuru/tsuru/cmd"
+)
+
+func TestConfigTTL(t *testing.T) {
+	var tests = []struct {
+		value string
+		ttl   time.Duration
+	}{
+		{"", defaultConfigTTL},
+		{"10m", 10 * time.Minute},
+		{"0", 0},
+		{"invalid", defaultConfigTTL},
+		{"-1h", defaultConfigTTL},
+	}
+	defer os.Unsetenv("TRANOR_CONFIG_TTL")
+	for _, test := range tests {
+		os.Setenv("TRANOR_CONFIG_TTL", test.value)
+		if ttl := configTTL(); ttl != test.ttl {
+			t.Errorf("wrong TTL for %q. Want %s. Got %s", test.value, test.ttl, ttl)
+		}
+	}
+}
+
+func TestLoadConfigFileRefreshesStaleConfig(t *testing.T) {
+	var ifNoneMatch string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifNoneMatch = r.Header.Get("If-None-Match")
+		w.Header().Set("ETag", `"v2"`)
+		w.Write([]byte(`{"target":"http://tsuru.example.com","envs":[{"name":"dev","dnsSuffix":"dev.example.com"}]}`))
+	}))
+	defer server.Close()
+	cleanup := setupStaleTarget(t, server.URL)
+	defer cleanup()
+	config, err := loadConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Environments) != 0 {
+		t.Errorf("loadConfigFile should return the cached copy, got %#v", config.Environments)
+	}
+	refreshed, err := waitConfigRefresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed == nil || len(refreshed.Environments) != 1 || refreshed.ETag != `"v2"` {
+		t.Fatalf("wrong refreshed config: %#v", refreshed)
+	}
+	if ifNoneMatch != `"v1"` {
+		t.Errorf("wrong If-None-Match header. Want %q. Got %q", `"v1"`, ifNoneMatch)
+	}
+	config, err = loadTargetConfig(defaultTargetName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Environments) != 1 || config.Source != server.URL {
+		t.Errorf("refreshed config not stored: %#v", config)
+	}
+}
+
+func TestLoadConfigFileRefreshesOverriddenTarget(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"target":"http://tsuru.customer.example.com","envs":[]}`))
+	}))
+	defer server.Close()
+	cleanup := setupStaleTarget(t, "")
+	defer cleanup()
+	defer os.Unsetenv("TRANOR_TARGET")
+	defer os.Unsetenv("TSURU_TARGET")
+	err := os.MkdirAll(cmd.JoinWithUserDir(".tsuru"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.WriteTarget("http://tsuru.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeTargetConfig("customer", &Config{Source: server.URL, Target: "http://tsuru.old.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := time.Now().Add(-2 * defaultConfigTTL)
+	err = os.Chtimes(configFilePath("customer"), updated, updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = useTarget("customer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = waitConfigRefresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadTargetConfig("customer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Target != "http://tsuru.customer.example.com" {
+		t.Errorf("refreshed config not stored: %#v", config)
+	}
+	os.Unsetenv("TSURU_TARGET")
+	target, err := cmd.ReadTarget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "http://tsuru.example.com" {
+		t.Errorf("the refresh of an overridden target changed the tsuru target to %q", target)
+	}
+}
+
+func TestLoadConfigFileNotModified(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(`{"target":"http://tsuru.example.com","envs":[]}`))
+	}))
+	defer server.Close()
+	cleanup := setupStaleTarget(t, server.URL)
+	defer cleanup()
+	config, err := loadConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed, err := waitConfigRefresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed != nil {
+		t.Errorf("unexpected refreshed config: %#v", refreshed)
+	}
+	if configStale(defaultTargetName, config) {
+		t.Error("config should be marked as fresh")
+	}
+}
+
+func TestLoadConfigFileFreshConfig(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+	cleanup := setupStaleTarget(t, server.URL)
+	defer cleanup()
+	now := time.Now()
+	err := os.Chtimes(configFilePath(defaultTargetName), now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitConfigRefresh()
+	if requests != 0 {
+		t.Errorf("fresh config should not be refreshed, got %d requests", requests)
+	}
+}
+
+func TestEnvListRunStaleConfig(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	cleanup := setupStaleTarget(t, server.URL)
+	defer cleanup()
+	var stdout, stderr bytes.Buffer
+	err := (&envList{}).Run(&cmd.Context{Stdout: &stdout, Stderr: &stderr}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stderr.String(), "Warning: the local configuration is stale (last updated at ") {
+		t.Errorf("missing stale warning in stderr: %q", stderr.String())
+	}
+}
+
+func setupStaleTarget(t *testing.T, server string) func() {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("HOME", dir)
+	os.Unsetenv("TRANOR_TARGET")
+	os.Unsetenv("TRANOR_CONFIG_TTL")
+	err = writeTargetConfig(defaultTargetName, &Config{
+		Source: server,
+		Target: "http://tsuru.example.com",
+		ETag:   `"v1"`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeCurrentTargetName(defaultTargetName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := time.Now().Add(-2 * defaultConfigTTL)
+	err = os.Chtimes(configFilePath(defaultTargetName), updated, updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resetConfigRefresh()
+	return func() {
+		waitConfigRefresh()
+		resetConfigRefresh()
+		os.RemoveAll(dir)
+	}
+}
+
+func resetConfigRefresh() {
+	configRefresh.Lock()
+	defer configRefresh.Unlock()
+	configRefresh.started = false
+	configRefresh.config = nil
+	configRefresh.err = nil
+}
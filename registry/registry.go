@@ -0,0 +1,467 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package registry provides a client of the subset of the Docker registry
+// HTTP API v2 used by tranor to copy images between registries.
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Media types of the image manifests supported by the client.
+const (
+	MediaTypeManifest    = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+)
+
+// Reference is a reference to a tagged image in a registry.
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+}
+
+// ParseReference parses an image reference in the form
+// registry/repository:tag. The registry is required.
+func ParseReference(image string) (Reference, error) {
+	var ref Reference
+	slash := strings.Index(image, "/")
+	if slash < 1 {
+		return ref, fmt.Errorf("invalid image %q: the registry is missing", image)
+	}
+	ref.Registry = image[:slash]
+	name := image[slash+1:]
+	colon := strings.LastIndex(name, ":")
+	if colon < 0 || strings.Contains(name[colon:], "/") {
+		return ref, fmt.Errorf("invalid image %q: the tag is missing", image)
+	}
+	ref.Repository, ref.Tag = name[:colon], name[colon+1:]
+	if ref.Repository == "" || ref.Tag == "" {
+		return ref, fmt.Errorf("invalid image %q", image)
+	}
+	return ref, nil
+}
+
+func (r Reference) String() string {
+	return r.Registry + "/" + r.Repository + ":" + r.Tag
+}
+
+// Error is returned when a registry responds with an error status code.
+type Error struct {
+	Registry   string
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("registry %s responded with status %d: %s", e.Registry, e.StatusCode, e.Message)
+}
+
+// Client is a client of Docker registries, supporting the basic and token
+// authentication schemes. Registries in the loopback interface use plain HTTP.
+type Client struct {
+	// HTTPClient is the client used to send the requests. When nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Credentials returns the credentials of the given registry, or empty
+	// strings for anonymous access. It may be nil.
+	Credentials func(registry string) (username, password string)
+
+	mu    sync.Mutex
+	auths map[string]string
+}
+
+// descriptor describes a blob referenced in an image manifest.
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type manifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    descriptor   `json:"config"`
+	Layers    []descriptor `json:"layers"`
+}
+
+// Copy copies the image src to dst, returning the digest of its manifest. The
+// manifest is only pushed after all of its blobs.
+func (c *Client) Copy(ctx context.Context, src, dst Reference) (string, error) {
+	data, mediaType, digest, err := c.getManifest(ctx, src)
+	if err != nil {
+		return "", err
+	}
+	if mediaType != MediaTypeManifest && mediaType != MediaTypeOCIManifest {
+		return "", fmt.Errorf("cannot copy image %s: unsupported manifest type %q", src, mediaType)
+	}
+	var m manifest
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return "", fmt.Errorf("invalid manifest of image %s: %s", src, err)
+	}
+	for _, blob := range append([]descriptor{m.Config}, m.Layers...) {
+		err = c.copyBlob(ctx, src, dst, blob)
+		if err != nil {
+			return "", err
+		}
+	}
+	pushed, err := c.putManifest(ctx, dst, mediaType, data)
+	if err != nil {
+		return "", err
+	}
+	if pushed != "" && pushed != digest {
+		return "", fmt.Errorf("the digest of image %s (%s) doesn't match the digest of image %s (%s)", dst, pushed, src, digest)
+	}
+	return digest, nil
+}
+
+// Digest returns the digest of the manifest of the image.
+func (c *Client) Digest(ctx context.Context, ref Reference) (string, error) {
+	_, _, digest, err := c.getManifest(ctx, ref)
+	return digest, err
+}
+
+// getManifest returns the manifest of the image, its media type and its
+// verified digest.
+func (c *Client) getManifest(ctx context.Context, ref Reference) ([]byte, string, string, error) {
+	header := http.Header{"Accept": {MediaTypeManifest, MediaTypeOCIManifest}}
+	resp, err := c.do(ctx, ref, "pull", request{method: http.MethodGet, path: "/manifests/" + ref.Tag, header: header})
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", "", err
+	}
+	digest := sha256Digest(data)
+	if reported := resp.Header.Get("Docker-Content-Digest"); reported != "" && reported != digest {
+		return nil, "", "", fmt.Errorf("the manifest of image %s doesn't match its digest (got %s, want %s)", ref, digest, reported)
+	}
+	mediaType := resp.Header.Get("Content-Type")
+	if i := strings.Index(mediaType, ";"); i > -1 {
+		mediaType = mediaType[:i]
+	}
+	return data, mediaType, digest, nil
+}
+
+func (c *Client) putManifest(ctx context.Context, ref Reference, mediaType string, data []byte) (string, error) {
+	header := http.Header{"Content-Type": {mediaType}}
+	resp, err := c.do(ctx, ref, "pull,push", request{method: http.MethodPut, path: "/manifests/" + ref.Tag, header: header, body: data})
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("Docker-Content-Digest"), nil
+}
+
+// copyBlob streams the blob from the repository of src to the repository of
+// dst, unless dst already has it, verifying its digest.
+func (c *Client) copyBlob(ctx context.Context, src, dst Reference, blob descriptor) error {
+	if !strings.HasPrefix(blob.Digest, "sha256:") {
+		return fmt.Errorf("cannot copy blob %q: unsupported digest algorithm", blob.Digest)
+	}
+	resp, err := c.do(ctx, dst, "pull,push", request{method: http.MethodHead, path: "/blobs/" + blob.Digest})
+	if err == nil {
+		resp.Body.Close()
+		return nil
+	}
+	if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusNotFound {
+		return err
+	}
+	resp, err = c.do(ctx, dst, "pull,push", request{method: http.MethodPost, path: "/blobs/uploads/"})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	location, err := c.resolve(dst, resp.Header.Get("Location"))
+	if err != nil {
+		return err
+	}
+	q := location.Query()
+	q.Set("digest", blob.Digest)
+	location.RawQuery = q.Encode()
+	source, err := c.do(ctx, src, "pull", request{method: http.MethodGet, path: "/blobs/" + blob.Digest})
+	if err != nil {
+		return err
+	}
+	defer source.Body.Close()
+	h := sha256.New()
+	upload := request{
+		method: http.MethodPut,
+		url:    location.String(),
+		header: http.Header{"Content-Type": {"application/octet-stream"}},
+		stream: io.TeeReader(source.Body, h),
+		length: blob.Size,
+	}
+	resp, err = c.do(ctx, dst, "pull,push", upload)
+	// the registry of dst also rejects the blob when it doesn't match its
+	// digest, but the error doesn't say that the source is the culprit.
+	if digest := hashDigest(h); digest != blob.Digest {
+		if err == nil {
+			resp.Body.Close()
+		}
+		return fmt.Errorf("blob %s of image %s doesn't match its digest (got %s)", blob.Digest, src, digest)
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// request is a request to the API of a repository. Requests with a stream
+// cannot be sent again after authenticating.
+type request struct {
+	method string
+	path   string
+	url    string
+	header http.Header
+	body   []byte
+	stream io.Reader
+	length int64
+}
+
+// do sends the request, authenticating with the given actions when required.
+// The caller must close the body of the response.
+func (c *Client) do(ctx context.Context, ref Reference, actions string, r request) (*http.Response, error) {
+	scope := "repository:" + ref.Repository + ":" + actions
+	resp, err := c.send(ctx, ref, scope, r)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && r.stream == nil {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		err = c.authenticate(ctx, ref.Registry, scope, challenge)
+		if err != nil {
+			return nil, err
+		}
+		resp, err = c.send(ctx, ref, scope, r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		message := strings.TrimSpace(string(data))
+		if message == "" {
+			message = http.StatusText(resp.StatusCode)
+		}
+		return nil, &Error{Registry: ref.Registry, StatusCode: resp.StatusCode, Message: message}
+	}
+	return resp, nil
+}
+
+func (c *Client) send(ctx context.Context, ref Reference, scope string, r request) (*http.Response, error) {
+	reqURL := r.url
+	if reqURL == "" {
+		reqURL = baseURL(ref.Registry) + "/v2/" + ref.Repository + r.path
+	}
+	var body io.Reader
+	if r.body != nil {
+		body = bytes.NewReader(r.body)
+	} else if r.stream != nil {
+		body = r.stream
+	}
+	req, err := http.NewRequest(r.method, reqURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if r.stream != nil {
+		req.ContentLength = r.length
+	}
+	for name, values := range r.header {
+		req.Header[name] = values
+	}
+	if auth := c.authorization(ref.Registry, scope); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	return c.httpClient().Do(req.WithContext(ctx))
+}
+
+// authenticate answers the authentication challenge of the registry,
+// storing the authorization used in the next requests with the given scope.
+func (c *Client) authenticate(ctx context.Context, registry, scope, challenge string) error {
+	var username, password string
+	if c.Credentials != nil {
+		username, password = c.Credentials(registry)
+	}
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if username == "" {
+			return fmt.Errorf("registry %s requires authentication, please log in with docker login", registry)
+		}
+		basic := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		c.setAuthorization(registry, "", "Basic "+basic)
+		return nil
+	case "bearer":
+		token, err := c.token(ctx, params, scope, username, password)
+		if err != nil {
+			return fmt.Errorf("unable to authenticate in registry %s: %s", registry, err)
+		}
+		c.setAuthorization(registry, scope, "Bearer "+token)
+		return nil
+	}
+	return fmt.Errorf("registry %s requires an unsupported authentication: %q", registry, challenge)
+}
+
+// token requests a token from the authorization service given in the
+// challenge of the registry.
+func (c *Client) token(ctx context.Context, params map[string]string, scope, username, password string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", errors.New("invalid authentication realm")
+	}
+	q := realm.Query()
+	if service := params["service"]; service != "" {
+		q.Set("service", service)
+	}
+	q.Set("scope", scope)
+	realm.RawQuery = q.Encode()
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := c.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("the authorization service responded with status %d", resp.StatusCode)
+	}
+	var result struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return "", err
+	}
+	if result.Token == "" {
+		result.Token = result.AccessToken
+	}
+	if result.Token == "" {
+		return "", errors.New("the authorization service didn't return a token")
+	}
+	return result.Token, nil
+}
+
+// authorization returns the Authorization header of requests to the registry
+// with the given scope.
+func (c *Client) authorization(registry, scope string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if auth, ok := c.auths[registry+" "+scope]; ok {
+		return auth
+	}
+	return c.auths[registry+" "]
+}
+
+func (c *Client) setAuthorization(registry, scope, auth string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.auths == nil {
+		c.auths = make(map[string]string)
+	}
+	c.auths[registry+" "+scope] = auth
+}
+
+func (c *Client) resolve(ref Reference, location string) (*url.URL, error) {
+	if location == "" {
+		return nil, fmt.Errorf("registry %s didn't return the location of the upload", ref.Registry)
+	}
+	base, err := url.Parse(baseURL(ref.Registry) + "/")
+	if err != nil {
+		return nil, err
+	}
+	return base.Parse(location)
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// baseURL returns the URL of the registry, using plain HTTP in the loopback
+// interface, like the Docker daemon.
+func baseURL(registry string) string {
+	host := registry
+	if h, _, err := net.SplitHostPort(registry); err == nil {
+		host = h
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return "http://" + registry
+	}
+	return "https://" + registry
+}
+
+// parseChallenge parses the value of the WWW-Authenticate header, like
+// `Bearer realm="https://auth.example.com/token",service="registry"`.
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := make(map[string]string)
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	if len(parts) < 2 {
+		return parts[0], params
+	}
+	rest := parts[1]
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(rest[:eq])
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.Index(rest, ","); comma > -1 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[strings.ToLower(key)] = value
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return parts[0], params
+}
+
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func hashDigest(h hash.Hash) string {
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
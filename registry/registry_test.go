@@ -0,0 +1,183 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package registry_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ef-ctx/tsuru-flow/registry"
+	"github.com/ef-ctx/tsuru-flow/registry/registrytest"
+)
+
+func TestParseReference(t *testing.T) {
+	var tests = []struct {
+		image       string
+		expectedRef registry.Reference
+		expectedErr string
+	}{
+		{
+			"registry.example.com/tsuru/app-myapp:v10",
+			registry.Reference{Registry: "registry.example.com", Repository: "tsuru/app-myapp", Tag: "v10"},
+			"",
+		},
+		{
+			"localhost:5000/tsuru/app-myapp:v10",
+			registry.Reference{Registry: "localhost:5000", Repository: "tsuru/app-myapp", Tag: "v10"},
+			"",
+		},
+		{"tsuru-app-myapp:v10", registry.Reference{}, `invalid image "tsuru-app-myapp:v10": the registry is missing`},
+		{"localhost:5000/tsuru/app-myapp", registry.Reference{}, `invalid image "localhost:5000/tsuru/app-myapp": the tag is missing`},
+		{"registry.example.com/tsuru/app-myapp:", registry.Reference{}, `invalid image "registry.example.com/tsuru/app-myapp:"`},
+	}
+	for _, test := range tests {
+		ref, err := registry.ParseReference(test.image)
+		if test.expectedErr != "" {
+			if err == nil || err.Error() != test.expectedErr {
+				t.Errorf("wrong error for %q\nwant %q\ngot  %v", test.image, test.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", test.image, err)
+		}
+		if ref != test.expectedRef {
+			t.Errorf("wrong reference for %q\nwant %#v\ngot  %#v", test.image, test.expectedRef, ref)
+		}
+		if ref.String() != test.image {
+			t.Errorf("wrong string for %q: %q", test.image, ref.String())
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	src := registrytest.NewServer("", "")
+	defer src.Stop()
+	dst := registrytest.NewServer("", "")
+	defer dst.Stop()
+	digest := src.AddImage("tsuru/app-myapp-stage", "v3", []byte("layer 1"), []byte("layer 2"))
+	srcRef := registry.Reference{Registry: src.Addr(), Repository: "tsuru/app-myapp-stage", Tag: "v3"}
+	dstRef := registry.Reference{Registry: dst.Addr(), Repository: "tsuru/app-myapp-stage", Tag: "v3"}
+	var client registry.Client
+	copied, err := client.Copy(context.Background(), srcRef, dstRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != digest {
+		t.Errorf("wrong digest returned\nwant %q\ngot  %q", digest, copied)
+	}
+	if got := dst.Digest("tsuru/app-myapp-stage", "v3"); got != digest {
+		t.Errorf("wrong digest in the destination\nwant %q\ngot  %q", digest, got)
+	}
+	if pushed := dst.PushedBlobs(); pushed != 3 {
+		t.Errorf("wrong number of blobs pushed. Want 3. Got %d", pushed)
+	}
+	got, err := client.Digest(context.Background(), dstRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != digest {
+		t.Errorf("wrong digest\nwant %q\ngot  %q", digest, got)
+	}
+	// blobs that already exist in the destination are not pushed again.
+	src.AddImage("tsuru/app-myapp-stage", "v4", []byte("layer 1"), []byte("layer 3"))
+	srcRef.Tag, dstRef.Tag = "v4", "v4"
+	_, err = client.Copy(context.Background(), srcRef, dstRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pushed := dst.PushedBlobs(); pushed != 5 {
+		t.Errorf("wrong number of blobs pushed. Want 5. Got %d", pushed)
+	}
+}
+
+func TestCopyWithTokenAuthentication(t *testing.T) {
+	src := registrytest.NewServer("stage", "stage-secret")
+	defer src.Stop()
+	dst := registrytest.NewServer("prod", "prod-secret")
+	defer dst.Stop()
+	digest := src.AddImage("tsuru/app-myapp-stage", "v3", []byte("layer 1"))
+	var requested []string
+	client := registry.Client{
+		Credentials: func(r string) (string, string) {
+			requested = append(requested, r)
+			switch r {
+			case src.Addr():
+				return "stage", "stage-secret"
+			case dst.Addr():
+				return "prod", "prod-secret"
+			}
+			return "", ""
+		},
+	}
+	srcRef := registry.Reference{Registry: src.Addr(), Repository: "tsuru/app-myapp-stage", Tag: "v3"}
+	dstRef := registry.Reference{Registry: dst.Addr(), Repository: "tsuru/app-myapp-stage", Tag: "v3"}
+	copied, err := client.Copy(context.Background(), srcRef, dstRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != digest || dst.Digest("tsuru/app-myapp-stage", "v3") != digest {
+		t.Errorf("image not copied\nwant %q\ngot  %q", digest, dst.Digest("tsuru/app-myapp-stage", "v3"))
+	}
+	if len(requested) == 0 {
+		t.Error("credentials were never requested")
+	}
+}
+
+func TestCopyInvalidCredentials(t *testing.T) {
+	src := registrytest.NewServer("stage", "stage-secret")
+	defer src.Stop()
+	src.AddImage("tsuru/app-myapp-stage", "v3", []byte("layer 1"))
+	client := registry.Client{
+		Credentials: func(string) (string, string) {
+			return "stage", "wrong"
+		},
+	}
+	ref := registry.Reference{Registry: src.Addr(), Repository: "tsuru/app-myapp-stage", Tag: "v3"}
+	_, err := client.Digest(context.Background(), ref)
+	expected := "unable to authenticate in registry " + src.Addr() + ": the authorization service responded with status 401"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error\nwant %q\ngot  %v", expected, err)
+	}
+}
+
+func TestCopyCorruptedBlob(t *testing.T) {
+	src := registrytest.NewServer("", "")
+	defer src.Stop()
+	dst := registrytest.NewServer("", "")
+	defer dst.Stop()
+	layer := []byte("layer 1")
+	src.AddImage("tsuru/app-myapp-stage", "v3", layer)
+	sum := sha256.Sum256(layer)
+	layerDigest := "sha256:" + hex.EncodeToString(sum[:])
+	src.CorruptBlob(layerDigest)
+	var client registry.Client
+	srcRef := registry.Reference{Registry: src.Addr(), Repository: "tsuru/app-myapp-stage", Tag: "v3"}
+	dstRef := registry.Reference{Registry: dst.Addr(), Repository: "tsuru/app-myapp-stage", Tag: "v3"}
+	_, err := client.Copy(context.Background(), srcRef, dstRef)
+	expectedPrefix := "blob " + layerDigest + " of image " + srcRef.String() + " doesn't match its digest"
+	if err == nil || !strings.HasPrefix(err.Error(), expectedPrefix) {
+		t.Errorf("wrong error\nwant %q\ngot  %v", expectedPrefix, err)
+	}
+	if dst.Digest("tsuru/app-myapp-stage", "v3") != "" {
+		t.Error("the image should not be pushed to the destination")
+	}
+}
+
+func TestCopyNotFound(t *testing.T) {
+	src := registrytest.NewServer("", "")
+	defer src.Stop()
+	var client registry.Client
+	ref := registry.Reference{Registry: src.Addr(), Repository: "tsuru/app-myapp-stage", Tag: "v3"}
+	_, err := client.Copy(context.Background(), ref, ref)
+	expected := &registry.Error{Registry: src.Addr(), StatusCode: 404, Message: "manifest unknown"}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("wrong error\nwant %#v\ngot  %#v", expected, err)
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package registrytest provides a fake implementation of the Docker registry
+// API v2, used to test the registry client and the commands of tranor.
+package registrytest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ef-ctx/tsuru-flow/registry"
+	"github.com/gorilla/mux"
+)
+
+type manifest struct {
+	mediaType string
+	data      []byte
+}
+
+type blobRef struct {
+	Digest string `json:"digest"`
+}
+
+// Server is a fake Docker registry, listening in the loopback interface.
+type Server struct {
+	username  string
+	password  string
+	blobs     map[string]map[string][]byte
+	manifests map[string]map[string]manifest
+	uploads   map[string]string
+	uploadID  int
+	pushed    int
+	server    *httptest.Server
+	router    *mux.Router
+	mu        sync.Mutex
+}
+
+// NewServer starts a fake registry. When username is not empty, the registry
+// requires the token authentication.
+func NewServer(username, password string) *Server {
+	s := Server{
+		username:  username,
+		password:  password,
+		blobs:     make(map[string]map[string][]byte),
+		manifests: make(map[string]map[string]manifest),
+		uploads:   make(map[string]string),
+	}
+	s.buildRouter()
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.router.ServeHTTP(w, r)
+	}))
+	return &s
+}
+
+func (s *Server) buildRouter() {
+	s.router = mux.NewRouter()
+	s.router.HandleFunc("/token", s.token)
+	r := s.router.PathPrefix("/v2").Subrouter()
+	r.HandleFunc("/{repository:.+}/manifests/{reference}", s.authenticated(s.manifest))
+	r.HandleFunc("/{repository:.+}/blobs/uploads/", s.authenticated(s.startUpload))
+	r.HandleFunc("/{repository:.+}/blobs/uploads/{id}", s.authenticated(s.finishUpload))
+	r.HandleFunc("/{repository:.+}/blobs/{digest}", s.authenticated(s.blob))
+}
+
+// Addr returns the address of the registry.
+func (s *Server) Addr() string {
+	return strings.TrimPrefix(s.server.URL, "http://")
+}
+
+// Stop stops the server.
+func (s *Server) Stop() {
+	s.server.Close()
+}
+
+// AddImage stores an image with the given layers in the registry, returning
+// the digest of its manifest.
+func (s *Server) AddImage(repository, tag string, layers ...[]byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	config := []byte(fmt.Sprintf(`{"architecture":"amd64","os":"linux","tag":%q}`, tag))
+	m := map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     registry.MediaTypeManifest,
+		"config":        s.storeBlob(repository, "application/vnd.docker.container.image.v1+json", config),
+	}
+	descriptors := make([]interface{}, len(layers))
+	for i, layer := range layers {
+		descriptors[i] = s.storeBlob(repository, "application/vnd.docker.image.rootfs.diff.tar.gzip", layer)
+	}
+	m["layers"] = descriptors
+	data, _ := json.Marshal(m)
+	s.storeManifest(repository, tag, manifest{mediaType: registry.MediaTypeManifest, data: data})
+	return digest(data)
+}
+
+// Digest returns the digest of the manifest of the image, or an empty string
+// if the image doesn't exist.
+func (s *Server) Digest(repository, tag string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.manifests[repository][tag]
+	if !ok {
+		return ""
+	}
+	return digest(m.data)
+}
+
+// CorruptBlob changes the content of the blob, so it no longer matches its
+// digest.
+func (s *Server) CorruptBlob(blobDigest string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, blobs := range s.blobs {
+		if data, ok := blobs[blobDigest]; ok && len(data) > 0 {
+			corrupted := append([]byte(nil), data...)
+			corrupted[0]++
+			blobs[blobDigest] = corrupted
+		}
+	}
+}
+
+// PushedBlobs returns the number of blobs pushed to the registry.
+func (s *Server) PushedBlobs() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pushed
+}
+
+func (s *Server) storeBlob(repository, mediaType string, data []byte) map[string]interface{} {
+	if s.blobs[repository] == nil {
+		s.blobs[repository] = make(map[string][]byte)
+	}
+	d := digest(data)
+	s.blobs[repository][d] = data
+	return map[string]interface{}{"mediaType": mediaType, "digest": d, "size": len(data)}
+}
+
+func (s *Server) storeManifest(repository, tag string, m manifest) {
+	if s.manifests[repository] == nil {
+		s.manifests[repository] = make(map[string]manifest)
+	}
+	s.manifests[repository][tag] = m
+	s.manifests[repository][digest(m.data)] = m
+}
+
+func (s *Server) authenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.username == "" {
+			handler(w, r)
+			return
+		}
+		repository := mux.Vars(r)["repository"]
+		if r.Header.Get("Authorization") != "Bearer "+s.tokenFor(repository) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registrytest",scope="repository:%s:pull"`, s.server.URL, repository))
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *Server) tokenFor(repository string) string {
+	return "token-" + repository
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	if !ok || username != s.username || password != s.password {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	parts := strings.Split(r.URL.Query().Get("scope"), ":")
+	if len(parts) != 3 || parts[0] != "repository" || r.URL.Query().Get("service") != "registrytest" {
+		http.Error(w, "invalid scope", http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"token": s.tokenFor(parts[1])})
+}
+
+func (s *Server) manifest(w http.ResponseWriter, r *http.Request) {
+	repository, reference := mux.Vars(r)["repository"], mux.Vars(r)["reference"]
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		m, ok := s.manifests[repository][reference]
+		if !ok {
+			http.Error(w, "manifest unknown", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Docker-Content-Digest", digest(m.data))
+		w.Header().Set("Content-Length", strconv.Itoa(len(m.data)))
+		if r.Method == http.MethodGet {
+			w.Write(m.data)
+		}
+	case http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		var m struct {
+			Config blobRef   `json:"config"`
+			Layers []blobRef `json:"layers"`
+		}
+		if err := json.Unmarshal(data, &m); err != nil {
+			http.Error(w, "manifest invalid", http.StatusBadRequest)
+			return
+		}
+		for _, blob := range append([]blobRef{m.Config}, m.Layers...) {
+			if _, ok := s.blobs[repository][blob.Digest]; !ok {
+				http.Error(w, "blob unknown: "+blob.Digest, http.StatusBadRequest)
+				return
+			}
+		}
+		s.storeManifest(repository, reference, manifest{mediaType: r.Header.Get("Content-Type"), data: data})
+		w.Header().Set("Docker-Content-Digest", digest(data))
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) blob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	data, ok := s.blobs[mux.Vars(r)["repository"]][mux.Vars(r)["digest"]]
+	if !ok {
+		http.Error(w, "blob unknown", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+func (s *Server) startUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	repository := mux.Vars(r)["repository"]
+	s.uploadID++
+	id := strconv.Itoa(s.uploadID)
+	s.uploads[id] = repository
+	w.Header().Set("Location", "/v2/"+repository+"/blobs/uploads/"+id)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) finishUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	repository, id := mux.Vars(r)["repository"], mux.Vars(r)["id"]
+	if s.uploads[id] != repository {
+		http.Error(w, "blob upload unknown", http.StatusNotFound)
+		return
+	}
+	delete(s.uploads, id)
+	data, _ := ioutil.ReadAll(r.Body)
+	expected := r.URL.Query().Get("digest")
+	if digest(data) != expected {
+		http.Error(w, "digest invalid", http.StatusBadRequest)
+		return
+	}
+	if s.blobs[repository] == nil {
+		s.blobs[repository] = make(map[string][]byte)
+	}
+	s.blobs[repository][expected] = data
+	s.pushed++
+	w.Header().Set("Docker-Content-Digest", expected)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+	"github.com/tsuru/gnuflag"
+)
+
+// rolloutPollInterval is the time between two checks of a deploy.
+var rolloutPollInterval = 5 * time.Second
+
+var healthCheckClient = &http.Client{Timeout: 10 * time.Second}
+
+// rolloutCheck waits for the units of the app to start and, optionally, for
+// the health check and the smoke test to pass after a deploy.
+type rolloutCheck struct {
+	timeout     time.Duration
+	healthCheck string
+	smokeTest   string
+	rollback    bool
+}
+
+func (r *rolloutCheck) addFlags(fs *gnuflag.FlagSet) {
+	fs.DurationVar(&r.timeout, "wait", 0, "after the deploy, wait up to the given time (like 5m) for all units to start, failing if they don't")
+	fs.StringVar(&r.healthCheck, "health-check", "", "path requested in the address of the project after its units start, failing if it doesn't respond successfully (requires --wait)")
+	fs.StringVar(&r.smokeTest, "smoke-test", "", "shell command run after the units start, failing if it exits with non-zero status (requires --wait)")
+	fs.BoolVar(&r.rollback, "auto-rollback", false, "roll back to the previous successful deploy when the deploy fails the checks (requires --wait)")
+}
+
+func (r *rolloutCheck) validate() error {
+	if r.timeout < 0 {
+		return errors.New("the value of --wait can't be negative")
+	}
+	if (r.healthCheck != "" || r.smokeTest != "" || r.rollback) && r.timeout == 0 {
+		return errors.New("please provide the time to wait for the checks with --wait")
+	}
+	return nil
+}
+
+func (r *rolloutCheck) enabled() bool {
+	return r.timeout > 0
+}
+
+// wait blocks until the deploy rolls out, or fails with the last failed check
+// after the timeout.
+func (r *rolloutCheck) wait(w io.Writer, client *tsuru.Client, a app) error {
+	if !r.enabled() {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(requestContext, r.timeout)
+	defer cancel()
+	fmt.Fprintf(w, "waiting for the units of %q to start...\n", a.Name)
+	err := poll(ctx, func() error {
+		updated, err := client.GetApp(ctx, a.Name)
+		if err != nil {
+			return err
+		}
+		return unitsStarted(updated.Units)
+	})
+	if err != nil {
+		return fmt.Errorf("the deploy to %q didn't roll out in %s: %s", a.Env.Name, r.timeout, err)
+	}
+	if r.healthCheck == "" {
+		fmt.Fprintln(w, "all units started")
+	} else {
+		url := "http://" + a.Addr + "/" + strings.TrimPrefix(r.healthCheck, "/")
+		fmt.Fprintf(w, "all units started, checking the health of %s...\n", url)
+		err = poll(ctx, func() error {
+			return healthCheck(ctx, url)
+		})
+		if err != nil {
+			return fmt.Errorf("the deploy to %q didn't pass the health check in %s: %s", a.Env.Name, r.timeout, err)
+		}
+		fmt.Fprintln(w, "health check passed")
+	}
+	if r.smokeTest == "" {
+		return nil
+	}
+	fmt.Fprintln(w, "running the smoke test...")
+	err = r.runSmokeTest(ctx, w, a)
+	if ctx.Err() != nil {
+		return fmt.Errorf("the deploy to %q didn't pass the smoke test in %s", a.Env.Name, r.timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("the deploy to %q didn't pass the smoke test: %s", a.Env.Name, err)
+	}
+	fmt.Fprintln(w, "smoke test passed")
+	return nil
+}
+
+// runSmokeTest runs the smoke-test command in the shell, with TRANOR_APP,
+// TRANOR_ENV and TRANOR_ADDR set.
+func (r *rolloutCheck) runSmokeTest(ctx context.Context, w io.Writer, a app) error {
+	c := exec.CommandContext(ctx, "/bin/sh", "-c", r.smokeTest)
+	c.Env = append(os.Environ(), "TRANOR_APP="+a.Name, "TRANOR_ENV="+a.Env.Name, "TRANOR_ADDR="+a.Addr)
+	c.Stdout = w
+	c.Stderr = w
+	return c.Run()
+}
+
+// poll calls check until it succeeds or the context is done, returning the
+// last error of check.
+func poll(ctx context.Context, check func() error) error {
+	var last error
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() == nil || last == nil {
+			last = err
+		}
+		select {
+		case <-ctx.Done():
+			return last
+		case <-time.After(rolloutPollInterval):
+		}
+	}
+}
+
+func unitsStarted(units []tsuru.Unit) error {
+	if len(units) == 0 {
+		return errors.New("the app has no units")
+	}
+	var pending []string
+	for _, u := range units {
+		if u.Status != tsuru.UnitStarted {
+			pending = append(pending, fmt.Sprintf("%s is %q", u.ID, u.Status))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d of %d unit(s) not started (%s)", len(pending), len(units), strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// healthCheck fails when the URL responds with a status of 400 or higher.
+func healthCheck(ctx context.Context, url string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := healthCheckClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s responded with status %d", url, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+// rolloutServer serves the app myapp, with the units in the given sequence of
+// states, and the health check of the app, with the given sequence of status
+// codes. The last element of each sequence is repeated in the subsequent
+// requests.
+type rolloutServer struct {
+	units  [][]tsuru.Unit
+	health []int
+	mu     sync.Mutex
+}
+
+func (s *rolloutServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.URL.Path {
+	case "/1.0/apps/myapp":
+		json.NewEncoder(w).Encode(tsuru.App{Name: "myapp", Units: s.units[0]})
+		if len(s.units) > 1 {
+			s.units = s.units[1:]
+		}
+	case "/healthcheck":
+		w.WriteHeader(s.health[0])
+		if len(s.health) > 1 {
+			s.health = s.health[1:]
+		}
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func TestRolloutCheckWait(t *testing.T) {
+	oldInterval := rolloutPollInterval
+	rolloutPollInterval = time.Millisecond
+	defer func() { rolloutPollInterval = oldInterval }()
+	var tests = []struct {
+		testCase       string
+		check          rolloutCheck
+		units          [][]tsuru.Unit
+		health         []int
+		expectedErr    string
+		expectedOutput string
+	}{
+		{
+			"units starting",
+			rolloutCheck{timeout: time.Second},
+			[][]tsuru.Unit{
+				{{ID: "u1", Status: "building"}},
+				{{ID: "u1", Status: "starting"}, {ID: "u2", Status: "starting"}},
+				{{ID: "u1", Status: "started"}, {ID: "u2", Status: "started"}},
+			},
+			nil,
+			"",
+			"waiting for the units of \"myapp\" to start...\nall units started\n",
+		},
+		{
+			"health check",
+			rolloutCheck{timeout: time.Second, healthCheck: "healthcheck"},
+			[][]tsuru.Unit{{{ID: "u1", Status: "started"}}},
+			[]int{http.StatusServiceUnavailable, http.StatusOK},
+			"",
+			"waiting for the units of \"myapp\" to start...\nall units started, checking the health of http://{addr}/healthcheck...\nhealth check passed\n",
+		},
+		{
+			"units not started",
+			rolloutCheck{timeout: 50 * time.Millisecond, healthCheck: "/healthcheck"},
+			[][]tsuru.Unit{{{ID: "u1", Status: "started"}, {ID: "u2", Status: "error"}}},
+			nil,
+			`the deploy to "dev" didn't roll out in 50ms: 1 of 2 unit(s) not started (u2 is "error")`,
+			"waiting for the units of \"myapp\" to start...\n",
+		},
+		{
+			"no units",
+			rolloutCheck{timeout: 50 * time.Millisecond},
+			[][]tsuru.Unit{nil},
+			nil,
+			`the deploy to "dev" didn't roll out in 50ms: the app has no units`,
+			"waiting for the units of \"myapp\" to start...\n",
+		},
+		{
+			"health check failure",
+			rolloutCheck{timeout: 50 * time.Millisecond, healthCheck: "/healthcheck"},
+			[][]tsuru.Unit{{{ID: "u1", Status: "started"}}},
+			[]int{http.StatusInternalServerError},
+			`the deploy to "dev" didn't pass the health check in 50ms: http://{addr}/healthcheck responded with status 500`,
+			"waiting for the units of \"myapp\" to start...\nall units started, checking the health of http://{addr}/healthcheck...\n",
+		},
+		{
+			"smoke test",
+			rolloutCheck{timeout: time.Second, smokeTest: "echo testing $TRANOR_APP in $TRANOR_ENV"},
+			[][]tsuru.Unit{{{ID: "u1", Status: "started"}}},
+			nil,
+			"",
+			"waiting for the units of \"myapp\" to start...\nall units started\nrunning the smoke test...\ntesting myapp in dev\nsmoke test passed\n",
+		},
+		{
+			"smoke test failure",
+			rolloutCheck{timeout: time.Second, smokeTest: "echo failed; exit 3"},
+			[][]tsuru.Unit{{{ID: "u1", Status: "started"}}},
+			nil,
+			`the deploy to "dev" didn't pass the smoke test: exit status 3`,
+			"waiting for the units of \"myapp\" to start...\nall units started\nrunning the smoke test...\nfailed\n",
+		},
+		{
+			"smoke test timeout",
+			rolloutCheck{timeout: 50 * time.Millisecond, smokeTest: "exec sleep 5"},
+			[][]tsuru.Unit{{{ID: "u1", Status: "started"}}},
+			nil,
+			`the deploy to "dev" didn't pass the smoke test in 50ms`,
+			"waiting for the units of \"myapp\" to start...\nall units started\nrunning the smoke test...\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.testCase, func(t *testing.T) {
+			server := httptest.NewServer(&rolloutServer{units: test.units, health: test.health})
+			defer server.Close()
+			cleanup, err := setupFakeConfig(server.URL, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cleanup()
+			addr := strings.TrimPrefix(server.URL, "http://")
+			a := app{App: tsuru.App{Name: "myapp"}, Env: Environment{Name: "dev"}, Addr: addr}
+			apiClient := testAPIClient(cmd.NewClient(http.DefaultClient, &cmd.Context{}, &cmd.Manager{}))
+			var stdout bytes.Buffer
+			err = test.check.wait(&stdout, apiClient, a)
+			if test.expectedErr == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if expectedErr := strings.Replace(test.expectedErr, "{addr}", addr, -1); test.expectedErr != "" && (err == nil || err.Error() != expectedErr) {
+				t.Errorf("wrong error\nwant %q\ngot  %v", expectedErr, err)
+			}
+			if expectedOutput := strings.Replace(test.expectedOutput, "{addr}", addr, -1); stdout.String() != expectedOutput {
+				t.Errorf("wrong output\nwant %q\ngot  %q", expectedOutput, stdout.String())
+			}
+		})
+	}
+}
+
+func TestRolloutCheckValidate(t *testing.T) {
+	var tests = []struct {
+		check  rolloutCheck
+		errMsg string
+	}{
+		{rolloutCheck{}, ""},
+		{rolloutCheck{timeout: time.Minute}, ""},
+		{rolloutCheck{timeout: time.Minute, healthCheck: "/healthcheck"}, ""},
+		{rolloutCheck{timeout: -time.Minute}, "the value of --wait can't be negative"},
+		{rolloutCheck{healthCheck: "/healthcheck"}, "please provide the time to wait for the checks with --wait"},
+		{rolloutCheck{smokeTest: "true"}, "please provide the time to wait for the checks with --wait"},
+		{rolloutCheck{rollback: true}, "please provide the time to wait for the checks with --wait"},
+	}
+	for _, test := range tests {
+		err := test.check.validate()
+		if test.errMsg == "" && err != nil {
+			t.Errorf("unexpected error for %#v: %s", test.check, err)
+		}
+		if test.errMsg != "" && (err == nil || err.Error() != test.errMsg) {
+			t.Errorf("wrong error for %#v\nwant %q\ngot  %v", test.check, test.errMsg, err)
+		}
+	}
+}
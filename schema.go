@@ -0,0 +1,151 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// configVersion is the current version of the configuration schema. Files
+// without a version are in version 1.
+const configVersion = 2
+
+// configMigrations[i] migrates a configuration from version i+1 to i+2.
+var configMigrations = []func(*Config){
+	// version 1 didn't normalize DNS suffixes, so a suffix like
+	// ".Example.com." was accepted.
+	func(c *Config) {
+		for i := range c.Environments {
+			c.Environments[i].DNSSuffix = strings.ToLower(strings.Trim(c.Environments[i].DNSSuffix, "."))
+		}
+	},
+}
+
+var (
+	envNameRegexp   = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+	dnsSuffixRegexp = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)*[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+)
+
+// configError is returned when the configuration doesn't match the schema. It
+// lists all problems found in the configuration.
+type configError struct {
+	problems []string
+}
+
+func (e *configError) add(format string, args ...interface{}) {
+	e.problems = append(e.problems, fmt.Sprintf(format, args...))
+}
+
+func (e *configError) Error() string {
+	return "invalid configuration:\n - " + strings.Join(e.problems, "\n - ")
+}
+
+func (c *Config) migrate() error {
+	if c.Version == 0 {
+		c.Version = 1
+	}
+	if c.Version > configVersion {
+		return fmt.Errorf("configuration version %d is not supported by this version of tranor (latest supported version is %d), please upgrade tranor", c.Version, configVersion)
+	}
+	for ; c.Version < configVersion; c.Version++ {
+		configMigrations[c.Version-1](c)
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	var errs configError
+	if c.Target == "" {
+		errs.add("the tsuru target is not defined")
+	}
+	if c.SigningKey != "" {
+		if _, err := decodePublicKey(c.SigningKey); err != nil {
+			errs.add("invalid signing key")
+		}
+	}
+	envs := make(map[string]bool, len(c.Environments))
+	suffixes := make(map[string]string, len(c.Environments))
+	for i, env := range c.Environments {
+		switch {
+		case env.Name == "":
+			errs.add("environment #%d: the name is not defined", i+1)
+		case !envNameRegexp.MatchString(env.Name):
+			errs.add("environment %q: invalid name, it must contain only lowercase letters, numbers and dashes", env.Name)
+		case envs[env.Name]:
+			errs.add("environment %q: defined more than once", env.Name)
+		}
+		envs[env.Name] = true
+		switch {
+		case env.DNSSuffix == "":
+			errs.add("environment %q: the DNS suffix is not defined", env.Name)
+		case !dnsSuffixRegexp.MatchString(env.DNSSuffix):
+			errs.add("environment %q: invalid DNS suffix %q", env.Name, env.DNSSuffix)
+		default:
+			if other, ok := suffixes[env.DNSSuffix]; ok {
+				errs.add("environment %q: the DNS suffix %q is already used by the environment %q", env.Name, env.DNSSuffix, other)
+			} else {
+				suffixes[env.DNSSuffix] = env.Name
+			}
+		}
+		env.validateConstraints(&errs)
+		validateFreezes(&errs, fmt.Sprintf("environment %q: ", env.Name), env.Freezes)
+	}
+	validateFreezes(&errs, "", c.Freezes)
+	for _, p := range c.Pipeline {
+		if !envs[p.From] || !envs[p.To] {
+			errs.add("pipeline: promotion from %q to %q references an undefined environment", p.From, p.To)
+		} else if p.From == p.To {
+			errs.add("pipeline: environment %q can't be promoted to itself", p.From)
+		}
+	}
+	if c.Naming != nil {
+		if _, err := newNamer(c.Naming); err != nil {
+			errs.problems = append(errs.problems, err.(*configError).problems...)
+		}
+	}
+	for _, a := range c.Approvers {
+		if a.Email == "" {
+			errs.add("approvers: the email is not defined")
+			continue
+		}
+		if _, err := decodePublicKey(a.PublicKey); err != nil {
+			errs.add("approver %q: invalid public key", a.Email)
+		}
+	}
+	if len(errs.problems) > 0 {
+		return &errs
+	}
+	return nil
+}
+
+func (e *Environment) validateConstraints(errs *configError) {
+	if e.DefaultPlan != "" && len(e.AllowedPlans) > 0 && !containsString(e.AllowedPlans, e.DefaultPlan) {
+		errs.add("environment %q: the default plan %q is not one of the allowed plans", e.Name, e.DefaultPlan)
+	}
+	if e.MinUnits < 0 || e.MaxUnits < 0 {
+		errs.add("environment %q: the number of units can't be negative", e.Name)
+	} else if e.MaxUnits > 0 && e.MinUnits > e.MaxUnits {
+		errs.add("environment %q: the minimum number of units is greater than the maximum", e.Name)
+	}
+	for _, name := range []string{"", "TRANOR_ENV_NAME"} {
+		if _, ok := e.EnvVars[name]; ok {
+			errs.add("environment %q: invalid environment variable name %q", e.Name, name)
+		}
+	}
+}
+
+func validateFreezes(errs *configError, prefix string, freezes []Freeze) {
+	for i, f := range freezes {
+		if f.Name == "" {
+			errs.add("%sfreeze #%d: the name is not defined", prefix, i+1)
+			continue
+		}
+		if _, err := f.window(); err != nil {
+			errs.add("%sfreeze %q: %s", prefix, f.Name, err)
+		}
+	}
+}
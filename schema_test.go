@@ -0,0 +1,188 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseConfigMigratesOldVersions(t *testing.T) {
+	config, err := parseConfig(strings.NewReader(`{
+		"target": "http://tsuru.example.com",
+		"envs": [
+			{"name": "dev", "dnsSuffix": ".Dev.Example.com."},
+			{"name": "prod", "dnsSuffix": "example.com"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Version != configVersion {
+		t.Errorf("wrong version. Want %d. Got %d", configVersion, config.Version)
+	}
+	if suffix := config.Environments[0].DNSSuffix; suffix != "dev.example.com" {
+		t.Errorf("wrong DNS suffix after migration. Want %q. Got %q", "dev.example.com", suffix)
+	}
+}
+
+func TestParseConfigUnsupportedVersion(t *testing.T) {
+	_, err := parseConfig(strings.NewReader(`{"version":3,"target":"http://tsuru.example.com","envs":[]}`))
+	expectedMsg := "configuration version 3 is not supported by this version of tranor (latest supported version is 2), please upgrade tranor"
+	if err == nil || err.Error() != expectedMsg {
+		t.Errorf("wrong error\nwant %q\ngot  %v", expectedMsg, err)
+	}
+}
+
+func TestParseConfigIgnoresUnknownFields(t *testing.T) {
+	config, err := parseConfig(strings.NewReader(`{
+		"version": 2,
+		"target": "http://tsuru.example.com",
+		"envs": [{"name": "prod", "dnsSuffix": "example.com", "maintenanceWindow": "Sun 02:00"}],
+		"auditLog": {"url": "https://audit.example.com"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Environments) != 1 || config.Environments[0].Name != "prod" {
+		t.Errorf("wrong environments: %#v", config.Environments)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	var tests = []struct {
+		testCase string
+		config   Config
+		problems []string
+	}{
+		{
+			"valid config",
+			Config{
+				Target: "http://tsuru.example.com",
+				Environments: []Environment{
+					{Name: "dev", DNSSuffix: "dev.example.com"},
+					{Name: "prod", DNSSuffix: "example.com"},
+				},
+				Pipeline: []Promotion{{From: "dev", To: "prod"}},
+			},
+			nil,
+		},
+		{
+			"missing target",
+			Config{},
+			[]string{"the tsuru target is not defined"},
+		},
+		{
+			"invalid envs",
+			Config{
+				Target: "http://tsuru.example.com",
+				Environments: []Environment{
+					{Name: "", DNSSuffix: "dev.example.com"},
+					{Name: "qa+1", DNSSuffix: "qa.example.com"},
+					{Name: "stage", DNSSuffix: ""},
+					{Name: "prod", DNSSuffix: "example..com"},
+					{Name: "prod", DNSSuffix: "prod.example.com"},
+					{Name: "demo", DNSSuffix: "dev.example.com"},
+				},
+			},
+			[]string{
+				"environment #1: the name is not defined",
+				`environment "qa+1": invalid name, it must contain only lowercase letters, numbers and dashes`,
+				`environment "stage": the DNS suffix is not defined`,
+				`environment "prod": invalid DNS suffix "example..com"`,
+				`environment "prod": defined more than once`,
+				`environment "demo": the DNS suffix "dev.example.com" is already used by the environment ""`,
+			},
+		},
+		{
+			"invalid pipeline",
+			Config{
+				Target:       "http://tsuru.example.com",
+				Environments: []Environment{{Name: "dev", DNSSuffix: "dev.example.com"}},
+				Pipeline:     []Promotion{{From: "dev", To: "prod"}, {From: "dev", To: "dev"}},
+			},
+			[]string{
+				`pipeline: promotion from "dev" to "prod" references an undefined environment`,
+				`pipeline: environment "dev" can't be promoted to itself`,
+			},
+		},
+		{
+			"invalid signing key",
+			Config{Target: "http://tsuru.example.com", SigningKey: "abc"},
+			[]string{"invalid signing key"},
+		},
+		{
+			"invalid env constraints",
+			Config{
+				Target: "http://tsuru.example.com",
+				Environments: []Environment{
+					{Name: "dev", DNSSuffix: "dev.example.com", DefaultPlan: "small", AllowedPlans: []string{"medium"}},
+					{Name: "qa", DNSSuffix: "qa.example.com", MinUnits: 3, MaxUnits: 2},
+					{Name: "prod", DNSSuffix: "example.com", MinUnits: -1, EnvVars: map[string]string{"TRANOR_ENV_NAME": "x"}},
+				},
+			},
+			[]string{
+				`environment "dev": the default plan "small" is not one of the allowed plans`,
+				`environment "qa": the minimum number of units is greater than the maximum`,
+				`environment "prod": the number of units can't be negative`,
+				`environment "prod": invalid environment variable name "TRANOR_ENV_NAME"`,
+			},
+		},
+		{
+			"invalid naming",
+			Config{
+				Target: "http://tsuru.example.com",
+				Naming: &Naming{App: "{{.Env}}", Pool: "{{.Project}}-{{.Env}}", CName: "{{.Project"},
+			},
+			[]string{
+				`naming: template: cname:1: unclosed action`,
+			},
+		},
+		{
+			"invalid approvers",
+			Config{
+				Target:    "http://tsuru.example.com",
+				Approvers: []Approver{{Email: "alice@example.com", PublicKey: "abc"}, {PublicKey: "abc"}},
+			},
+			[]string{
+				`approver "alice@example.com": invalid public key`,
+				"approvers: the email is not defined",
+			},
+		},
+		{
+			"invalid freezes",
+			Config{
+				Target: "http://tsuru.example.com",
+				Environments: []Environment{
+					{Name: "prod", DNSSuffix: "example.com", Freezes: []Freeze{{Name: "weekend", From: "Fri 16:00", To: "Mon"}}},
+				},
+				Freezes: []Freeze{
+					{Name: "holidays", From: "2018-12-22 00:00", To: "2019-01-02 08:00", Timezone: "Nowhere/City"},
+					{From: "Fri 16:00", To: "Mon 08:00"},
+				},
+			},
+			[]string{
+				`environment "prod": freeze "weekend": invalid end "Mon", use a weekday and a time (like "Mon 08:00") or a date and a time (like "2018-12-26 08:00")`,
+				`freeze "holidays": invalid time zone "Nowhere/City"`,
+				"freeze #2: the name is not defined",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.testCase, func(t *testing.T) {
+			err := test.config.validate()
+			if len(test.problems) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			expectedMsg := "invalid configuration:\n - " + strings.Join(test.problems, "\n - ")
+			if err == nil || err.Error() != expectedMsg {
+				t.Errorf("wrong error\nwant %q\ngot  %v", expectedMsg, err)
+			}
+		})
+	}
+}
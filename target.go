@@ -1,27 +1,71 @@
 package main
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 
+	"github.com/tsuru/gnuflag"
 	"github.com/tsuru/tsuru/cmd"
 )
 
-type targetSet struct{}
+const defaultTargetName = "default"
 
-func (targetSet) Info() *cmd.Info {
+var targetNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// validateTargetName ensures that the name of a target can be used as the
+// name of its directory in ~/.tranor/targets, rejecting names like ".." that
+// would resolve to other directories.
+func validateTargetName(name string) error {
+	if !targetNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid target name %q, it must start with a letter or a number and contain only letters, numbers, dots, dashes and underscores", name)
+	}
+	return nil
+}
+
+type targetSet struct {
+	fs              *gnuflag.FlagSet
+	publicKey       string
+	acceptKeyChange bool
+}
+
+func (c *targetSet) Info() *cmd.Info {
 	return &cmd.Info{
-		Name:    "target-set",
-		Usage:   "target-set <target>",
-		Desc:    "sets the remote tranor server",
+		Name:  "target-set",
+		Usage: "target-set <target> [name] [--public-key <key>] [--accept-key-change]",
+		Desc: `sets the remote tranor server
+
+The configuration downloaded from the server is stored under the given name
+(defaults to "default"), which becomes the current target. Use target-use to
+switch between targets.
+
+When a public key is provided, the server must publish the ed25519 signature
+of the configuration in config.json.sig and the key is pinned for the target:
+every later download of the configuration is verified with it, and changing
+the key requires the flag --accept-key-change.`,
 		MinArgs: 1,
+		MaxArgs: 2,
 	}
 }
 
-func (targetSet) Run(ctx *cmd.Context, _ *cmd.Client) error {
-	err := downloadConfiguration(ctx.Args[0])
+func (c *targetSet) Run(ctx *cmd.Context, _ *cmd.Client) error {
+	name := defaultTargetName
+	if len(ctx.Args) > 1 {
+		name = ctx.Args[1]
+	}
+	if err := validateTargetName(name); err != nil {
+		return err
+	}
+	err := downloadConfiguration(name, ctx.Args[0], c.publicKey, c.acceptKeyChange)
 	if err != nil {
 		return err
 	}
@@ -29,24 +73,295 @@ func (targetSet) Run(ctx *cmd.Context, _ *cmd.Client) error {
 	return nil
 }
 
-func downloadConfiguration(server string) error {
-	url := strings.TrimRight(server, "/") + "/config.json"
-	resp, err := http.Get(url)
+func (c *targetSet) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("target-set", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.publicKey, "public-key", "", "base64 encoded ed25519 public key used to verify the configuration")
+		c.fs.BoolVar(&c.acceptKeyChange, "accept-key-change", false, "accept a public key different from the one pinned for the target")
+	}
+	return c.fs
+}
+
+type targetList struct{}
+
+func (targetList) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "target-list",
+		Usage: "target-list",
+		Desc:  "lists the configured tranor targets, marking the current one with *",
+	}
+}
+
+func (targetList) Run(ctx *cmd.Context, _ *cmd.Client) error {
+	names, err := targetNames()
+	if err != nil {
+		return err
+	}
+	current := currentTargetName()
+	for _, name := range names {
+		prefix := "  "
+		if name == current {
+			prefix = "* "
+		}
+		line := prefix + name
+		if config, err := loadTargetConfig(name); err == nil {
+			line += fmt.Sprintf(" (%s)", config.Target)
+		}
+		fmt.Fprintln(ctx.Stdout, line)
+	}
+	return nil
+}
+
+type targetUse struct{}
+
+func (targetUse) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "target-use",
+		Usage:   "target-use <name>",
+		Desc:    "defines the current tranor target",
+		MinArgs: 1,
+		MaxArgs: 1,
+	}
+}
+
+func (targetUse) Run(ctx *cmd.Context, _ *cmd.Client) error {
+	name := ctx.Args[0]
+	if err := validateTargetName(name); err != nil {
+		return err
+	}
+	config, err := loadTargetConfig(name)
+	if err != nil {
+		return fmt.Errorf("target %q not found", name)
+	}
+	err = writeCurrentTargetName(name)
+	if err != nil {
+		return err
+	}
+	err = config.writeTarget(name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(ctx.Stdout, "Now using target %q (%s)\n", name, config.Target)
+	return nil
+}
+
+type targetRemove struct{}
+
+func (targetRemove) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "target-remove",
+		Usage:   "target-remove <name>",
+		Desc:    "removes the given tranor target",
+		MinArgs: 1,
+		MaxArgs: 1,
+	}
+}
+
+func (targetRemove) Run(ctx *cmd.Context, _ *cmd.Client) error {
+	name := ctx.Args[0]
+	if err := validateTargetName(name); err != nil {
+		return err
+	}
+	dir := filepath.Dir(configFilePath(name))
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("target %q not found", name)
+	}
+	err := os.RemoveAll(dir)
 	if err != nil {
 		return err
 	}
+	if name == currentTargetName() {
+		os.Remove(cmd.JoinWithUserDir(".tranor", "target"))
+	}
+	fmt.Fprintf(ctx.Stdout, "Target %q successfully removed!\n", name)
+	return nil
+}
+
+func targetNames() ([]string, error) {
+	infos, err := ioutil.ReadDir(cmd.JoinWithUserDir(".tranor", "targets"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if info.IsDir() {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// useTarget overrides the current tranor target for this invocation, also
+// pointing the tsuru client to the tsuru target of the given tranor target.
+func useTarget(name string) error {
+	if err := validateTargetName(name); err != nil {
+		return err
+	}
+	config, err := loadTargetConfig(name)
+	if err != nil {
+		return fmt.Errorf("target %q not found", name)
+	}
+	os.Setenv("TRANOR_TARGET", name)
+	os.Setenv("TSURU_TARGET", config.Target)
+	return nil
+}
+
+// extractTargetFlag removes the --target flag from the flags of the command
+// line, returning the remaining arguments and the value of the flag.
+// Arguments of the command, like in "envvar-set -n myproj -- OPTS=--target=x",
+// are kept.
+func extractTargetFlag(manager *cmd.Manager, args []string) ([]string, string, error) {
+	var (
+		target    string
+		isFlag    = flagArgs(manager, args)
+		remaining = make([]string, 0, len(args))
+	)
+	for i := 0; i < len(args); i++ {
+		switch {
+		case !isFlag[i]:
+			remaining = append(remaining, args[i])
+		case args[i] == "--target":
+			if i+1 >= len(args) {
+				return nil, "", errors.New("flag needs an argument: --target")
+			}
+			target = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--target="):
+			target = strings.TrimPrefix(args[i], "--target=")
+		default:
+			remaining = append(remaining, args[i])
+		}
+	}
+	return remaining, target, nil
+}
+
+// downloadConfiguration downloads the configuration from the given server and
+// stores it as the target with the given name. The configuration is verified
+// with the public key pinned for the target, if any. The given public key
+// replaces the pinned key only when acceptKeyChange is true.
+func downloadConfiguration(name, server, publicKey string, acceptKeyChange bool) error {
+	signingKey, err := pinnedKey(name, publicKey, acceptKeyChange)
+	if err != nil {
+		return err
+	}
+	config, err := fetchConfiguration(server, signingKey, nil)
+	if err != nil {
+		return err
+	}
+	err = writeTargetConfig(name, config)
+	if err != nil {
+		return err
+	}
+	err = writeCurrentTargetName(name)
+	if err != nil {
+		return err
+	}
+	return config.writeTarget(name)
+}
+
+// pinnedKey returns the key that must be used to verify the configuration of
+// the given target.
+func pinnedKey(name, publicKey string, acceptKeyChange bool) (string, error) {
+	if publicKey != "" {
+		if _, err := decodePublicKey(publicKey); err != nil {
+			return "", fmt.Errorf("invalid public key: %s", err)
+		}
+	}
+	current, err := loadTargetConfig(name)
+	if err != nil || current.SigningKey == "" {
+		return publicKey, nil
+	}
+	if publicKey == "" || publicKey == current.SigningKey {
+		return current.SigningKey, nil
+	}
+	if !acceptKeyChange {
+		return "", fmt.Errorf("the public key of the target %q has changed, use --accept-key-change to trust the new key", name)
+	}
+	return publicKey, nil
+}
+
+// fetchConfiguration downloads the configuration from the given server,
+// verifying it with the signing key, if any. When a cached copy is provided,
+// the request is conditional and fetchConfiguration returns nil if the
+// configuration didn't change.
+func fetchConfiguration(server, signingKey string, cached *Config) (*Config, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(server, "/")+"/config.json", nil)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+	resp, err := configClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
 	defer resp.Body.Close()
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
 	if resp.StatusCode != http.StatusOK {
-		data, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("failed to download config file: %d - %s", resp.StatusCode, data)
+		return nil, fmt.Errorf("failed to download config file: %d - %s", resp.StatusCode, data)
 	}
-	config, err := parseConfig(resp.Body)
+	if signingKey != "" {
+		err = verifyConfigSignature(server, signingKey, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	config, err := parseConfig(bytes.NewReader(data))
 	if err != nil {
-		return fmt.Errorf("invalid configuration returned by the remote target: %s", err)
+		return nil, fmt.Errorf("invalid configuration returned by the remote target: %s", err)
 	}
-	err = writeConfigFile(config)
+	config.Source = server
+	config.SigningKey = signingKey
+	config.ETag = resp.Header.Get("ETag")
+	config.LastModified = resp.Header.Get("Last-Modified")
+	return config, nil
+}
+
+func verifyConfigSignature(server, publicKey string, data []byte) error {
+	key, err := decodePublicKey(publicKey)
 	if err != nil {
 		return err
 	}
-	return config.writeTarget()
+	encodedSignature, err := fetchFile(server, "config.json.sig", "signature file")
+	if err != nil {
+		return err
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encodedSignature)))
+	if err != nil || !ed25519.Verify(key, data, signature) {
+		return errors.New("invalid signature in the configuration returned by the remote target")
+	}
+	return nil
+}
+
+func fetchFile(server, name, desc string) ([]byte, error) {
+	url := strings.TrimRight(server, "/") + "/" + name
+	resp, err := configClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %d - %s", desc, resp.StatusCode, data)
+	}
+	return data, nil
 }
@@ -2,21 +2,27 @@ package main
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/tsuru/tsuru/cmd"
 )
 
 func TestTargetSetIsACommand(t *testing.T) {
-	var _ cmd.Command = targetSet{}
+	var _ cmd.FlaggedCommand = &targetSet{}
 }
 
 func TestTargetSetInfo(t *testing.T) {
-	info := targetSet{}.Info()
+	info := (&targetSet{}).Info()
 	if info == nil {
 		t.Fatal("unexpected <nil> info")
 	}
@@ -51,14 +57,14 @@ func TestTargetSetRun(t *testing.T) {
 		Stdout: &stdout,
 		Stderr: &stderr,
 	}
-	err = targetSet{}.Run(&ctx, nil)
+	err = (&targetSet{}).Run(&ctx, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	expectedMsg := "Target successfully defined!\n"
 	expectedTarget := "mytarget"
-	expectedTargets := "tranor\tmytarget\n"
-	expectedConfig := `{"target":"mytarget","registry":"localhost:3030","envs":[]}` + "\n"
+	expectedTargets := "tranor-default\tmytarget\n"
+	expectedConfig := `{"version":2,"source":"` + server.URL + `","target":"mytarget","registry":"localhost:3030","envs":[]}` + "\n"
 	if stdout.String() != expectedMsg {
 		t.Errorf("wrong stdout msg.\nWant %q\nGot  %q", expectedMsg, stdout.String())
 	}
@@ -76,7 +82,7 @@ func TestTargetSetRun(t *testing.T) {
 	if string(targets) != expectedTargets {
 		t.Errorf("wrong targets file. Want %q. Got %q", expectedTargets, string(targets))
 	}
-	config, err := ioutil.ReadFile(cmd.JoinWithUserDir(".tranor", "config.json"))
+	config, err := ioutil.ReadFile(cmd.JoinWithUserDir(".tranor", "targets", "default", "config.json"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -102,7 +108,7 @@ func TestTargetSetRunFailure(t *testing.T) {
 		Stdout: &stdout,
 		Stderr: &stderr,
 	}
-	err = targetSet{}.Run(&ctx, nil)
+	err = (&targetSet{}).Run(&ctx, nil)
 	if err == nil {
 		t.Fatal("got unexpected <nil> error")
 	}
@@ -122,15 +128,15 @@ func TestDownloadConfiguration(t *testing.T) {
 	}
 	defer os.RemoveAll(dir)
 	os.Setenv("HOME", dir)
-	err = downloadConfiguration(server.URL)
+	err = downloadConfiguration("internal", server.URL, "", false)
 	if err != nil {
 		t.Error(err)
 	}
-	content, err := ioutil.ReadFile(cmd.JoinWithUserDir(".tranor", "config.json"))
+	content, err := ioutil.ReadFile(cmd.JoinWithUserDir(".tranor", "targets", "internal", "config.json"))
 	if err != nil {
 		t.Fatal(err)
 	}
-	config += "\n"
+	config = `{"version":2,"source":"` + server.URL + `",` + config[1:] + "\n"
 	if string(content) != config {
 		t.Errorf("wrong config written. Want %q. Got %q", config, string(content))
 	}
@@ -153,7 +159,7 @@ func TestDownloadConfigurationInvalidConfig(t *testing.T) {
 	}
 	defer os.RemoveAll(dir)
 	os.Setenv("HOME", dir)
-	err = downloadConfiguration(server.URL)
+	err = downloadConfiguration("default", server.URL, "", false)
 	expectedMsg := "invalid configuration returned by the remote target: invalid character 'i' looking for beginning of value"
 	if err.Error() != expectedMsg {
 		t.Errorf("invalid error message.\nWant %q\nGot %q", expectedMsg, err.Error())
@@ -172,7 +178,7 @@ func TestDownloadConfigurationHTTPError(t *testing.T) {
 	}
 	defer os.RemoveAll(dir)
 	os.Setenv("HOME", dir)
-	err = downloadConfiguration(server.URL)
+	err = downloadConfiguration("default", server.URL, "", false)
 	expectedMsg := "failed to download config file: 404 - something went wrong"
 	if err.Error() != expectedMsg {
 		t.Errorf("wrong error message\nWant %q\nGot  %q", expectedMsg, err.Error())
@@ -186,8 +192,439 @@ func TestDownloadConfigurationNetworkError(t *testing.T) {
 	}
 	defer os.RemoveAll(dir)
 	os.Setenv("HOME", dir)
-	err = downloadConfiguration("http://192.0.2.12:66000")
+	err = downloadConfiguration("default", "http://192.0.2.12:66000", "", false)
 	if err == nil {
 		t.Error("unexpected <nil> error")
 	}
 }
+
+func TestTargetSetRunWithName(t *testing.T) {
+	os.Unsetenv("TSURU_TARGET")
+	os.Unsetenv("TRANOR_TARGET")
+	server := newConfigServer(`{"target":"http://tsuru-internal.example.com","envs":[]}`)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("HOME", dir)
+	ctx := cmd.Context{Args: []string{server.URL, "internal"}, Stdout: ioutil.Discard}
+	err = (&targetSet{}).Run(&ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := currentTargetName(); name != "internal" {
+		t.Errorf("wrong current target. Want %q. Got %q", "internal", name)
+	}
+	config, err := loadConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Target != "http://tsuru-internal.example.com" {
+		t.Errorf("wrong config loaded: %#v", config)
+	}
+}
+
+func TestTargetListRun(t *testing.T) {
+	os.Unsetenv("TRANOR_TARGET")
+	cleanup := setupFakeTargets(t)
+	defer cleanup()
+	var stdout bytes.Buffer
+	err := targetList{}.Run(&cmd.Context{Stdout: &stdout}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `  customer (http://tsuru.example.com)
+* internal (http://tsuru-internal.example.com)
+`
+	if stdout.String() != expected {
+		t.Errorf("wrong output.\nWant %q\nGot  %q", expected, stdout.String())
+	}
+}
+
+func TestTargetListRunNoTargets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("HOME", dir)
+	var stdout bytes.Buffer
+	err = targetList{}.Run(&cmd.Context{Stdout: &stdout}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "" {
+		t.Errorf("unexpected output: %q", stdout.String())
+	}
+}
+
+func TestTargetUseRun(t *testing.T) {
+	os.Unsetenv("TSURU_TARGET")
+	os.Unsetenv("TRANOR_TARGET")
+	cleanup := setupFakeTargets(t)
+	defer cleanup()
+	var stdout bytes.Buffer
+	err := targetUse{}.Run(&cmd.Context{Args: []string{"customer"}, Stdout: &stdout}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMsg := "Now using target \"customer\" (http://tsuru.example.com)\n"
+	if stdout.String() != expectedMsg {
+		t.Errorf("wrong output.\nWant %q\nGot  %q", expectedMsg, stdout.String())
+	}
+	if name := currentTargetName(); name != "customer" {
+		t.Errorf("wrong current target. Want %q. Got %q", "customer", name)
+	}
+	target, err := cmd.ReadTarget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "http://tsuru.example.com" {
+		t.Errorf("wrong tsuru target. Want %q. Got %q", "http://tsuru.example.com", target)
+	}
+}
+
+func TestTargetUseRunNotFound(t *testing.T) {
+	cleanup := setupFakeTargets(t)
+	defer cleanup()
+	err := targetUse{}.Run(&cmd.Context{Args: []string{"staging"}, Stdout: ioutil.Discard}, nil)
+	if err == nil || err.Error() != `target "staging" not found` {
+		t.Errorf("wrong error: %v", err)
+	}
+}
+
+func TestTargetRemoveRun(t *testing.T) {
+	os.Unsetenv("TRANOR_TARGET")
+	cleanup := setupFakeTargets(t)
+	defer cleanup()
+	err := targetRemove{}.Run(&cmd.Context{Args: []string{"internal"}, Stdout: ioutil.Discard}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := targetNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "customer" {
+		t.Errorf("wrong targets after removal: %#v", names)
+	}
+	if name := currentTargetName(); name != "" {
+		t.Errorf("unexpected current target: %q", name)
+	}
+	err = targetRemove{}.Run(&cmd.Context{Args: []string{"internal"}, Stdout: ioutil.Discard}, nil)
+	if err == nil || err.Error() != `target "internal" not found` {
+		t.Errorf("wrong error: %v", err)
+	}
+}
+
+func TestTargetRemoveRunInvalidName(t *testing.T) {
+	os.Unsetenv("TRANOR_TARGET")
+	cleanup := setupFakeTargets(t)
+	defer cleanup()
+	for _, name := range []string{"..", "../..", ".", "", "customer/../..", "/tmp"} {
+		err := targetRemove{}.Run(&cmd.Context{Args: []string{name}, Stdout: ioutil.Discard}, nil)
+		if err == nil || !strings.HasPrefix(err.Error(), fmt.Sprintf("invalid target name %q", name)) {
+			t.Errorf("wrong error for %q: %v", name, err)
+		}
+	}
+	names, err := targetNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Errorf("targets should not be removed: %#v", names)
+	}
+}
+
+func TestValidateTargetName(t *testing.T) {
+	var tests = []struct {
+		name  string
+		valid bool
+	}{
+		{"default", true},
+		{"customer-2", true},
+		{"tsuru.example.com", true},
+		{"my_target", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{".hidden", false},
+		{"a/b", false},
+		{`a\b`, false},
+	}
+	for _, test := range tests {
+		err := validateTargetName(test.name)
+		if (err == nil) != test.valid {
+			t.Errorf("wrong result for %q. Want valid=%v. Got %v", test.name, test.valid, err)
+		}
+	}
+}
+
+func TestUseTarget(t *testing.T) {
+	cleanup := setupFakeTargets(t)
+	defer func() {
+		os.Unsetenv("TRANOR_TARGET")
+		os.Unsetenv("TSURU_TARGET")
+		cleanup()
+	}()
+	err := useTarget("customer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Target != "http://tsuru.example.com" {
+		t.Errorf("wrong config loaded: %#v", config)
+	}
+	if target, _ := cmd.ReadTarget(); target != "http://tsuru.example.com" {
+		t.Errorf("wrong tsuru target: %q", target)
+	}
+	err = useTarget("staging")
+	if err == nil || err.Error() != `target "staging" not found` {
+		t.Errorf("wrong error: %v", err)
+	}
+}
+
+func TestExtractTargetFlag(t *testing.T) {
+	var tests = []struct {
+		input          []string
+		expectedArgs   []string
+		expectedTarget string
+		errMsg         string
+	}{
+		{[]string{"project-list"}, []string{"project-list"}, "", ""},
+		{[]string{"--target", "internal", "project-list"}, []string{"project-list"}, "internal", ""},
+		{[]string{"project-info", "-n", "myproj", "--target=customer"}, []string{"project-info", "-n", "myproj"}, "customer", ""},
+		{[]string{"project-list", "--target"}, nil, "", "flag needs an argument: --target"},
+		{[]string{"envvar-set", "-n", "myproj", "--", "OPTS=--target=x"}, []string{"envvar-set", "-n", "myproj", "--", "OPTS=--target=x"}, "", ""},
+		{[]string{"envvar-set", "-n", "myproj", "FOO=bar", "--target", "customer"}, []string{"envvar-set", "-n", "myproj", "FOO=bar", "--target", "customer"}, "", ""},
+		{[]string{"--dry-run", "--target", "internal", "envvar-set", "-n", "--target", "FOO=bar"}, []string{"--dry-run", "envvar-set", "-n", "--target", "FOO=bar"}, "internal", ""},
+	}
+	manager := buildManager("tranor")
+	for _, test := range tests {
+		args, target, err := extractTargetFlag(manager, test.input)
+		var errMsg string
+		if err != nil {
+			errMsg = err.Error()
+		}
+		if errMsg != test.errMsg {
+			t.Errorf("%v: wrong error. Want %q. Got %q", test.input, test.errMsg, errMsg)
+		}
+		if !reflect.DeepEqual(args, test.expectedArgs) {
+			t.Errorf("%v: wrong args. Want %#v. Got %#v", test.input, test.expectedArgs, args)
+		}
+		if target != test.expectedTarget {
+			t.Errorf("%v: wrong target. Want %q. Got %q", test.input, test.expectedTarget, target)
+		}
+	}
+}
+
+func newConfigServer(config string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/config.json" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(config))
+	}))
+}
+
+func setupFakeTargets(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("HOME", dir)
+	err = writeTargetConfig("internal", &Config{Target: "http://tsuru-internal.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeTargetConfig("customer", &Config{Target: "http://tsuru.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeCurrentTargetName("internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() { os.RemoveAll(dir) }
+}
+
+func TestDownloadConfigurationFailsValidation(t *testing.T) {
+	server := newConfigServer(`{"target":"http://mytarget.example.com","envs":[{"name":"dev","dnsSuffix":""}]}`)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("HOME", dir)
+	err = downloadConfiguration("default", server.URL, "", false)
+	expectedMsg := "invalid configuration returned by the remote target: invalid configuration:\n - environment \"dev\": the DNS suffix is not defined"
+	if err == nil || err.Error() != expectedMsg {
+		t.Errorf("wrong error message\nWant %q\nGot  %v", expectedMsg, err)
+	}
+	if _, err = os.Stat(configFilePath("default")); !os.IsNotExist(err) {
+		t.Errorf("invalid configuration should not be written: %v", err)
+	}
+}
+
+func TestDownloadConfigurationSigned(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := `{"target":"http://mytarget.example.com","envs":[]}`
+	server := newSignedConfigServer(config, ed25519.Sign(privateKey, []byte(config)))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("HOME", dir)
+	encodedKey := base64.StdEncoding.EncodeToString(publicKey)
+	err = downloadConfiguration("default", server.URL, encodedKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadTargetConfig("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.SigningKey != encodedKey {
+		t.Errorf("wrong pinned key. Want %q. Got %q", encodedKey, c.SigningKey)
+	}
+	err = downloadConfiguration("default", server.URL, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = loadTargetConfig("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.SigningKey != encodedKey {
+		t.Errorf("pinned key should be kept on refresh. Want %q. Got %q", encodedKey, c.SigningKey)
+	}
+}
+
+func TestDownloadConfigurationInvalidSignature(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := `{"target":"http://mytarget.example.com","envs":[]}`
+	server := newSignedConfigServer(config, ed25519.Sign(otherKey, []byte(config)))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("HOME", dir)
+	err = downloadConfiguration("default", server.URL, base64.StdEncoding.EncodeToString(publicKey), false)
+	expectedMsg := "invalid signature in the configuration returned by the remote target"
+	if err == nil || err.Error() != expectedMsg {
+		t.Errorf("wrong error message\nWant %q\nGot  %v", expectedMsg, err)
+	}
+	if _, err = os.Stat(configFilePath("default")); !os.IsNotExist(err) {
+		t.Errorf("unverified configuration should not be written: %v", err)
+	}
+}
+
+func TestDownloadConfigurationMissingSignature(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newConfigServer(`{"target":"http://mytarget.example.com","envs":[]}`)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("HOME", dir)
+	err = downloadConfiguration("default", server.URL, base64.StdEncoding.EncodeToString(publicKey), false)
+	expectedMsg := "failed to download signature file: 404 - not found\n"
+	if err == nil || err.Error() != expectedMsg {
+		t.Errorf("wrong error message\nWant %q\nGot  %v", expectedMsg, err)
+	}
+}
+
+func TestDownloadConfigurationKeyChange(t *testing.T) {
+	oldKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := `{"target":"http://mytarget.example.com","envs":[]}`
+	server := newSignedConfigServer(config, ed25519.Sign(privateKey, []byte(config)))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("HOME", dir)
+	err = writeTargetConfig("default", &Config{Target: "http://mytarget.example.com", SigningKey: base64.StdEncoding.EncodeToString(oldKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodedKey := base64.StdEncoding.EncodeToString(newKey)
+	err = downloadConfiguration("default", server.URL, "", false)
+	expectedMsg := "invalid signature in the configuration returned by the remote target"
+	if err == nil || err.Error() != expectedMsg {
+		t.Errorf("wrong error message\nWant %q\nGot  %v", expectedMsg, err)
+	}
+	err = downloadConfiguration("default", server.URL, encodedKey, false)
+	expectedMsg = `the public key of the target "default" has changed, use --accept-key-change to trust the new key`
+	if err == nil || err.Error() != expectedMsg {
+		t.Errorf("wrong error message\nWant %q\nGot  %v", expectedMsg, err)
+	}
+	err = downloadConfiguration("default", server.URL, encodedKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadTargetConfig("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.SigningKey != encodedKey {
+		t.Errorf("wrong pinned key. Want %q. Got %q", encodedKey, c.SigningKey)
+	}
+}
+
+func TestTargetSetRunInvalidPublicKey(t *testing.T) {
+	var c targetSet
+	c.Flags().Parse(true, []string{"--public-key", "abc"})
+	err := c.Run(&cmd.Context{Args: []string{"http://192.0.2.12"}, Stdout: ioutil.Discard}, nil)
+	expectedMsg := "invalid public key: invalid ed25519 public key"
+	if err == nil || err.Error() != expectedMsg {
+		t.Errorf("wrong error message\nWant %q\nGot  %v", expectedMsg, err)
+	}
+}
+
+func newSignedConfigServer(config string, signature []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config.json":
+			w.Write([]byte(config))
+		case "/config.json.sig":
+			w.Write([]byte(base64.StdEncoding.EncodeToString(signature) + "\n"))
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+}
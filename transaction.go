@@ -0,0 +1,55 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// transaction records the completed changes of a command, so they can be
+// undone in reverse order when a later change fails.
+type transaction struct {
+	steps []undoStep
+}
+
+type undoStep struct {
+	desc string
+	undo func(ctx context.Context) error
+}
+
+// done records a completed change and the function that undoes it.
+func (t *transaction) done(desc string, undo func(ctx context.Context) error) {
+	t.steps = append(t.steps, undoStep{desc: desc, undo: undo})
+}
+
+// rollback undoes the recorded changes in reverse order, under
+// cleanupContext, and returns cause, listing the changes not undone.
+func (t *transaction) rollback(w io.Writer, cause error) error {
+	if len(t.steps) == 0 {
+		return cause
+	}
+	fmt.Fprintf(w, "failed: %s\nrolling back the changes...\n", cause)
+	ctx, cancel := cleanupContext()
+	defer cancel()
+	var failed []string
+	for i := len(t.steps) - 1; i >= 0; i-- {
+		step := t.steps[i]
+		fmt.Fprintf(w, " %s... ", step.desc)
+		if err := step.undo(ctx); err != nil {
+			fmt.Fprintln(w, "failed")
+			failed = append(failed, fmt.Sprintf("%s: %s", step.desc, err))
+			continue
+		}
+		fmt.Fprintln(w, "ok")
+	}
+	t.steps = nil
+	if len(failed) > 0 {
+		return fmt.Errorf("%s\nthe rollback failed, the following changes were not undone:\n - %s", cause, strings.Join(failed, "\n - "))
+	}
+	return cause
+}
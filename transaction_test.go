@@ -0,0 +1,75 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTransactionRollback(t *testing.T) {
+	var (
+		tx    transaction
+		undid []string
+	)
+	for _, name := range []string{"first", "second", "third"} {
+		name := name
+		tx.done("undo "+name, func(context.Context) error {
+			undid = append(undid, name)
+			if name == "second" {
+				return errors.New("something went wrong")
+			}
+			return nil
+		})
+	}
+	var buf bytes.Buffer
+	err := tx.rollback(&buf, errors.New("update failed"))
+	if expected := []string{"third", "second", "first"}; !reflect.DeepEqual(undid, expected) {
+		t.Errorf("wrong rollback order\nwant %#v\ngot  %#v", expected, undid)
+	}
+	expectedErr := "update failed\nthe rollback failed, the following changes were not undone:\n - undo second: something went wrong"
+	if err == nil || err.Error() != expectedErr {
+		t.Errorf("wrong error\nwant %q\ngot  %v", expectedErr, err)
+	}
+	expectedOutput := `failed: update failed
+rolling back the changes...
+ undo third... ok
+ undo second... failed
+ undo first... ok
+`
+	if buf.String() != expectedOutput {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expectedOutput, buf.String())
+	}
+}
+
+func TestTransactionRollbackNoSteps(t *testing.T) {
+	var tx transaction
+	var buf bytes.Buffer
+	cause := errors.New("update failed")
+	err := tx.rollback(&buf, cause)
+	if err != cause {
+		t.Errorf("wrong error: %v", err)
+	}
+	if buf.String() != "" {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
+
+func TestTransactionRollbackAfterAbandon(t *testing.T) {
+	defer resetInterrupts()
+	var tx transaction
+	tx.done("undo change", func(ctx context.Context) error {
+		return ctx.Err()
+	})
+	cancelRequests()
+	var buf bytes.Buffer
+	err := tx.rollback(&buf, errInterrupted)
+	if err != errInterrupted {
+		t.Errorf("the rollback should run after the requests are canceled, got %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/cezarsa/form"
+)
+
+// App is an app in the tsuru API.
+type App struct {
+	Name          string   `json:"name"`
+	CName         []string `json:"cname"`
+	Description   string   `json:"description"`
+	RepositoryURL string   `json:"repository"`
+	Platform      string   `json:"platform"`
+	Teams         []string `json:"teams"`
+	Owner         string   `json:"owner"`
+	Pool          string   `json:"pool"`
+	TeamOwner     string   `json:"teamowner"`
+	Units         []Unit   `json:"units"`
+	Plan          Plan     `json:"plan"`
+}
+
+// UnitStarted is the status of the units that are running.
+const UnitStarted = "started"
+
+// Unit is a unit of an app.
+type Unit struct {
+	ID          string `json:"ID"`
+	ProcessName string `json:"ProcessName"`
+	Status      string `json:"Status"`
+}
+
+// Plan is the plan of an app.
+type Plan struct {
+	Name string `json:"name"`
+}
+
+// CreateAppOptions are the parameters used to create and update apps.
+type CreateAppOptions struct {
+	Name        string `form:"name"`
+	Platform    string `form:"platform"`
+	Description string `form:"description,omitempty"`
+	Team        string `form:"teamOwner,omitempty"`
+	Plan        string `form:"plan,omitempty"`
+	Pool        string `form:"pool,omitempty"`
+}
+
+// CreateApp creates an app, returning the data returned by the API, like the
+// URL of the repository of the app.
+func (c *Client) CreateApp(ctx context.Context, opts CreateAppOptions) (map[string]string, error) {
+	v, err := form.EncodeToValues(opts)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/apps", v, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var app map[string]string
+	err = json.NewDecoder(resp.Body).Decode(&app)
+	return app, err
+}
+
+// UpdateApp updates the plan, team, description and pool of the app with the
+// given name. Empty options are left unchanged.
+func (c *Client) UpdateApp(ctx context.Context, opts CreateAppOptions) error {
+	name := opts.Name
+	opts.Name = ""
+	opts.Platform = ""
+	v, err := form.EncodeToValues(opts)
+	if err != nil {
+		return err
+	}
+	return c.stream(ctx, http.MethodPut, "/apps/"+name, v, true)
+}
+
+// DeleteApp deletes the app.
+func (c *Client) DeleteApp(ctx context.Context, appName string) error {
+	return c.stream(ctx, http.MethodDelete, "/apps/"+appName, nil, true)
+}
+
+// ListApps returns the apps matching the given filters, like the name
+// regular expression in "name".
+func (c *Client) ListApps(ctx context.Context, filters map[string]string) ([]App, error) {
+	qs := make(url.Values)
+	for k, v := range filters {
+		qs.Set(k, v)
+	}
+	resp, err := c.get(ctx, "/apps?"+qs.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	var apps []App
+	err = json.NewDecoder(resp.Body).Decode(&apps)
+	return apps, err
+}
+
+// GetApp returns the app with the given name.
+func (c *Client) GetApp(ctx context.Context, appName string) (App, error) {
+	var a App
+	resp, err := c.get(ctx, "/apps/"+appName)
+	if err != nil {
+		return a, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&a)
+	return a, err
+}
+
+// AddCName adds the cname to the app.
+func (c *Client) AddCName(ctx context.Context, appName, cname string) error {
+	v := make(url.Values)
+	v.Set("cname", cname)
+	return c.stream(ctx, http.MethodPost, "/apps/"+appName+"/cname", v, true)
+}
+
+// AddUnits adds the given number of units to the app. It's never retried.
+func (c *Client) AddUnits(ctx context.Context, appName string, units int) error {
+	v := make(url.Values)
+	v.Set("process", "")
+	v.Set("units", strconv.Itoa(units))
+	return c.stream(ctx, http.MethodPut, "/apps/"+appName+"/units", v, false)
+}
+
+// RemoveUnits removes the given number of units from the app.
+func (c *Client) RemoveUnits(ctx context.Context, appName string, units int) error {
+	v := make(url.Values)
+	v.Set("process", "")
+	v.Set("units", strconv.Itoa(units))
+	return c.stream(ctx, http.MethodDelete, "/apps/"+appName+"/units?"+v.Encode(), nil, false)
+}
@@ -0,0 +1,250 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+)
+
+func TestCreateApp(t *testing.T) {
+	client := newTestClient()
+	ctx := context.Background()
+	data, err := client.CreateApp(ctx, tsuru.CreateAppOptions{
+		Name:        "myapp",
+		Description: "my nice app",
+		Plan:        "medium",
+		Platform:    "python",
+		Pool:        "mypool",
+		Team:        "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedData := map[string]string{"repository_url": "git@gandalf.example.com:myapp.git"}
+	if !reflect.DeepEqual(data, expectedData) {
+		t.Errorf("wrong data returned\nwant %#v\ngot  %#v", expectedData, data)
+	}
+	a, err := client.GetApp(ctx, "myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedApp := tsuru.App{
+		Name:          "myapp",
+		Description:   "my nice app",
+		RepositoryURL: "git@gandalf.example.com:myapp.git",
+		Platform:      "python",
+		Teams:         []string{"admin"},
+		Owner:         "user@example.com",
+		Pool:          "mypool",
+		TeamOwner:     "admin",
+		Plan:          tsuru.Plan{Name: "medium"},
+	}
+	if !reflect.DeepEqual(a, expectedApp) {
+		t.Errorf("wrong app\nwant %#v\ngot  %#v", expectedApp, a)
+	}
+}
+
+func TestCreateAppPayload(t *testing.T) {
+	var payload url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		payload, _ = url.ParseQuery(string(data))
+		w.Write([]byte(`{"repository_url":"git@example.com:app.git"}`))
+	}))
+	defer srv.Close()
+	client := tsuru.NewClient(http.DefaultClient, srv.URL)
+	_, err := client.CreateApp(context.Background(), tsuru.CreateAppOptions{
+		Name:     "app",
+		Platform: "python",
+		Team:     "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPayload := url.Values{
+		"name":      {"app"},
+		"platform":  {"python"},
+		"teamOwner": {"admin"},
+	}
+	if !reflect.DeepEqual(payload, expectedPayload) {
+		t.Errorf("wrong payload\nwant %#v\ngot  %#v", expectedPayload, payload)
+	}
+}
+
+func TestCreateAppConflict(t *testing.T) {
+	client := newTestClient()
+	ctx := context.Background()
+	opts := tsuru.CreateAppOptions{Name: "myapp", Platform: "python"}
+	_, err := client.CreateApp(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := client.CreateApp(ctx, opts)
+	if !tsuru.IsConflict(err) {
+		t.Errorf("wrong error: %#v", err)
+	}
+	if data != nil {
+		t.Errorf("unexpected non-nil data: %#v", data)
+	}
+}
+
+func TestUpdateApp(t *testing.T) {
+	client := newTestClient()
+	ctx := context.Background()
+	_, err := client.CreateApp(ctx, tsuru.CreateAppOptions{Name: "myapp", Platform: "python", Plan: "small", Team: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.UpdateApp(ctx, tsuru.CreateAppOptions{
+		Name:        "myapp",
+		Description: "my nice app - updated!",
+		Plan:        "medium",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := client.GetApp(ctx, "myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Description != "my nice app - updated!" || a.Plan.Name != "medium" || a.TeamOwner != "admin" {
+		t.Errorf("app not updated correctly: %#v", a)
+	}
+}
+
+func TestUpdateAppNotFound(t *testing.T) {
+	client := newTestClient()
+	err := client.UpdateApp(context.Background(), tsuru.CreateAppOptions{Name: "myapp", Plan: "medium"})
+	if !tsuru.IsNotFound(err) {
+		t.Errorf("wrong error: %#v", err)
+	}
+}
+
+func TestDeleteApp(t *testing.T) {
+	client := newTestClient()
+	ctx := context.Background()
+	_, err := client.CreateApp(ctx, tsuru.CreateAppOptions{Name: "myapp", Platform: "python"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.DeleteApp(ctx, "myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.GetApp(ctx, "myapp")
+	if !tsuru.IsNotFound(err) {
+		t.Errorf("wrong error: %#v", err)
+	}
+	err = client.DeleteApp(ctx, "myapp")
+	if !tsuru.IsNotFound(err) {
+		t.Errorf("wrong error: %#v", err)
+	}
+}
+
+func TestListApps(t *testing.T) {
+	client := newTestClient()
+	ctx := context.Background()
+	for _, name := range []string{"proj1-dev", "proj1-prod", "proj2-dev"} {
+		_, err := client.CreateApp(ctx, tsuru.CreateAppOptions{Name: name, Platform: "python"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	apps, err := client.ListApps(ctx, map[string]string{"name": "^proj1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, a := range apps {
+		names = append(names, a.Name)
+	}
+	expectedNames := []string{"proj1-dev", "proj1-prod"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("wrong list of apps\nwant %#v\ngot  %#v", expectedNames, names)
+	}
+}
+
+func TestListAppsEmpty(t *testing.T) {
+	client := newTestClient()
+	apps, err := client.ListApps(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 0 {
+		t.Errorf("got unexpected non-empty app list: %#v", apps)
+	}
+}
+
+func TestGetAppNotFound(t *testing.T) {
+	client := newTestClient()
+	a, err := client.GetApp(context.Background(), "myapp")
+	if !tsuru.IsNotFound(err) {
+		t.Errorf("wrong error: %#v", err)
+	}
+	if !reflect.DeepEqual(a, tsuru.App{}) {
+		t.Errorf("got non-empty app: %#v", a)
+	}
+}
+
+func TestAddCName(t *testing.T) {
+	client := newTestClient()
+	ctx := context.Background()
+	_, err := client.CreateApp(ctx, tsuru.CreateAppOptions{Name: "myapp", Platform: "python"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.AddCName(ctx, "myapp", "myapp.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.AddCName(ctx, "myapp", "myapp.example.com")
+	if !tsuru.IsConflict(err) {
+		t.Errorf("wrong error: %#v", err)
+	}
+	a, err := client.GetApp(ctx, "myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedCNames := []string{"myapp.example.com"}
+	if !reflect.DeepEqual(a.CName, expectedCNames) {
+		t.Errorf("wrong cnames\nwant %#v\ngot  %#v", expectedCNames, a.CName)
+	}
+}
+
+func TestAddAndRemoveUnits(t *testing.T) {
+	client := newTestClient()
+	ctx := context.Background()
+	_, err := client.CreateApp(ctx, tsuru.CreateAppOptions{Name: "myapp", Platform: "python"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.AddUnits(ctx, "myapp", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.RemoveUnits(ctx, "myapp", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := client.GetApp(ctx, "myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Units) != 2 {
+		t.Errorf("wrong number of units. Want 2. Got %d", len(a.Units))
+	}
+	err = client.RemoveUnits(ctx, "myapp", 5)
+	if err == nil {
+		t.Error("unexpected <nil> error")
+	}
+}
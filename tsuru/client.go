@@ -0,0 +1,119 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tsuru provides a client of the subset of the tsuru API used by
+// tranor.
+package tsuru
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	tsuruerrors "github.com/tsuru/tsuru/errors"
+	tsuruio "github.com/tsuru/tsuru/io"
+)
+
+// Doer sends HTTP requests, like the client of the tsuru cmd package.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Client is a client of the tsuru API. Error responses are returned as a
+// *NotFoundError, *ConflictError, *ForbiddenError or *HTTPError.
+type Client struct {
+	// Retry is the policy used to retry idempotent requests. Requests are
+	// not retried by default.
+	Retry RetryPolicy
+
+	doer   Doer
+	target string
+}
+
+// NewClient returns a client of the tsuru API running in the given target,
+// sending requests with the given Doer.
+func NewClient(doer Doer, target string) *Client {
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		target = "http://" + target
+	}
+	return &Client{doer: doer, target: strings.TrimRight(target, "/")}
+}
+
+// URL returns the URL of the given path in the API.
+func (c *Client) URL(path string) string {
+	return c.target + "/1.0" + path
+}
+
+func (c *Client) get(ctx context.Context, path string) (*http.Response, error) {
+	return c.do(ctx, http.MethodGet, path, nil, true)
+}
+
+// do sends a request to the API, with the given form as body, if not nil.
+// Idempotent requests are retried according to the retry policy of the
+// client. The body of the response must be closed by the caller.
+func (c *Client) do(ctx context.Context, method, path string, form url.Values, idempotent bool) (*http.Response, error) {
+	for retry := 0; ; retry++ {
+		resp, err := c.send(ctx, method, path, form)
+		if err == nil || !idempotent || retry >= c.Retry.Retries || !temporary(ctx, err) {
+			return resp, err
+		}
+		if waitErr := c.Retry.wait(ctx, retry); waitErr != nil {
+			return nil, err
+		}
+	}
+}
+
+// send sends a request to the API once.
+func (c *Client) send(ctx context.Context, method, path string, form url.Values) (*http.Response, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, c.URL(path), body)
+	if err != nil {
+		return nil, err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := c.doer.Do(req.WithContext(ctx))
+	if err != nil {
+		// unauthorized errors are returned as is, so the tsuru cmd package can
+		// ask the user to log in
+		if e, ok := err.(*tsuruerrors.HTTP); ok && e.Code != http.StatusUnauthorized {
+			return nil, newHTTPError(e.Code, e.Message)
+		}
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		message := string(data)
+		if strings.TrimSpace(message) == "" {
+			message = resp.Status
+		}
+		return nil, newHTTPError(resp.StatusCode, message)
+	}
+	return resp, nil
+}
+
+// stream sends a request to an endpoint that streams JSON messages, returning
+// the error reported in the stream, if any.
+func (c *Client) stream(ctx context.Context, method, path string, form url.Values, idempotent bool) error {
+	return c.streamTo(ctx, method, path, form, idempotent, ioutil.Discard)
+}
+
+// streamTo is like stream, but writes the messages in the stream to w.
+func (c *Client) streamTo(ctx context.Context, method, path string, form url.Values, idempotent bool, w io.Writer) error {
+	resp, err := c.do(ctx, method, path, form, idempotent)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(tsuruio.NewStreamWriter(w, nil), resp.Body)
+	return err
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+	"github.com/ef-ctx/tsuru-flow/tsuru/tsurutest"
+	tsuruerrors "github.com/tsuru/tsuru/errors"
+)
+
+var server *tsurutest.Server
+
+func TestMain(m *testing.M) {
+	server = tsurutest.NewServer()
+	code := m.Run()
+	server.Close()
+	os.Exit(code)
+}
+
+// newTestClient resets the fake tsuru API and returns a client of it.
+func newTestClient() *tsuru.Client {
+	server.Reset()
+	return tsuru.NewClient(http.DefaultClient, server.URL())
+}
+
+type doerFunc func(*http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestClientURL(t *testing.T) {
+	var tests = []struct {
+		target   string
+		expected string
+	}{
+		{"http://tsuru.example.com", "http://tsuru.example.com/1.0/apps"},
+		{"https://tsuru.example.com/", "https://tsuru.example.com/1.0/apps"},
+		{"tsuru.example.com:8080", "http://tsuru.example.com:8080/1.0/apps"},
+	}
+	for _, test := range tests {
+		got := tsuru.NewClient(nil, test.target).URL("/apps")
+		if got != test.expected {
+			t.Errorf("wrong URL for target %q\nwant %q\ngot  %q", test.target, test.expected, got)
+		}
+	}
+}
+
+func TestClientErrorStatus(t *testing.T) {
+	var tests = []struct {
+		code     int
+		body     string
+		check    func(error) bool
+		expected string
+	}{
+		{http.StatusNotFound, "app not found\n", tsuru.IsNotFound, "app not found"},
+		{http.StatusConflict, "app already exists\n", tsuru.IsConflict, "app already exists"},
+		{http.StatusForbidden, "", tsuru.IsForbidden, "403 Forbidden"},
+		{http.StatusInternalServerError, "something went wrong", nil, "something went wrong"},
+	}
+	for _, test := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.code)
+			w.Write([]byte(test.body))
+		}))
+		client := tsuru.NewClient(http.DefaultClient, srv.URL)
+		_, err := client.GetApp(context.Background(), "myapp")
+		srv.Close()
+		if err == nil {
+			t.Errorf("%d: unexpected <nil> error", test.code)
+			continue
+		}
+		if test.check != nil && !test.check(err) {
+			t.Errorf("%d: wrong error type: %#v", test.code, err)
+		}
+		if err.Error() != test.expected {
+			t.Errorf("%d: wrong error message\nwant %q\ngot  %q", test.code, test.expected, err)
+		}
+	}
+}
+
+func TestClientErrorFromDoer(t *testing.T) {
+	client := tsuru.NewClient(doerFunc(func(*http.Request) (*http.Response, error) {
+		return nil, &tsuruerrors.HTTP{Code: http.StatusNotFound, Message: "app not found\n"}
+	}), "http://tsuru.example.com")
+	_, err := client.GetApp(context.Background(), "myapp")
+	if !tsuru.IsNotFound(err) {
+		t.Fatalf("wrong error: %#v", err)
+	}
+	if err.Error() != "app not found" {
+		t.Errorf("wrong error message. Want %q. Got %q", "app not found", err.Error())
+	}
+}
+
+func TestClientUnauthorizedFromDoer(t *testing.T) {
+	unauthorized := &tsuruerrors.HTTP{Code: http.StatusUnauthorized, Message: "invalid token"}
+	client := tsuru.NewClient(doerFunc(func(*http.Request) (*http.Response, error) {
+		return nil, unauthorized
+	}), "http://tsuru.example.com")
+	_, err := client.GetApp(context.Background(), "myapp")
+	if err != unauthorized {
+		t.Errorf("unauthorized errors should be returned as is, got %#v", err)
+	}
+}
+
+func TestClientCanceledContext(t *testing.T) {
+	client := newTestClient()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := client.ListApps(ctx, nil)
+	if err == nil {
+		t.Fatal("unexpected <nil> error")
+	}
+}
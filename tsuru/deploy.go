@@ -0,0 +1,70 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Deploy is a deploy of an app. Error is set in failed deploys.
+type Deploy struct {
+	ID        string        `json:"id"`
+	Commit    string        `json:"commit,omitempty"`
+	Timestamp time.Time     `json:"timestamp"`
+	Duration  time.Duration `json:"duration,omitempty"`
+	Image     string        `json:"image"`
+	User      string        `json:"user,omitempty"`
+	Origin    string        `json:"origin,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// ListDeploys returns the most recent deploys of the app, up to the given
+// limit (zero means no limit).
+func (c *Client) ListDeploys(ctx context.Context, appName string, limit int) ([]Deploy, error) {
+	path := "/deploys?app=" + url.QueryEscape(appName)
+	if limit > 0 {
+		path = fmt.Sprintf("/deploys?limit=%d&app=%s", limit, url.QueryEscape(appName))
+	}
+	resp, err := c.get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	var deploys []Deploy
+	err = json.NewDecoder(resp.Body).Decode(&deploys)
+	return deploys, err
+}
+
+// RollbackDeploy deploys again an image of the app, writing the output to w.
+func (c *Client) RollbackDeploy(ctx context.Context, appName, image, message string, w io.Writer) error {
+	form := url.Values{"origin": {"rollback"}, "image": {image}}
+	if message != "" {
+		form.Set("message", message)
+	}
+	return c.streamTo(ctx, http.MethodPost, "/apps/"+appName+"/deploy/rollback", form, false, w)
+}
+
+// LastDeploy returns the most recent deploy of the app, or an empty deploy if
+// the app was never deployed.
+func (c *Client) LastDeploy(ctx context.Context, appName string) (Deploy, error) {
+	var d Deploy
+	deploys, err := c.ListDeploys(ctx, appName, 1)
+	if err != nil {
+		return d, err
+	}
+	if len(deploys) > 0 {
+		d = deploys[0]
+	}
+	return d, nil
+}
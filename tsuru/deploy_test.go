@@ -0,0 +1,116 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru_test
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+)
+
+func TestListDeploys(t *testing.T) {
+	client := newTestClient()
+	ctx := context.Background()
+	_, err := client.CreateApp(ctx, tsuru.CreateAppOptions{Name: "myapp", Platform: "python"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deploys := []tsuru.Deploy{
+		{ID: "1", Image: "v1", Timestamp: time.Date(2018, 1, 10, 10, 0, 0, 0, time.UTC)},
+		{ID: "2", Image: "v2", Timestamp: time.Date(2018, 1, 11, 10, 0, 0, 0, time.UTC)},
+		{ID: "3", Image: "v3", Timestamp: time.Date(2018, 1, 12, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, d := range deploys {
+		server.AddDeploy("myapp", d)
+	}
+	got, err := client.ListDeploys(ctx, "myapp", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []tsuru.Deploy{deploys[2], deploys[1]}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong deploys\nwant %#v\ngot  %#v", expected, got)
+	}
+	got, err = client.ListDeploys(ctx, "myapp", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Errorf("wrong number of deploys. Want 3. Got %d", len(got))
+	}
+}
+
+func TestListDeploysNotFound(t *testing.T) {
+	client := newTestClient()
+	_, err := client.ListDeploys(context.Background(), "myapp", 0)
+	if !tsuru.IsNotFound(err) {
+		t.Errorf("wrong error: %#v", err)
+	}
+}
+
+func TestLastDeploy(t *testing.T) {
+	client := newTestClient()
+	ctx := context.Background()
+	_, err := client.CreateApp(ctx, tsuru.CreateAppOptions{Name: "myapp", Platform: "python"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := client.LastDeploy(ctx, "myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d, tsuru.Deploy{}) {
+		t.Errorf("expected an empty deploy, got %#v", d)
+	}
+	expected := tsuru.Deploy{
+		ID:        "57ccc9490640fd3def98b157",
+		Commit:    "40244ff2866eba7e2da6eee8a6fc51464c9f604f",
+		Image:     "v938",
+		Timestamp: time.Date(2016, 9, 5, 1, 24, 25, 706e6, time.UTC),
+	}
+	server.AddDeploy("myapp", tsuru.Deploy{ID: "older", Image: "v937"})
+	server.AddDeploy("myapp", expected)
+	d, err = client.LastDeploy(ctx, "myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d, expected) {
+		t.Errorf("wrong deploy\nwant %#v\ngot  %#v", expected, d)
+	}
+}
+
+func TestRollbackDeploy(t *testing.T) {
+	client := newTestClient()
+	ctx := context.Background()
+	_, err := client.CreateApp(ctx, tsuru.CreateAppOptions{Name: "myapp", Platform: "python"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.AddDeploy("myapp", tsuru.Deploy{ID: "1", Image: "v1"})
+	server.AddDeploy("myapp", tsuru.Deploy{ID: "2", Image: "v2"})
+	var output bytes.Buffer
+	err = client.RollbackDeploy(ctx, "myapp", "v1", "", &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "rolling back to v1\n"; output.String() != expected {
+		t.Errorf("wrong output\nwant %q\ngot  %q", expected, output.String())
+	}
+	d, err := client.LastDeploy(ctx, "myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Image != "v1" || d.Origin != "rollback" {
+		t.Errorf("wrong last deploy: %#v", d)
+	}
+	err = client.RollbackDeploy(ctx, "myapp", "v3", "", &output)
+	if err == nil || err.Error() != "invalid version: v3" {
+		t.Errorf("wrong error: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/cezarsa/form"
+	"github.com/tsuru/tsuru/api"
+)
+
+// EnvVar is an environment variable of an app.
+type EnvVar struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Public bool   `json:"public"`
+}
+
+func (e *EnvVar) String() string {
+	value := "*** (private variable)"
+	if e.Public {
+		value = e.Value
+	}
+	return fmt.Sprintf("%s=%s", e.Name, value)
+}
+
+// SetEnvVars sets the given environment variables in the app.
+func (c *Client) SetEnvVars(ctx context.Context, appName string, envVars *api.Envs) error {
+	v, err := form.EncodeToValues(envVars)
+	if err != nil {
+		return err
+	}
+	return c.stream(ctx, http.MethodPost, "/apps/"+appName+"/env", v, true)
+}
+
+// GetEnvVars returns the environment variables of the app.
+func (c *Client) GetEnvVars(ctx context.Context, appName string) ([]EnvVar, error) {
+	resp, err := c.get(ctx, "/apps/"+appName+"/env")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var vars []EnvVar
+	err = json.NewDecoder(resp.Body).Decode(&vars)
+	return vars, err
+}
+
+// UnsetEnvVars removes the given environment variables from the app.
+func (c *Client) UnsetEnvVars(ctx context.Context, appName string, noRestart bool, names []string) error {
+	qs := make(url.Values)
+	qs.Set("noRestart", strconv.FormatBool(noRestart))
+	for _, name := range names {
+		qs.Add("env", name)
+	}
+	return c.stream(ctx, http.MethodDelete, "/apps/"+appName+"/env?"+qs.Encode(), nil, true)
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+	"github.com/tsuru/tsuru/api"
+)
+
+func TestSetAndGetEnvVars(t *testing.T) {
+	client := newTestClient()
+	ctx := context.Background()
+	_, err := client.CreateApp(ctx, tsuru.CreateAppOptions{Name: "myapp", Platform: "python"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SetEnvVars(ctx, "myapp", &api.Envs{
+		Envs: []struct{ Name, Value string }{{Name: "USER_NAME", Value: "root"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SetEnvVars(ctx, "myapp", &api.Envs{
+		Private: true,
+		Envs:    []struct{ Name, Value string }{{Name: "USER_PASSWORD", Value: "r00t"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars, err := client.GetEnvVars(ctx, "myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedVars := []tsuru.EnvVar{
+		{Name: "TSURU_APPDIR", Value: "*** (private variable)"},
+		{Name: "TSURU_APP_TOKEN", Value: "*** (private variable)"},
+		{Name: "TSURU_APPNAME", Value: "*** (private variable)"},
+		{Name: "USER_NAME", Value: "root", Public: true},
+		{Name: "USER_PASSWORD", Value: "*** (private variable)"},
+	}
+	if !reflect.DeepEqual(vars, expectedVars) {
+		t.Errorf("wrong list of vars\nwant %#v\ngot  %#v", expectedVars, vars)
+	}
+}
+
+func TestUnsetEnvVars(t *testing.T) {
+	client := newTestClient()
+	ctx := context.Background()
+	_, err := client.CreateApp(ctx, tsuru.CreateAppOptions{Name: "myapp", Platform: "python"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SetEnvVars(ctx, "myapp", &api.Envs{
+		Envs: []struct{ Name, Value string }{
+			{Name: "USER_NAME", Value: "root"},
+			{Name: "PASSWORD_HINT", Value: "r00t"},
+			{Name: "DEBUG", Value: "1"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.UnsetEnvVars(ctx, "myapp", true, []string{"USER_NAME", "PASSWORD_HINT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars, err := client.GetEnvVars(ctx, "myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := vars[len(vars)-1]
+	if len(vars) != 4 || last.Name != "DEBUG" {
+		t.Errorf("wrong list of vars after unset: %#v", vars)
+	}
+}
+
+func TestEnvVarsNotFound(t *testing.T) {
+	client := newTestClient()
+	ctx := context.Background()
+	err := client.SetEnvVars(ctx, "myapp", &api.Envs{})
+	if !tsuru.IsNotFound(err) {
+		t.Errorf("set: wrong error: %#v", err)
+	}
+	vars, err := client.GetEnvVars(ctx, "myapp")
+	if !tsuru.IsNotFound(err) {
+		t.Errorf("get: wrong error: %#v", err)
+	}
+	if vars != nil {
+		t.Errorf("get: unexpected non-nil vars: %#v", vars)
+	}
+	err = client.UnsetEnvVars(ctx, "myapp", false, []string{"USER_NAME"})
+	if !tsuru.IsNotFound(err) {
+		t.Errorf("unset: wrong error: %#v", err)
+	}
+}
+
+func TestEnvVarStringRepr(t *testing.T) {
+	var tests = []struct {
+		testCase string
+		input    tsuru.EnvVar
+		expected string
+	}{
+		{
+			"public variable",
+			tsuru.EnvVar{
+				Name:   "USER_NAME",
+				Value:  "root",
+				Public: true,
+			},
+			"USER_NAME=root",
+		},
+		{
+			"private variable",
+			tsuru.EnvVar{
+				Name:  "USER_PASSWORD",
+				Value: "r00t",
+			},
+			"USER_PASSWORD=*** (private variable)",
+		},
+	}
+	for _, test := range tests {
+		repr := test.input.String()
+		if repr != test.expected {
+			t.Errorf("%s: wrong representation\nwant %q\ngot  %q", test.testCase, test.expected, repr)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"net/http"
+	"strings"
+)
+
+// HTTPError is returned when the tsuru API responds with an error status code.
+type HTTPError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *HTTPError) Error() string {
+	return e.Message
+}
+
+// NotFoundError is returned when the resource doesn't exist in the tsuru API.
+type NotFoundError struct {
+	HTTPError
+}
+
+// ConflictError is returned when the change conflicts with the current state
+// of the resource, like creating an app that already exists.
+type ConflictError struct {
+	HTTPError
+}
+
+// ForbiddenError is returned when the user isn't allowed to access the
+// resource.
+type ForbiddenError struct {
+	HTTPError
+}
+
+func newHTTPError(statusCode int, message string) error {
+	e := HTTPError{StatusCode: statusCode, Message: strings.TrimSpace(message)}
+	switch statusCode {
+	case http.StatusNotFound:
+		return &NotFoundError{e}
+	case http.StatusConflict:
+		return &ConflictError{e}
+	case http.StatusForbidden:
+		return &ForbiddenError{e}
+	}
+	return &e
+}
+
+// IsNotFound reports whether err is a *NotFoundError.
+func IsNotFound(err error) bool {
+	_, ok := err.(*NotFoundError)
+	return ok
+}
+
+// IsConflict reports whether err is a *ConflictError.
+func IsConflict(err error) bool {
+	_, ok := err.(*ConflictError)
+	return ok
+}
+
+// IsForbidden reports whether err is a *ForbiddenError.
+func IsForbidden(err error) bool {
+	_, ok := err.(*ForbiddenError)
+	return ok
+}
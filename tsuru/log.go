@@ -0,0 +1,89 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Log is an entry of the log of an app.
+type Log struct {
+	Date    time.Time `json:"date"`
+	Message string    `json:"message"`
+	Source  string    `json:"source"`
+	Unit    string    `json:"unit,omitempty"`
+}
+
+// LogOptions are the parameters of the log stream of an app.
+type LogOptions struct {
+	Lines  int
+	Follow bool
+	Source string
+	Unit   string
+}
+
+// StreamLogs calls fn for each entry of the log of the app until the stream
+// ends or fn returns an error.
+func (c *Client) StreamLogs(ctx context.Context, appName string, opts LogOptions, fn func(Log) error) error {
+	path := fmt.Sprintf("/apps/%s/log?lines=%d", appName, opts.Lines)
+	if opts.Source != "" {
+		path += "&source=" + url.QueryEscape(opts.Source)
+	}
+	if opts.Unit != "" {
+		path += "&unit=" + url.QueryEscape(opts.Unit)
+	}
+	if opts.Follow {
+		path += "&follow=1"
+	}
+	resp, err := c.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var chunk json.RawMessage
+		err = decoder.Decode(&chunk)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		var logs []Log
+		if err = json.Unmarshal(chunk, &logs); err != nil {
+			return streamError(chunk)
+		}
+		for _, l := range logs {
+			if err = fn(l); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// streamError returns the error reported in the middle of a log stream.
+func streamError(chunk []byte) error {
+	var msg struct {
+		Error string
+	}
+	if json.Unmarshal(chunk, &msg) == nil && msg.Error != "" {
+		return errors.New(msg.Error)
+	}
+	return fmt.Errorf("invalid log entries: %s", chunk)
+}
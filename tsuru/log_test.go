@@ -0,0 +1,133 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+)
+
+func TestStreamLogs(t *testing.T) {
+	client := newTestClient()
+	ctx := context.Background()
+	_, err := client.CreateApp(ctx, tsuru.CreateAppOptions{Name: "myapp", Platform: "python"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	logs := []tsuru.Log{
+		{Date: now, Message: "creating app", Source: "tsuru"},
+		{Date: now.Add(time.Second), Message: "starting", Source: "app", Unit: "myapp-0"},
+		{Date: now.Add(2 * time.Second), Message: "listening", Source: "app", Unit: "myapp-0"},
+	}
+	server.AddLogs("myapp", logs...)
+	var got []tsuru.Log
+	err = client.StreamLogs(ctx, "myapp", tsuru.LogOptions{Lines: 2}, func(l tsuru.Log) error {
+		got = append(got, l)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, logs[1:]) {
+		t.Errorf("wrong logs\nwant %#v\ngot  %#v", logs[1:], got)
+	}
+	got = nil
+	err = client.StreamLogs(ctx, "myapp", tsuru.LogOptions{Lines: 10, Source: "tsuru"}, func(l tsuru.Log) error {
+		got = append(got, l)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, logs[:1]) {
+		t.Errorf("wrong logs\nwant %#v\ngot  %#v", logs[:1], got)
+	}
+}
+
+func TestStreamLogsMultipleChunks(t *testing.T) {
+	var path string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.RequestURI()
+		w.Write([]byte(`[{"Date":"2018-01-10T10:00:00Z","Message":"first","Source":"app","Unit":"abc"}]`))
+		w.Write([]byte(`[{"Date":"2018-01-10T10:00:01Z","Message":"second","Source":"app","Unit":"abc"}]`))
+	}))
+	defer s.Close()
+	client := tsuru.NewClient(http.DefaultClient, s.URL)
+	var messages []string
+	opts := tsuru.LogOptions{Lines: 5, Follow: true, Source: "app", Unit: "abc"}
+	err := client.StreamLogs(context.Background(), "myapp", opts, func(l tsuru.Log) error {
+		messages = append(messages, l.Message)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"first", "second"}; !reflect.DeepEqual(messages, expected) {
+		t.Errorf("wrong messages\nwant %#v\ngot  %#v", expected, messages)
+	}
+	if expected := "/1.0/apps/myapp/log?lines=5&source=app&unit=abc&follow=1"; path != expected {
+		t.Errorf("wrong path\nwant %q\ngot  %q", expected, path)
+	}
+}
+
+func TestStreamLogsStreamError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"Date":"2018-01-10T10:00:00Z","Message":"first","Source":"app"}]`))
+		w.Write([]byte(`{"Message":"","Error":"log stream closed"}`))
+	}))
+	defer s.Close()
+	client := tsuru.NewClient(http.DefaultClient, s.URL)
+	var n int
+	err := client.StreamLogs(context.Background(), "myapp", tsuru.LogOptions{Lines: 5}, func(l tsuru.Log) error {
+		n++
+		return nil
+	})
+	if err == nil || err.Error() != "log stream closed" {
+		t.Errorf("wrong error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("wrong number of logs. Want 1. Got %d", n)
+	}
+}
+
+func TestStreamLogsCallbackError(t *testing.T) {
+	client := newTestClient()
+	ctx := context.Background()
+	_, err := client.CreateApp(ctx, tsuru.CreateAppOptions{Name: "myapp", Platform: "python"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.AddLogs("myapp", tsuru.Log{Message: "first"}, tsuru.Log{Message: "second"})
+	errStop := errors.New("stop")
+	var n int
+	err = client.StreamLogs(ctx, "myapp", tsuru.LogOptions{Lines: 10}, func(l tsuru.Log) error {
+		n++
+		return errStop
+	})
+	if err != errStop {
+		t.Errorf("wrong error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("wrong number of logs. Want 1. Got %d", n)
+	}
+}
+
+func TestStreamLogsNotFound(t *testing.T) {
+	client := newTestClient()
+	err := client.StreamLogs(context.Background(), "myapp", tsuru.LogOptions{Lines: 10}, func(tsuru.Log) error {
+		return nil
+	})
+	if !tsuru.IsNotFound(err) {
+		t.Errorf("wrong error: %#v", err)
+	}
+}
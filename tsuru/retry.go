@@ -0,0 +1,83 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+
+	tsuruerrors "github.com/tsuru/tsuru/errors"
+)
+
+// RetryPolicy defines how idempotent requests are retried after network
+// errors and 502, 503 and 504 responses, with an exponential backoff and a
+// random jitter.
+type RetryPolicy struct {
+	Retries  int
+	Delay    time.Duration
+	MaxDelay time.Duration // zero means no limit
+}
+
+// backoff returns the delay before the given retry, starting at zero.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.Delay << uint(retry)
+	if delay <= 0 || (p.MaxDelay > 0 && delay > p.MaxDelay) {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// wait between half and all of the delay
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// wait sleeps before the given retry, returning early with an error if the
+// context is done.
+func (p RetryPolicy) wait(ctx context.Context, retry int) error {
+	timer := time.NewTimer(p.backoff(retry))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// temporary reports whether the request that failed with the given error may
+// succeed if sent again.
+func temporary(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if e, ok := httpError(err); ok {
+		switch e.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	// unauthorized errors are passed through as returned by the doer
+	if _, ok := err.(*tsuruerrors.HTTP); ok {
+		return false
+	}
+	return true
+}
+
+func httpError(err error) (*HTTPError, bool) {
+	switch e := err.(type) {
+	case *HTTPError:
+		return e, true
+	case *NotFoundError:
+		return &e.HTTPError, true
+	case *ConflictError:
+		return &e.HTTPError, true
+	case *ForbiddenError:
+		return &e.HTTPError, true
+	}
+	return nil, false
+}
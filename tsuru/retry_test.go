@@ -0,0 +1,171 @@
+// Copyright 2017 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+)
+
+// flakyServer responds with the given status codes, in order, to the first
+// requests it receives, and with 200 OK to the following ones.
+type flakyServer struct {
+	*httptest.Server
+	codes []int
+	mu    sync.Mutex
+	reqs  []string
+}
+
+func newFlakyServer(codes ...int) *flakyServer {
+	s := flakyServer{codes: codes}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.reqs = append(s.reqs, r.Method+" "+r.URL.Path)
+		if len(s.codes) > 0 {
+			code := s.codes[0]
+			s.codes = s.codes[1:]
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	return &s
+}
+
+func (s *flakyServer) requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.reqs)
+}
+
+func newRetryingClient(target string, retries int) *tsuru.Client {
+	client := tsuru.NewClient(http.DefaultClient, target)
+	client.Retry = tsuru.RetryPolicy{Retries: retries, Delay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+	return client
+}
+
+func TestRetryTransientErrors(t *testing.T) {
+	srv := newFlakyServer(http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout)
+	defer srv.Close()
+	client := newRetryingClient(srv.URL, 3)
+	_, err := client.GetApp(context.Background(), "myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.requests(); n != 4 {
+		t.Errorf("wrong number of requests. Want 4. Got %d", n)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	srv := newFlakyServer(http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	defer srv.Close()
+	client := newRetryingClient(srv.URL, 2)
+	err := client.SetEnvVars(context.Background(), "myapp", nil)
+	e, ok := err.(*tsuru.HTTPError)
+	if !ok || e.StatusCode != http.StatusBadGateway {
+		t.Errorf("wrong error: %#v", err)
+	}
+	if n := srv.requests(); n != 3 {
+		t.Errorf("wrong number of requests. Want 3. Got %d", n)
+	}
+}
+
+func TestRetryOnlyIdempotentRequests(t *testing.T) {
+	var tests = []struct {
+		name string
+		call func(*tsuru.Client) error
+		reqs int
+	}{
+		{"create app", func(c *tsuru.Client) error {
+			_, err := c.CreateApp(context.Background(), tsuru.CreateAppOptions{Name: "myapp", Platform: "python"})
+			return err
+		}, 1},
+		{"add units", func(c *tsuru.Client) error {
+			return c.AddUnits(context.Background(), "myapp", 2)
+		}, 1},
+		{"add cname", func(c *tsuru.Client) error {
+			return c.AddCName(context.Background(), "myapp", "myapp.example.com")
+		}, 2},
+		{"update app", func(c *tsuru.Client) error {
+			return c.UpdateApp(context.Background(), tsuru.CreateAppOptions{Name: "myapp", Plan: "small"})
+		}, 2},
+		{"delete app", func(c *tsuru.Client) error {
+			return c.DeleteApp(context.Background(), "myapp")
+		}, 2},
+		{"unset env vars", func(c *tsuru.Client) error {
+			return c.UnsetEnvVars(context.Background(), "myapp", false, []string{"USER"})
+		}, 2},
+	}
+	for _, test := range tests {
+		srv := newFlakyServer(http.StatusBadGateway)
+		test.call(newRetryingClient(srv.URL, 3))
+		if n := srv.requests(); n != test.reqs {
+			t.Errorf("%s: wrong number of requests. Want %d. Got %d", test.name, test.reqs, n)
+		}
+		srv.Close()
+	}
+}
+
+func TestRetryPermanentErrors(t *testing.T) {
+	srv := newFlakyServer(http.StatusInternalServerError, http.StatusNotFound)
+	defer srv.Close()
+	client := newRetryingClient(srv.URL, 3)
+	_, err := client.GetApp(context.Background(), "myapp")
+	if err == nil {
+		t.Fatal("unexpected <nil> error")
+	}
+	if n := srv.requests(); n != 1 {
+		t.Errorf("wrong number of requests. Want 1. Got %d", n)
+	}
+}
+
+func TestRetryNetworkErrors(t *testing.T) {
+	var calls int
+	client := tsuru.NewClient(doerFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		if calls < 3 {
+			return nil, errors.New("connection reset by peer")
+		}
+		return http.DefaultClient.Do(req)
+	}), server.URL())
+	client.Retry = tsuru.RetryPolicy{Retries: 3, Delay: time.Millisecond}
+	server.Reset()
+	_, err := client.ListApps(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("wrong number of calls. Want 3. Got %d", calls)
+	}
+}
+
+func TestRetryCanceledDuringBackoff(t *testing.T) {
+	srv := newFlakyServer(http.StatusBadGateway, http.StatusBadGateway)
+	defer srv.Close()
+	client := tsuru.NewClient(http.DefaultClient, srv.URL)
+	client.Retry = tsuru.RetryPolicy{Retries: 3, Delay: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := client.GetApp(ctx, "myapp")
+	if err == nil {
+		t.Fatal("unexpected <nil> error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Minute {
+		t.Errorf("the backoff wasn't interrupted, took %s", elapsed)
+	}
+	if n := srv.requests(); n != 1 {
+		t.Errorf("wrong number of requests. Want 1. Got %d", n)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2016 EF CTX. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsurutest
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) getLogs(w http.ResponseWriter, r *http.Request) {
+	appName := mux.Vars(r)["appname"]
+	if _, index := s.findApp(appName); index < 0 {
+		http.Error(w, "app not found", http.StatusNotFound)
+		return
+	}
+	lines, err := strconv.Atoi(r.URL.Query().Get("lines"))
+	if err != nil {
+		http.Error(w, "invalid number of lines", http.StatusBadRequest)
+		return
+	}
+	logs := []tsuru.Log{}
+	for _, l := range s.logs[appName] {
+		if source := r.URL.Query().Get("source"); source != "" && l.Source != source {
+			continue
+		}
+		if unit := r.URL.Query().Get("unit"); unit != "" && l.Unit != unit {
+			continue
+		}
+		logs = append(logs, l)
+	}
+	if lines < len(logs) {
+		logs = logs[len(logs)-lines:]
+	}
+	s.writeJSON(w, logs)
+}
+
+// AddLogs appends the given entries to the log of the app with the given
+// name. Log streams always end after the entries are sent, even when
+// following the log.
+func (s *Server) AddLogs(appName string, logs ...tsuru.Log) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logs[appName] = append(s.logs[appName], logs...)
+}
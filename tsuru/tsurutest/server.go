@@ -2,7 +2,9 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-package main
+// Package tsurutest provides a fake implementation of the tsuru API, used to
+// test the tsuru client and the commands of tranor.
+package tsurutest
 
 import (
 	"encoding/json"
@@ -11,31 +13,41 @@ import (
 	"net/http/httptest"
 	"regexp"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/cezarsa/form"
+	"github.com/ef-ctx/tsuru-flow/tsuru"
 	"github.com/gorilla/mux"
 	"github.com/tsuru/tsuru/api"
 )
 
-// fakeTsuruServer provides a non-thread-safe, partial implementation of the
-// tsuru API.
-type fakeTsuruServer struct {
-	apps    []app
-	envVars map[string][]envVar
-	deploys map[string][]deploy
+// Server provides a partial implementation of the tsuru API. Requests are
+// handled one at a time.
+type Server struct {
+	apps    []tsuru.App
+	envVars map[string][]tsuru.EnvVar
+	deploys map[string][]tsuru.Deploy
+	logs    map[string][]tsuru.Log
 	server  *httptest.Server
 	router  *mux.Router
+	mu      sync.Mutex
 }
 
-func newFakeTsuruServer() *fakeTsuruServer {
-	var s fakeTsuruServer
+// NewServer starts a fake tsuru API, without any apps.
+func NewServer() *Server {
+	var s Server
 	s.buildRouter()
-	s.server = httptest.NewServer(s.router)
-	s.reset()
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.router.ServeHTTP(w, r)
+	}))
+	s.Reset()
 	return &s
 }
 
-func (s *fakeTsuruServer) buildRouter() {
+func (s *Server) buildRouter() {
 	s.router = mux.NewRouter()
 	r := s.router.PathPrefix("/1.0").Subrouter()
 	r.HandleFunc("/apps", func(w http.ResponseWriter, r *http.Request) {
@@ -73,18 +85,21 @@ func (s *fakeTsuruServer) buildRouter() {
 		}
 	})
 	r.HandleFunc("/deploys", s.listDeploys)
+	r.HandleFunc("/apps/{appname}/deploy/rollback", s.rollbackDeploy)
 	r.HandleFunc("/apps/{appname}/cname", s.addCName)
+	r.HandleFunc("/apps/{appname}/units", s.changeUnits)
+	r.HandleFunc("/apps/{appname}/log", s.getLogs)
 	r.HandleFunc("/apps/{appname}/quota", s.getAppQuota)
 	r.HandleFunc("/services/instances", s.serviceInstances)
 }
 
-func (s *fakeTsuruServer) createApp(w http.ResponseWriter, r *http.Request) {
+func (s *Server) createApp(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	var opts createAppOptions
+	var opts tsuru.CreateAppOptions
 	form.DecodeValues(&opts, r.Form)
 	if opts.Name == "" || opts.Platform == "" {
 		http.Error(w, "invalid params", http.StatusBadRequest)
@@ -99,21 +114,18 @@ func (s *fakeTsuruServer) createApp(w http.ResponseWriter, r *http.Request) {
 		opts.Plan = "autogenerated"
 	}
 	repositoryURL := fmt.Sprintf("git@gandalf.example.com:%s.git", opts.Name)
-	s.apps = append(s.apps, app{
-		Name:        opts.Name,
-		Platform:    opts.Platform,
-		Description: opts.Description,
-		TeamOwner:   opts.Team,
-		Teams:       []string{opts.Team},
-		Plan: struct {
-			Name string `json:"name"`
-		}{Name: opts.Plan},
+	s.apps = append(s.apps, tsuru.App{
+		Name:          opts.Name,
+		Platform:      opts.Platform,
+		Description:   opts.Description,
+		TeamOwner:     opts.Team,
+		Teams:         []string{opts.Team},
+		Plan:          tsuru.Plan{Name: opts.Plan},
 		Pool:          opts.Pool,
 		Owner:         "user@example.com",
 		RepositoryURL: repositoryURL,
-		Addr:          opts.Name + ".tsuru.example.com",
 	})
-	s.envVars[opts.Name] = []envVar{
+	s.envVars[opts.Name] = []tsuru.EnvVar{
 		{Name: "TSURU_APPDIR", Value: "something"},
 		{Name: "TSURU_APP_TOKEN", Value: "sometoken"},
 		{Name: "TSURU_APPNAME", Value: opts.Name},
@@ -122,13 +134,13 @@ func (s *fakeTsuruServer) createApp(w http.ResponseWriter, r *http.Request) {
 	s.writeJSON(w, map[string]string{"repository_url": repositoryURL})
 }
 
-func (s *fakeTsuruServer) listApps(w http.ResponseWriter, r *http.Request) {
+func (s *Server) listApps(w http.ResponseWriter, r *http.Request) {
 	nameRegexp, err := regexp.Compile(r.URL.Query().Get("name"))
 	if err != nil {
 		http.Error(w, "invalid name regexp", http.StatusBadRequest)
 		return
 	}
-	var apps []app
+	var apps []tsuru.App
 	for _, a := range s.apps {
 		if nameRegexp.MatchString(a.Name) {
 			apps = append(apps, a)
@@ -141,13 +153,13 @@ func (s *fakeTsuruServer) listApps(w http.ResponseWriter, r *http.Request) {
 	s.writeJSON(w, apps)
 }
 
-func (s *fakeTsuruServer) updateApp(w http.ResponseWriter, r *http.Request) {
+func (s *Server) updateApp(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	var opts createAppOptions
+	var opts tsuru.CreateAppOptions
 	form.DecodeValues(&opts, r.Form)
 	a, index := s.findApp(mux.Vars(r)["appname"])
 	if index < 0 {
@@ -169,7 +181,7 @@ func (s *fakeTsuruServer) updateApp(w http.ResponseWriter, r *http.Request) {
 	s.apps[index] = a
 }
 
-func (s *fakeTsuruServer) getApp(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getApp(w http.ResponseWriter, r *http.Request) {
 	a, index := s.findApp(mux.Vars(r)["appname"])
 	if index < 0 {
 		http.Error(w, "app not found", http.StatusNotFound)
@@ -178,7 +190,7 @@ func (s *fakeTsuruServer) getApp(w http.ResponseWriter, r *http.Request) {
 	s.writeJSON(w, a)
 }
 
-func (s *fakeTsuruServer) deleteApp(w http.ResponseWriter, r *http.Request) {
+func (s *Server) deleteApp(w http.ResponseWriter, r *http.Request) {
 	_, index := s.findApp(mux.Vars(r)["appname"])
 	if index < 0 {
 		http.Error(w, "app not found", http.StatusNotFound)
@@ -189,7 +201,7 @@ func (s *fakeTsuruServer) deleteApp(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func (s *fakeTsuruServer) setEnvs(w http.ResponseWriter, r *http.Request) {
+func (s *Server) setEnvs(w http.ResponseWriter, r *http.Request) {
 	appName := mux.Vars(r)["appname"]
 	envs, ok := s.envVars[appName]
 	if !ok {
@@ -204,27 +216,45 @@ func (s *fakeTsuruServer) setEnvs(w http.ResponseWriter, r *http.Request) {
 	}
 	form.DecodeValues(&evars, r.Form)
 	for _, e := range evars.Envs {
-		envs = append(envs, envVar{
+		v := tsuru.EnvVar{
 			Name:   e.Name,
 			Value:  e.Value,
 			Public: !evars.Private,
-		})
+		}
+		var replaced bool
+		for i := range envs {
+			if envs[i].Name == e.Name {
+				envs[i] = v
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			envs = append(envs, v)
+		}
 	}
 	s.envVars[appName] = envs
 	w.WriteHeader(http.StatusOK)
 }
 
-func (s *fakeTsuruServer) getEnvs(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getEnvs(w http.ResponseWriter, r *http.Request) {
 	appName := mux.Vars(r)["appname"]
 	envs, ok := s.envVars[appName]
 	if !ok {
 		http.Error(w, "app not found", http.StatusNotFound)
 		return
 	}
+	// like tsuru, never return the values of private variables
+	envs = append([]tsuru.EnvVar(nil), envs...)
+	for i := range envs {
+		if !envs[i].Public {
+			envs[i].Value = "*** (private variable)"
+		}
+	}
 	s.writeJSON(w, envs)
 }
 
-func (s *fakeTsuruServer) unsetEnvs(w http.ResponseWriter, r *http.Request) {
+func (s *Server) unsetEnvs(w http.ResponseWriter, r *http.Request) {
 	appName := mux.Vars(r)["appname"]
 	envs, ok := s.envVars[appName]
 	if !ok {
@@ -232,7 +262,7 @@ func (s *fakeTsuruServer) unsetEnvs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	envNames := r.URL.Query()["env"]
-	var newEnvs []envVar
+	var newEnvs []tsuru.EnvVar
 	for _, e := range envs {
 		var exclude bool
 		for _, envName := range envNames {
@@ -249,7 +279,7 @@ func (s *fakeTsuruServer) unsetEnvs(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func (s *fakeTsuruServer) listDeploys(w http.ResponseWriter, r *http.Request) {
+func (s *Server) listDeploys(w http.ResponseWriter, r *http.Request) {
 	appName := r.URL.Query().Get("app")
 	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
 	if appName == "" {
@@ -268,14 +298,46 @@ func (s *fakeTsuruServer) listDeploys(w http.ResponseWriter, r *http.Request) {
 	if limit == 0 || limit > len(deployList) {
 		limit = len(deployList)
 	}
-	var deploys []deploy
+	var deploys []tsuru.Deploy
 	for i := len(deployList) - 1; i >= len(deployList)-limit; i-- {
 		deploys = append(deploys, deployList[i])
 	}
 	s.writeJSON(w, deploys)
 }
 
-func (s *fakeTsuruServer) addCName(w http.ResponseWriter, r *http.Request) {
+func (s *Server) rollbackDeploy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	appName := mux.Vars(r)["appname"]
+	image := r.FormValue("image")
+	deployList, ok := s.deploys[appName]
+	if !ok {
+		http.Error(w, "app not found", http.StatusNotFound)
+		return
+	}
+	var found bool
+	for _, d := range deployList {
+		if d.Image == image && d.Error == "" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		http.Error(w, "invalid version: "+image, http.StatusBadRequest)
+		return
+	}
+	s.deploys[appName] = append(deployList, tsuru.Deploy{
+		ID:        strconv.Itoa(len(deployList) + 1),
+		Image:     image,
+		Origin:    "rollback",
+		Timestamp: time.Now().UTC(),
+	})
+	s.writeJSON(w, map[string]string{"Message": "rolling back to " + image + "\n"})
+}
+
+func (s *Server) addCName(w http.ResponseWriter, r *http.Request) {
 	cName := r.FormValue("cname")
 	if cName == "" {
 		http.Error(w, "missing param", http.StatusBadRequest)
@@ -296,21 +358,54 @@ func (s *fakeTsuruServer) addCName(w http.ResponseWriter, r *http.Request) {
 	s.apps[index] = a
 }
 
-func (s *fakeTsuruServer) getAppQuota(w http.ResponseWriter, r *http.Request) {
+func (s *Server) changeUnits(w http.ResponseWriter, r *http.Request) {
+	units, err := strconv.Atoi(r.FormValue("units"))
+	if err != nil || units < 1 {
+		http.Error(w, "invalid number of units", http.StatusBadRequest)
+		return
+	}
+	a, index := s.findApp(mux.Vars(r)["appname"])
+	if index < 0 {
+		http.Error(w, "app not found", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		for i := 0; i < units; i++ {
+			a.Units = append(a.Units, tsuru.Unit{
+				ID:          fmt.Sprintf("%s-%d", a.Name, len(a.Units)),
+				ProcessName: "web",
+				Status:      tsuru.UnitStarted,
+			})
+		}
+	case http.MethodDelete:
+		if units > len(a.Units) {
+			http.Error(w, "not enough units", http.StatusBadRequest)
+			return
+		}
+		a.Units = a.Units[:len(a.Units)-units]
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.apps[index] = a
+}
+
+func (s *Server) getAppQuota(w http.ResponseWriter, r *http.Request) {
 	s.writeJSON(w, map[string]interface{}{"Limit": -1})
 }
 
-func (s *fakeTsuruServer) serviceInstances(w http.ResponseWriter, r *http.Request) {
+func (s *Server) serviceInstances(w http.ResponseWriter, r *http.Request) {
 	s.writeJSON(w, map[string]interface{}{})
 }
 
-func (s *fakeTsuruServer) writeJSON(w http.ResponseWriter, data interface{}) {
+func (s *Server) writeJSON(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(data)
 }
 
-func (s *fakeTsuruServer) findApp(name string) (a app, index int) {
+func (s *Server) findApp(name string) (a tsuru.App, index int) {
 	index = -1
 	for i := range s.apps {
 		if s.apps[i].Name == name {
@@ -322,16 +417,35 @@ func (s *fakeTsuruServer) findApp(name string) (a app, index int) {
 	return a, index
 }
 
-func (s *fakeTsuruServer) url() string {
+// URL returns the URL of the fake API.
+func (s *Server) URL() string {
 	return s.server.URL
 }
 
-func (s *fakeTsuruServer) token() string {
+// Token returns a token accepted by the fake API.
+func (s *Server) Token() string {
 	return "whatever"
 }
 
-func (s *fakeTsuruServer) reset() {
+// AddDeploy records a deploy of the app with the given name, which must
+// exist in the fake API. Deploys must be added in chronological order.
+func (s *Server) AddDeploy(appName string, d tsuru.Deploy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deploys[appName] = append(s.deploys[appName], d)
+}
+
+// Reset removes all apps from the fake API.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.apps = nil
-	s.envVars = make(map[string][]envVar)
-	s.deploys = make(map[string][]deploy)
+	s.envVars = make(map[string][]tsuru.EnvVar)
+	s.deploys = make(map[string][]tsuru.Deploy)
+	s.logs = make(map[string][]tsuru.Log)
+}
+
+// Close stops the fake API.
+func (s *Server) Close() {
+	s.server.Close()
 }
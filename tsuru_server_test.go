@@ -5,9 +5,11 @@
 package main
 
 import (
-	"io/ioutil"
 	"net/http"
+	"testing"
 
+	"github.com/ef-ctx/tsuru-flow/tsuru"
+	"github.com/ef-ctx/tsuru-flow/tsuru/tsurutest"
 	"github.com/tsuru/tsuru/cmd"
 )
 
@@ -29,16 +31,16 @@ func (s *actualTsuruServer) reset() {
 		panic(err)
 	}
 	defer cleanup()
+	apiClient := testAPIClient(client)
 	appPrefixes := []string{"myproj", "superproj", "proj1"}
 	for _, prefix := range appPrefixes {
 		filters := map[string]string{"name": "^" + prefix}
-		apps, err := listApps(client, filters)
+		apps, err := apiClient.ListApps(requestContext, filters)
 		if err != nil {
 			panic(err)
 		}
-		_, err = deleteApps(apps, client, ioutil.Discard)
-		if err != nil {
-			panic(err)
+		for _, a := range apps {
+			apiClient.DeleteApp(requestContext, a.Name)
 		}
 	}
 }
@@ -50,3 +52,35 @@ func (s *actualTsuruServer) token() string {
 func (s *actualTsuruServer) url() string {
 	return s.tsuruHost
 }
+
+// fakeTsuruServer adapts the fake tsuru API from the tsurutest package to the
+// tsuruServer interface.
+type fakeTsuruServer struct {
+	*tsurutest.Server
+}
+
+func newFakeTsuruServer() *fakeTsuruServer {
+	return &fakeTsuruServer{Server: tsurutest.NewServer()}
+}
+
+func (s *fakeTsuruServer) url() string {
+	return s.URL()
+}
+
+func (s *fakeTsuruServer) token() string {
+	return s.Token()
+}
+
+func (s *fakeTsuruServer) reset() {
+	s.Reset()
+}
+
+// addDeploy records a deploy of the app in the fake tsuru API. Tests that
+// need it are skipped when running against a real tsuru server.
+func addDeploy(t *testing.T, appName string, d tsuru.Deploy) {
+	s, ok := tsuruServer.(*fakeTsuruServer)
+	if !ok {
+		t.Skip("deploys can only be recorded in the fake tsuru API")
+	}
+	s.AddDeploy(appName, d)
+}